@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"iot_modulo1.0/pkg/alerts"
+)
+
+// Motor de alertas (avalia as regras dentro do pipeline da Globalstar)
+var alertEngine *alerts.Engine
+
+// Watchdog de dispositivos silenciosos (heartbeat perdido)
+var watchdog *alerts.Watchdog
+
+// Escalonamento de alertas não reconhecidos
+var escalator *alerts.Escalator
+
+type AlertAction struct {
+	ID int `json:"id"`
+}
+
+type DeviceIntervalUpdate struct {
+	DeviceID                int `json:"device_id"`
+	ExpectedIntervalMinutes int `json:"expected_interval_minutes"`
+}
+
+type GroupData struct {
+	ID        int    `json:"id"`
+	OrgID     int    `json:"organization_id"`
+	Name      string `json:"name"`
+	DeviceIDs []int  `json:"device_ids"`
+}
+
+// --- ALERTAS ---
+
+// onAlertChange: Alerta aberto entra na política de escalonamento (ou recebe a
+// notificação padrão); reconhecido/resolvido interrompe o escalonamento
+func onAlertChange(a alerts.Alert) {
+	switch a.State {
+	case alerts.StateOpen:
+		if !escalator.Start(a) {
+			notifyAlertOpened(a)
+		}
+	case alerts.StateAcknowledged:
+		escalator.Stop(a, fmt.Sprintf("reconhecido pelo usuário %d", a.AcknowledgedBy))
+	case alerts.StateResolved:
+		escalator.Stop(a, "resolvido")
+	}
+}
+
+// apiAlertsHandler: Lista alertas dos dispositivos visíveis ao usuário (?state=open)
+func apiAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	filter := alerts.ListFilter{UserID: userID, OrgID: orgOf(r), AllOrgs: crossTenant(r), State: r.URL.Query().Get("state")}
+	if can(r, PermDevicesReadAll) {
+		filter.UserID = 0
+	}
+	filter.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+
+	list, err := alertEngine.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// alertAckHandler: Reconhece um alerta aberto
+func alertAckHandler(w http.ResponseWriter, r *http.Request) {
+	changeAlertState(w, r, AuditAlertAck, func(id, userID int) (alerts.Alert, error) {
+		return alertEngine.Acknowledge(id, userID)
+	})
+}
+
+// alertResolveHandler: Encerra um alerta manualmente
+func alertResolveHandler(w http.ResponseWriter, r *http.Request) {
+	changeAlertState(w, r, AuditAlertResolve, func(id, userID int) (alerts.Alert, error) {
+		return alertEngine.Resolve(id)
+	})
+}
+
+func changeAlertState(w http.ResponseWriter, r *http.Request, action AuditAction, apply func(id, userID int) (alerts.Alert, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AlertAction
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	current, err := alertEngine.Get(req.ID)
+	// Reconhecer/resolver exige nível operator no dispositivo
+	if err != nil || !hasDevicePermission(r, current.DeviceID, AccessOperator) {
+		http.Error(w, "Alerta não encontrado", http.StatusNotFound)
+		return
+	}
+
+	a, err := apply(req.ID, userID)
+	if errors.Is(err, alerts.ErrInvalidState) {
+		http.Error(w, "Alerta já está neste estado ou foi encerrado", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Erro ao atualizar alerta", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetAlert, a.ID), Details: fmt.Sprintf("Alerta %d (%s) -> %s", a.ID, a.ESN, a.State),
+		Changes: AuditChanges{"state": {From: current.State, To: a.State}}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// --- STATUS DE COMUNICAÇÃO (WATCHDOG) ---
+
+// deviceStatusHandler: Online/offline dos dispositivos visíveis ao usuário
+func deviceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]alerts.DeviceStatus, 0)
+	for _, s := range watchdog.Statuses() {
+		if hasDevicePermission(r, s.DeviceID, AccessViewer) {
+			list = append(list, s)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// deviceIntervalHandler: Define o intervalo esperado de reporte de um dispositivo (nível owner)
+func deviceIntervalHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeviceIntervalUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpectedIntervalMinutes < 0 {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	if !hasDevicePermission(r, req.DeviceID, AccessOwner) {
+		http.Error(w, "Dispositivo não encontrado", http.StatusNotFound)
+		return
+	}
+	var oldInterval int
+	db.QueryRow("SELECT expected_interval_minutes FROM devices WHERE id = ?", req.DeviceID).Scan(&oldInterval)
+
+	if err := watchdog.SetInterval(req.DeviceID, req.ExpectedIntervalMinutes); err != nil {
+		http.Error(w, "Erro ao atualizar intervalo", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditDeviceInterval, Target: auditTarget(TargetDevice, req.DeviceID),
+		Details: fmt.Sprintf("Device %d: intervalo esperado %d min", req.DeviceID, req.ExpectedIntervalMinutes),
+		Changes: AuditChanges{"expected_interval_minutes": {From: oldInterval, To: req.ExpectedIntervalMinutes}}})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- REGRAS DE ALERTA (alerts:manage) ---
+
+// alertRulesHandler: GET lista as regras, POST cria/atualiza
+func alertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		rules, err := alertEngine.ListRules()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		visible := make([]alerts.Rule, 0, len(rules))
+		for _, rule := range rules {
+			if orgID, err := ruleTargetOrg(rule.DeviceID, rule.GroupID); err == nil && inScope(r, orgID) {
+				visible = append(visible, rule)
+			}
+		}
+		json.NewEncoder(w).Encode(visible)
+		return
+	}
+
+	rule := alerts.Rule{Enabled: true, Severity: alerts.SeverityWarning}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	action := AuditAlertRuleCreated
+	var before interface{}
+	if rule.ID > 0 {
+		action = AuditAlertRuleUpdated
+		if old, ok := findRule(rule.ID); ok {
+			before = old
+		}
+		if orgID, err := storedRuleOrg(rule.ID); err != nil || !inScope(r, orgID) {
+			http.Error(w, "Regra não encontrada", http.StatusNotFound)
+			return
+		}
+	}
+	// Alvo (e política, se houver) precisam ser da organização de quem grava
+	if rule.DeviceID != 0 || rule.GroupID != 0 {
+		orgID, err := ruleTargetOrg(rule.DeviceID, rule.GroupID)
+		if err != nil || !inScope(r, orgID) {
+			http.Error(w, "Dispositivo ou grupo não encontrado", http.StatusNotFound)
+			return
+		}
+		if rule.EscalationPolicyID != 0 && !allInOrg("escalation_policies", []int{rule.EscalationPolicyID}, orgID) {
+			http.Error(w, "Política de escalonamento de outra organização", http.StatusBadRequest)
+			return
+		}
+	}
+
+	saved, err := alertEngine.SaveRule(rule)
+	if errors.Is(err, alerts.ErrInvalidRule) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao salvar regra de alerta: %v", err)
+		http.Error(w, "Erro ao salvar regra", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetAlertRule, saved.ID),
+		Details: fmt.Sprintf("Regra %d (%s): %s %s %.2f", saved.ID, saved.Name, saved.Metric, saved.Condition, saved.Threshold),
+		Changes: diffChanges(before, saved)})
+
+	json.NewEncoder(w).Encode(saved)
+}
+
+func deleteAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var req AlertAction
+	json.NewDecoder(r.Body).Decode(&req)
+	orgID, err := storedRuleOrg(req.ID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Regra não encontrada", http.StatusNotFound)
+		return
+	}
+
+	if err := alertEngine.DeleteRule(req.ID); err != nil {
+		http.Error(w, "Erro ao remover regra", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditAlertRuleDeleted, Target: auditTarget(TargetAlertRule, req.ID),
+		Details: fmt.Sprintf("Removeu regra de alerta ID %d", req.ID), OrgID: orgID})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- POLÍTICAS DE ESCALONAMENTO (alerts:manage) ---
+
+// escalationPoliciesHandler: GET lista as políticas, POST cria/atualiza
+func escalationPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		list, err := escalator.Policies()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		visible := make([]alerts.Policy, 0, len(list))
+		for _, p := range list {
+			if inScope(r, p.OrgID) {
+				visible = append(visible, p)
+			}
+		}
+		json.NewEncoder(w).Encode(visible)
+		return
+	}
+
+	var p alerts.Policy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	action := AuditEscalationPolicyCreated
+	var before interface{}
+	if p.ID > 0 {
+		action = AuditEscalationPolicyUpdated
+		if old, err := escalator.Policy(p.ID); err == nil {
+			before = old
+		}
+	}
+	orgID, ok := ownerOrg(r, "escalation_policies", p.ID, p.OrgID)
+	if !ok {
+		http.Error(w, "Política ou organização não encontrada", http.StatusNotFound)
+		return
+	}
+	p.OrgID = orgID
+	// Destinatários nominais também precisam ser da organização
+	for _, s := range p.Steps {
+		if !allInOrg("users", s.UserIDs, orgID) {
+			http.Error(w, "Usuário de outra organização na política", http.StatusBadRequest)
+			return
+		}
+	}
+
+	saved, err := escalator.SavePolicy(p)
+	if errors.Is(err, alerts.ErrInvalidRule) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao salvar política de escalonamento: %v", err)
+		http.Error(w, "Erro ao salvar política", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetEscalationPolicy, saved.ID),
+		Details: fmt.Sprintf("Política %d (%s) com %d etapas, %d repetições", saved.ID, saved.Name, len(saved.Steps), saved.RepeatCount),
+		Changes: diffChanges(before, saved)})
+
+	json.NewEncoder(w).Encode(saved)
+}
+
+func deleteEscalationPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var req AlertAction
+	json.NewDecoder(r.Body).Decode(&req)
+	orgID, err := recordOrg("escalation_policies", req.ID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Política não encontrada", http.StatusNotFound)
+		return
+	}
+
+	if err := escalator.DeletePolicy(req.ID); err != nil {
+		http.Error(w, "Erro ao remover política", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditEscalationPolicyDeleted, Target: auditTarget(TargetEscalationPolicy, req.ID),
+		Details: fmt.Sprintf("Removeu política de escalonamento ID %d", req.ID), OrgID: orgID})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- GRUPOS DE DISPOSITIVOS (devices:manage) ---
+
+// groupsHandler: GET lista os grupos com seus membros, POST cria/atualiza
+func groupsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		cond, args := orgCond(r, "g")
+		rows, err := db.Query("SELECT g.id, COALESCE(g.organization_id, 0), g.name FROM device_groups g WHERE "+cond+" ORDER BY g.name", args...)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer rows.Close()
+
+		groups := make([]GroupData, 0)
+		for rows.Next() {
+			var g GroupData
+			rows.Scan(&g.ID, &g.OrgID, &g.Name)
+			g.DeviceIDs = []int{}
+			mRows, _ := db.Query("SELECT device_id FROM device_group_members WHERE group_id = ?", g.ID)
+			for mRows.Next() {
+				var id int
+				mRows.Scan(&id)
+				g.DeviceIDs = append(g.DeviceIDs, id)
+			}
+			mRows.Close()
+			groups = append(groups, g)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+		return
+	}
+
+	var g GroupData
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil || g.Name == "" {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	orgID, ok := ownerOrg(r, "device_groups", g.ID, g.OrgID)
+	if !ok {
+		http.Error(w, "Grupo ou organização não encontrada", http.StatusNotFound)
+		return
+	}
+	g.OrgID = orgID
+	if !allInOrg("devices", g.DeviceIDs, g.OrgID) {
+		http.Error(w, "Dispositivo de outra organização no grupo", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Erro ao salvar grupo", 500)
+		return
+	}
+	defer tx.Rollback()
+
+	action := AuditGroupUpdated
+	var before interface{}
+	if g.ID > 0 {
+		if old, ok := loadGroup(g.ID); ok {
+			before = old
+		}
+		_, err = tx.Exec("UPDATE device_groups SET name = ? WHERE id = ?", g.Name, g.ID)
+	} else {
+		action = AuditGroupCreated
+		res, insErr := tx.Exec("INSERT INTO device_groups (name, organization_id) VALUES (?, NULLIF(?, 0))", g.Name, g.OrgID)
+		err = insErr
+		if err == nil {
+			id, _ := res.LastInsertId()
+			g.ID = int(id)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM device_group_members WHERE group_id = ?", g.ID)
+	}
+	for _, deviceID := range g.DeviceIDs {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO device_group_members (group_id, device_id) VALUES (?, ?)", g.ID, deviceID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Erro ao salvar grupo: %v", err)
+		http.Error(w, "Erro ao salvar grupo", 500)
+		return
+	}
+
+	// Regras e geofences por grupo dependem dos membros
+	reloadGroupConsumers()
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetGroup, g.ID),
+		Details: fmt.Sprintf("Grupo %d (%s) com %d dispositivos", g.ID, g.Name, len(g.DeviceIDs)), Changes: diffChanges(before, g)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// findRule: Regra gravada, para o diff da auditoria
+func findRule(id int) (alerts.Rule, bool) {
+	rules, err := alertEngine.ListRules()
+	if err != nil {
+		return alerts.Rule{}, false
+	}
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule, true
+		}
+	}
+	return alerts.Rule{}, false
+}
+
+// loadGroup: Grupo gravado (nome e membros), para o diff da auditoria
+func loadGroup(id int) (GroupData, bool) {
+	g := GroupData{ID: id, DeviceIDs: []int{}}
+	if err := db.QueryRow("SELECT COALESCE(organization_id, 0), name FROM device_groups WHERE id = ?", id).Scan(&g.OrgID, &g.Name); err != nil {
+		return g, false
+	}
+	rows, err := db.Query("SELECT device_id FROM device_group_members WHERE group_id = ? ORDER BY device_id", id)
+	if err != nil {
+		return g, false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deviceID int
+		rows.Scan(&deviceID)
+		g.DeviceIDs = append(g.DeviceIDs, deviceID)
+	}
+	return g, true
+}
+
+// reloadGroupConsumers: Recarrega quem mantém cache dos membros de grupos
+func reloadGroupConsumers() {
+	if err := alertEngine.Load(); err != nil {
+		log.Printf("Erro ao recarregar regras de alerta: %v", err)
+	}
+	if err := geofences.Load(); err != nil {
+		log.Printf("Erro ao recarregar geofences: %v", err)
+	}
+}
+
+func deleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var g GroupData
+	json.NewDecoder(r.Body).Decode(&g)
+	orgID, err := recordOrg("device_groups", g.ID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Grupo não encontrado", http.StatusNotFound)
+		return
+	}
+	db.Exec("DELETE FROM device_groups WHERE id = ?", g.ID)
+
+	reloadGroupConsumers()
+
+	recordAudit(r, AuditEvent{Action: AuditGroupDeleted, Target: auditTarget(TargetGroup, g.ID), Details: fmt.Sprintf("Deletou grupo ID %d", g.ID), OrgID: orgID})
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- CHAVES DE API (INTEGRAÇÕES) ---
+// ERPs e controladores de irrigação autenticam com "Authorization: Bearer gsk_..."
+// nas mesmas rotas do frontend. Só o SHA-256 da chave é guardado; o prefixo
+// (gsk_ + 8 caracteres) identifica a chave na listagem.
+//
+// Chave pessoal: age como o usuário dono (papel e vínculos atuais).
+// Chave da organização: não depende de ninguém continuar na empresa e age como
+// admin da organização. Nos dois casos o escopo limita o que a chave faz (ver
+// apiKeyScopes): nada de administração, só telemetria e operação.
+
+const (
+	apiKeyPrefix    = "gsk_"
+	apiKeyShownSize = len(apiKeyPrefix) + 8
+
+	APIScopeRead  = "read"
+	APIScopeWrite = "write"
+)
+
+// apiKeyScopes: Teto de permissões de cada escopo (o papel do dono também precisa tê-las)
+var apiKeyScopes = map[string][]Permission{
+	APIScopeRead: {
+		PermDevicesRead, PermDevicesReadAll,
+		PermAlertsRead,
+		PermGeofencesRead,
+	},
+	APIScopeWrite: {
+		PermDevicesRead, PermDevicesReadAll, PermDevicesRename, PermDevicesConfigure,
+		PermAlertsRead, PermAlertsAck,
+		PermGeofencesRead,
+	},
+}
+
+type APIKey struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	Prefix         string `json:"prefix"`
+	Scope          string `json:"scope"`
+	UserID         int    `json:"user_id"` // 0 = chave da organização
+	Username       string `json:"username,omitempty"`
+	OrganizationID int    `json:"organization_id"`
+	ExpiresAt      string `json:"expires_at"`
+	LastUsedAt     string `json:"last_used_at"`
+	LastUsedIP     string `json:"last_used_ip"`
+	CreatedAt      string `json:"created_at"`
+	Revoked        bool   `json:"revoked"`
+	// Só na resposta da criação (não é possível recuperá-la depois)
+	Key string `json:"key,omitempty"`
+}
+
+type APIKeyRequest struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// 0 = não expira
+	ExpiresInDays int `json:"expires_in_days"`
+	// Chave da organização (apikeys:manage); só master escolhe a organização
+	Organization   bool `json:"organization"`
+	OrganizationID int  `json:"organization_id"`
+}
+
+var errAPIKeyInvalid = errors.New("chave de API inválida")
+
+// scopeAllows: O escopo da chave permite exercer a permissão?
+func scopeAllows(scope string, perm Permission) bool {
+	for _, p := range apiKeyScopes[scope] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// isAPIKey: O valor do Authorization é uma chave de API (e não um JWT)?
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// viaAPIKey: A requisição foi autenticada por chave de API?
+func viaAPIKey(r *http.Request) bool {
+	return r.Header.Get("X-API-Key-ID") != ""
+}
+
+// requireSession: Rotas da conta do próprio usuário (2FA, logout, preferências,
+// chaves) exigem login de verdade, não chave de API. Usar depois do authMiddleware.
+func requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if viaAPIKey(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authenticateAPIKey: Chave ativa -> identidade (claims), escopo e ID da chave
+func authenticateAPIKey(key, ip string) (claims *Claims, scope string, keyID int, err error) {
+	var userID, keyOrg, userOrg sql.NullInt64
+	var role, username sql.NullString
+	var expiresAt, revokedAt sql.NullTime
+	err = db.QueryRow(`SELECT k.id, k.user_id, k.organization_id, k.scope, k.expires_at, k.revoked_at, u.role, u.organization_id, u.username
+		FROM api_keys k LEFT JOIN users u ON u.id = k.user_id WHERE k.key_hash = ?`, hashToken(key)).
+		Scan(&keyID, &userID, &keyOrg, &scope, &expiresAt, &revokedAt, &role, &userOrg, &username)
+	if err != nil || revokedAt.Valid || (expiresAt.Valid && time.Now().After(expiresAt.Time)) {
+		return nil, "", 0, errAPIKeyInvalid
+	}
+
+	claims = &Claims{Role: RoleAdmin, OrgID: int(keyOrg.Int64)}
+	if userID.Valid {
+		claims = &Claims{UserID: int(userID.Int64), Role: role.String, OrgID: int(userOrg.Int64), Username: username.String}
+	}
+
+	// Uso registrado no máximo uma vez por minuto por chave
+	db.Exec(`UPDATE api_keys SET last_used_at = NOW(), last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)`, ip, keyID)
+	return claims, scope, keyID, nil
+}
+
+// newAPIKey: Gera a chave em claro (exibida uma vez) e o prefixo de exibição
+func newAPIKey() (key, prefix string, err error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + secret
+	return key, key[:apiKeyShownSize], nil
+}
+
+// formatNullTime: Data no formato da API ("" se nula)
+func formatNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format("02/01/2006 15:04:05")
+}
+
+// --- CADASTRO ---
+
+// apiKeysHandler: GET lista as chaves visíveis, POST cria uma chave (a resposta traz a chave em claro)
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	if r.Method == http.MethodGet {
+		list, err := listAPIKeys(r, userID)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" || req.ExpiresInDays < 0 {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	if _, ok := apiKeyScopes[req.Scope]; !ok {
+		http.Error(w, "Escopo inválido (read ou write)", 400)
+		return
+	}
+
+	k := APIKey{Name: strings.TrimSpace(req.Name), Scope: req.Scope}
+	var owner, orgID interface{}
+	if req.Organization {
+		if !can(r, PermAPIKeysManage) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		k.OrganizationID = targetOrg(r, req.OrganizationID)
+		if !organizationExists(k.OrganizationID) {
+			http.Error(w, "Organização não encontrada", http.StatusBadRequest)
+			return
+		}
+		orgID = k.OrganizationID
+	} else {
+		k.UserID, k.OrganizationID = userID, orgOf(r)
+		owner = userID
+	}
+
+	var expiresAt interface{}
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = t
+		k.ExpiresAt = t.Format("02/01/2006 15:04:05")
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		http.Error(w, "Erro ao gerar chave", 500)
+		return
+	}
+	res, err := db.Exec(`INSERT INTO api_keys (name, prefix, key_hash, user_id, organization_id, scope, created_by, expires_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, 0), ?, ?, ?)`, k.Name, prefix, hashToken(key), owner, orgID, k.Scope, userID, expiresAt)
+	if err != nil {
+		http.Error(w, "Erro ao salvar chave", 500)
+		return
+	}
+	id, _ := res.LastInsertId()
+	k.ID, k.Prefix, k.Key = int(id), prefix, key
+	k.CreatedAt = time.Now().Format("02/01/2006 15:04:05")
+
+	target := fmt.Sprintf("usuário ID %d", userID)
+	if req.Organization {
+		target = fmt.Sprintf("organização %d", k.OrganizationID)
+	}
+	recordAudit(r, AuditEvent{Action: AuditAPIKeyCreated, Target: auditTarget(TargetAPIKey, k.ID),
+		Details: fmt.Sprintf("Chave %s (%s, escopo %s) para %s", prefix, k.Name, k.Scope, target)})
+
+	json.NewEncoder(w).Encode(k)
+}
+
+// listAPIKeys: As próprias chaves e, com apikeys:manage, as da organização e dos seus usuários
+func listAPIKeys(r *http.Request, userID int) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT k.id, k.name, k.prefix, k.scope, COALESCE(k.user_id, 0), COALESCE(u.username, ''),
+		COALESCE(u.organization_id, k.organization_id, 0), k.expires_at, k.last_used_at, COALESCE(k.last_used_ip, ''),
+		k.created_at, k.revoked_at IS NOT NULL
+		FROM api_keys k LEFT JOIN users u ON u.id = k.user_id
+		WHERE k.user_id = ? OR (? AND (? OR COALESCE(u.organization_id, k.organization_id, 0) = ?))
+		ORDER BY k.id DESC`, userID, can(r, PermAPIKeysManage), crossTenant(r), orgOf(r))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]APIKey, 0)
+	for rows.Next() {
+		var k APIKey
+		var expiresAt, lastUsed sql.NullTime
+		var createdAt time.Time
+		rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scope, &k.UserID, &k.Username, &k.OrganizationID,
+			&expiresAt, &lastUsed, &k.LastUsedIP, &createdAt, &k.Revoked)
+		k.ExpiresAt, k.LastUsedAt = formatNullTime(expiresAt), formatNullTime(lastUsed)
+		k.CreatedAt = createdAt.Format("02/01/2006 15:04:05")
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+// revokeAPIKeyHandler: Revoga uma chave (a própria ou, com apikeys:manage, da organização)
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	var owner sql.NullInt64
+	var ownerRole, prefix string
+	var orgID int
+	err := db.QueryRow(`SELECT k.user_id, COALESCE(u.role, ''), COALESCE(u.organization_id, k.organization_id, 0), k.prefix
+		FROM api_keys k LEFT JOIN users u ON u.id = k.user_id WHERE k.id = ?`, req.ID).Scan(&owner, &ownerRole, &orgID, &prefix)
+	own := err == nil && owner.Valid && int(owner.Int64) == userID
+	managed := err == nil && can(r, PermAPIKeysManage) && inScope(r, orgID) && canManageRole(r.Header.Get("X-User-Role"), ownerRole)
+	if !own && !managed {
+		http.Error(w, "Chave não encontrada", http.StatusNotFound)
+		return
+	}
+
+	db.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL", req.ID)
+	recordAudit(r, AuditEvent{Action: AuditAPIKeyRevoked, Target: auditTarget(TargetAPIKey, req.ID),
+		Details: fmt.Sprintf("Revogou a chave %s (ID %d)", prefix, req.ID)})
+	w.WriteHeader(http.StatusOK)
+}
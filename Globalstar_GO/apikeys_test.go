@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestScopeAllows(t *testing.T) {
+	cases := []struct {
+		scope string
+		perm  Permission
+		ok    bool
+	}{
+		{APIScopeRead, PermDevicesRead, true},
+		{APIScopeRead, PermAlertsRead, true},
+		{APIScopeRead, PermDevicesRename, false},
+		{APIScopeRead, PermAlertsAck, false},
+		{APIScopeWrite, PermDevicesRename, true},
+		{APIScopeWrite, PermAlertsAck, true},
+		// Administração nunca passa por chave, qualquer que seja o escopo
+		{APIScopeWrite, PermUsersManage, false},
+		{APIScopeWrite, PermAPIKeysManage, false},
+		{"admin", PermDevicesRead, false},
+	}
+	for _, c := range cases {
+		if got := scopeAllows(c.scope, c.perm); got != c.ok {
+			t.Errorf("scopeAllows(%s, %s) = %v, esperado %v", c.scope, c.perm, got, c.ok)
+		}
+	}
+}
+
+// apiKeyRow: Chave 4; userID 0 = chave da organização 2
+type apiKeyRow struct {
+	userID    int
+	scope     string
+	expiresAt interface{}
+	revokedAt interface{}
+}
+
+func expectAPIKey(mock sqlmock.Sqlmock, key string, k apiKeyRow) {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "organization_id", "scope", "expires_at", "revoked_at", "role",
+		"organization_id", "username"})
+	if k.userID == 0 {
+		rows.AddRow(4, nil, 2, k.scope, k.expiresAt, k.revokedAt, nil, nil, nil)
+	} else {
+		rows.AddRow(4, k.userID, nil, k.scope, k.expiresAt, k.revokedAt, RoleAdmin, 2, "joao")
+	}
+	mock.ExpectQuery("FROM api_keys k LEFT JOIN users u").WithArgs(hashToken(key)).WillReturnRows(rows)
+	if k.revokedAt == nil && (k.expiresAt == nil || k.expiresAt.(time.Time).After(time.Now())) {
+		mock.ExpectExec("UPDATE api_keys SET last_used_at").WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// withAPIKey: Passa pelo authMiddleware com a chave; devolve o status e se o handler rodou
+func withAPIKey(key string, route func(http.HandlerFunc) http.HandlerFunc) (int, *http.Request) {
+	var reached *http.Request
+	handler := authMiddleware(route(func(w http.ResponseWriter, r *http.Request) { reached = r }))
+	r := httptest.NewRequest(http.MethodPost, "/api/teste", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	// Cabeçalho interno forjado pelo cliente não pode ampliar o escopo
+	r.Header.Set("X-API-Scope", APIScopeWrite)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w.Code, reached
+}
+
+func permission(perm Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc { return requirePermission(perm, next) }
+}
+
+func TestAPIKeyScopeCapsTheOwnerRole(t *testing.T) {
+	mock := mockDB(t)
+
+	// Chave de leitura de um admin: o papel permite renomear, o escopo não
+	expectAPIKey(mock, "gsk_leitura", apiKeyRow{userID: 9, scope: APIScopeRead})
+	if code, reached := withAPIKey("gsk_leitura", permission(PermDevicesRename)); code != http.StatusForbidden || reached != nil {
+		t.Fatalf("chave de leitura renomeando: status %d", code)
+	}
+
+	// Chave da organização age como admin dela, mas só dentro do escopo
+	expectAPIKey(mock, "gsk_org", apiKeyRow{scope: APIScopeWrite})
+	code, reached := withAPIKey("gsk_org", permission(PermDevicesRename))
+	if code != http.StatusOK || reached == nil {
+		t.Fatalf("chave da organização renomeando: status %d", code)
+	}
+	if reached.Header.Get("X-Org-ID") != "2" || reached.Header.Get("X-User-ID") != "0" || reached.Header.Get("X-API-Key-ID") != "4" {
+		t.Fatalf("identidade da chave da organização: %v", reached.Header)
+	}
+	expectAPIKey(mock, "gsk_org", apiKeyRow{scope: APIScopeWrite})
+	if code, _ := withAPIKey("gsk_org", permission(PermUsersManage)); code != http.StatusForbidden {
+		t.Fatalf("chave da organização gerenciando usuários: status %d", code)
+	}
+}
+
+func TestAPIKeyRevokedOrExpiredIsRejected(t *testing.T) {
+	mock := mockDB(t)
+	open := func(next http.HandlerFunc) http.HandlerFunc { return next }
+
+	expectAPIKey(mock, "gsk_revogada", apiKeyRow{userID: 9, scope: APIScopeRead, revokedAt: time.Now().Add(-time.Hour)})
+	if code, reached := withAPIKey("gsk_revogada", open); code != http.StatusUnauthorized || reached != nil {
+		t.Fatalf("chave revogada: status %d", code)
+	}
+	expectAPIKey(mock, "gsk_vencida", apiKeyRow{userID: 9, scope: APIScopeRead, expiresAt: time.Now().Add(-time.Minute)})
+	if code, reached := withAPIKey("gsk_vencida", open); code != http.StatusUnauthorized || reached != nil {
+		t.Fatalf("chave expirada: status %d", code)
+	}
+}
+
+func TestRequireSessionRefusesAPIKeys(t *testing.T) {
+	mock := mockDB(t)
+	expectAPIKey(mock, "gsk_escrita", apiKeyRow{userID: 9, scope: APIScopeWrite, expiresAt: time.Now().Add(time.Hour)})
+	if code, reached := withAPIKey("gsk_escrita", requireSession); code != http.StatusForbidden || reached != nil {
+		t.Fatalf("rota da conta com chave de API: status %d", code)
+	}
+}
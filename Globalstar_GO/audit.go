@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"iot_modulo1.0/pkg/auditchain"
+)
+
+// --- EVENTOS DE AUDITORIA ---
+// Cada evento tem autor (ID e username reais), ação do catálogo abaixo, alvo
+// (tipo e ID), texto livre, diff JSON dos campos alterados (sem segredos), ID
+// da requisição e user agent. Tudo entra no hash da cadeia (audit_chain.go).
+
+type AuditAction string
+
+const (
+	// Login e sessão
+	AuditLogin                  AuditAction = "LOGIN"
+	AuditLoginFailed            AuditAction = "LOGIN_FAILED"
+	AuditLoginLocked            AuditAction = "LOGIN_LOCKED"
+	AuditLoginUnlocked          AuditAction = "LOGIN_UNLOCKED"
+	AuditLoginMFAFailed         AuditAction = "LOGIN_MFA_FAILED"
+	AuditLogout                 AuditAction = "LOGOUT"
+	AuditLogoutAll              AuditAction = "LOGOUT_ALL"
+	AuditRefreshTokenReuse      AuditAction = "REFRESH_TOKEN_REUSE"
+	AuditSessionRevoked         AuditAction = "REVOKE_SESSION"
+	AuditSessionsRevoked        AuditAction = "REVOKE_SESSIONS"
+	AuditUserSessionRevoked     AuditAction = "REVOKE_USER_SESSION"
+	AuditUserSessionsRevoked    AuditAction = "REVOKE_USER_SESSIONS"
+	AuditSSOLoginFailed         AuditAction = "SSO_LOGIN_FAILED"
+	AuditSSOIdentityLinked      AuditAction = "SSO_IDENTITY_LINKED"
+	AuditSSOUserProvisioned     AuditAction = "SSO_USER_PROVISIONED"
+	AuditSSORoleChange          AuditAction = "SSO_ROLE_CHANGE"
+	AuditMFAEnrolled            AuditAction = "MFA_ENROLLED"
+	AuditMFADisabled            AuditAction = "MFA_DISABLED"
+	AuditMFADisableFailed       AuditAction = "MFA_DISABLE_FAILED"
+	AuditMFAReset               AuditAction = "MFA_RESET"
+	AuditMFARecoveryCodeUsed    AuditAction = "MFA_RECOVERY_CODE_USED"
+	AuditMFARecoveryCodesRegen  AuditAction = "MFA_RECOVERY_CODES_REGENERATED"
+	AuditPasswordChanged        AuditAction = "PASSWORD_CHANGED"
+	AuditPasswordChangeFailed   AuditAction = "PASSWORD_CHANGE_FAILED"
+	AuditPasswordChangeRequired AuditAction = "PASSWORD_CHANGE_REQUIRED"
+	AuditForgotPassword         AuditAction = "FORGOT_PASSWORD"
+	AuditResetPassword          AuditAction = "RESET_PASSWORD"
+
+	// Contas
+	AuditUserCreated          AuditAction = "CREATE_USER"
+	AuditUserUpdated          AuditAction = "UPDATE_USER"
+	AuditUserDeleted          AuditAction = "DELETE_USER"
+	AuditInviteSent           AuditAction = "INVITE_SENT"
+	AuditInviteResent         AuditAction = "INVITE_RESENT"
+	AuditInviteRevoked        AuditAction = "INVITE_REVOKED"
+	AuditInviteAccepted       AuditAction = "INVITE_ACCEPTED"
+	AuditProfileUpdated       AuditAction = "UPDATE_PROFILE"
+	AuditEmailChangeRequested AuditAction = "EMAIL_CHANGE_REQUESTED"
+	AuditEmailChangeFailed    AuditAction = "EMAIL_CHANGE_FAILED"
+	AuditEmailChanged         AuditAction = "EMAIL_CHANGED"
+	AuditPermissionChange     AuditAction = "PERMISSION_CHANGE" // vínculo criado ou removido
+	AuditPermissionLevel      AuditAction = "PERMISSION_LEVEL_CHANGE"
+	AuditAPIKeyCreated        AuditAction = "CREATE_API_KEY"
+	AuditAPIKeyRevoked        AuditAction = "REVOKE_API_KEY"
+	AuditNotificationPrefs    AuditAction = "UPDATE_NOTIFICATION_PREFS"
+
+	// Dispositivos e operação
+	AuditDeviceRenamed           AuditAction = "UPDATE_DEVICE"
+	AuditDeviceInterval          AuditAction = "UPDATE_DEVICE_INTERVAL"
+	AuditDeviceOrganization      AuditAction = "DEVICE_ORGANIZATION_CHANGE"
+	AuditGroupCreated            AuditAction = "CREATE_GROUP"
+	AuditGroupUpdated            AuditAction = "UPDATE_GROUP"
+	AuditGroupDeleted            AuditAction = "DELETE_GROUP"
+	AuditGeofenceCreated         AuditAction = "CREATE_GEOFENCE"
+	AuditGeofenceUpdated         AuditAction = "UPDATE_GEOFENCE"
+	AuditGeofenceDeleted         AuditAction = "DELETE_GEOFENCE"
+	AuditAlertRuleCreated        AuditAction = "CREATE_ALERT_RULE"
+	AuditAlertRuleUpdated        AuditAction = "UPDATE_ALERT_RULE"
+	AuditAlertRuleDeleted        AuditAction = "DELETE_ALERT_RULE"
+	AuditAlertAck                AuditAction = "ALERT_ACK"
+	AuditAlertResolve            AuditAction = "ALERT_RESOLVE"
+	AuditAlertEscalation         AuditAction = "ALERT_ESCALATION"
+	AuditAlertEscalationStopped  AuditAction = "ALERT_ESCALATION_STOPPED"
+	AuditEscalationPolicyCreated AuditAction = "CREATE_ESCALATION_POLICY"
+	AuditEscalationPolicyUpdated AuditAction = "UPDATE_ESCALATION_POLICY"
+	AuditEscalationPolicyDeleted AuditAction = "DELETE_ESCALATION_POLICY"
+
+	// Plataforma
+	AuditOrganizationCreated AuditAction = "CREATE_ORGANIZATION"
+	AuditOrganizationUpdated AuditAction = "UPDATE_ORGANIZATION"
+	AuditOrganizationDeleted AuditAction = "DELETE_ORGANIZATION"
+	AuditSSOProviderCreated  AuditAction = "CREATE_SSO_PROVIDER"
+	AuditSSOProviderUpdated  AuditAction = "UPDATE_SSO_PROVIDER"
+	AuditSSOProviderDeleted  AuditAction = "DELETE_SSO_PROVIDER"
+	AuditTemplateUpdated     AuditAction = "UPDATE_NOTIFICATION_TEMPLATE"
+	AuditVerified            AuditAction = "AUDIT_VERIFIED"
+)
+
+// Tipos de alvo
+const (
+	TargetUser             = "user"
+	TargetSession          = "session"
+	TargetAPIKey           = "api_key"
+	TargetDevice           = "device"
+	TargetGroup            = "device_group"
+	TargetGeofence         = "geofence"
+	TargetAlert            = "alert"
+	TargetAlertRule        = "alert_rule"
+	TargetEscalationPolicy = "escalation_policy"
+	TargetOrganization     = "organization"
+	TargetSSOProvider      = "sso_provider"
+	TargetTemplate         = "notification_template"
+	TargetAuditChain       = "audit_chain"
+)
+
+// AuditTarget: O que a ação afetou
+type AuditTarget struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func auditTarget(kind string, id interface{}) AuditTarget {
+	return AuditTarget{Type: kind, ID: fmt.Sprint(id)}
+}
+
+// FieldChange: Valor antes e depois (nil = ausente)
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type AuditChanges map[string]FieldChange
+
+// AuditEvent: Autor em branco é preenchido a partir da requisição autenticada.
+// OrgID em branco é a organização do alvo (ver auditOrg); quem apaga o alvo
+// informa a organização que ele tinha.
+type AuditEvent struct {
+	ActorID int
+	Actor   string
+	Action  AuditAction
+	Target  AuditTarget
+	Details string
+	Changes AuditChanges
+	OrgID   int
+}
+
+// recordAudit: Completa o evento com os dados da requisição (r nil = evento do sistema) e grava
+func recordAudit(r *http.Request, ev AuditEvent) {
+	entry := auditchain.Entry{
+		UserID:     ev.ActorID,
+		Username:   ev.Actor,
+		Action:     string(ev.Action),
+		TargetType: ev.Target.Type,
+		TargetID:   ev.Target.ID,
+		Details:    ev.Details,
+	}
+	if r != nil {
+		if entry.UserID == 0 && entry.Username == "" {
+			entry.UserID, _ = strconv.Atoi(r.Header.Get("X-User-ID"))
+			entry.Username = actorName(r)
+		}
+		entry.IP = clientIP(r)
+		entry.RequestID = r.Header.Get("X-Request-ID")
+		entry.UserAgent = r.UserAgent()
+	}
+	if entry.Username == "" {
+		entry.Username = "Sistema"
+	}
+	entry.OrgID = auditOrg(r, ev, entry.UserID)
+	if len(ev.Changes) > 0 {
+		raw, _ := json.Marshal(ev.Changes)
+		entry.Changes = string(raw)
+	}
+
+	log.Printf("[AUDIT] User: %s | Action: %s | Target: %s/%s | Det: %s | Req: %s",
+		entry.Username, entry.Action, entry.TargetType, entry.TargetID, entry.Details, entry.RequestID)
+	go func() {
+		// Encadeado ao registro anterior (ver audit_chain.go)
+		if err := auditChain.Append(entry); err != nil {
+			log.Printf("ERRO CRÍTICO AO GRAVAR LOG: %v", err)
+		}
+	}()
+}
+
+// auditOrg: Organização que enxerga o evento: a do alvo, senão a do autor.
+// 0 = evento da plataforma (só quem vê todas as organizações).
+func auditOrg(r *http.Request, ev AuditEvent, actorID int) int {
+	if ev.OrgID != 0 {
+		return ev.OrgID
+	}
+	if orgID, ok := auditTargetOrg(ev.Target); ok {
+		return orgID
+	}
+	if actorID != 0 {
+		if orgID, err := recordOrg("users", actorID); err == nil {
+			return orgID
+		}
+	}
+	if r != nil {
+		return orgOf(r)
+	}
+	return 0
+}
+
+// auditTargetOrg: Organização atual do alvo (ok = false se ele não existe ou não tem dono)
+func auditTargetOrg(t AuditTarget) (int, bool) {
+	id, idErr := strconv.Atoi(t.ID)
+	var orgID int
+	var err error
+	switch t.Type {
+	case TargetOrganization:
+		orgID, err = id, idErr
+	case TargetUser:
+		orgID, err = recordOrg("users", id)
+	case TargetDevice:
+		orgID, err = recordOrg("devices", id)
+	case TargetGroup:
+		orgID, err = recordOrg("device_groups", id)
+	case TargetGeofence:
+		orgID, err = recordOrg("geofences", id)
+	case TargetEscalationPolicy:
+		orgID, err = recordOrg("escalation_policies", id)
+	case TargetSSOProvider:
+		orgID, err = recordOrg("sso_providers", id)
+	case TargetAlertRule:
+		orgID, err = storedRuleOrg(id)
+	case TargetAlert:
+		err = db.QueryRow(`SELECT COALESCE(d.organization_id, 0) FROM alerts a JOIN devices d ON d.id = a.device_id
+			WHERE a.id = ?`, t.ID).Scan(&orgID)
+	case TargetSession:
+		err = db.QueryRow(`SELECT COALESCE(u.organization_id, 0) FROM sessions s JOIN users u ON u.id = s.user_id
+			WHERE s.id = ?`, t.ID).Scan(&orgID)
+	case TargetAPIKey:
+		err = db.QueryRow(`SELECT COALESCE(u.organization_id, k.organization_id, 0) FROM api_keys k
+			LEFT JOIN users u ON u.id = k.user_id WHERE k.id = ?`, t.ID).Scan(&orgID)
+	case TargetTemplate, TargetAuditChain:
+		// Configuração da plataforma
+		return 0, true
+	default:
+		return 0, false
+	}
+	return orgID, err == nil
+}
+
+// usernameOf: Autor de eventos sem usuário autenticado na requisição (login, refresh)
+func usernameOf(userID int) string {
+	var username string
+	if err := db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		return "User:" + strconv.Itoa(userID)
+	}
+	return username
+}
+
+// --- DIFF ---
+
+// secretField: Campos que nunca entram no diff (senhas, segredos, tokens, hashes)
+func secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "secret", "token", "hash", "recovery"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffChanges: Campos (pelas tags JSON) que mudaram entre before e after
+func diffChanges(before, after interface{}) AuditChanges {
+	a, b := jsonFields(before), jsonFields(after)
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	changes := make(AuditChanges)
+	for _, k := range names {
+		if secretField(k) || reflect.DeepEqual(a[k], b[k]) {
+			continue
+		}
+		changes[k] = FieldChange{From: a[k], To: b[k]}
+	}
+	return changes
+}
+
+// jsonFields: Valor como mapa de campos JSON (nil vira mapa vazio)
+func jsonFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(raw, &fields)
+	return fields
+}
+
+// --- ID DA REQUISIÇÃO ---
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID: Aceita o X-Request-ID do proxy (se bem formado) ou gera um; devolvido na resposta
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"iot_modulo1.0/pkg/auditchain"
+)
+
+// --- INTEGRIDADE DA AUDITORIA ---
+// recordAudit (audit.go) grava pela cadeia (pkg/auditchain). A chave de assinatura
+// dos checkpoints vem de AUDIT_SIGNING_KEY (semente Ed25519 em base64); sem
+// ela, é derivada de JWT_SECRET, o que dispensa configuração mas deixa quem
+// conhece o segredo do JWT capaz de assinar checkpoints.
+
+var auditChain *auditchain.Chain
+
+const defaultAuditCheckpointInterval = time.Hour
+
+// initAuditChain: Chave de assinatura e checkpoint periódico (AUDIT_CHECKPOINT_INTERVAL, ex: "30m")
+func initAuditChain() {
+	key := auditchain.DeriveKey(string(jwtKey))
+	if raw := os.Getenv("AUDIT_SIGNING_KEY"); raw != "" {
+		parsed, err := auditchain.ParsePrivateKey(raw)
+		if err != nil {
+			log.Fatal("AUDIT_SIGNING_KEY inválida: ", err)
+		}
+		key = parsed
+	} else {
+		log.Println("Aviso: AUDIT_SIGNING_KEY não configurada; checkpoints da auditoria assinados com chave derivada do JWT_SECRET.")
+	}
+	auditChain = auditchain.NewChain(db, key)
+	log.Printf("Auditoria encadeada. Chave pública dos checkpoints: %s", auditChain.PublicKey())
+
+	interval := defaultAuditCheckpointInterval
+	if raw := os.Getenv("AUDIT_CHECKPOINT_INTERVAL"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d >= time.Minute {
+			interval = d
+		} else {
+			log.Printf("Aviso: AUDIT_CHECKPOINT_INTERVAL inválido (%q), usando %s", raw, interval)
+		}
+	}
+	go auditChain.Run(interval)
+}
+
+// auditVerifyHandler: GET percorre a cadeia e informa o primeiro elo quebrado.
+// ?checkpoint=1 assina a cabeça atual antes de responder.
+func auditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("checkpoint") == "1" {
+		if _, err := auditChain.Checkpoint(); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	report, err := auditChain.Verify()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	details := "Cadeia íntegra"
+	if !report.OK {
+		details = "Cadeia quebrada: " + report.FirstBroken.Reason
+	}
+	recordAudit(r, AuditEvent{Action: AuditVerified, Target: auditTarget(TargetAuditChain, report.Entries), Details: details})
+
+	json.NewEncoder(w).Encode(struct {
+		auditchain.Report
+		PublicKey string `json:"public_key"`
+	}{report, auditChain.PublicKey()})
+}
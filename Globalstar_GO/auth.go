@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// --- TOKENS DE ACESSO E REFRESH ---
+// O access token (JWT) vive poucos minutos e carrega a token_version do usuário;
+// authMiddleware compara com a do banco, então incrementar a versão derruba na
+// hora todos os tokens emitidos (troca de papel, senha redefinida, "sair de tudo").
+// O refresh token é opaco, guardado só como hash e trocado a cada uso.
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var errTokenRevoked = errors.New("token revogado")
+
+// hashToken: SHA-256 em hex (refresh tokens nunca são guardados em claro)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens: Gera o par access/refresh. familyID vazio inicia uma nova família
+// (login), que é também a sessão listada em /api/sessions.
+func issueTokens(r *http.Request, userID int, role string, version int, familyID string) (access, refresh string, err error) {
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+	if err := recordSession(r, familyID, userID); err != nil {
+		return "", "", err
+	}
+
+	claims := &Claims{
+		UserID:       userID,
+		Role:         role,
+		TokenVersion: version,
+		SessionID:    familyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	access, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	refresh = base64.RawURLEncoding.EncodeToString(buf)
+
+	_, err = db.Exec("INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES (?, ?, ?, ?)",
+		userID, hashToken(refresh), familyID, time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// tokenResponse: Corpo comum das respostas que entregam tokens
+func tokenResponse(access, refresh, role, username, fullName string) map[string]interface{} {
+	return map[string]interface{}{
+		"token":         access,
+		"refresh_token": refresh,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"role":          role,
+		"username":      username,
+		"full_name":     fullName,
+		"permissions":   permissionsOf(role),
+	}
+}
+
+// writeTokens: Resposta comum do login e do refresh
+func writeTokens(w http.ResponseWriter, access, refresh, role, username, fullName string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenResponse(access, refresh, role, username, fullName))
+}
+
+// authenticate: Assinatura/expiração do JWT + versão atual do usuário no banco
+func authenticate(tokenStr string) (*Claims, error) {
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	var version int
+	var sessionActive bool
+	// Usuário removido também cai aqui. Tokens sem sid (emitidos antes das
+	// sessões) valem até expirar.
+	err = db.QueryRow(`SELECT u.token_version, COALESCE(u.organization_id, 0), u.username, s.id IS NOT NULL AND s.revoked_at IS NULL
+		FROM users u LEFT JOIN sessions s ON s.id = ? AND s.user_id = u.id WHERE u.id = ?`, claims.SessionID, claims.UserID).
+		Scan(&version, &claims.OrgID, &claims.Username, &sessionActive)
+	if err != nil {
+		return nil, errTokenRevoked
+	}
+	if version != claims.TokenVersion || (claims.SessionID != "" && !sessionActive) {
+		return nil, errTokenRevoked
+	}
+	return claims, nil
+}
+
+// revokeUserTokens: Invalida todos os tokens do usuário e derruba as conexões em tempo real
+func revokeUserTokens(userID int) {
+	db.Exec("UPDATE users SET token_version = token_version + 1 WHERE id = ?", userID)
+	db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userID)
+	db.Exec("UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userID)
+	hub.DisconnectUser(userID)
+}
+
+// refreshHandler: Troca um refresh token válido por um novo par (POST /api/auth/refresh)
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var id, userID int
+	var familyID string
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err := db.QueryRow("SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = ?",
+		hashToken(req.RefreshToken)).Scan(&id, &userID, &familyID, &expiresAt, &revokedAt)
+	if err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+
+	if revokedAt.Valid {
+		// Token já trocado sendo reapresentado: provável roubo, encerra a família
+		revokeSession(familyID, userID)
+		recordAudit(r, AuditEvent{ActorID: userID, Actor: usernameOf(userID), Action: AuditRefreshTokenReuse,
+			Target: auditTarget(TargetSession, familyID), Details: "Refresh token reutilizado; sessão encerrada"})
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(expiresAt) {
+		http.Error(w, "Token expirado", http.StatusUnauthorized)
+		return
+	}
+
+	// Condicional: de duas trocas simultâneas, só uma vence
+	res, err := db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		http.Error(w, "Erro ao renovar sessão", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+
+	// Papel e versão vêm do banco: mudanças valem a partir deste token
+	var username, role, fullName string
+	var version int
+	var totpEnabled bool
+	err = db.QueryRow("SELECT username, role, full_name, token_version, totp_enabled FROM users WHERE id = ?", userID).
+		Scan(&username, &role, &fullName, &version, &totpEnabled)
+	if err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+	// Papel passou a exigir 2FA (ou o 2FA foi resetado): volta para o login
+	if mfaRequiredRoles[role] && !totpEnabled {
+		http.Error(w, "2FA obrigatório, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+
+	access, refresh, err := issueTokens(r, userID, role, version, familyID)
+	if err != nil {
+		http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, access, refresh, role, username, fullName)
+}
+
+// logoutHandler: Revoga a sessão do refresh token informado, ou todas com "all"
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+		All          bool   `json:"all"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	if req.All {
+		revokeUserTokens(userID)
+		recordAudit(r, AuditEvent{Action: AuditLogoutAll, Target: auditTarget(TargetUser, userID), Details: "Encerrou todas as sessões"})
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	target := auditTarget(TargetSession, r.Header.Get("X-Session-ID"))
+	if req.RefreshToken != "" {
+		var familyID string
+		err := db.QueryRow("SELECT family_id FROM refresh_tokens WHERE token_hash = ? AND user_id = ?",
+			hashToken(req.RefreshToken), userID).Scan(&familyID)
+		if err == nil {
+			revokeSession(familyID, userID)
+			target = auditTarget(TargetSession, familyID)
+		}
+	}
+	recordAudit(r, AuditEvent{Action: AuditLogout, Target: target, Details: "Logout (sessão encerrada)"})
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+
+	"iot_modulo1.0/pkg/auditchain"
+	"iot_modulo1.0/pkg/realtime"
+)
+
+// expectAudit: A próxima gravação na auditoria precisa ser desta ação. Devolve a
+// espera pela gravação (recordAudit grava em segundo plano).
+func expectAudit(t *testing.T, action AuditAction) (wait func()) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := auditChain
+	auditChain = auditchain.NewChain(conn, nil)
+	auditChain.Logf = func(string, ...interface{}) {}
+	t.Cleanup(func() { auditChain = old; conn.Close() })
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT last_seq, last_hash FROM audit_chain_head").
+		WillReturnRows(sqlmock.NewRows([]string{"last_seq", "last_hash"}).AddRow(0, ""))
+	arg := sqlmock.AnyArg()
+	// Conferida a ação, o resto da gravação não interessa ao teste
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(arg, arg, string(action), arg, arg, arg, arg, arg, arg, arg, arg).
+		WillReturnError(errors.New("fim do teste"))
+	mock.ExpectRollback()
+
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for mock.ExpectationsWereMet() != nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("auditoria sem %s: %v", action, mock.ExpectationsWereMet())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// runningHub: Hub em memória rodando (revogar uma sessão derruba as conexões dela)
+func runningHub(t *testing.T) {
+	old := hub
+	hub = realtime.NewHub(func(int) map[int]bool { return nil }, nil)
+	hub.Bus = realtime.NewMemoryPubSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	go hub.Run(ctx)
+	t.Cleanup(func() { cancel(); hub = old })
+}
+
+func testJWTKey(t *testing.T) {
+	old := jwtKey
+	jwtKey = []byte("segredo-dos-testes")
+	t.Cleanup(func() { jwtKey = old })
+}
+
+func refresh(token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`))
+	w := httptest.NewRecorder()
+	refreshHandler(w, r)
+	return w
+}
+
+func expectRefreshLookup(mock sqlmock.Sqlmock, token string, revokedAt interface{}) {
+	mock.ExpectQuery("SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = \\?").
+		WithArgs(hashToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+			AddRow(5, 9, "familia-1", time.Now().Add(time.Hour), revokedAt))
+}
+
+func TestRefreshRotatesOnceAndRevokesOnReuse(t *testing.T) {
+	mock := mockDB(t)
+	testJWTKey(t)
+	runningHub(t)
+
+	// Primeira troca: o token vira revogado e a família ganha um novo par
+	expectRefreshLookup(mock, "antigo", nil)
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE id = \\? AND revoked_at IS NULL").WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT username, role, full_name, token_version, totp_enabled FROM users").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "role", "full_name", "token_version", "totp_enabled"}).
+			AddRow("joao", RoleUser, "João", 3, false))
+	mock.ExpectExec("INSERT INTO sessions").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_tokens").WithArgs(9, sqlmock.AnyArg(), "familia-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(6, 1))
+
+	w := refresh("antigo")
+	var body map[string]interface{}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil {
+		t.Fatalf("primeira troca: status %d (%s)", w.Code, w.Body.String())
+	}
+	if body["refresh_token"] == "antigo" || body["refresh_token"] == "" {
+		t.Fatalf("refresh token não foi trocado: %v", body["refresh_token"])
+	}
+	claims, err := parseToken(body["token"].(string))
+	if err != nil || claims.SessionID != "familia-1" || claims.TokenVersion != 3 {
+		t.Fatalf("access token da mesma sessão e versão esperado: %+v (%v)", claims, err)
+	}
+
+	// Reapresentar o token já trocado encerra a sessão inteira e vai para a auditoria
+	waitAudit := expectAudit(t, AuditRefreshTokenReuse)
+	expectRefreshLookup(mock, "antigo", time.Now())
+	mock.ExpectExec("UPDATE sessions SET revoked_at = NOW\\(\\) WHERE id = \\?").WithArgs("familia-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE family_id = \\?").WithArgs("familia-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT username FROM users WHERE id = \\?").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("joao"))
+	mock.ExpectQuery("FROM sessions s JOIN users u").WithArgs("familia-1").
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(2))
+
+	if w := refresh("antigo"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reuso: status %d, esperado 401", w.Code)
+	}
+	waitAudit()
+}
+
+func TestAuthenticateRejectsStaleVersionAndRevokedSession(t *testing.T) {
+	mock := mockDB(t)
+	testJWTKey(t)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 9, Role: RoleUser, TokenVersion: 3, SessionID: "familia-1",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}}).SignedString(jwtKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name          string
+		version       int
+		sessionActive bool
+		ok            bool
+	}{
+		{"válido", 3, true, true},
+		{"versão antiga (senha trocada, logout geral)", 4, true, false},
+		{"sessão revogada", 3, false, false},
+	}
+	for _, c := range cases {
+		mock.ExpectQuery("SELECT u.token_version").WithArgs("familia-1", 9).
+			WillReturnRows(sqlmock.NewRows([]string{"token_version", "organization_id", "username", "active"}).
+				AddRow(c.version, 2, "joao", c.sessionActive))
+		claims, err := authenticate(token)
+		if c.ok && (err != nil || claims.OrgID != 2 || claims.Username != "joao") {
+			t.Fatalf("%s: %+v (%v)", c.name, claims, err)
+		}
+		if !c.ok && !errors.Is(err, errTokenRevoked) {
+			t.Fatalf("%s: aceito, esperado errTokenRevoked (%v)", c.name, err)
+		}
+	}
+}
+
+func TestRefreshRejectsExpiredToken(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("FROM refresh_tokens WHERE token_hash = \\?").WithArgs(hashToken("velho")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+			AddRow(5, 9, "familia-1", time.Now().Add(-time.Minute), nil))
+	if w := refresh("velho"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, esperado 401", w.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+
+	"iot_modulo1.0/pkg/auditchain"
+)
+
+// Verifica a cadeia de auditoria direto no banco (mesmas variáveis DB_* do backend):
+//
+//	go run ./cmd/auditverify [-json]
+//
+// A chave vem de AUDIT_PUBLIC_KEY (auditor sem acesso à chave privada), de
+// AUDIT_SIGNING_KEY ou, na falta das duas, é derivada de JWT_SECRET.
+// Sai com código 1 se a cadeia estiver quebrada.
+func main() {
+	asJSON := flag.Bool("json", false, "imprime o relatório em JSON")
+	flag.Parse()
+	_ = godotenv.Load()
+
+	pub, err := publicKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
+		os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"))
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Fatal("Erro driver MySQL:", err)
+	}
+	defer db.Close()
+
+	report, err := auditchain.NewVerifier(db, pub).Verify()
+	if err != nil {
+		log.Fatal("Erro ao verificar a cadeia:", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReport(report)
+	}
+	if !report.OK {
+		os.Exit(1)
+	}
+}
+
+func publicKey() (ed25519.PublicKey, error) {
+	if raw := os.Getenv("AUDIT_PUBLIC_KEY"); raw != "" {
+		return auditchain.ParsePublicKey(raw)
+	}
+	if raw := os.Getenv("AUDIT_SIGNING_KEY"); raw != "" {
+		key, err := auditchain.ParsePrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return auditchain.DeriveKey(secret).Public().(ed25519.PublicKey), nil
+	}
+	return nil, fmt.Errorf("informe AUDIT_PUBLIC_KEY, AUDIT_SIGNING_KEY ou JWT_SECRET")
+}
+
+func printReport(rep auditchain.Report) {
+	fmt.Printf("Registros encadeados: %d (anteriores à cadeia: %d)\n", rep.Entries, rep.Legacy)
+	fmt.Printf("Checkpoints: %d (chave %s)\n", rep.Checkpoints, rep.KeyID)
+	if cp := rep.LastCheckpoint; cp != nil {
+		fmt.Printf("Último checkpoint válido: seq %d em %s\n", cp.Seq, cp.SignedAt.Format("02/01/2006 15:04:05"))
+	}
+	if rep.OK {
+		fmt.Println("OK: cadeia íntegra")
+		return
+	}
+	b := rep.FirstBroken
+	fmt.Printf("QUEBRADA em seq %d", b.Seq)
+	if b.LogID > 0 {
+		fmt.Printf(" (audit_logs.id %d)", b.LogID)
+	}
+	fmt.Printf(": %s\n", b.Reason)
+}
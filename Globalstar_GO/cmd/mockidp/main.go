@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"iot_modulo1.0/pkg/oidc"
+)
+
+// IdP OIDC de teste para o login SSO em desenvolvimento:
+//
+//	go run ./cmd/mockidp
+//
+// Cadastre um provedor com emissor MOCK_IDP_ISSUER e client_id MOCK_IDP_CLIENT_ID.
+// O backend precisa de SSO_DEV_LOCAL_ISSUER=true para aceitar o emissor http local.
+func main() {
+	addr := getenv("MOCK_IDP_ADDR", ":9000")
+	issuer := getenv("MOCK_IDP_ISSUER", "http://localhost:9000")
+	clientID := getenv("MOCK_IDP_CLIENT_ID", "iotdata")
+
+	idp, err := oidc.NewMockIdP(issuer, clientID)
+	if err != nil {
+		log.Fatal("Erro ao gerar chave do IdP:", err)
+	}
+	log.Printf("Mock IdP em %s (emissor %s, client_id %s)", addr, issuer, clientID)
+	log.Fatal(http.ListenAndServe(addr, idp))
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
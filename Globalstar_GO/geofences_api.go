@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"iot_modulo1.0/pkg/geofence"
+)
+
+// Avaliador de geofences (posições decodificadas no pipeline da Globalstar)
+var geofences *geofence.Evaluator
+
+// geofenceEventsHandler: Entradas/saídas dos dispositivos visíveis ao usuário (?device_id=)
+func geofenceEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	filter := geofence.EventFilter{UserID: userID, OrgID: orgOf(r), AllOrgs: crossTenant(r)}
+	if can(r, PermDevicesReadAll) {
+		filter.UserID = 0
+	}
+	filter.DeviceID, _ = strconv.Atoi(r.URL.Query().Get("device_id"))
+	filter.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+
+	events, err := geofences.Events(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// geofencesHandler: GET lista as cercas, POST cria/atualiza (geofences:manage)
+func geofencesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		list, err := geofences.List()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		visible := make([]geofence.Fence, 0, len(list))
+		for _, f := range list {
+			if inScope(r, f.OrgID) {
+				visible = append(visible, f)
+			}
+		}
+		json.NewEncoder(w).Encode(visible)
+		return
+	}
+
+	var f geofence.Fence
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	action := AuditGeofenceCreated
+	var before interface{}
+	if f.ID > 0 {
+		action = AuditGeofenceUpdated
+		if list, err := geofences.List(); err == nil {
+			for _, old := range list {
+				if old.ID == f.ID {
+					before = old
+				}
+			}
+		}
+	}
+	orgID, ok := ownerOrg(r, "geofences", f.ID, f.OrgID)
+	if !ok {
+		http.Error(w, "Geofence ou organização não encontrada", http.StatusNotFound)
+		return
+	}
+	f.OrgID = orgID
+	if !allInOrg("devices", f.DeviceIDs, orgID) || !allInOrg("device_groups", f.GroupIDs, orgID) {
+		http.Error(w, "Dispositivo ou grupo de outra organização na geofence", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := geofences.Save(f)
+	if errors.Is(err, geofence.ErrInvalidGeoJSON) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao salvar geofence: %v", err)
+		http.Error(w, "Erro ao salvar geofence", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetGeofence, saved.ID),
+		Details: fmt.Sprintf("Geofence %d (%s): %d dispositivos, %d grupos", saved.ID, saved.Name, len(saved.DeviceIDs), len(saved.GroupIDs)),
+		Changes: diffChanges(before, saved)})
+
+	json.NewEncoder(w).Encode(saved)
+}
+
+func deleteGeofenceHandler(w http.ResponseWriter, r *http.Request) {
+	var f geofence.Fence
+	json.NewDecoder(r.Body).Decode(&f)
+	orgID, err := recordOrg("geofences", f.ID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Geofence não encontrada", http.StatusNotFound)
+		return
+	}
+
+	if err := geofences.Delete(f.ID); err != nil {
+		http.Error(w, "Erro ao remover geofence", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditGeofenceDeleted, Target: auditTarget(TargetGeofence, f.ID), Details: fmt.Sprintf("Deletou geofence ID %d", f.ID), OrgID: orgID})
+
+	w.WriteHeader(http.StatusOK)
+}
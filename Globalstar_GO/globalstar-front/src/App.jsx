@@ -5,6 +5,7 @@ import LandingPageIoT from './LandingPageIoT';
 import LandingPageIgam from './LandingPageIgam';
 import ForgotPassword from './ForgotPassword';
 import ResetPassword from './ResetPassword';
+import VerifyEmail from './VerifyEmail';
 
 function App() {
   return (
@@ -13,8 +14,11 @@ function App() {
         <Route path="/" element={<LandingPageIoT />} />
         <Route path="/agro-igam" element={<LandingPageIgam />} />
         <Route path="/login" element={<Login />} />
+        <Route path="/sso" element={<Login />} />
         <Route path="/forgot-password" element={<ForgotPassword />} />
         <Route path="/reset-password" element={<ResetPassword />} />
+        <Route path="/activate" element={<ResetPassword invite />} />
+        <Route path="/verify-email" element={<VerifyEmail />} />
         <Route path="/dashboard" element={<Dashboard />} />
       </Routes>
     </BrowserRouter>
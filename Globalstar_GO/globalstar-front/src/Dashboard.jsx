@@ -2,17 +2,22 @@ import React, { useEffect, useState } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { Smartphone, XCircle } from 'lucide-react';
 
-import api from './services/api';
+import api, { refreshSession } from './services/api';
+import { passwordPolicyMessage } from './services/passwordPolicy';
 import MonitorTab from './components/Dashboard/MonitorTab';
 import UsersTab from './components/Dashboard/UsersTab';
 import LinksTab from './components/Dashboard/LinksTab';
 import AuditTab from './components/Dashboard/AuditTab';
+import ApiKeysTab from './components/Dashboard/ApiKeysTab';
+import SsoTab from './components/Dashboard/SsoTab';
+import SessionsTab from './components/Dashboard/SessionsTab';
+import ProfileTab from './components/Dashboard/ProfileTab';
 import UserModal from './components/Dashboard/UserModal';
 
 export default function Dashboard() {
   const [activeTab, setActiveTab] = useState('monitor');
   const [messages, setMessages] = useState([]);
-  const [masterData, setMasterData] = useState({ users: [], devices: [] });
+  const [masterData, setMasterData] = useState({ users: [], devices: [], organizations: [] });
   const [isModalOpen, setIsModalOpen] = useState(false);
   const [editingUser, setEditingUser] = useState(null);
 
@@ -24,6 +29,9 @@ export default function Dashboard() {
 
   const navigate = useNavigate();
   const role = localStorage.getItem('role');
+  // Permissões do papel (rbac.go no backend); a interface só mostra o que é permitido
+  const permissions = JSON.parse(localStorage.getItem('permissions') || '[]');
+  const can = (permission) => permissions.includes(permission);
   const token = localStorage.getItem('token');
   const currentUser = localStorage.getItem('user');
   const fullName = localStorage.getItem('full_name') || currentUser;
@@ -32,38 +40,79 @@ export default function Dashboard() {
     if (!token) { navigate('/'); return; }
 
     fetchMessages();
-    if (role === 'master') fetchMasterData();
+    if (can('users:read')) fetchMasterData();
 
     // --- WEBSOCKET AGNOSTICO (Lê da URL do Navegador) ---
     const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
     const wsHost = window.location.host; // Pega o IP/Porta automaticamente
     const wsUrl = `${wsProtocol}//${wsHost}/ws`;
 
-    const socket = new WebSocket(wsUrl);
-
-    socket.onopen = () => console.log("WebSocket Conectado!");
-
-    socket.onmessage = (event) => {
-      try {
-        const data = JSON.parse(event.data);
-        if (data.type === 'DEVICE_UPDATE') {
-          setMessages(prev => prev.map(m => m.esn === data.esn ? { ...m, device_name: data.name } : m));
-          if (role === 'master') {
-            setMasterData(prev => ({
-              ...prev,
-              devices: prev.devices.map(d => d.esn === data.esn ? { ...d, name: data.name } : d)
-            }));
-          }
-        } else if (data.esn && data.payload) {
-          setMessages(prev => [data, ...prev]);
+    // Último event_id recebido: na reconexão o servidor reenvia o que foi perdido
+    let lastEventId = 0;
+    let socket;
+    let reconnectTimer;
+    let closed = false;
+
+    const handleEvent = (data) => {
+      if (data.type === 'RESYNC_REQUIRED') {
+        // Lacuna maior que o buffer do servidor: recarrega pela API
+        fetchMessages();
+        if (can('users:read')) fetchMasterData();
+        return;
+      }
+      if (data.type === 'DEVICE_UPDATE') {
+        setMessages(prev => prev.map(m => m.esn === data.esn ? { ...m, device_name: data.name } : m));
+        if (can('users:read')) {
+          setMasterData(prev => ({
+            ...prev,
+            devices: prev.devices.map(d => d.esn === data.esn ? { ...d, name: data.name } : d)
+          }));
         }
-      } catch (err) {
-        console.error("Erro ao processar mensagem WS:", err);
+      } else if (data.esn && data.payload) {
+        setMessages(prev => prev.some(m => m.id === data.id) ? prev : [data, ...prev]);
       }
     };
 
-    socket.onclose = () => console.log("WebSocket Desconectado");
-    return () => socket.close();
+    const connect = () => {
+      if (closed) return;
+      const url = lastEventId ? `${wsUrl}?resume_from=${lastEventId}` : wsUrl;
+      // Autentica o handshake com o JWT via subprotocolo (não aparece na URL/logs)
+      // Lê o token a cada conexão: ele é renovado pelo interceptor da API
+      socket = new WebSocket(url, ['bearer', localStorage.getItem('token')]);
+
+      // O servidor só envia os tópicos inscritos
+      socket.onopen = () => {
+        console.log("WebSocket Conectado!");
+        socket.send(JSON.stringify({
+          action: 'subscribe',
+          topics: ['event:NEW_MESSAGE', 'event:DEVICE_UPDATE']
+        }));
+      };
+
+      socket.onmessage = (event) => {
+        try {
+          const data = JSON.parse(event.data);
+          if (data.event_id) lastEventId = data.event_id;
+          else if (data.type === 'SUBSCRIPTIONS' && !lastEventId) lastEventId = data.last_event_id;
+          handleEvent(data);
+        } catch (err) {
+          console.error("Erro ao processar mensagem WS:", err);
+        }
+      };
+
+      socket.onclose = () => {
+        console.log("WebSocket Desconectado");
+        // Renova o access token antes de reconectar (ele pode ter expirado)
+        if (!closed) reconnectTimer = setTimeout(() => refreshSession().catch(() => {}).finally(connect), 3000);
+      };
+    };
+
+    connect();
+    return () => {
+      closed = true;
+      clearTimeout(reconnectTimer);
+      socket.close();
+    };
   }, [token, role, navigate]);
 
   const fetchMessages = async () => {
@@ -84,7 +133,9 @@ export default function Dashboard() {
       if (res.data && typeof res.data === 'object' && !Array.isArray(res.data)) {
         setMasterData({
           users: Array.isArray(res.data.users) ? res.data.users : [],
-          devices: Array.isArray(res.data.devices) ? res.data.devices : []
+          devices: Array.isArray(res.data.devices) ? res.data.devices : [],
+          // Só vem para o master (organizations:manage)
+          organizations: Array.isArray(res.data.organizations) ? res.data.organizations : []
         });
       }
     } catch (e) { console.error(e); }
@@ -96,13 +147,14 @@ export default function Dashboard() {
     const data = Object.fromEntries(formData.entries());
     if (editingUser) data.id = editingUser.id;
     if (data.id) data.id = parseInt(data.id);
+    if (data.organization_id !== undefined) data.organization_id = parseInt(data.organization_id) || 0;
 
     try {
       await api.post('/api/master/user', data);
-      alert(editingUser ? 'Usuário atualizado!' : 'Usuário criado!');
+      alert(editingUser ? 'Usuário atualizado!' : `Usuário criado! Convite enviado para ${data.email}.`);
       setIsModalOpen(false);
       fetchMasterData();
-    } catch (err) { alert("Erro ao salvar usuário"); }
+    } catch (err) { alert(passwordPolicyMessage(err) || (typeof err.response?.data === 'string' && err.response.data) || "Erro ao salvar usuário"); }
   };
 
   const handleDeleteUser = async (id) => {
@@ -113,9 +165,32 @@ export default function Dashboard() {
     } catch (err) { alert("Erro ao excluir"); }
   };
 
-  const handlePermission = async (userId, deviceId, action) => {
+  const handleUnlockUser = async (id) => {
     try {
-      await api.post('/api/master/permission', { user_id: parseInt(userId), device_id: parseInt(deviceId), action });
+      await api.post('/api/master/user/unlock', { id });
+      fetchMasterData();
+    } catch (err) { alert("Erro ao desbloquear usuário"); }
+  };
+
+  const handleResendInvite = async (id) => {
+    try {
+      await api.post('/api/master/user/invite/resend', { id });
+      alert('Convite reenviado! O link anterior deixou de valer.');
+      fetchMasterData();
+    } catch (err) { alert(err.response?.data || "Erro ao reenviar convite"); }
+  };
+
+  const handleRevokeInvite = async (id) => {
+    if (!confirm("Revogar o convite? O link enviado deixará de funcionar.")) return;
+    try {
+      await api.post('/api/master/user/invite/revoke', { id });
+      fetchMasterData();
+    } catch (err) { alert(err.response?.data || "Erro ao revogar convite"); }
+  };
+
+  const handlePermission = async (userId, deviceId, action, level) => {
+    try {
+      await api.post('/api/master/permission', { user_id: parseInt(userId), device_id: parseInt(deviceId), action, level });
       fetchMasterData();
     } catch (err) { alert("Erro ao atualizar permissão"); }
   };
@@ -142,7 +217,13 @@ export default function Dashboard() {
     });
   };
 
-  const logout = () => {
+  const logout = async () => {
+    try {
+      // Revoga o refresh token no servidor (o access token expira sozinho)
+      await api.post('/api/logout', { refresh_token: localStorage.getItem('refresh_token') });
+    } catch (err) {
+      console.error("Erro ao encerrar sessão:", err);
+    }
     localStorage.clear();
     navigate('/');
   };
@@ -175,18 +256,25 @@ export default function Dashboard() {
             </div>
           </div>
           <div className="flex items-center gap-4">
-            {(role === 'master' || role === 'support') && (
-              <nav className="flex bg-gray-100 p-1 rounded-lg">
-                <button onClick={() => setActiveTab('monitor')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'monitor' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Monitor</button>
-                {role === 'master' && (
-                  <>
-                    <button onClick={() => setActiveTab('users')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'users' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Usuários</button>
-                    <button onClick={() => setActiveTab('links')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'links' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Vínculos</button>
-                  </>
-                )}
+            {/* Todos têm ao menos Monitor e Integrações (chaves de API pessoais) */}
+            <nav className="flex bg-gray-100 p-1 rounded-lg">
+              <button onClick={() => setActiveTab('monitor')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'monitor' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Monitor</button>
+              {can('users:manage') && (
+                <>
+                  <button onClick={() => setActiveTab('users')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'users' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Usuários</button>
+                  <button onClick={() => setActiveTab('links')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'links' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Vínculos</button>
+                </>
+              )}
+              {can('audit:read') && (
                 <button onClick={() => setActiveTab('audit')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'audit' ? 'bg-white shadow text-purple-600' : 'text-gray-500 hover:text-gray-900'}`}>Auditoria</button>
-              </nav>
-            )}
+              )}
+              <button onClick={() => setActiveTab('apikeys')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'apikeys' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Integrações</button>
+              <button onClick={() => setActiveTab('sessions')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'sessions' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Sessões</button>
+              <button onClick={() => setActiveTab('profile')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'profile' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>Minha conta</button>
+              {can('sso:manage') && (
+                <button onClick={() => setActiveTab('sso')} className={`px-3 py-1.5 rounded-md text-sm font-medium transition-all ${activeTab === 'sso' ? 'bg-white shadow text-blue-600' : 'text-gray-500 hover:text-gray-900'}`}>SSO</button>
+              )}
+            </nav>
             <div className="flex items-center gap-3 pl-4 border-l">
               <div className="text-right hidden sm:block">
                 <p className="text-sm font-bold text-gray-700">{fullName}</p>
@@ -200,12 +288,16 @@ export default function Dashboard() {
 
       <div className="flex-1 max-w-7xl mx-auto w-full p-6">
         {activeTab === 'monitor' && <MonitorTab filteredGroups={filteredGroups} monitorSubTab={monitorSubTab} setMonitorSubTab={setMonitorSubTab} monitorSearch={monitorSearch} setMonitorSearch={setMonitorSearch} expandedDevices={expandedDevices} toggleDeviceExpand={toggleDeviceExpand} editingDeviceESN={editingDeviceESN} setEditingDeviceESN={setEditingDeviceESN} tempDeviceName={tempDeviceName} setTempDeviceName={setTempDeviceName} saveDeviceName={saveDeviceName} startEditingDevice={startEditingDevice} />}
-        {activeTab === 'users' && role === 'master' && <UsersTab users={masterData.users} currentUser={currentUser} onEdit={(u) => { setEditingUser(u); setIsModalOpen(true); }} onDelete={handleDeleteUser} onAdd={() => { setEditingUser(null); setIsModalOpen(true); }} />}
-        {activeTab === 'links' && role === 'master' && <LinksTab users={masterData.users} devices={masterData.devices} onPermissionChange={handlePermission} />}
-        {activeTab === 'audit' && (role === 'master' || role === 'support') && <AuditTab />}
+        {activeTab === 'users' && can('users:manage') && <UsersTab users={masterData.users} currentUser={currentUser} onEdit={(u) => { setEditingUser(u); setIsModalOpen(true); }} onDelete={handleDeleteUser} onUnlock={handleUnlockUser} onResendInvite={handleResendInvite} onRevokeInvite={handleRevokeInvite} onAdd={() => { setEditingUser(null); setIsModalOpen(true); }} />}
+        {activeTab === 'links' && can('permissions:manage') && <LinksTab users={masterData.users} devices={masterData.devices} onPermissionChange={handlePermission} />}
+        {activeTab === 'audit' && can('audit:read') && <AuditTab canVerify={can('audit:verify')} />}
+        {activeTab === 'apikeys' && <ApiKeysTab canManage={can('apikeys:manage')} />}
+        {activeTab === 'sso' && can('sso:manage') && <SsoTab />}
+        {activeTab === 'sessions' && <SessionsTab users={masterData.users} canManage={can('sessions:manage')} />}
+        {activeTab === 'profile' && <ProfileTab />}
       </div>
 
-      {isModalOpen && <UserModal isOpen={isModalOpen} onClose={() => setIsModalOpen(false)} user={editingUser} onSubmit={handleUserSubmit} currentUser={currentUser} />}
+      {isModalOpen && <UserModal isOpen={isModalOpen} onClose={() => setIsModalOpen(false)} user={editingUser} onSubmit={handleUserSubmit} currentUser={currentUser} canAssignMaster={role === 'master'} organizations={can('organizations:manage') ? masterData.organizations : null} />}
     </div>
   );
 }
\ No newline at end of file
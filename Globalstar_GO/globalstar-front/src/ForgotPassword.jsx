@@ -23,7 +23,11 @@ export default function ForgotPassword() {
             setMessage('Se o usuário existir, enviamos um link de recuperação para o e-mail cadastrado. Por favor, verifique sua caixa de entrada e spam.');
         } catch (err) {
             setStatus('error');
-            setMessage('Ocorreu um erro ao tentar processar a solicitação. Tente novamente mais tarde.');
+            if (err.response?.status === 429) {
+                setMessage('Muitas solicitações deste endereço. Aguarde alguns minutos e tente novamente.');
+            } else {
+                setMessage('Ocorreu um erro ao tentar processar a solicitação. Tente novamente mais tarde.');
+            }
         }
     };
 
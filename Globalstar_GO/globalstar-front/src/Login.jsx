@@ -1,15 +1,85 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { useNavigate } from 'react-router-dom';
-import { User, Lock, Wifi, ArrowRight, Loader2 } from 'lucide-react';
+import { User, Lock, Wifi, ArrowRight, Loader2, ShieldCheck, Building2, Mail } from 'lucide-react';
 import api from './services/api';
+import { passwordPolicyMessage } from './services/passwordPolicy';
 
 export default function Login() {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [isLoading, setIsLoading] = useState(false);
+  // Segundo fator: 'password' -> 'code' (2FA ativo) ou 'enroll' (2FA obrigatório
+  // ainda não cadastrado) -> 'recovery' (exibe os códigos de recuperação uma vez)
+  const [step, setStep] = useState('password');
+  const [mfaToken, setMfaToken] = useState('');
+  const [code, setCode] = useState('');
+  const [enrollment, setEnrollment] = useState(null);
+  const [recoveryCodes, setRecoveryCodes] = useState([]);
+  const [pendingSession, setPendingSession] = useState(null);
+  const [ssoEmail, setSsoEmail] = useState('');
+  const [notice, setNotice] = useState('');
+  // Troca obrigatória (primeiro acesso ou senha expirada) antes do 2FA
+  const [changeToken, setChangeToken] = useState('');
+  const [changeReason, setChangeReason] = useState('');
+  const [newPassword, setNewPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
   const navigate = useNavigate();
 
+  // Salva os dados de sessão
+  const startSession = (data) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refresh_token', data.refresh_token);
+    localStorage.setItem('role', data.role);
+    localStorage.setItem('permissions', JSON.stringify(data.permissions || []));
+    localStorage.setItem('user', data.username);
+    if (data.full_name) {
+      localStorage.setItem('full_name', data.full_name);
+    }
+    navigate('/dashboard');
+  };
+
+  const showError = (err, unauthorized) => {
+    if (err.code === "ERR_NETWORK") {
+      setError('Erro de conexão com o servidor.');
+    } else if (err.response?.status === 429) {
+      // Bloqueio por excesso de tentativas (a mensagem traz o tempo de espera)
+      setError(err.response.data || 'Muitas tentativas. Aguarde e tente novamente.');
+    } else if (err.response?.status === 401) {
+      setError(unauthorized);
+    } else {
+      setError('Ocorreu um erro inesperado. Tente novamente.');
+    }
+  };
+
+  // Resposta do login (senha ou SSO): pede o segundo fator ou abre a sessão
+  const handleLoginResponse = async (data) => {
+    if (data.password_change_required) {
+      setChangeToken(data.change_token);
+      setChangeReason(data.reason);
+      setStep('newpassword');
+      setIsLoading(false);
+      return;
+    }
+    if (data.mfa_required) {
+      setMfaToken(data.mfa_token);
+      setStep('code');
+      setIsLoading(false);
+      return;
+    }
+    if (data.mfa_enrollment_required) {
+      // Papel exige 2FA: gera o segredo para cadastrar no app autenticador
+      const setup = await api.post('/api/login/2fa/setup', { mfa_token: data.mfa_token });
+      setMfaToken(data.mfa_token);
+      setEnrollment(setup.data);
+      setStep('enroll');
+      setIsLoading(false);
+      return;
+    }
+
+    startSession(data);
+  };
+
   const handleLogin = async (e) => {
     e.preventDefault();
     setIsLoading(true);
@@ -19,25 +89,91 @@ export default function Login() {
       // Usamos api.post em vez de axios.post
       // Não precisamos passar a URL completa, apenas o endpoint '/login'
       const res = await api.post('/login', { username, password });
+      await handleLoginResponse(res.data);
+    } catch (err) {
+      // O tratamento de erro permanece similar, mas agora usamos o objeto de erro do axios
+      showError(err, 'Usuário ou senha incorretos.');
+      setIsLoading(false);
+    }
+  };
 
-      // Salva os dados de sessão
-      localStorage.setItem('token', res.data.token);
-      localStorage.setItem('role', res.data.role);
-      localStorage.setItem('user', res.data.username);
-      if (res.data.full_name) {
-        localStorage.setItem('full_name', res.data.full_name);
-      }
+  const handleNewPassword = async (e) => {
+    e.preventDefault();
+    if (newPassword !== confirmPassword) {
+      setError('As senhas não coincidem.');
+      return;
+    }
+    setIsLoading(true);
+    setError('');
 
-      navigate('/dashboard');
+    try {
+      const res = await api.post('/api/login/password', { change_token: changeToken, new_password: newPassword });
+      await handleLoginResponse(res.data);
     } catch (err) {
-      // O tratamento de erro permanece similar, mas agora usamos o objeto de erro do axios
-      if (err.code === "ERR_NETWORK") {
-        setError('Erro de conexão com o servidor.');
-      } else if (err.response?.status === 401) {
-        setError('Usuário ou senha incorretos.');
+      const policyError = passwordPolicyMessage(err);
+      if (policyError) {
+        setError(policyError);
       } else {
-        setError('Ocorreu um erro inesperado. Tente novamente.');
+        showError(err, 'Sessão expirada. Faça login novamente.');
+        if (err.response?.status === 401) setStep('password');
+      }
+      setIsLoading(false);
+    }
+  };
+
+  // Retorno do SSO: o backend redireciona para /login#sso=<ticket> ou #sso_error=<mensagem>
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const ticket = params.get('sso');
+    const ssoError = params.get('sso_error');
+    const linked = params.get('sso_linked');
+    if (!ticket && !ssoError && !linked) return;
+    window.history.replaceState(null, '', window.location.pathname);
+
+    if (linked) {
+      setNotice(`Conta vinculada ao provedor ${linked}. Já é possível entrar com SSO.`);
+      return;
+    }
+
+    if (ssoError) {
+      setError(ssoError);
+      return;
+    }
+    setIsLoading(true);
+    api.post('/api/sso/exchange', { ticket })
+      .then((res) => handleLoginResponse(res.data))
+      .catch((err) => {
+        showError(err, 'Sessão de SSO expirada. Tente novamente.');
+        setIsLoading(false);
+      });
+  }, []);
+
+  // SSO: o provedor é escolhido pelo domínio do e-mail
+  const handleSSO = (e) => {
+    e.preventDefault();
+    setIsLoading(true);
+    window.location.href = `/api/sso/start?email=${encodeURIComponent(ssoEmail)}`;
+  };
+
+  const handleCode = async (e) => {
+    e.preventDefault();
+    setIsLoading(true);
+    setError('');
+
+    try {
+      const res = await api.post('/api/login/2fa', { mfa_token: mfaToken, code });
+      if (res.data.recovery_codes) {
+        // Cadastro concluído: os códigos só aparecem agora
+        setRecoveryCodes(res.data.recovery_codes);
+        setPendingSession(res.data);
+        setStep('recovery');
+        setIsLoading(false);
+        return;
       }
+      startSession(res.data);
+    } catch (err) {
+      showError(err, 'Código inválido ou expirado.');
+      setCode('');
       setIsLoading(false);
     }
   };
@@ -61,62 +197,203 @@ export default function Login() {
           <h2 className="text-sm font-semibold text-blue-600 uppercase tracking-widest mt-1">by Data Frontier</h2>
         </div>
 
+        {/* Mensagem de Erro */}
+        {notice && !error && (
+          <div className="bg-green-50 text-green-700 text-xs font-medium p-3 rounded-lg border border-green-100 text-center mb-5 relative z-10">
+            {notice}
+          </div>
+        )}
+        {error && (
+          <div className="bg-red-50 text-red-600 text-xs font-medium p-3 rounded-lg border border-red-100 animate-pulse text-center mb-5 relative z-10">
+            {error}
+          </div>
+        )}
+
         {/* Formulário */}
-        <form onSubmit={handleLogin} className="space-y-5 relative z-10">
+        {step === 'password' && (
+          <form onSubmit={handleLogin} className="space-y-5 relative z-10">
 
-          {/* Mensagem de Erro */}
-          {error && (
-            <div className="bg-red-50 text-red-600 text-xs font-medium p-3 rounded-lg border border-red-100 animate-pulse text-center">
-              {error}
+            {/* Input Usuário */}
+            <div className="relative group">
+              <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
+                <User className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
+              </div>
+              <input
+                type="text"
+                className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm"
+                placeholder="Usuário"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                required
+              />
             </div>
-          )}
 
-          {/* Input Usuário */}
-          <div className="relative group">
-            <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
-              <User className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
+            {/* Input Senha */}
+            <div className="relative group">
+              <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
+                <Lock className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
+              </div>
+              <input
+                type="password"
+                className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm"
+                placeholder="Senha"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                required
+              />
             </div>
-            <input
-              type="text"
-              className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm"
-              placeholder="Usuário"
-              value={username}
-              onChange={(e) => setUsername(e.target.value)}
-              required
-            />
-          </div>
 
-          {/* Input Senha */}
-          <div className="relative group">
-            <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
-              <Lock className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
-            </div>
-            <input
-              type="password"
-              className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm"
-              placeholder="Senha"
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              required
-            />
-          </div>
+            {/* Botão de Login */}
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full flex items-center justify-center py-3 px-4 border border-transparent rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500 transition-all duration-200 transform hover:-translate-y-0.5 disabled:opacity-70 disabled:cursor-not-allowed"
+            >
+              {isLoading ? (
+                <Loader2 className="w-5 h-5 animate-spin" />
+              ) : (
+                <>
+                  Entrar
+                  <ArrowRight className="ml-2 w-4 h-4" />
+                </>
+              )}
+            </button>
+          </form>
+        )}
 
-          {/* Botão de Login */}
+        {step === 'password' && (
           <button
-            type="submit"
-            disabled={isLoading}
-            className="w-full flex items-center justify-center py-3 px-4 border border-transparent rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500 transition-all duration-200 transform hover:-translate-y-0.5 disabled:opacity-70 disabled:cursor-not-allowed"
+            type="button"
+            onClick={() => { setError(''); setStep('sso'); }}
+            className="w-full mt-3 flex items-center justify-center py-3 px-4 border border-gray-200 rounded-xl text-sm font-bold text-gray-600 bg-white hover:bg-gray-50 transition-all duration-200 relative z-10"
           >
-            {isLoading ? (
-              <Loader2 className="w-5 h-5 animate-spin" />
-            ) : (
-              <>
-                Entrar
-                <ArrowRight className="ml-2 w-4 h-4" />
-              </>
-            )}
+            <Building2 className="mr-2 w-4 h-4" />
+            Entrar com SSO corporativo
           </button>
-        </form>
+        )}
+
+        {/* SSO: e-mail corporativo -> provedor de identidade da organização */}
+        {step === 'sso' && (
+          <form onSubmit={handleSSO} className="space-y-5 relative z-10">
+            <div className="relative group">
+              <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
+                <Mail className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
+              </div>
+              <input
+                type="email"
+                autoFocus
+                className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm"
+                placeholder="E-mail corporativo"
+                value={ssoEmail}
+                onChange={(e) => setSsoEmail(e.target.value)}
+                required
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full flex items-center justify-center py-3 px-4 border border-transparent rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500 transition-all duration-200 disabled:opacity-70 disabled:cursor-not-allowed"
+            >
+              {isLoading ? <Loader2 className="w-5 h-5 animate-spin" /> : 'Continuar'}
+            </button>
+            <button
+              type="button"
+              onClick={() => { setError(''); setStep('password'); }}
+              className="w-full text-xs text-gray-400 hover:text-blue-600 transition-colors"
+            >
+              Entrar com usuário e senha
+            </button>
+          </form>
+        )}
+
+        {/* Nova senha (primeiro acesso ou senha expirada) */}
+        {step === 'newpassword' && (
+          <form onSubmit={handleNewPassword} className="space-y-5 relative z-10">
+            <p className="text-sm text-gray-600">
+              {changeReason === 'expired'
+                ? 'Sua senha expirou. Escolha uma nova senha para continuar.'
+                : 'Primeiro acesso: troque a senha provisória para continuar.'}
+            </p>
+            {[['Nova senha', newPassword, setNewPassword], ['Confirme a nova senha', confirmPassword, setConfirmPassword]].map(([placeholder, value, setValue], i) => (
+              <div key={placeholder} className="relative group">
+                <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
+                  <Lock className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
+                </div>
+                <input
+                  type="password"
+                  autoComplete="new-password"
+                  autoFocus={i === 0}
+                  className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm"
+                  placeholder={placeholder}
+                  value={value}
+                  onChange={(e) => setValue(e.target.value)}
+                  required
+                />
+              </div>
+            ))}
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full flex items-center justify-center py-3 px-4 border border-transparent rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500 transition-all duration-200 disabled:opacity-70 disabled:cursor-not-allowed"
+            >
+              {isLoading ? <Loader2 className="w-5 h-5 animate-spin" /> : 'Salvar nova senha'}
+            </button>
+          </form>
+        )}
+
+        {/* Cadastro do app autenticador (2FA obrigatório) */}
+        {step === 'enroll' && enrollment && (
+          <div className="space-y-3 relative z-10 mb-5 text-sm text-gray-600">
+            <p>Seu perfil exige autenticação em dois fatores. Adicione a conta no app autenticador (Google Authenticator, Authy...) com a chave abaixo e digite o código gerado.</p>
+            <div className="bg-gray-50 border border-gray-200 rounded-lg p-3 font-mono text-xs break-all text-gray-800 select-all">{enrollment.secret}</div>
+            <a href={enrollment.otpauth_uri} className="block text-xs text-blue-600 hover:underline break-all">Abrir no app autenticador</a>
+          </div>
+        )}
+
+        {/* Código do app ou de recuperação */}
+        {(step === 'code' || step === 'enroll') && (
+          <form onSubmit={handleCode} className="space-y-5 relative z-10">
+            <div className="relative group">
+              <div className="absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none">
+                <ShieldCheck className="h-5 w-5 text-gray-400 group-focus-within:text-blue-500 transition-colors" />
+              </div>
+              <input
+                type="text"
+                autoComplete="one-time-code"
+                autoFocus
+                className="block w-full pl-10 pr-3 py-3 border border-gray-200 rounded-xl leading-5 bg-gray-50 text-gray-900 placeholder-gray-400 focus:outline-none focus:bg-white focus:ring-2 focus:ring-blue-500 focus:border-transparent transition-all duration-200 sm:text-sm tracking-widest"
+                placeholder={step === 'code' ? 'Código do app ou de recuperação' : 'Código de 6 dígitos'}
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                required
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={isLoading}
+              className="w-full flex items-center justify-center py-3 px-4 border border-transparent rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-blue-500 transition-all duration-200 disabled:opacity-70 disabled:cursor-not-allowed"
+            >
+              {isLoading ? <Loader2 className="w-5 h-5 animate-spin" /> : 'Verificar'}
+            </button>
+          </form>
+        )}
+
+        {/* Códigos de recuperação (exibidos uma única vez) */}
+        {step === 'recovery' && (
+          <div className="space-y-4 relative z-10 text-sm text-gray-600">
+            <p>Guarde estes códigos de recuperação em local seguro. Cada um permite entrar uma vez caso perca o celular.</p>
+            <div className="grid grid-cols-2 gap-2 bg-gray-50 border border-gray-200 rounded-lg p-3 font-mono text-xs text-gray-800 select-all">
+              {recoveryCodes.map((c) => <span key={c}>{c}</span>)}
+            </div>
+            <button
+              onClick={() => startSession(pendingSession)}
+              className="w-full flex items-center justify-center py-3 px-4 rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 transition-all duration-200"
+            >
+              Continuar
+              <ArrowRight className="ml-2 w-4 h-4" />
+            </button>
+          </div>
+        )}
 
         {/* Rodapé: Esqueci minha senha */}
         <div className="mt-8 text-center relative z-10">
@@ -2,8 +2,10 @@ import React, { useState, useEffect } from 'react';
 import { useNavigate, useLocation } from 'react-router-dom';
 import { Lock, ArrowRight, Loader2, KeyRound, AlertCircle } from 'lucide-react';
 import api from './services/api';
+import { passwordPolicyMessage } from './services/passwordPolicy';
 
-export default function ResetPassword() {
+// Também é a página do convite (/activate): mesmo formulário, outro endpoint e outros textos
+export default function ResetPassword({ invite = false }) {
     const [password, setPassword] = useState('');
     const [confirmPassword, setConfirmPassword] = useState('');
     const [status, setStatus] = useState('idle'); // idle, loading, success, error
@@ -19,9 +21,11 @@ export default function ResetPassword() {
     useEffect(() => {
         if (!token) {
             setStatus('error');
-            setMessage('Link de redefinição inválido ou ausente. Por favor, solicite a recuperação de senha novamente.');
+            setMessage(invite
+                ? 'Link de ativação inválido ou ausente. Peça ao administrador para reenviar o convite.'
+                : 'Link de redefinição inválido ou ausente. Por favor, solicite a recuperação de senha novamente.');
         }
-    }, [token]);
+    }, [token, invite]);
 
     const handleSubmit = async (e) => {
         e.preventDefault();
@@ -32,22 +36,24 @@ export default function ResetPassword() {
             return;
         }
 
-        if (password.length < 6) {
-            setStatus('error');
-            setMessage('A senha deve ter pelo menos 6 caracteres.');
-            return;
-        }
-
         setStatus('loading');
         setMessage('');
 
         try {
-            await api.post('/reset-password', { token, new_password: password });
+            await api.post(invite ? '/api/invite/accept' : '/api/reset-password', { token, new_password: password });
             setStatus('success');
-            setMessage('Senha redefinida com sucesso! Você já pode fazer o login.');
+            setMessage(invite
+                ? 'Conta ativada! Você já pode fazer o login com a senha escolhida.'
+                : 'Senha redefinida com sucesso! Você já pode fazer o login.');
         } catch (err) {
             setStatus('error');
-            if (err.response?.status === 400 || err.response?.status === 401) {
+            // Senha fora da política: o link continua válido, basta escolher outra
+            const policyError = passwordPolicyMessage(err);
+            if (policyError) {
+                setMessage(policyError);
+            } else if (invite && err.response?.status === 400) {
+                setMessage('O convite expirou, foi revogado ou já foi usado. Peça ao administrador para reenviá-lo.');
+            } else if (err.response?.status === 400 || err.response?.status === 401) {
                 setMessage('O link de recuperação expirou ou é inválido. Por favor, solicite outro recarregando a página de login.');
             } else {
                 setMessage('Ocorreu um erro ao tentar redefinir a senha. Tente novamente.');
@@ -67,9 +73,11 @@ export default function ResetPassword() {
                     <div className="bg-blue-600 w-16 h-16 rounded-2xl flex items-center justify-center mx-auto mb-4 shadow-lg">
                         <KeyRound className="text-white w-8 h-8" />
                     </div>
-                    <h1 className="text-2xl font-bold text-gray-800 tracking-tight">Crie sua nova senha</h1>
+                    <h1 className="text-2xl font-bold text-gray-800 tracking-tight">{invite ? 'Ative sua conta' : 'Crie sua nova senha'}</h1>
                     <p className="text-sm text-gray-500 mt-2">
-                        Insira a nova senha para sua conta e confirme para validar.
+                        {invite
+                            ? 'Escolha a senha de acesso da sua conta e confirme para ativá-la.'
+                            : 'Insira a nova senha para sua conta e confirme para validar.'}
                     </p>
                 </div>
 
@@ -132,7 +140,7 @@ export default function ResetPassword() {
                             {status === 'loading' ? (
                                 <Loader2 className="w-5 h-5 animate-spin" />
                             ) : (
-                                'Salvar Nova Senha'
+                                invite ? 'Ativar Conta' : 'Salvar Nova Senha'
                             )}
                         </button>
 
@@ -0,0 +1,74 @@
+import React, { useEffect, useState } from 'react';
+import { useNavigate, useLocation } from 'react-router-dom';
+import { MailCheck, ArrowRight, Loader2, AlertCircle } from 'lucide-react';
+import api from './services/api';
+
+// Link de confirmação do novo e-mail (FRONTEND_URL/verify-email?token=XYZ)
+export default function VerifyEmail() {
+    const [status, setStatus] = useState('loading'); // loading, success, error
+    const [message, setMessage] = useState('');
+
+    const navigate = useNavigate();
+    const location = useLocation();
+    const token = new URLSearchParams(location.search).get('token');
+
+    useEffect(() => {
+        if (!token) {
+            setStatus('error');
+            setMessage('Link de confirmação inválido ou ausente.');
+            return;
+        }
+        api.post('/api/me/email/verify', { token })
+            .then(() => {
+                setStatus('success');
+                setMessage('E-mail confirmado! Ele já é o e-mail da sua conta.');
+            })
+            .catch((err) => {
+                setStatus('error');
+                setMessage(typeof err.response?.data === 'string' && err.response.data
+                    ? err.response.data
+                    : 'Não foi possível confirmar o e-mail. Tente novamente.');
+            });
+    }, [token]);
+
+    return (
+        <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-slate-900 via-blue-900 to-slate-900 font-sans">
+            <div className="bg-white p-8 md:p-10 rounded-3xl shadow-2xl w-full max-w-sm border border-gray-200 relative overflow-hidden">
+
+                {/* Decoracao */}
+                <div className="absolute -top-10 -right-10 w-32 h-32 bg-blue-100 rounded-full blur-3xl opacity-50"></div>
+                <div className="absolute -bottom-10 -left-10 w-32 h-32 bg-blue-100 rounded-full blur-3xl opacity-50"></div>
+
+                <div className="text-center mb-8 relative z-10">
+                    <div className="bg-blue-600 w-16 h-16 rounded-2xl flex items-center justify-center mx-auto mb-4 shadow-lg">
+                        <MailCheck className="text-white w-8 h-8" />
+                    </div>
+                    <h1 className="text-2xl font-bold text-gray-800 tracking-tight">Confirmação de e-mail</h1>
+                </div>
+
+                <div className="relative z-10 text-center space-y-6">
+                    {status === 'loading' && <Loader2 className="w-6 h-6 animate-spin mx-auto text-blue-600" />}
+                    {status === 'success' && (
+                        <div className="bg-green-50 text-green-700 text-sm font-medium p-4 rounded-xl border border-green-200 shadow-sm leading-relaxed">
+                            {message}
+                        </div>
+                    )}
+                    {status === 'error' && (
+                        <div className="bg-red-50 text-red-600 text-xs font-medium p-3 rounded-lg border border-red-100 flex items-start gap-2 text-left">
+                            <AlertCircle className="w-4 h-4 shrink-0 mt-0.5" />
+                            <span>{message}</span>
+                        </div>
+                    )}
+                    {status !== 'loading' && (
+                        <button
+                            onClick={() => navigate(localStorage.getItem('token') ? '/dashboard' : '/login')}
+                            className="w-full flex items-center justify-center py-3 px-4 border border-transparent rounded-xl shadow-md text-sm font-bold text-white bg-blue-600 hover:bg-blue-700 transition-all duration-200"
+                        >
+                            Continuar <ArrowRight className="ml-2 w-4 h-4" />
+                        </button>
+                    )}
+                </div>
+            </div>
+        </div>
+    );
+}
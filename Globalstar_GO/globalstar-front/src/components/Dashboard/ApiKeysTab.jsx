@@ -0,0 +1,147 @@
+import React, { useEffect, useState } from 'react';
+import { KeyRound, Plus, RefreshCw, Ban, Copy } from 'lucide-react';
+import api from '../../services/api';
+
+// Chaves de API para integrações (ERP, controladores). A chave completa só
+// aparece uma vez, logo após a criação.
+export default function ApiKeysTab({ canManage }) {
+    const [keys, setKeys] = useState([]);
+    const [loading, setLoading] = useState(false);
+    const [form, setForm] = useState({ name: '', scope: 'read', expires_in_days: 90, organization: false });
+    const [createdKey, setCreatedKey] = useState(null);
+
+    const fetchKeys = async () => {
+        setLoading(true);
+        try {
+            const res = await api.get('/api/api-keys');
+            setKeys(res.data || []);
+        } catch (error) {
+            console.error("Erro ao buscar chaves", error);
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    useEffect(() => {
+        fetchKeys();
+    }, []);
+
+    const handleCreate = async (e) => {
+        e.preventDefault();
+        try {
+            const res = await api.post('/api/api-keys', { ...form, expires_in_days: parseInt(form.expires_in_days) || 0 });
+            setCreatedKey(res.data.key);
+            setForm({ ...form, name: '' });
+            fetchKeys();
+        } catch (err) { alert("Erro ao criar chave"); }
+    };
+
+    const handleRevoke = async (id) => {
+        if (!confirm("Revogar esta chave? Integrações que a usam deixarão de funcionar.")) return;
+        try {
+            await api.post('/api/api-keys/revoke', { id });
+            fetchKeys();
+        } catch (err) { alert("Erro ao revogar chave"); }
+    };
+
+    return (
+        <div className="space-y-6 animate-in fade-in duration-500">
+            {/* NOVA CHAVE */}
+            <form onSubmit={handleCreate} className="bg-white p-4 rounded-xl shadow-sm border border-gray-200 flex flex-wrap gap-3 items-end">
+                <div className="flex-1 min-w-[200px]">
+                    <label className="text-xs font-bold text-gray-500 uppercase">Nome</label>
+                    <input required className="w-full mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm" placeholder="Ex: ERP Fazenda Norte"
+                        value={form.name} onChange={e => setForm({ ...form, name: e.target.value })} />
+                </div>
+                <div>
+                    <label className="text-xs font-bold text-gray-500 uppercase">Escopo</label>
+                    <select className="w-full mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm bg-white"
+                        value={form.scope} onChange={e => setForm({ ...form, scope: e.target.value })}>
+                        <option value="read">Somente leitura</option>
+                        <option value="write">Leitura e operação</option>
+                    </select>
+                </div>
+                <div>
+                    <label className="text-xs font-bold text-gray-500 uppercase">Expira em (dias)</label>
+                    <input type="number" min="0" className="w-28 mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm" title="0 = não expira"
+                        value={form.expires_in_days} onChange={e => setForm({ ...form, expires_in_days: e.target.value })} />
+                </div>
+                {canManage && (
+                    <label className="flex items-center gap-2 text-sm text-gray-600 py-2">
+                        <input type="checkbox" checked={form.organization} onChange={e => setForm({ ...form, organization: e.target.checked })} />
+                        Chave da organização
+                    </label>
+                )}
+                <button type="submit" className="bg-blue-600 hover:bg-blue-700 text-white px-4 py-2 rounded-lg flex items-center gap-2 text-sm font-bold transition">
+                    <Plus size={16}/> Criar chave
+                </button>
+            </form>
+
+            {createdKey && (
+                <div className="bg-green-50 border border-green-200 rounded-xl p-4 text-sm text-green-800">
+                    <p className="font-bold mb-2">Copie a chave agora: ela não será exibida novamente.</p>
+                    <div className="flex items-center gap-2">
+                        <code className="flex-1 bg-white border border-green-200 rounded px-3 py-2 font-mono text-xs break-all select-all">{createdKey}</code>
+                        <button onClick={() => navigator.clipboard?.writeText(createdKey)} className="p-2 hover:bg-green-100 rounded-lg" title="Copiar"><Copy size={16}/></button>
+                    </div>
+                </div>
+            )}
+
+            {/* LISTA */}
+            <div className="bg-white rounded-xl shadow-sm border border-gray-200 overflow-hidden">
+                <div className="p-4 border-b border-gray-200 bg-gray-50 flex justify-between items-center">
+                    <h3 className="font-bold text-gray-700 flex items-center gap-2">
+                        <KeyRound size={18} className="text-blue-600"/> Chaves de API
+                    </h3>
+                    <button onClick={fetchKeys} className="p-2 text-gray-500 hover:text-blue-600 transition">
+                        <RefreshCw size={18} className={loading ? "animate-spin" : ""} />
+                    </button>
+                </div>
+                <table className="w-full text-sm text-left">
+                    <thead className="bg-gray-100 text-gray-500 font-semibold">
+                        <tr>
+                            <th className="p-3">Nome</th>
+                            <th className="p-3">Chave</th>
+                            <th className="p-3">Dono</th>
+                            <th className="p-3">Escopo</th>
+                            <th className="p-3">Expira</th>
+                            <th className="p-3">Último uso</th>
+                            <th className="p-3 text-right">Ações</th>
+                        </tr>
+                    </thead>
+                    <tbody className="divide-y divide-gray-100">
+                        {keys.map(k => (
+                            <tr key={k.id} className={`hover:bg-gray-50 ${k.revoked ? 'opacity-40' : ''}`}>
+                                <td className="p-3 font-bold text-gray-700">{k.name}</td>
+                                <td className="p-3 font-mono text-xs text-gray-500">{k.prefix}…</td>
+                                <td className="p-3 text-gray-600">{k.user_id ? `@${k.username}` : 'Organização'}</td>
+                                <td className="p-3">
+                                    <span className={`px-2 py-1 rounded text-[10px] font-bold ${k.scope === 'write' ? 'bg-amber-100 text-amber-700' : 'bg-gray-100 text-gray-600'}`}>
+                                        {k.scope === 'write' ? 'LEITURA/OPERAÇÃO' : 'LEITURA'}
+                                    </span>
+                                </td>
+                                <td className="p-3 text-gray-500 whitespace-nowrap">{k.expires_at || 'Nunca'}</td>
+                                <td className="p-3 text-gray-500 whitespace-nowrap">
+                                    {k.last_used_at || '—'}
+                                    {k.last_used_ip && <div className="text-[10px] font-mono text-gray-400">{k.last_used_ip}</div>}
+                                </td>
+                                <td className="p-3 text-right">
+                                    {k.revoked ? (
+                                        <span className="text-xs text-gray-400">Revogada</span>
+                                    ) : (
+                                        <button onClick={() => handleRevoke(k.id)} className="p-2 text-red-600 hover:bg-red-50 rounded-lg" title="Revogar"><Ban size={16}/></button>
+                                    )}
+                                </td>
+                            </tr>
+                        ))}
+                        {keys.length === 0 && (
+                            <tr>
+                                <td colSpan="7" className="p-8 text-center text-gray-400 italic">Nenhuma chave criada.</td>
+                            </tr>
+                        )}
+                    </tbody>
+                </table>
+            </div>
+        </div>
+    );
+}
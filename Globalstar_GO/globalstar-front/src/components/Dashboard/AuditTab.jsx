@@ -1,11 +1,19 @@
-import React, { useEffect, useState } from 'react';
-import { ShieldCheck, Search, RefreshCw } from 'lucide-react';
+import React, { Fragment, useEffect, useState } from 'react';
+import { ShieldCheck, ShieldAlert, Search, RefreshCw, Link2, ChevronRight, ChevronDown } from 'lucide-react';
 import api from '../../services/api'; // Ajuste o caminho se necessário
 
-export default function AuditTab() {
+// Valor de um campo no diff (null = ausente)
+const formatValue = (v) => v === null || v === undefined ? '—' : typeof v === 'object' ? JSON.stringify(v) : String(v);
+
+// Com audit:verify (master) dá para conferir a cadeia de hashes dos registros.
+// Clicar numa linha mostra o diff dos campos, o ID da requisição e o user agent.
+export default function AuditTab({ canVerify }) {
     const [logs, setLogs] = useState([]);
     const [loading, setLoading] = useState(false);
     const [search, setSearch] = useState('');
+    const [integrity, setIntegrity] = useState(null);
+    const [verifying, setVerifying] = useState(false);
+    const [expanded, setExpanded] = useState(null);
 
     const fetchLogs = async () => {
         setLoading(true);
@@ -23,10 +31,24 @@ export default function AuditTab() {
         fetchLogs();
     }, []);
 
+    const handleVerify = async () => {
+        setVerifying(true);
+        try {
+            const res = await api.get('/api/master/audit/verify');
+            setIntegrity(res.data);
+        } catch (err) {
+            alert("Erro ao verificar a integridade da auditoria");
+        } finally {
+            setVerifying(false);
+        }
+    };
+
     const filteredLogs = logs.filter(l => 
         l.username.toLowerCase().includes(search.toLowerCase()) ||
         l.action.toLowerCase().includes(search.toLowerCase()) ||
-        l.details.toLowerCase().includes(search.toLowerCase())
+        l.details.toLowerCase().includes(search.toLowerCase()) ||
+        `${l.target_type || ''}:${l.target_id || ''}`.toLowerCase().includes(search.toLowerCase()) ||
+        (l.request_id || '').toLowerCase().includes(search.toLowerCase())
     );
 
     return (
@@ -44,40 +66,104 @@ export default function AuditTab() {
                             value={search} onChange={e => setSearch(e.target.value)}
                         />
                     </div>
+                    {canVerify && (
+                        <button onClick={handleVerify} disabled={verifying} className="px-3 py-2 text-sm font-bold text-purple-600 hover:bg-purple-50 rounded-lg flex items-center gap-1 transition disabled:opacity-50">
+                            <Link2 size={16}/> Verificar integridade
+                        </button>
+                    )}
                     <button onClick={fetchLogs} className="p-2 text-gray-500 hover:text-purple-600 transition">
                         <RefreshCw size={18} className={loading ? "animate-spin" : ""} />
                     </button>
                 </div>
             </div>
-            
+
+            {integrity && (
+                <div className={`px-4 py-3 text-sm border-b flex items-start gap-2 ${integrity.ok ? 'bg-green-50 text-green-700 border-green-200' : 'bg-red-50 text-red-700 border-red-200'}`}>
+                    {integrity.ok ? <ShieldCheck size={18} className="shrink-0"/> : <ShieldAlert size={18} className="shrink-0"/>}
+                    <div>
+                        <div className="font-bold">
+                            {integrity.ok
+                                ? `Cadeia íntegra: ${integrity.entries} registros verificados`
+                                : `Cadeia quebrada no registro seq ${integrity.first_broken.seq}${integrity.first_broken.log_id ? ` (ID ${integrity.first_broken.log_id})` : ''}: ${integrity.first_broken.reason}`}
+                        </div>
+                        <div className="text-xs opacity-80">
+                            {integrity.checkpoints} checkpoint(s) assinado(s)
+                            {integrity.last_checkpoint && `, último até seq ${integrity.last_checkpoint.seq}`}
+                            {integrity.legacy > 0 && ` · ${integrity.legacy} registro(s) anterior(es) à cadeia`}
+                            {` · chave ${integrity.key_id}`}
+                        </div>
+                    </div>
+                </div>
+            )}
+
             <div className="flex-1 overflow-y-auto">
                 <table className="w-full text-sm text-left">
                     <thead className="bg-gray-100 text-gray-500 font-semibold sticky top-0">
                         <tr>
+                            <th className="p-3 w-6"></th>
                             <th className="p-3">Data/Hora</th>
                             <th className="p-3">Usuário</th>
                             <th className="p-3">Ação</th>
+                            <th className="p-3">Alvo</th>
                             <th className="p-3">Detalhes</th>
                             <th className="p-3">IP</th>
                         </tr>
                     </thead>
                     <tbody className="divide-y divide-gray-100">
                         {filteredLogs.map(log => (
-                            <tr key={log.id} className="hover:bg-gray-50">
-                                <td className="p-3 text-gray-500 whitespace-nowrap">{log.created_at}</td>
-                                <td className="p-3 font-bold text-gray-700">{log.username}</td>
-                                <td className="p-3">
-                                    <span className={`px-2 py-1 rounded text-[10px] font-bold ${
-                                        log.action === 'LOGIN' ? 'bg-green-100 text-green-700' : 
-                                        log.action === 'UPDATE_DEVICE' ? 'bg-blue-100 text-blue-700' :
-                                        'bg-gray-100 text-gray-600'
-                                    }`}>
-                                        {log.action}
-                                    </span>
-                                </td>
-                                <td className="p-3 text-gray-600">{log.details}</td>
-                                <td className="p-3 text-gray-400 text-xs font-mono">{log.ip_address}</td>
-                            </tr>
+                            <Fragment key={log.id}>
+                                <tr className="hover:bg-gray-50 cursor-pointer" onClick={() => setExpanded(expanded === log.id ? null : log.id)}>
+                                    <td className="p-3 text-gray-400">
+                                        {expanded === log.id ? <ChevronDown size={14}/> : <ChevronRight size={14}/>}
+                                    </td>
+                                    <td className="p-3 text-gray-500 whitespace-nowrap">{log.created_at}</td>
+                                    <td className="p-3 font-bold text-gray-700">{log.username}</td>
+                                    <td className="p-3">
+                                        <span className={`px-2 py-1 rounded text-[10px] font-bold ${
+                                            log.action === 'LOGIN' ? 'bg-green-100 text-green-700' : 
+                                            log.action === 'UPDATE_DEVICE' ? 'bg-blue-100 text-blue-700' :
+                                            'bg-gray-100 text-gray-600'
+                                        }`}>
+                                            {log.action}
+                                        </span>
+                                    </td>
+                                    <td className="p-3 text-gray-500 text-xs font-mono whitespace-nowrap">
+                                        {log.target_type ? `${log.target_type}:${log.target_id}` : '—'}
+                                    </td>
+                                    <td className="p-3 text-gray-600">
+                                        {log.details}
+                                        {log.changes && <span className="ml-2 text-[10px] font-bold text-purple-600">{Object.keys(log.changes).length} campo(s)</span>}
+                                    </td>
+                                    <td className="p-3 text-gray-400 text-xs font-mono">{log.ip_address}</td>
+                                </tr>
+                                {expanded === log.id && (
+                                    <tr className="bg-gray-50">
+                                        <td></td>
+                                        <td colSpan={6} className="p-3 text-xs text-gray-600 space-y-2">
+                                            {log.changes ? (
+                                                <table className="text-xs">
+                                                    <thead className="text-gray-400">
+                                                        <tr><th className="pr-4 text-left">Campo</th><th className="pr-4 text-left">Antes</th><th className="text-left">Depois</th></tr>
+                                                    </thead>
+                                                    <tbody>
+                                                        {Object.entries(log.changes).map(([field, c]) => (
+                                                            <tr key={field}>
+                                                                <td className="pr-4 font-mono font-bold">{field}</td>
+                                                                <td className="pr-4 font-mono text-red-600 break-all">{formatValue(c.from)}</td>
+                                                                <td className="font-mono text-green-700 break-all">{formatValue(c.to)}</td>
+                                                            </tr>
+                                                        ))}
+                                                    </tbody>
+                                                </table>
+                                            ) : <div className="text-gray-400">Sem alterações de campos registradas</div>}
+                                            <div className="text-gray-400">
+                                                Autor ID {log.user_id || '—'} · Requisição <span className="font-mono">{log.request_id || '—'}</span>
+                                                {log.user_agent && <> · <span className="font-mono">{log.user_agent}</span></>}
+                                            </div>
+                                        </td>
+                                    </tr>
+                                )}
+                            </Fragment>
                         ))}
                     </tbody>
                 </table>
@@ -85,7 +85,7 @@ export default function LinksTab({ users, devices, onPermissionChange }) {
                                     <div className="bg-blue-100 text-blue-700 p-1.5 rounded-lg"><LinkIcon size={16}/></div>
                                     Acessos de {selectedUser.full_name}
                                 </h3>
-                                <p className="text-xs text-gray-500 ml-9">Gerencie quais dispositivos este usuário pode ver.</p>
+                                <p className="text-xs text-gray-500 ml-9">Gerencie quais dispositivos este usuário pode ver e o que pode fazer em cada um.</p>
                             </div>
                             <div className="relative w-64">
                                 <Search className="absolute left-2 top-2.5 text-gray-400 h-4 w-4" />
@@ -103,6 +103,7 @@ export default function LinksTab({ users, devices, onPermissionChange }) {
                                     // Verificação de segurança para array de users
                                     const devUsers = Array.isArray(dev.users) ? dev.users : [];
                                     const hasAccess = devUsers.includes(selectedUser.username);
+                                    const level = dev.access?.[selectedUser.username] || 'viewer';
                                     
                                     return (
                                         <div key={dev.id} 
@@ -123,7 +124,20 @@ export default function LinksTab({ users, devices, onPermissionChange }) {
                                                     <div className="text-[10px] text-gray-400 truncate w-32">{dev.name}</div>
                                                 </div>
                                             </div>
-                                            <div className="text-gray-400">
+                                            <div className="text-gray-400 flex items-center gap-2">
+                                                {hasAccess && (
+                                                    <select
+                                                        value={level}
+                                                        onClick={e => e.stopPropagation()}
+                                                        onChange={e => onPermissionChange(selectedUser.id, dev.id, 'grant', e.target.value)}
+                                                        className="text-xs border border-green-200 rounded-md bg-white text-green-800 px-1 py-0.5"
+                                                        title="Nível de acesso"
+                                                    >
+                                                        <option value="viewer">Visualizar</option>
+                                                        <option value="operator">Operar</option>
+                                                        <option value="owner">Configurar</option>
+                                                    </select>
+                                                )}
                                                 {hasAccess 
                                                     ? <CheckCircle size={20} className="text-green-500" />
                                                     : <PlusCircle size={20} className="group-hover:text-blue-500 transition"/>
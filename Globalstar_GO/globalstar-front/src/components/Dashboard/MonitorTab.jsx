@@ -66,6 +66,8 @@ export default function MonitorTab({
                     const sharedUsers = msgs[0]?.shared_with || [];
                     const deviceName = msgs[0].device_name || '';
                     const isEditing = editingDeviceESN === esn;
+                    // Vínculo "viewer" não renomeia (mensagens ao vivo não trazem o nível)
+                    const canRename = !msgs.some(m => m.access_level === 'viewer');
 
                     return (
                         <div key={esn} className="bg-white rounded-xl shadow-sm border border-gray-200 overflow-hidden group transition hover:shadow-md">
@@ -95,13 +97,15 @@ export default function MonitorTab({
                                             ) : (
                                                 <h3 className="font-bold text-base tracking-wide flex items-center gap-2 group/title">
                                                     {deviceName || <span className="text-gray-500 italic text-sm">Sem Nome</span>}
-                                                    <button
-                                                        onClick={() => startEditingDevice(esn, deviceName)}
-                                                        className="opacity-0 group-hover/title:opacity-100 text-gray-400 hover:text-white transition-opacity"
-                                                        title="Editar Nome"
-                                                    >
-                                                        <Edit2 size={12} />
-                                                    </button>
+                                                    {canRename && (
+                                                        <button
+                                                            onClick={() => startEditingDevice(esn, deviceName)}
+                                                            className="opacity-0 group-hover/title:opacity-100 text-gray-400 hover:text-white transition-opacity"
+                                                            title="Editar Nome"
+                                                        >
+                                                            <Edit2 size={12} />
+                                                        </button>
+                                                    )}
                                                 </h3>
                                             )}
                                         </div>
@@ -0,0 +1,142 @@
+import React, { useEffect, useState } from 'react';
+import { UserCircle, Lock, Save, MailWarning, KeyRound } from 'lucide-react';
+import api from '../../services/api';
+import { passwordPolicyMessage } from '../../services/passwordPolicy';
+
+const inputClass = "w-full mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm";
+
+// Dados de contato e senha do próprio usuário. O e-mail novo só vale depois
+// do link de confirmação enviado para ele.
+export default function ProfileTab() {
+    const [profile, setProfile] = useState(null);
+    const [form, setForm] = useState({});
+    const [passwords, setPasswords] = useState({ current_password: '', new_password: '', confirm: '' });
+    // Senha atual pedida só quando o e-mail muda
+    const [emailPassword, setEmailPassword] = useState('');
+
+    const fetchProfile = async () => {
+        try {
+            const res = await api.get('/api/me');
+            setProfile(res.data);
+            setForm({
+                full_name: res.data.full_name, email: res.data.email, phone: res.data.phone,
+                address: res.data.address, city: res.data.city, state: res.data.state,
+            });
+        } catch (error) {
+            console.error("Erro ao buscar perfil", error);
+        }
+    };
+
+    useEffect(() => {
+        fetchProfile();
+    }, []);
+
+    const handleSave = async (e) => {
+        e.preventDefault();
+        try {
+            const res = await api.patch('/api/me', emailChanged ? { ...form, current_password: emailPassword } : form);
+            setProfile(res.data);
+            if (res.data.full_name) localStorage.setItem('full_name', res.data.full_name);
+            alert(res.data.pending_email && res.data.pending_email !== profile.pending_email
+                ? `Perfil salvo. Enviamos um link de confirmação para ${res.data.pending_email}.`
+                : 'Perfil salvo!');
+            setForm({ ...form, email: res.data.email });
+            setEmailPassword('');
+        } catch (err) { alert(err.response?.data || "Erro ao salvar perfil"); }
+    };
+
+    const handlePassword = async (e) => {
+        e.preventDefault();
+        if (passwords.new_password !== passwords.confirm) {
+            alert('As senhas não coincidem.');
+            return;
+        }
+        try {
+            await api.post('/api/me/password', { current_password: passwords.current_password, new_password: passwords.new_password });
+            setPasswords({ current_password: '', new_password: '', confirm: '' });
+            alert('Senha alterada! As outras sessões foram encerradas.');
+        } catch (err) {
+            alert(passwordPolicyMessage(err) || err.response?.data || "Erro ao alterar senha");
+        }
+    };
+
+    // Vínculo explícito com o IdP da organização: o navegador vai ao provedor e volta em /sso
+    const handleLinkSSO = async () => {
+        try {
+            const res = await api.post('/api/sso/link', {});
+            window.location.href = res.data.url;
+        } catch (err) { alert(err.response?.data || "Erro ao iniciar o vínculo com o SSO"); }
+    };
+
+    if (!profile) return null;
+
+    const emailChanged = (form.email || '').trim().toLowerCase() !== (profile.email || '').toLowerCase();
+
+    const field = (label, key, props = {}) => (
+        <div>
+            <label className="text-xs font-bold text-gray-500 uppercase">{label}</label>
+            <input className={inputClass} {...props} value={form[key] || ''} onChange={e => setForm({ ...form, [key]: e.target.value })} />
+        </div>
+    );
+
+    return (
+        <div className="grid grid-cols-1 lg:grid-cols-2 gap-6 animate-in fade-in duration-500">
+            <form onSubmit={handleSave} className="bg-white p-5 rounded-xl shadow-sm border border-gray-200 space-y-3">
+                <h3 className="font-bold text-gray-700 flex items-center gap-2">
+                    <UserCircle size={18} className="text-blue-600"/> Meus dados
+                    <span className="ml-auto text-xs font-normal text-gray-400">@{profile.username}</span>
+                </h3>
+                {field('Nome completo', 'full_name', { maxLength: 100 })}
+                {field('E-mail', 'email', { type: 'email', maxLength: 100 })}
+                {emailChanged && (
+                    <div>
+                        <label className="text-xs font-bold text-gray-500 uppercase">Senha atual (para trocar o e-mail)</label>
+                        <input type="password" required autoComplete="current-password" className={inputClass}
+                            value={emailPassword} onChange={e => setEmailPassword(e.target.value)} />
+                    </div>
+                )}
+                {profile.pending_email && (
+                    <p className="text-xs text-amber-700 bg-amber-50 border border-amber-200 rounded-lg p-2 flex items-center gap-2">
+                        <MailWarning size={14}/> Aguardando confirmação de {profile.pending_email}
+                    </p>
+                )}
+                <div className="grid grid-cols-2 gap-3">
+                    {field('Telefone', 'phone', { maxLength: 20 })}
+                    {field('Estado (UF)', 'state', { maxLength: 2 })}
+                </div>
+                {field('Endereço', 'address', { maxLength: 255 })}
+                {field('Cidade', 'city', { maxLength: 100 })}
+                <button type="submit" className="bg-blue-600 hover:bg-blue-700 text-white px-4 py-2 rounded-lg flex items-center gap-2 text-sm font-bold transition">
+                    <Save size={16}/> Salvar
+                </button>
+            </form>
+
+            <form onSubmit={handlePassword} className="bg-white p-5 rounded-xl shadow-sm border border-gray-200 space-y-3 self-start">
+                <h3 className="font-bold text-gray-700 flex items-center gap-2">
+                    <Lock size={18} className="text-blue-600"/> Alterar senha
+                </h3>
+                {profile.password_changed_at && <p className="text-xs text-gray-400">Última troca: {profile.password_changed_at}</p>}
+                {[['Senha atual', 'current_password', 'current-password'], ['Nova senha', 'new_password', 'new-password'], ['Confirme a nova senha', 'confirm', 'new-password']].map(([label, key, autoComplete]) => (
+                    <div key={key}>
+                        <label className="text-xs font-bold text-gray-500 uppercase">{label}</label>
+                        <input type="password" required autoComplete={autoComplete} className={inputClass}
+                            value={passwords[key]} onChange={e => setPasswords({ ...passwords, [key]: e.target.value })} />
+                    </div>
+                ))}
+                <button type="submit" className="bg-blue-600 hover:bg-blue-700 text-white px-4 py-2 rounded-lg text-sm font-bold transition">
+                    Alterar senha
+                </button>
+            </form>
+
+            <div className="bg-white p-5 rounded-xl shadow-sm border border-gray-200 space-y-3 self-start">
+                <h3 className="font-bold text-gray-700 flex items-center gap-2">
+                    <KeyRound size={18} className="text-blue-600"/> Login corporativo (SSO)
+                </h3>
+                <p className="text-xs text-gray-500">Vincula esta conta à identidade do provedor da sua organização (o e-mail precisa ser o mesmo).</p>
+                <button type="button" onClick={handleLinkSSO} className="bg-blue-600 hover:bg-blue-700 text-white px-4 py-2 rounded-lg text-sm font-bold transition">
+                    Vincular ao SSO
+                </button>
+            </div>
+        </div>
+    );
+}
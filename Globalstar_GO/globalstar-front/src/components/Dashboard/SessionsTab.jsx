@@ -0,0 +1,127 @@
+import React, { useEffect, useState } from 'react';
+import { MonitorSmartphone, RefreshCw, LogOut } from 'lucide-react';
+import api from '../../services/api';
+
+// Navegador e sistema a partir do user agent (só para exibição)
+const describeAgent = (ua) => {
+    if (!ua) return 'Desconhecido';
+    const browser = [['Edg/', 'Edge'], ['OPR/', 'Opera'], ['Firefox/', 'Firefox'], ['Chrome/', 'Chrome'], ['Safari/', 'Safari']]
+        .find(([token]) => ua.includes(token))?.[1];
+    const os = [['Windows', 'Windows'], ['Android', 'Android'], ['iPhone', 'iOS'], ['iPad', 'iOS'], ['Mac OS', 'macOS'], ['Linux', 'Linux']]
+        .find(([token]) => ua.includes(token))?.[1];
+    return browser ? `${browser}${os ? ` em ${os}` : ''}` : ua.slice(0, 60);
+};
+
+// Onde a conta está conectada. Com sessions:manage (master) dá para escolher
+// outro usuário e encerrar as sessões dele.
+export default function SessionsTab({ users, canManage }) {
+    const [sessions, setSessions] = useState([]);
+    const [loading, setLoading] = useState(false);
+    const [userId, setUserId] = useState(0); // 0 = minhas sessões
+
+    const fetchSessions = async () => {
+        setLoading(true);
+        try {
+            const res = userId
+                ? await api.get(`/api/master/sessions?user_id=${userId}`)
+                : await api.get('/api/sessions');
+            setSessions(res.data || []);
+        } catch (error) {
+            console.error("Erro ao buscar sessões", error);
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    useEffect(() => {
+        fetchSessions();
+    }, [userId]);
+
+    const handleRevoke = async (id) => {
+        if (!confirm("Encerrar esta sessão? O dispositivo precisará fazer login novamente.")) return;
+        try {
+            if (userId) {
+                await api.post('/api/master/sessions/revoke', { id });
+            } else {
+                await api.post('/api/sessions/revoke', { id });
+            }
+            fetchSessions();
+        } catch (err) { alert("Erro ao encerrar sessão"); }
+    };
+
+    const handleRevokeAll = async () => {
+        const msg = userId
+            ? "Encerrar todas as sessões deste usuário?"
+            : "Encerrar todas as sessões em outros dispositivos?";
+        if (!confirm(msg)) return;
+        try {
+            if (userId) {
+                await api.post('/api/master/sessions/revoke', { user_id: userId });
+            } else {
+                await api.post('/api/sessions/revoke', { others: true });
+            }
+            fetchSessions();
+        } catch (err) { alert("Erro ao encerrar sessões"); }
+    };
+
+    return (
+        <div className="space-y-6 animate-in fade-in duration-500">
+            <div className="bg-white rounded-xl shadow-sm border border-gray-200 overflow-hidden">
+                <div className="p-4 border-b border-gray-200 bg-gray-50 flex flex-wrap gap-3 justify-between items-center">
+                    <h3 className="font-bold text-gray-700 flex items-center gap-2">
+                        <MonitorSmartphone size={18} className="text-blue-600"/> Sessões ativas
+                    </h3>
+                    <div className="flex items-center gap-2">
+                        {canManage && users?.length > 0 && (
+                            <select className="px-3 py-1.5 border border-gray-200 rounded-lg text-sm bg-white"
+                                value={userId} onChange={e => setUserId(parseInt(e.target.value) || 0)}>
+                                <option value={0}>Minhas sessões</option>
+                                {users.map(u => <option key={u.id} value={u.id}>@{u.username}</option>)}
+                            </select>
+                        )}
+                        <button onClick={handleRevokeAll} className="px-3 py-1.5 text-sm font-bold text-red-600 hover:bg-red-50 rounded-lg transition">
+                            {userId ? 'Encerrar todas' : 'Sair dos outros dispositivos'}
+                        </button>
+                        <button onClick={fetchSessions} className="p-2 text-gray-500 hover:text-blue-600 transition">
+                            <RefreshCw size={18} className={loading ? "animate-spin" : ""} />
+                        </button>
+                    </div>
+                </div>
+                <table className="w-full text-sm text-left">
+                    <thead className="bg-gray-100 text-gray-500 font-semibold">
+                        <tr>
+                            <th className="p-3">Dispositivo</th>
+                            <th className="p-3">IP</th>
+                            <th className="p-3">Início</th>
+                            <th className="p-3">Último acesso</th>
+                            <th className="p-3 text-right">Ações</th>
+                        </tr>
+                    </thead>
+                    <tbody className="divide-y divide-gray-100">
+                        {sessions.map(s => (
+                            <tr key={s.id} className="hover:bg-gray-50">
+                                <td className="p-3 text-gray-700" title={s.user_agent}>
+                                    <span className="font-bold">{describeAgent(s.user_agent)}</span>
+                                    {s.current && <span className="ml-2 px-2 py-0.5 rounded text-[10px] font-bold bg-green-100 text-green-700">ESTA SESSÃO</span>}
+                                </td>
+                                <td className="p-3 font-mono text-xs text-gray-500">{s.ip_address || '—'}</td>
+                                <td className="p-3 text-gray-500 whitespace-nowrap">{s.created_at}</td>
+                                <td className="p-3 text-gray-500 whitespace-nowrap">{s.last_seen_at}</td>
+                                <td className="p-3 text-right">
+                                    {!s.current && (
+                                        <button onClick={() => handleRevoke(s.id)} className="p-2 text-red-600 hover:bg-red-50 rounded-lg" title="Encerrar sessão"><LogOut size={16}/></button>
+                                    )}
+                                </td>
+                            </tr>
+                        ))}
+                        {sessions.length === 0 && (
+                            <tr>
+                                <td colSpan="5" className="p-8 text-center text-gray-400 italic">Nenhuma sessão ativa.</td>
+                            </tr>
+                        )}
+                    </tbody>
+                </table>
+            </div>
+        </div>
+    );
+}
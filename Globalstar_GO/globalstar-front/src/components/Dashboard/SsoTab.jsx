@@ -0,0 +1,161 @@
+import React, { useEffect, useState } from 'react';
+import { Building2, Plus, RefreshCw, Edit, Trash2, X } from 'lucide-react';
+import api from '../../services/api';
+
+const emptyForm = {
+    id: 0, name: '', issuer: '', client_id: '', client_secret: '', email_domains: '',
+    role_claim: 'groups', mapping: '', default_role: 'user', jit_enabled: true, enabled: true,
+};
+
+// Mapeamento editado como texto: uma linha "valor_da_claim=papel" por entrada
+const mappingToText = (mapping) => Object.entries(mapping || {}).map(([k, v]) => `${k}=${v}`).join('\n');
+const textToMapping = (text) => Object.fromEntries(
+    text.split('\n').map(l => l.split('=').map(s => s.trim())).filter(([k, v]) => k && v)
+);
+
+// Provedores de identidade (OpenID Connect) da organização
+export default function SsoTab() {
+    const [providers, setProviders] = useState([]);
+    const [loading, setLoading] = useState(false);
+    const [form, setForm] = useState(null);
+
+    const fetchProviders = async () => {
+        setLoading(true);
+        try {
+            const res = await api.get('/api/master/sso-providers');
+            setProviders(res.data || []);
+        } catch (error) {
+            console.error("Erro ao buscar provedores", error);
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    useEffect(() => {
+        fetchProviders();
+    }, []);
+
+    const handleSave = async (e) => {
+        e.preventDefault();
+        const { mapping, ...payload } = form;
+        try {
+            await api.post('/api/master/sso-providers', { ...payload, role_mapping: textToMapping(mapping) });
+            setForm(null);
+            fetchProviders();
+        } catch (err) { alert(err.response?.data || "Erro ao salvar provedor"); }
+    };
+
+    const handleDelete = async (id) => {
+        if (!confirm("Excluir este provedor? Os usuários vinculados voltam a entrar só com senha.")) return;
+        try {
+            await api.post('/api/master/sso-providers/delete', { id });
+            fetchProviders();
+        } catch (err) { alert("Erro ao excluir provedor"); }
+    };
+
+    const field = (label, key, props = {}) => (
+        <div>
+            <label className="text-xs font-bold text-gray-500 uppercase">{label}</label>
+            <input className="w-full mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm" {...props}
+                value={form[key]} onChange={e => setForm({ ...form, [key]: e.target.value })} />
+        </div>
+    );
+
+    return (
+        <div className="space-y-6 animate-in fade-in duration-500">
+            {form && (
+                <form onSubmit={handleSave} className="bg-white p-4 rounded-xl shadow-sm border border-gray-200 space-y-3">
+                    <div className="flex justify-between items-center">
+                        <h3 className="font-bold text-gray-700">{form.id ? 'Editar provedor' : 'Novo provedor'}</h3>
+                        <button type="button" onClick={() => setForm(null)} className="p-1 text-gray-400 hover:text-gray-700"><X size={18}/></button>
+                    </div>
+                    <div className="grid grid-cols-1 md:grid-cols-2 gap-3">
+                        {field('Nome', 'name', { required: true, placeholder: 'Ex: Azure AD Fazenda Norte' })}
+                        {field('Emissor (issuer)', 'issuer', { required: true, placeholder: 'https://login.empresa.com' })}
+                        {field('Client ID', 'client_id', { required: true })}
+                        {field('Client secret', 'client_secret', { type: 'password', placeholder: form.has_client_secret ? '(mantido)' : 'Opcional com PKCE' })}
+                        {field('Domínios de e-mail', 'email_domains', { required: true, placeholder: 'empresa.com,empresa.com.br' })}
+                        {field('Claim de grupos', 'role_claim', { placeholder: 'groups' })}
+                        <div>
+                            <label className="text-xs font-bold text-gray-500 uppercase">Papel padrão</label>
+                            <select className="w-full mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm bg-white"
+                                value={form.default_role} onChange={e => setForm({ ...form, default_role: e.target.value })}>
+                                <option value="user">Usuário</option>
+                                <option value="support">Suporte</option>
+                                <option value="admin">Admin</option>
+                            </select>
+                        </div>
+                        <div>
+                            <label className="text-xs font-bold text-gray-500 uppercase">Grupo = papel (um por linha)</label>
+                            <textarea rows="3" className="w-full mt-1 px-3 py-2 border border-gray-200 rounded-lg text-sm font-mono" placeholder="ti-admins=admin"
+                                value={form.mapping} onChange={e => setForm({ ...form, mapping: e.target.value })} />
+                        </div>
+                    </div>
+                    <div className="flex flex-wrap gap-4 items-center">
+                        <label className="flex items-center gap-2 text-sm text-gray-600">
+                            <input type="checkbox" checked={form.jit_enabled} onChange={e => setForm({ ...form, jit_enabled: e.target.checked })} />
+                            Criar usuários no primeiro login
+                        </label>
+                        <label className="flex items-center gap-2 text-sm text-gray-600">
+                            <input type="checkbox" checked={form.enabled} onChange={e => setForm({ ...form, enabled: e.target.checked })} />
+                            Ativo
+                        </label>
+                        <button type="submit" className="ml-auto bg-blue-600 hover:bg-blue-700 text-white px-4 py-2 rounded-lg text-sm font-bold transition">Salvar</button>
+                    </div>
+                </form>
+            )}
+
+            <div className="bg-white rounded-xl shadow-sm border border-gray-200 overflow-hidden">
+                <div className="p-4 border-b border-gray-200 bg-gray-50 flex justify-between items-center">
+                    <h3 className="font-bold text-gray-700 flex items-center gap-2">
+                        <Building2 size={18} className="text-blue-600"/> Login único (SSO)
+                    </h3>
+                    <div className="flex items-center gap-2">
+                        <button onClick={() => setForm({ ...emptyForm })} className="bg-blue-600 hover:bg-blue-700 text-white px-3 py-1.5 rounded-lg flex items-center gap-2 text-sm font-bold transition">
+                            <Plus size={16}/> Novo provedor
+                        </button>
+                        <button onClick={fetchProviders} className="p-2 text-gray-500 hover:text-blue-600 transition">
+                            <RefreshCw size={18} className={loading ? "animate-spin" : ""} />
+                        </button>
+                    </div>
+                </div>
+                <table className="w-full text-sm text-left">
+                    <thead className="bg-gray-100 text-gray-500 font-semibold">
+                        <tr>
+                            <th className="p-3">Nome</th>
+                            <th className="p-3">Emissor</th>
+                            <th className="p-3">Domínios</th>
+                            <th className="p-3">Papel padrão</th>
+                            <th className="p-3">Status</th>
+                            <th className="p-3 text-right">Ações</th>
+                        </tr>
+                    </thead>
+                    <tbody className="divide-y divide-gray-100">
+                        {providers.map(p => (
+                            <tr key={p.id} className={`hover:bg-gray-50 ${p.enabled ? '' : 'opacity-40'}`}>
+                                <td className="p-3 font-bold text-gray-700">{p.name}</td>
+                                <td className="p-3 font-mono text-xs text-gray-500 break-all">{p.issuer}</td>
+                                <td className="p-3 text-gray-600">{p.email_domains}</td>
+                                <td className="p-3 text-gray-600">{p.default_role}{p.jit_enabled ? '' : ' (sem criação automática)'}</td>
+                                <td className="p-3">
+                                    <span className={`px-2 py-1 rounded text-[10px] font-bold ${p.enabled ? 'bg-green-100 text-green-700' : 'bg-gray-100 text-gray-600'}`}>
+                                        {p.enabled ? 'ATIVO' : 'INATIVO'}
+                                    </span>
+                                </td>
+                                <td className="p-3 text-right whitespace-nowrap">
+                                    <button onClick={() => setForm({ ...emptyForm, ...p, client_secret: '', mapping: mappingToText(p.role_mapping) })} className="p-2 text-blue-600 hover:bg-blue-50 rounded-lg" title="Editar"><Edit size={16}/></button>
+                                    <button onClick={() => handleDelete(p.id)} className="p-2 text-red-600 hover:bg-red-50 rounded-lg" title="Excluir"><Trash2 size={16}/></button>
+                                </td>
+                            </tr>
+                        ))}
+                        {providers.length === 0 && (
+                            <tr>
+                                <td colSpan="6" className="p-8 text-center text-gray-400 italic">Nenhum provedor configurado.</td>
+                            </tr>
+                        )}
+                    </tbody>
+                </table>
+            </div>
+        </div>
+    );
+}
@@ -1,7 +1,7 @@
 import React from 'react';
 import { XCircle } from 'lucide-react';
 
-export default function UserModal({ isOpen, onClose, user, onSubmit, currentUser }) {
+export default function UserModal({ isOpen, onClose, user, onSubmit, currentUser, canAssignMaster, organizations }) {
     if (!isOpen) return null;
 
     return (
@@ -32,17 +32,35 @@ export default function UserModal({ isOpen, onClose, user, onSubmit, currentUser
                                         <option value="user">Usuário (Visualizador)</option>
                                         <option value="support">Suporte</option>
                                         <option value="admin">Admin</option>
-                                        <option value="master">Master Admin</option>
+                                        {(canAssignMaster || user?.role === 'master') && <option value="master">Master Admin</option>}
                                     </select>
                                 </div>
                                 <div>
                                     <label className="block text-sm font-medium text-gray-700 mb-1">Usuário (Login)</label>
                                     <input name="username" defaultValue={user?.username} required className="w-full border border-gray-300 p-2.5 rounded-lg" />
                                 </div>
-                                <div>
-                                    <label className="block text-sm font-medium text-gray-700 mb-1">Senha {user && <span className="text-xs text-gray-400 font-normal">(Opcional)</span>}</label>
-                                    <input name="password" type="password" className="w-full border border-gray-300 p-2.5 rounded-lg" placeholder="••••••" />
-                                </div>
+                                {user && !user.invite_pending ? (
+                                    <div>
+                                        <label className="block text-sm font-medium text-gray-700 mb-1">Senha <span className="text-xs text-gray-400 font-normal">(Opcional)</span></label>
+                                        <input name="password" type="password" className="w-full border border-gray-300 p-2.5 rounded-lg" placeholder="••••••" />
+                                        {user.username !== currentUser && <p className="text-[11px] text-gray-400 mt-1">Senha provisória: o usuário deverá trocá-la no próximo login.</p>}
+                                    </div>
+                                ) : (
+                                    <div className="text-xs text-blue-700 bg-blue-50 border border-blue-100 rounded-lg p-3 self-end">
+                                        {user
+                                            ? 'Convite pendente: alterar o e-mail reenvia o convite para o novo endereço.'
+                                            : 'O usuário receberá um convite por e-mail para escolher a própria senha.'}
+                                    </div>
+                                )}
+                                {organizations && (
+                                    <div className="md:col-span-2">
+                                        <label className="block text-sm font-medium text-gray-700 mb-1">Organização</label>
+                                        <select name="organization_id" defaultValue={user?.organization_id || 0} className="w-full border border-gray-300 p-2.5 rounded-lg bg-white">
+                                            <option value={0}>Padrão (sem organização)</option>
+                                            {organizations.map(o => <option key={o.id} value={o.id}>{o.name}</option>)}
+                                        </select>
+                                    </div>
+                                )}
                             </div>
                         </div>
                         <div>
@@ -50,7 +68,7 @@ export default function UserModal({ isOpen, onClose, user, onSubmit, currentUser
                             <div className="grid grid-cols-1 md:grid-cols-2 gap-4">
                                 <div>
                                     <label className="block text-sm font-medium text-gray-700 mb-1">Email</label>
-                                    <input name="email" type="email" defaultValue={user?.email} className="w-full border border-gray-300 p-2.5 rounded-lg" />
+                                    <input name="email" type="email" defaultValue={user?.email} required={!user || user.invite_pending} className="w-full border border-gray-300 p-2.5 rounded-lg" />
                                 </div>
                                 <div>
                                     <label className="block text-sm font-medium text-gray-700 mb-1">Telefone</label>
@@ -1,10 +1,10 @@
 import React, { useState } from 'react';
 import { 
     Search, Filter, UserPlus, MapPin, Edit, 
-    Trash2, ChevronLeft, ChevronRight 
+    Trash2, ChevronLeft, ChevronRight, Lock, Unlock, Mail, MailX 
 } from 'lucide-react';
 
-export default function UsersTab({ users, currentUser, onEdit, onDelete, onAdd }) {
+export default function UsersTab({ users, currentUser, onEdit, onDelete, onUnlock, onResendInvite, onRevokeInvite, onAdd }) {
     // Estados locais de UI (busca e paginação pertencem à tabela)
     const [searchTerm, setSearchTerm] = useState('');
     const [roleFilter, setRoleFilter] = useState('all');
@@ -84,6 +84,17 @@ export default function UsersTab({ users, currentUser, onEdit, onDelete, onAdd }
                                     <td className="p-4">
                                         <div className="font-bold text-gray-900">{u.full_name}</div>
                                         <div className="text-xs text-gray-500 font-mono">@{u.username}</div>
+                                        {u.locked_until && (
+                                            <div className="flex items-center gap-1 text-[10px] text-red-600 font-bold mt-1" title={`Bloqueado até ${u.locked_until}`}>
+                                                <Lock size={10}/> Login bloqueado
+                                            </div>
+                                        )}
+                                        {u.invite_pending && (
+                                            <div className="flex items-center gap-1 text-[10px] text-amber-600 font-bold mt-1"
+                                                title={u.invite_expires_at ? `Link válido até ${u.invite_expires_at}` : 'Link expirado ou revogado'}>
+                                                <Mail size={10}/> {u.invite_expires_at ? 'Convite pendente' : 'Convite sem link válido'}
+                                            </div>
+                                        )}
                                     </td>
                                     <td className="p-4">
                                         <span className={`px-2 py-1 rounded-full text-[10px] font-bold uppercase tracking-wide border ${
@@ -104,6 +115,17 @@ export default function UsersTab({ users, currentUser, onEdit, onDelete, onAdd }
                                     </td>
                                     <td className="p-4 text-right">
                                         <div className="flex justify-end gap-2">
+                                            {u.locked_until && (
+                                                <button onClick={() => onUnlock(u.id)} title="Desbloquear login" className="p-2 text-amber-600 hover:bg-amber-50 rounded-lg"><Unlock size={16}/></button>
+                                            )}
+                                            {u.invite_pending && (
+                                                <>
+                                                    <button onClick={() => onResendInvite(u.id)} title="Reenviar convite" className="p-2 text-blue-600 hover:bg-blue-50 rounded-lg"><Mail size={16}/></button>
+                                                    {u.invite_expires_at && (
+                                                        <button onClick={() => onRevokeInvite(u.id)} title="Revogar convite" className="p-2 text-amber-600 hover:bg-amber-50 rounded-lg"><MailX size={16}/></button>
+                                                    )}
+                                                </>
+                                            )}
                                             <button onClick={() => onEdit(u)} className="p-2 text-blue-600 hover:bg-blue-50 rounded-lg"><Edit size={16}/></button>
                                             <button 
                                                 onClick={() => onDelete(u.id)} 
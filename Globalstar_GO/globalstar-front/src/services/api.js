@@ -18,4 +18,48 @@ api.interceptors.request.use((config) => {
   return Promise.reject(error);
 });
 
+// Renovação: o access token dura poucos minutos. Num 401, troca o refresh token
+// por um novo par (uma única chamada para todas as requisições que falharem
+// juntas) e repete a requisição original.
+let refreshing = null;
+
+const refreshTokens = async () => {
+  const refreshToken = localStorage.getItem('refresh_token');
+  if (!refreshToken) throw new Error('Sem refresh token');
+  const res = await axios.post('/api/auth/refresh', { refresh_token: refreshToken });
+  localStorage.setItem('token', res.data.token);
+  localStorage.setItem('refresh_token', res.data.refresh_token);
+  localStorage.setItem('role', res.data.role);
+  localStorage.setItem('permissions', JSON.stringify(res.data.permissions || []));
+  return res.data.token;
+};
+
+// refreshSession: Renova o par de tokens (compartilhada entre chamadas simultâneas)
+export const refreshSession = () => {
+  refreshing = refreshing || refreshTokens().finally(() => { refreshing = null; });
+  return refreshing;
+};
+
+api.interceptors.response.use((response) => response, async (error) => {
+  const original = error.config;
+  const url = original?.url || '';
+  // Etapas do login (2FA, troca de senha, SSO) devolvem 401 por código/ticket inválido, não por sessão expirada
+  const loginStep = url === '/login' || url.startsWith('/api/login/') || (url.startsWith('/api/sso/') && url !== '/api/sso/link');
+  if (error.response?.status !== 401 || original._retry || loginStep || url === '/api/auth/refresh') {
+    return Promise.reject(error);
+  }
+  original._retry = true;
+
+  try {
+    const token = await refreshSession();
+    original.headers.Authorization = `Bearer ${token}`;
+    return api(original);
+  } catch (refreshError) {
+    // Sessão revogada ou expirada: volta para o login
+    localStorage.clear();
+    window.location.href = '/';
+    return Promise.reject(refreshError);
+  }
+});
+
 export default api;
\ No newline at end of file
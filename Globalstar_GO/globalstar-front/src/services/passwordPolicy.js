@@ -0,0 +1,7 @@
+// Erro estruturado da política de senhas (422 com "violations") em texto para a tela.
+// Retorna null se o erro não for de política.
+export const passwordPolicyMessage = (err) => {
+  const data = err.response?.data;
+  if (err.response?.status !== 422 || data?.error !== 'password_policy') return null;
+  return (data.violations || []).map(v => v.message).join('. ') || data.message;
+};
@@ -53,9 +53,6 @@ CREATE TABLE IF NOT EXISTS audit_logs (
 
 -- --- DADOS INICIAIS (SEED) ---
 
--- Criar utilizador MASTER padrão
--- Login: admin
--- Senha: admin (Hash gerado com custo 14)
-INSERT IGNORE INTO users (username, password_hash, role, full_name) 
-VALUES ('Admin-Master', '$2a$14$K92F7sZrYY1fQBUOJ2LQheymCEQcDqELgWDIe2O7cRtS6FgbEfpA.', 'master', 'Administrador do Sistema');
--- Nota: O hash acima é ilustrativo. Se não funcionar, use o script gerar_senha.go para criar um novo hash para "admin" e substitua aqui.
\ No newline at end of file
+-- O utilizador MASTER inicial (Admin-Master) não é mais criado aqui: o backend
+-- cria-o na primeira inicialização com a senha de MASTER_INITIAL_PASSWORD (ou uma
+-- senha aleatória exibida uma vez no log) e troca obrigatória no primeiro login.
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"iot_modulo1.0/pkg/notify"
+)
+
+// --- CONVITES ---
+// Contas novas não recebem senha de quem as cadastra: o usuário é criado com
+// uma senha aleatória inutilizável e recebe por e-mail um link de ativação de
+// uso único (mesma tabela e mecânica da recuperação de senha, com purpose
+// "invite"), onde escolhe a própria senha. O admin pode reenviar o convite
+// (o link anterior deixa de valer) ou revogá-lo.
+
+const (
+	resetPurposeReset  = "reset"
+	resetPurposeInvite = "invite"
+	inviteTTL          = 72 * time.Hour
+)
+
+// sendInvite: Gera um novo link de ativação (invalidando os anteriores) e envia por e-mail
+func sendInvite(userID int, fullName, email string) error {
+	db.Exec("DELETE FROM password_resets WHERE email = ? AND purpose = ?", email, resetPurposeInvite)
+
+	token := uuid.New().String()
+	_, err := db.Exec("INSERT INTO password_resets (email, token, expires_at, purpose) VALUES (?, ?, ?, ?)",
+		email, token, time.Now().Add(inviteTTL), resetPurposeInvite)
+	if err != nil {
+		return err
+	}
+
+	if !notifier.Has(notify.ChannelEmail) {
+		log.Printf("Aviso: SMTP não configurado. Convite gerado para %s: %s", email, token)
+		return nil
+	}
+	link := fmt.Sprintf("%s/activate?token=%s", os.Getenv("FRONTEND_URL"), token)
+	return notifier.Enqueue(notify.Notification{
+		UserID:   userID,
+		Channel:  notify.ChannelEmail,
+		To:       email,
+		Template: "user_invite",
+		Data:     map[string]interface{}{"Name": fullName, "Link": link},
+	})
+}
+
+// createInvitedUser: Cadastra a conta pendente (sem senha conhecida) e envia o convite
+func createInvitedUser(u UserData) (int, error) {
+	placeholder, err := randomHex(32)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := hashPassword(placeholder)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec(`INSERT INTO users (username, password_hash, role, full_name, email, phone, address, city, state, organization_id, invite_pending) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0), 1)`,
+		u.Username, hash, u.Role, u.FullName, u.Email, u.Phone, u.Address, u.City, u.State, u.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if err := sendInvite(int(id), u.FullName, u.Email); err != nil {
+		log.Printf("Aviso: Falha ao enviar convite para %s: %v", u.Email, err)
+	}
+	return int(id), nil
+}
+
+// loadInvitedUser: Conta com convite pendente que o autor pode administrar
+func loadInvitedUser(w http.ResponseWriter, r *http.Request, id int) (username, fullName, email string, ok bool) {
+	var role string
+	var orgID int
+	var pending bool
+	err := db.QueryRow("SELECT username, full_name, COALESCE(email, ''), role, COALESCE(organization_id, 0), invite_pending FROM users WHERE id = ?", id).
+		Scan(&username, &fullName, &email, &role, &orgID, &pending)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if !canManageRole(r.Header.Get("X-User-Role"), role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !pending {
+		http.Error(w, "Usuário já ativou a conta", http.StatusConflict)
+		return
+	}
+	return username, fullName, email, true
+}
+
+// resendInviteHandler: Novo link de ativação para a conta pendente
+func resendInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var u UserData
+	json.NewDecoder(r.Body).Decode(&u)
+
+	_, fullName, email, ok := loadInvitedUser(w, r, u.ID)
+	if !ok {
+		return
+	}
+	if email == "" {
+		http.Error(w, "Usuário sem e-mail cadastrado", http.StatusBadRequest)
+		return
+	}
+	if err := sendInvite(u.ID, fullName, email); err != nil {
+		http.Error(w, "Erro ao enviar convite", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditInviteResent, Target: auditTarget(TargetUser, u.ID),
+		Details: fmt.Sprintf("Reenviou o convite do usuário ID %d para %s", u.ID, email)})
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeInviteHandler: Invalida o link de ativação (a conta continua pendente até um novo convite)
+func revokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var u UserData
+	json.NewDecoder(r.Body).Decode(&u)
+
+	_, _, email, ok := loadInvitedUser(w, r, u.ID)
+	if !ok {
+		return
+	}
+	db.Exec("DELETE FROM password_resets WHERE email = ? AND purpose = ?", email, resetPurposeInvite)
+
+	recordAudit(r, AuditEvent{Action: AuditInviteRevoked, Target: auditTarget(TargetUser, u.ID),
+		Details: fmt.Sprintf("Revogou o convite do usuário ID %d", u.ID)})
+	w.WriteHeader(http.StatusOK)
+}
+
+// acceptInviteHandler: Público. O convidado escolhe a senha e a conta fica ativa.
+func acceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var email string
+	var expiresAt time.Time
+	err := db.QueryRow("SELECT email, expires_at FROM password_resets WHERE token = ? AND purpose = ?", req.Token, resetPurposeInvite).
+		Scan(&email, &expiresAt)
+	if err != nil {
+		http.Error(w, "Convite inválido", http.StatusBadRequest)
+		return
+	}
+	if time.Now().After(expiresAt) {
+		http.Error(w, "Convite expirado", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var username string
+	if err := db.QueryRow("SELECT id, username FROM users WHERE email = ? AND invite_pending = 1", email).Scan(&userID, &username); err != nil {
+		http.Error(w, "Convite inválido", http.StatusBadRequest)
+		return
+	}
+	// Violação mantém o convite: o usuário escolhe outra senha e envia de novo
+	if violations := checkPassword(userID, username, req.NewPassword); len(violations) > 0 {
+		writePasswordPolicyError(w, violations)
+		return
+	}
+	if err := setPassword(userID, req.NewPassword, false); err != nil {
+		http.Error(w, "Erro ao salvar senha", http.StatusInternalServerError)
+		return
+	}
+	db.Exec("DELETE FROM password_resets WHERE email = ?", email)
+
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditInviteAccepted,
+		Target: auditTarget(TargetUser, userID), Details: "Conta ativada pelo link de convite"})
+	w.WriteHeader(http.StatusOK)
+}
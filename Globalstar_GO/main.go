@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"net/smtp"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"iot_modulo1.0/pkg/alerts"
+	"iot_modulo1.0/pkg/geofence"
 	"iot_modulo1.0/pkg/globalstar"
+	"iot_modulo1.0/pkg/notify"
+	"iot_modulo1.0/pkg/realtime"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -31,11 +36,16 @@ var db *sql.DB
 var upgrader = websocket.Upgrader{
 	// Isso garante que o WebSocket funcione vindo de qualquer IP
 	CheckOrigin: func(r *http.Request) bool { return true },
+	// Token via subprotocolo: new WebSocket(url, ["bearer", token])
+	Subprotocols: []string{"bearer"},
 }
 
-// Canal para broadcast de mensagens (usado pelo pacote globalstar e handlers)
-var broadcast = make(chan interface{})
-var clients = make(map[*websocket.Conn]bool)
+// Canal para broadcast de mensagens (usado pelo pacote globalstar e handlers).
+// Os envios bloqueiam em vez de descartar; o buffer absorve picos enquanto o hub distribui.
+var broadcast = make(chan interface{}, 1024)
+
+// Hub: conexões autenticadas, cada uma com fila e goroutine de escrita próprias
+var hub = realtime.NewHub(loadDevicePermissions, loadGroupDevices)
 
 // --- ESTRUTURAS ---
 type Credentials struct {
@@ -46,6 +56,13 @@ type Credentials struct {
 type Claims struct {
 	UserID int    `json:"user_id"`
 	Role   string `json:"role"`
+	// Precisa bater com users.token_version (ver authenticate)
+	TokenVersion int `json:"ver"`
+	// Sessão (família do refresh token); sessão revogada derruba o token na hora
+	SessionID string `json:"sid,omitempty"`
+	// Organização e username atuais, lidos do banco a cada requisição (não vão no token)
+	OrgID    int    `json:"-"`
+	Username string `json:"-"`
 	jwt.RegisteredClaims
 }
 
@@ -60,12 +77,21 @@ type UserData struct {
 	Address  string `json:"address"`
 	City     string `json:"city"`
 	State    string `json:"state"`
+	// 0 = organização padrão; só master escolhe (admins criam na própria)
+	OrganizationID int `json:"organization_id"`
+	// Login bloqueado por excesso de falhas até esta data (só leitura)
+	LockedUntil string `json:"locked_until,omitempty"`
+	// Conta aguardando ativação pelo convite e validade do link atual (vazio = revogado)
+	InvitePending   bool   `json:"invite_pending,omitempty"`
+	InviteExpiresAt string `json:"invite_expires_at,omitempty"`
 }
 
 type PermissionRequest struct {
 	UserID   int    `json:"user_id"`
 	DeviceID int    `json:"device_id"`
 	Action   string `json:"action"`
+	// Nível do vínculo no "grant" (padrão viewer); grant em vínculo existente troca o nível
+	Level string `json:"level"`
 }
 
 type DeviceUpdate struct {
@@ -75,28 +101,38 @@ type DeviceUpdate struct {
 
 // Estrutura para Logs de Auditoria
 type AuditLog struct {
-	ID        int    `json:"id"`
-	Username  string `json:"username"`
-	Action    string `json:"action"`
-	Details   string `json:"details"`
-	IP        string `json:"ip_address"`
-	CreatedAt string `json:"created_at"`
+	ID         int             `json:"id"`
+	UserID     int             `json:"user_id"`
+	Username   string          `json:"username"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Details    string          `json:"details"`
+	Changes    json.RawMessage `json:"changes,omitempty"`
+	IP         string          `json:"ip_address"`
+	RequestID  string          `json:"request_id,omitempty"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	CreatedAt  string          `json:"created_at"`
 }
 
 // --- FUNÇÕES AUXILIARES ---
 
-// createAuditLog: Grava logs de segurança no banco de dados
-func createAuditLog(userID int, username, action, details, ip string) {
-	log.Printf("[AUDIT] User: %s | Action: %s | Det: %s", username, action, details)
-
-	go func() {
-		// Tenta gravar no banco (assume que userID pode ser 0 ou NULL dependendo do schema)
-		_, err := db.Exec("INSERT INTO audit_logs (user_id, username, action, details, ip_address) VALUES (?, ?, ?, ?, ?)",
-			userID, username, action, details, ip)
-		if err != nil {
-			log.Printf("ERRO CRÍTICO AO GRAVAR LOG: %v", err)
-		}
-	}()
+// hasDevicePermission: Master acessa tudo; o dispositivo precisa ser da organização do
+// usuário e, sem devices:read_all, estar vinculado a ele com nível >= minLevel.
+// Usa as permissões efetivas da requisição (uma chave de API só leitura não passa
+// pelo atalho de master nem configura nada).
+func hasDevicePermission(r *http.Request, deviceID int, minLevel string) bool {
+	if crossTenant(r) {
+		return true
+	}
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	var exists int
+	err := db.QueryRow(`SELECT 1 FROM devices d
+		WHERE d.id = ? AND COALESCE(d.organization_id, 0) = ?
+		AND (? OR EXISTS (SELECT 1 FROM user_permissions up WHERE up.user_id = ? AND up.device_id = d.id
+			AND FIELD(up.access_level, 'viewer', 'operator', 'owner') >= ?))`,
+		deviceID, orgOf(r), can(r, PermDevicesReadAll), userID, accessRank[minLevel]).Scan(&exists)
+	return err == nil
 }
 
 func initDB() {
@@ -123,59 +159,142 @@ func initDB() {
 	}
 	fmt.Println("Conectado ao MySQL!")
 
-	// Criar tabela de recuperação de senha se não existir
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS password_resets (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			email VARCHAR(255) NOT NULL,
-			token VARCHAR(255) NOT NULL UNIQUE,
-			expires_at DATETIME NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+	// Criar tabelas auxiliares (recuperação de senha, alertas...) se não existirem
+	ensureSchema()
+}
+
+// initPubSub: Redis (REDIS_URL) para várias réplicas; senão, em memória
+func initPubSub() realtime.PubSub {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return realtime.NewMemoryPubSub()
+	}
+	prefix := os.Getenv("REDIS_PREFIX")
+	if prefix == "" {
+		prefix = "globalstar"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bus, err := realtime.NewRedisPubSub(ctx, url, prefix)
 	if err != nil {
-		log.Printf("Aviso: Falha ao criar tabela password_resets: %v", err)
+		log.Fatal("Redis Offline:", err)
 	}
+	fmt.Println("Eventos em tempo real via Redis!")
+	return bus
 }
 
 // --- WEBSOCKET HANDLERS ---
 
-// handleConnections: Gerencia novas conexões WebSocket
+// handleConnections: Gerencia novas conexões WebSocket (exige JWT no handshake)
 func handleConnections(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(wsToken(r))
+	if err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Erro WS Upgrade: %v", err)
 		return
 	}
-	defer ws.Close()
 
-	clients[ws] = true
+	// Último event_id recebido antes de cair (replay do que foi perdido)
+	resumeFrom, _ := strconv.ParseInt(r.URL.Query().Get("resume_from"), 10, 64)
 
-	// Loop para manter conexão ativa
-	for {
-		var msg map[string]interface{}
-		// Lê mensagens do cliente (ping/pong ou comandos)
-		err := ws.ReadJSON(&msg)
-		if err != nil {
-			delete(clients, ws)
-			break
-		}
+	// Bloqueia até a conexão fechar (ping/pong e escrita ficam com o hub)
+	id := realtime.Identity{UserID: claims.UserID, SeesAll: roleHas(claims.Role, PermOrgsManage), SessionID: claims.SessionID}
+	hub.ServeClient(ws, id, resumeFrom)
+}
+
+// streamHandler: Mesmo feed do WebSocket via Server-Sent Events (GET /api/stream).
+// Tópicos em ?topics=device:12,event:ALERT_UPDATE (padrão: tudo o que o usuário vê).
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tokenStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenStr == "" {
+		// EventSource do navegador não envia cabeçalhos
+		tokenStr = r.URL.Query().Get("token")
+	}
+	claims, err := authenticate(tokenStr)
+	if err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+
+	var topics []string
+	if t := r.URL.Query().Get("topics"); t != "" {
+		topics = strings.Split(t, ",")
+	}
+	// Reconexão automática envia Last-Event-ID; a primeira conexão pode usar ?last_event_id=
+	lastEventID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if lastEventID == 0 {
+		lastEventID, _ = strconv.ParseInt(r.URL.Query().Get("last_event_id"), 10, 64)
+	}
+
+	id := realtime.Identity{UserID: claims.UserID, SeesAll: roleHas(claims.Role, PermOrgsManage), SessionID: claims.SessionID}
+	if err := hub.ServeStream(w, r, id, topics, lastEventID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
 }
 
-// handleMessages: Goroutine que distribui mensagens para todos os clientes conectados
-func handleMessages() {
-	for {
-		msg := <-broadcast
-		for client := range clients {
-			err := client.WriteJSON(msg)
-			if err != nil {
-				log.Printf("Erro WS Write: %v", err)
-				client.Close()
-				delete(clients, client)
-			}
+// wsToken: JWT enviado em ?token= ou no subprotocolo ("bearer", "<token>")
+func wsToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, p := range protocols {
+		if p == "bearer" && i+1 < len(protocols) {
+			return protocols[i+1]
 		}
 	}
+	return ""
+}
+
+// loadDevicePermissions: Dispositivos da organização do usuário (com devices:read_all)
+// ou só os vinculados a ele em user_permissions
+func loadDevicePermissions(userID int) map[int]bool {
+	devices := make(map[int]bool)
+	var role string
+	var orgID int
+	if err := db.QueryRow("SELECT role, COALESCE(organization_id, 0) FROM users WHERE id = ?", userID).Scan(&role, &orgID); err != nil {
+		return devices
+	}
+	rows, err := db.Query(`SELECT d.id FROM devices d WHERE COALESCE(d.organization_id, 0) = ?
+		AND (? OR EXISTS (SELECT 1 FROM user_permissions up WHERE up.user_id = ? AND up.device_id = d.id))`,
+		orgID, roleHas(role, PermDevicesReadAll), userID)
+	if err != nil {
+		log.Printf("Erro ao carregar permissões WS do usuário %d: %v", userID, err)
+		return devices
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		devices[id] = true
+	}
+	return devices
+}
+
+// loadGroupDevices: Dispositivos de um grupo (inscrição "group:<id>" no WS)
+func loadGroupDevices(groupID int) map[int]bool {
+	devices := make(map[int]bool)
+	rows, err := db.Query("SELECT device_id FROM device_group_members WHERE group_id = ?", groupID)
+	if err != nil {
+		log.Printf("Erro ao carregar membros do grupo %d: %v", groupID, err)
+		return devices
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		devices[id] = true
+	}
+	return devices
 }
 
 // --- API HANDLERS ---
@@ -186,35 +305,70 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewDecoder(r.Body).Decode(&creds)
 
 	var storedHash, role, fullName string
-	var userID int
+	var userID, version int
+
+	var totpEnabled, mustChange bool
+	var changedAt sql.NullTime
+
+	// Bloqueio/atraso por IP e por conta vale antes mesmo de conferir a senha
+	ip, account := clientIP(r), accountKey(creds.Username)
+	if wait := loginWait(ip, account); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
 
-	err := db.QueryRow("SELECT id, password_hash, role, full_name FROM users WHERE username = ?", creds.Username).Scan(&userID, &storedHash, &role, &fullName)
+	err := db.QueryRow("SELECT id, password_hash, role, full_name, token_version, totp_enabled, must_change_password, password_changed_at FROM users WHERE username = ?", creds.Username).Scan(&userID, &storedHash, &role, &fullName, &version, &totpEnabled, &mustChange, &changedAt)
 	if err != nil {
+		registerLoginFailure(r, 0, account)
 		http.Error(w, "Credenciais inválidas", http.StatusUnauthorized)
 		return
 	}
 
 	if err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(creds.Password)); err != nil {
-		createAuditLog(userID, creds.Username, "LOGIN_FAILED", "Senha incorreta", r.RemoteAddr)
+		recordAudit(r, AuditEvent{ActorID: userID, Actor: creds.Username, Action: AuditLoginFailed,
+			Target: auditTarget(TargetUser, userID), Details: "Senha incorreta"})
+		registerLoginFailure(r, userID, account)
 		http.Error(w, "Credenciais inválidas", http.StatusUnauthorized)
 		return
 	}
 
-	createAuditLog(userID, creds.Username, "LOGIN", "Login realizado com sucesso", r.RemoteAddr)
+	// Primeiro acesso ou senha expirada: troca antes de seguir (ver password.go)
+	if reason := passwordChangeReason(mustChange, changedAt); reason != "" {
+		requirePasswordChange(w, r, userID, creds.Username, reason)
+		return
+	}
+
+	finishLogin(w, r, userID, creds.Username, role, fullName, version, totpEnabled, "Login realizado com sucesso")
+}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		UserID:           userID,
-		Role:             role,
-		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expirationTime)},
+// finishLogin: Depois da primeira etapa (senha ou SSO), entrega o desafio do
+// 2FA (trocado em /api/login/2fa) ou os tokens
+func finishLogin(w http.ResponseWriter, r *http.Request, userID int, username, role, fullName string, version int, totpEnabled bool, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	if totpEnabled || mfaRequiredRoles[role] {
+		purpose, key := mfaPurposeVerify, "mfa_required"
+		if !totpEnabled {
+			purpose, key = mfaPurposeEnroll, "mfa_enrollment_required"
+		}
+		challenge, err := newMFAChallenge(userID, purpose)
+		if err != nil {
+			http.Error(w, "Erro ao iniciar 2FA", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{key: true, "mfa_token": challenge})
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, _ := token.SignedString(jwtKey)
+	throttleClear(scopeLoginUser, accountKey(username))
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditLogin, Target: auditTarget(TargetUser, userID), Details: details})
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": tokenString, "role": role, "username": creds.Username, "full_name": fullName,
-	})
+	// Access token curto + refresh token (nova família de sessão)
+	access, refresh, err := issueTokens(r, userID, role, version, "")
+	if err != nil {
+		http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, access, refresh, role, username, fullName)
 }
 
 // --- RECUPERAÇÃO DE SENHA ---
@@ -228,11 +382,23 @@ func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Cota por IP (429) e por conta (silenciosa, para não revelar se o usuário existe)
+	if hits, resetAt := throttleHit(scopeResetIP, clientIP(r), resetWindow); hits > resetPerIP {
+		tooManyAttempts(w, time.Until(resetAt))
+		return
+	}
+	if hits, _ := throttleHit(scopeResetUser, accountKey(req.Username), resetWindow); hits > resetPerAccount {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	var userID int
 	var email string
+	var invitePending bool
 	// Tentar achar por username ou email
-	err := db.QueryRow("SELECT id, email FROM users WHERE username = ? OR email = ?", req.Username, req.Username).Scan(&userID, &email)
-	if err != nil || email == "" {
+	err := db.QueryRow("SELECT id, email, invite_pending FROM users WHERE username = ? OR email = ?", req.Username, req.Username).Scan(&userID, &email, &invitePending)
+	// Conta com convite pendente só é ativada pelo link do convite (ou reenvio pelo admin)
+	if err != nil || email == "" || invitePending {
 		// Retornar OK de qualquer jeito para não expor a existência (ou falta) do usuário
 		w.WriteHeader(http.StatusOK)
 		return
@@ -241,38 +407,25 @@ func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	token := uuid.New().String()
 	expiresAt := time.Now().Add(1 * time.Hour)
 
-	_, err = db.Exec("INSERT INTO password_resets (email, token, expires_at) VALUES (?, ?, ?)", email, token, expiresAt)
+	_, err = db.Exec("INSERT INTO password_resets (email, token, expires_at, purpose) VALUES (?, ?, ?, ?)", email, token, expiresAt, resetPurposeReset)
 	if err != nil {
 		log.Printf("Erro ao salvar reset token: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Enviar e-mail
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	smtpFrom := os.Getenv("SMTP_FROM")
+	// Enviar e-mail (fila de notificações, com novas tentativas)
 	frontURL := os.Getenv("FRONTEND_URL") // Ex: http://localhost:5173 ou https://meusite.com
 
-	if smtpHost != "" && smtpPort != "" {
-		auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-
+	if notifier.Has(notify.ChannelEmail) {
 		resetLink := fmt.Sprintf("%s/reset-password?token=%s", frontURL, token)
-		subject := "Subject: Redefinir sua senha - Data Frontier\r\n"
-		mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-
-		body := fmt.Sprintf(`<html><body>
-			<h2>Data Frontier - Recuperação de Senha</h2>
-			<p>Você solicitou a redefinição da sua senha.</p>
-			<p>Clique no link abaixo para criar uma nova senha:</p>
-			<p><a href="%s" style="background-color: #2563EB; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Redefinir Senha</a></p>
-			<p>Se você não solicitou isso, ignore este e-mail. Este link expira em 1 hora.</p>
-		</body></html>`, resetLink)
-
-		msg := []byte(subject + mime + body)
-		err := smtp.SendMail(smtpHost+":"+smtpPort, auth, smtpFrom, []string{email}, msg)
+		err := notifier.Enqueue(notify.Notification{
+			UserID:   userID,
+			Channel:  notify.ChannelEmail,
+			To:       email,
+			Template: "password_reset",
+			Data:     map[string]interface{}{"Link": resetLink},
+		})
 		if err != nil {
 			log.Printf("Aviso: Falha ao enviar e-mail de recuperação: %v", err)
 		}
@@ -280,7 +433,8 @@ func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Aviso: SMTP não configurado. Token gerado para %s: %s", email, token)
 	}
 
-	createAuditLog(userID, req.Username, "FORGOT_PASSWORD", "Solicitação de redefinição de senha", r.RemoteAddr)
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: req.Username, Action: AuditForgotPassword,
+		Target: auditTarget(TargetUser, userID), Details: "Solicitação de redefinição de senha"})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -297,7 +451,7 @@ func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	var email string
 	var expiresAt time.Time
-	err := db.QueryRow("SELECT email, expires_at FROM password_resets WHERE token = ?", req.Token).Scan(&email, &expiresAt)
+	err := db.QueryRow("SELECT email, expires_at FROM password_resets WHERE token = ? AND purpose = ?", req.Token, resetPurposeReset).Scan(&email, &expiresAt)
 	if err != nil {
 		http.Error(w, "Token inválido", http.StatusBadRequest)
 		return
@@ -308,25 +462,31 @@ func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), 14)
-	if err != nil {
-		http.Error(w, "Erro ao criptografar senha", http.StatusInternalServerError)
+	var userID int
+	var username string
+	if err := db.QueryRow("SELECT id, username FROM users WHERE email = ?", email).Scan(&userID, &username); err != nil {
+		http.Error(w, "Token inválido", http.StatusBadRequest)
+		return
+	}
+	// Violação mantém o token: o usuário corrige a senha e envia de novo
+	if violations := checkPassword(userID, username, req.NewPassword); len(violations) > 0 {
+		writePasswordPolicyError(w, violations)
 		return
 	}
 
-	_, err = db.Exec("UPDATE users SET password_hash = ? WHERE email = ?", hash, email)
-	if err != nil {
+	if err := setPassword(userID, req.NewPassword, false); err != nil {
 		http.Error(w, "Erro ao atualizar senha", http.StatusInternalServerError)
 		return
 	}
 
 	// Deletar o token usado
 	db.Exec("DELETE FROM password_resets WHERE token = ?", req.Token)
-	db.Exec("DELETE FROM password_resets WHERE email = ?", email) // Limpa tokens antigos do usuario
+	db.Exec("DELETE FROM password_resets WHERE email = ? AND purpose = ?", email, resetPurposeReset) // Limpa tokens antigos do usuario
 
-	var userID int
-	db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&userID)
-	createAuditLog(userID, email, "RESET_PASSWORD", "Senha redefinida via link de recuperação", r.RemoteAddr)
+	// Sessões abertas com a senha antiga deixam de valer
+	revokeUserTokens(userID)
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditResetPassword,
+		Target: auditTarget(TargetUser, userID), Details: "Senha redefinida via link de recuperação"})
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -339,19 +499,23 @@ func apiMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	var rows *sql.Rows
 	var err error
 
-	if role == "master" {
-		query = `SELECT m.id, d.esn, d.name, m.payload, m.received_at, d.id 
+	// Nunca sai da organização do usuário (exceto master)
+	cond, args := orgCond(r, "d")
+
+	if roleHas(role, PermDevicesReadAll) {
+		query = `SELECT m.id, d.esn, d.name, m.payload, m.received_at, d.id, 'owner'
 		         FROM messages m JOIN devices d ON m.device_id = d.id 
+		         WHERE ` + cond + `
 		         ORDER BY m.received_at DESC LIMIT 500`
-		rows, err = db.Query(query)
+		rows, err = db.Query(query, args...)
 	} else {
-		query = `SELECT m.id, d.esn, d.name, m.payload, m.received_at, d.id 
+		query = `SELECT m.id, d.esn, d.name, m.payload, m.received_at, d.id, up.access_level
 		         FROM messages m 
 		         JOIN devices d ON m.device_id = d.id 
 		         JOIN user_permissions up ON up.device_id = d.id
-		         WHERE up.user_id = ?
+		         WHERE up.user_id = ? AND ` + cond + `
 		         ORDER BY m.received_at DESC LIMIT 500`
-		rows, err = db.Query(query, userID)
+		rows, err = db.Query(query, append([]interface{}{userID}, args...)...)
 	}
 
 	if err != nil {
@@ -368,6 +532,8 @@ func apiMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		ReceivedAt string   `json:"received_at"`
 		DeviceID   int      `json:"-"`
 		SharedWith []string `json:"shared_with"`
+		// Nível do usuário no dispositivo (o frontend esconde o que não pode fazer)
+		AccessLevel string `json:"access_level"`
 	}
 
 	messages := make([]MsgResponse, 0)
@@ -375,19 +541,19 @@ func apiMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var m MsgResponse
 		var t time.Time
-		rows.Scan(&m.ID, &m.ESN, &m.DeviceName, &m.Payload, &t, &m.DeviceID)
+		rows.Scan(&m.ID, &m.ESN, &m.DeviceName, &m.Payload, &t, &m.DeviceID, &m.AccessLevel)
 		m.ReceivedAt = t.Format("02/01/2006 15:04:05")
 		m.SharedWith = []string{}
 		messages = append(messages, m)
 	}
 
-	if len(messages) > 0 && role != "master" {
+	if len(messages) > 0 && !roleHas(role, PermDevicesReadAll) {
 		for i := range messages {
 			devID := messages[i].DeviceID
 			shareRows, _ := db.Query(`
 				SELECT u.full_name FROM users u
 				JOIN user_permissions up ON up.user_id = u.id
-				WHERE up.device_id = ? AND u.id != ?`, devID, userID)
+				WHERE up.device_id = ? AND u.id != ? AND COALESCE(u.organization_id, 0) = ?`, devID, userID, orgOf(r))
 
 			var sharers []string
 			if shareRows != nil {
@@ -408,15 +574,18 @@ func apiMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(messages)
 }
 
-// apiAuditLogsHandler: Retorna os logs para o frontend
+// apiAuditLogsHandler: Últimos 100 registros; ?target_type=&target_id= filtra pelo
+// alvo. Fora do master, só os da organização do alvo (registros anteriores à
+// coluna organization_id usam a do autor; eventos do sistema ficam de fora).
 func apiAuditLogsHandler(w http.ResponseWriter, r *http.Request) {
-	role := r.Header.Get("X-User-Role")
-	if role != "master" && role != "support" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	rows, err := db.Query("SELECT id, username, action, details, ip_address, created_at FROM audit_logs ORDER BY created_at DESC LIMIT 100")
+	q := r.URL.Query()
+	rows, err := db.Query(`SELECT a.id, COALESCE(a.user_id, 0), a.username, a.action, COALESCE(a.target_type, ''), COALESCE(a.target_id, ''),
+		a.details, COALESCE(a.changes, ''), a.ip_address, COALESCE(a.request_id, ''), COALESCE(a.user_agent, ''), a.created_at
+		FROM audit_logs a LEFT JOIN users u ON u.id = a.user_id
+		WHERE (? OR COALESCE(a.organization_id, CASE WHEN u.id IS NOT NULL THEN COALESCE(u.organization_id, 0) END) = ?)
+		AND (? = '' OR a.target_type = ?) AND (? = '' OR a.target_id = ?)
+		ORDER BY a.created_at DESC LIMIT 100`, crossTenant(r), orgOf(r),
+		q.Get("target_type"), q.Get("target_type"), q.Get("target_id"), q.Get("target_id"))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -427,7 +596,11 @@ func apiAuditLogsHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var l AuditLog
 		var t time.Time
-		rows.Scan(&l.ID, &l.Username, &l.Action, &l.Details, &l.IP, &t)
+		var changes string
+		rows.Scan(&l.ID, &l.UserID, &l.Username, &l.Action, &l.TargetType, &l.TargetID, &l.Details, &changes, &l.IP, &l.RequestID, &l.UserAgent, &t)
+		if changes != "" {
+			l.Changes = json.RawMessage(changes)
+		}
 		l.CreatedAt = t.Format("02/01/2006 15:04:05")
 		logs = append(logs, l)
 	}
@@ -442,138 +615,371 @@ func updateDeviceNameHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.Exec("UPDATE devices SET name = ? WHERE esn = ?", d.Name, d.ESN)
+	// Só renomeia dispositivos que o usuário enxerga
+	var deviceID int
+	var oldName string
+	if err := db.QueryRow("SELECT id, COALESCE(name, '') FROM devices WHERE esn = ?", d.ESN).Scan(&deviceID, &oldName); err != nil {
+		http.Error(w, "Dispositivo não encontrado", http.StatusNotFound)
+		return
+	}
+	if !hasDevicePermission(r, deviceID, AccessOperator) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	_, err := db.Exec("UPDATE devices SET name = ? WHERE id = ?", d.Name, deviceID)
 	if err != nil {
 		http.Error(w, "Erro ao atualizar nome", 500)
 		return
 	}
 
 	// 1. Auditoria
-	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
-	createAuditLog(userID, "User:"+r.Header.Get("X-User-ID"), "UPDATE_DEVICE", fmt.Sprintf("ESN %s renomeado para %s", d.ESN, d.Name), r.RemoteAddr)
-
-	// 2. Broadcast WebSocket
-	broadcast <- map[string]string{
-		"type": "DEVICE_UPDATE",
-		"esn":  d.ESN,
-		"name": d.Name,
+	recordAudit(r, AuditEvent{Action: AuditDeviceRenamed, Target: auditTarget(TargetDevice, deviceID),
+		Details: fmt.Sprintf("ESN %s renomeado para %s", d.ESN, d.Name), Changes: AuditChanges{"name": {From: oldName, To: d.Name}}})
+
+	// 2. Broadcast WebSocket (device_id permite filtrar por permissão)
+	broadcast <- map[string]interface{}{
+		"type":      "DEVICE_UPDATE",
+		"device_id": deviceID,
+		"esn":       d.ESN,
+		"name":      d.Name,
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// masterDataHandler: Usuários e dispositivos da organização (master vê todas e recebe a lista de organizações)
 func masterDataHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("X-User-Role") != "master" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	uRows, _ := db.Query("SELECT id, username, role, full_name, email, phone, address, city, state FROM users ORDER BY id DESC")
+	uCond, uArgs := orgCond(r, "u")
+	// Validade do convite e bloqueio de login ativo (só os longos; os atrasos de segundos não aparecem)
+	uArgs = append([]interface{}{resetPurposeInvite, scopeLoginUser, time.Now().Add(loginMaxDelay)}, uArgs...)
+	uRows, _ := db.Query(`SELECT u.id, u.username, u.role, u.full_name, u.email, u.phone, u.address, u.city, u.state,
+		COALESCE(u.organization_id, 0), t.blocked_until, u.invite_pending,
+		(SELECT MAX(pr.expires_at) FROM password_resets pr WHERE pr.email = u.email AND pr.purpose = ?) FROM users u
+		LEFT JOIN auth_throttle t ON t.scope = ? AND t.subject = LOWER(u.username) AND t.blocked_until > ?
+		WHERE `+uCond+` ORDER BY u.id DESC`, uArgs...)
 	users := make([]UserData, 0)
 	defer uRows.Close()
 	for uRows.Next() {
 		var u UserData
-		uRows.Scan(&u.ID, &u.Username, &u.Role, &u.FullName, &u.Email, &u.Phone, &u.Address, &u.City, &u.State)
+		var lockedUntil, inviteExpires sql.NullTime
+		uRows.Scan(&u.ID, &u.Username, &u.Role, &u.FullName, &u.Email, &u.Phone, &u.Address, &u.City, &u.State, &u.OrganizationID, &lockedUntil,
+			&u.InvitePending, &inviteExpires)
+		if lockedUntil.Valid {
+			u.LockedUntil = lockedUntil.Time.Format("02/01/2006 15:04:05")
+		}
+		// Link vencido aparece como sem convite válido (reenviar)
+		if u.InvitePending && inviteExpires.Valid && inviteExpires.Time.After(time.Now()) {
+			u.InviteExpiresAt = inviteExpires.Time.Format("02/01/2006 15:04:05")
+		}
 		users = append(users, u)
 	}
 
-	dRows, _ := db.Query("SELECT id, esn, name FROM devices")
+	dCond, dArgs := orgCond(r, "d")
+	dRows, _ := db.Query("SELECT d.id, d.esn, d.name, COALESCE(d.organization_id, 0) FROM devices d WHERE "+dCond, dArgs...)
 	type DeviceData struct {
-		ID    int      `json:"id"`
-		ESN   string   `json:"esn"`
-		Name  string   `json:"name"`
-		Users []string `json:"users"`
+		ID             int      `json:"id"`
+		ESN            string   `json:"esn"`
+		Name           string   `json:"name"`
+		OrganizationID int      `json:"organization_id"`
+		Users          []string `json:"users"`
+		// Nível de cada usuário vinculado (username -> viewer/operator/owner)
+		Access map[string]string `json:"access"`
 	}
 	devices := make([]DeviceData, 0)
 	defer dRows.Close()
 
 	for dRows.Next() {
 		var d DeviceData
-		dRows.Scan(&d.ID, &d.ESN, &d.Name)
+		dRows.Scan(&d.ID, &d.ESN, &d.Name, &d.OrganizationID)
 
-		pRows, _ := db.Query("SELECT u.username FROM users u JOIN user_permissions up ON up.user_id = u.id WHERE up.device_id = ?", d.ID)
+		pRows, _ := db.Query("SELECT u.username, up.access_level FROM users u JOIN user_permissions up ON up.user_id = u.id WHERE up.device_id = ?", d.ID)
 		usersLinked := []string{}
+		d.Access = make(map[string]string)
 		for pRows.Next() {
-			var uname string
-			pRows.Scan(&uname)
+			var uname, level string
+			pRows.Scan(&uname, &level)
 			usersLinked = append(usersLinked, uname)
+			d.Access[uname] = level
 		}
 		pRows.Close()
 		d.Users = usersLinked
 		devices = append(devices, d)
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{"users": users, "devices": devices})
+	data := map[string]interface{}{"users": users, "devices": devices}
+	if crossTenant(r) {
+		orgs, err := listOrganizations()
+		if err != nil {
+			log.Printf("Erro ao listar organizações: %v", err)
+		}
+		data["organizations"] = orgs
+	}
+	json.NewEncoder(w).Encode(data)
 }
 
 func upsertUserHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("X-User-Role") != "master" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
 	var u UserData
 	json.NewDecoder(r.Body).Decode(&u)
 
+	actorRole := r.Header.Get("X-User-Role")
 	actorID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
-	actionType := "CREATE_USER"
+	action := AuditUserCreated
 	details := fmt.Sprintf("Criou usuário %s", u.Username)
 
+	// Cadastro atual, para o diff da auditoria
+	var old UserData
 	if u.ID > 0 {
-		actionType = "UPDATE_USER"
+		action = AuditUserUpdated
 		details = fmt.Sprintf("Atualizou usuário ID %d (%s)", u.ID, u.Username)
+		err := db.QueryRow(`SELECT id, username, role, full_name, COALESCE(email, ''), COALESCE(phone, ''), COALESCE(address, ''),
+			COALESCE(city, ''), COALESCE(state, ''), COALESCE(organization_id, 0), invite_pending FROM users WHERE id = ?`, u.ID).
+			Scan(&old.ID, &old.Username, &old.Role, &old.FullName, &old.Email, &old.Phone, &old.Address, &old.City, &old.State, &old.OrganizationID, &old.InvitePending)
+		// Usuário de outra organização é tratado como inexistente
+		if err != nil || !inScope(r, old.OrganizationID) {
+			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+			return
+		}
+		// Papel em branco (ex: editando a si mesmo) mantém o atual
+		if u.Role == "" {
+			u.Role = old.Role
+		}
+	}
+	if !validRole(u.Role) {
+		http.Error(w, "Papel inválido", http.StatusBadRequest)
+		return
+	}
+	// Admin não cria, edita nem promove contas master
+	if !canManageRole(actorRole, u.Role) || (u.ID > 0 && !canManageRole(actorRole, old.Role)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// Admin de organização só cadastra na própria
+	u.OrganizationID = targetOrg(r, u.OrganizationID)
+	if !organizationExists(u.OrganizationID) {
+		http.Error(w, "Organização não encontrada", http.StatusBadRequest)
+		return
+	}
+
+	// Cadastro sem senha vira convite: o e-mail é obrigatório e identifica o link de ativação
+	u.Email = strings.TrimSpace(u.Email)
+	invite := u.ID == 0 && u.Password == ""
+	if invite && u.Email == "" {
+		http.Error(w, "E-mail obrigatório para enviar o convite", http.StatusBadRequest)
+		return
+	}
+	if u.Email != "" && !strings.EqualFold(u.Email, old.Email) && emailInUse(strings.ToLower(u.Email), u.ID) {
+		http.Error(w, "E-mail já usado por outra conta", http.StatusConflict)
+		return
+	}
+
+	// Senha nova passa pela política
+	if u.Password != "" {
+		if violations := checkPassword(u.ID, u.Username, u.Password); len(violations) > 0 {
+			writePasswordPolicyError(w, violations)
+			return
+		}
+	}
+
+	if u.ID > 0 {
+		_, err := db.Exec(`UPDATE users SET username=?, role=?, full_name=?, email=?, phone=?, address=?, city=?, state=?, organization_id=NULLIF(?, 0) WHERE id=?`,
+			u.Username, u.Role, u.FullName, u.Email, u.Phone, u.Address, u.City, u.State, u.OrganizationID, u.ID)
+		if err != nil {
+			userSaveError(w, err)
+			return
+		}
 		if u.Password != "" {
-			hash, _ := bcrypt.GenerateFromPassword([]byte(u.Password), 14)
-			db.Exec(`UPDATE users SET username=?, password_hash=?, role=?, full_name=?, email=?, phone=?, address=?, city=?, state=? WHERE id=?`,
-				u.Username, hash, u.Role, u.FullName, u.Email, u.Phone, u.Address, u.City, u.State, u.ID)
-		} else {
-			db.Exec(`UPDATE users SET username=?, role=?, full_name=?, email=?, phone=?, address=?, city=?, state=? WHERE id=?`,
-				u.Username, u.Role, u.FullName, u.Email, u.Phone, u.Address, u.City, u.State, u.ID)
+			// Senha definida por outra pessoa precisa ser trocada no próximo login
+			if err := setPassword(u.ID, u.Password, u.ID != actorID); err != nil {
+				http.Error(w, "Erro ao atualizar senha", http.StatusInternalServerError)
+				return
+			}
+			details += ", senha redefinida"
 		}
+		if old.OrganizationID != u.OrganizationID {
+			// Vínculos com dispositivos da organização anterior deixam de valer
+			db.Exec(`DELETE up FROM user_permissions up JOIN devices d ON d.id = up.device_id
+				WHERE up.user_id = ? AND COALESCE(d.organization_id, 0) != ?`, u.ID, u.OrganizationID)
+		}
+		// Convite pendente acompanha a troca de e-mail (o link antigo deixa de valer)
+		if old.InvitePending && u.Password == "" && u.Email != old.Email {
+			db.Exec("DELETE FROM password_resets WHERE email = ? AND purpose = ?", old.Email, resetPurposeInvite)
+			if u.Email != "" {
+				if err := sendInvite(u.ID, u.FullName, u.Email); err != nil {
+					log.Printf("Aviso: Falha ao reenviar convite para %s: %v", u.Email, err)
+				}
+				details += ", convite reenviado para " + u.Email
+			}
+		}
+		// Troca de papel, organização ou senha encerra as sessões do usuário na hora
+		if old.Role != u.Role || old.OrganizationID != u.OrganizationID || u.Password != "" {
+			revokeUserTokens(u.ID)
+		}
+	} else if invite {
+		id, err := createInvitedUser(u)
+		if err != nil {
+			userSaveError(w, err)
+			return
+		}
+		u.ID = id
+		u.InvitePending = true
+		action = AuditInviteSent
+		details = fmt.Sprintf("Convidou o usuário %s (%s)", u.Username, u.Email)
 	} else {
-		hash, _ := bcrypt.GenerateFromPassword([]byte(u.Password), 14)
-		db.Exec(`INSERT INTO users (username, password_hash, role, full_name, email, phone, address, city, state) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			u.Username, hash, u.Role, u.FullName, u.Email, u.Phone, u.Address, u.City, u.State)
+		// Conta criada pelo admin com senha (ex: via API): a senha inicial é trocada no primeiro login
+		hash, err := hashPassword(u.Password)
+		if err != nil {
+			http.Error(w, "Erro ao criptografar senha", http.StatusInternalServerError)
+			return
+		}
+		res, err := db.Exec(`INSERT INTO users (username, password_hash, role, full_name, email, phone, address, city, state, organization_id, must_change_password) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0), 1)`,
+			u.Username, hash, u.Role, u.FullName, u.Email, u.Phone, u.Address, u.City, u.State, u.OrganizationID)
+		if err != nil {
+			userSaveError(w, err)
+			return
+		}
+		id, _ := res.LastInsertId()
+		u.ID = int(id)
+		recordPasswordHistory(u.ID, hash)
 	}
 
-	createAuditLog(actorID, "Master", actionType, details, r.RemoteAddr)
+	// Só os campos do cadastro entram no diff (senha nunca)
+	after := u
+	after.Password, after.LockedUntil, after.InviteExpiresAt = "", "", ""
+	var before interface{}
+	if old.ID > 0 {
+		before = old
+		after.InvitePending = old.InvitePending && u.Password == ""
+	}
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetUser, u.ID), Details: details, Changes: diffChanges(before, after)})
 	w.WriteHeader(http.StatusOK)
 }
 
-func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("X-User-Role") != "master" {
-		http.Error(w, "Forbidden", 403)
+// userSaveError: Nome de usuário repetido (UNIQUE) é 409; o resto é erro interno
+func userSaveError(w http.ResponseWriter, err error) {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == 1062 {
+		http.Error(w, "Nome de usuário já está em uso", http.StatusConflict)
 		return
 	}
+	log.Printf("Erro ao salvar usuário: %v", err)
+	http.Error(w, "Erro ao salvar usuário", http.StatusInternalServerError)
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	var u UserData
 	json.NewDecoder(r.Body).Decode(&u)
-	db.Exec("DELETE FROM users WHERE id = ?", u.ID)
 
-	actorID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
-	createAuditLog(actorID, "Master", "DELETE_USER", fmt.Sprintf("Deletou usuário ID %d", u.ID), r.RemoteAddr)
+	var targetRole, username string
+	var orgID int
+	err := db.QueryRow("SELECT role, username, COALESCE(organization_id, 0) FROM users WHERE id = ?", u.ID).Scan(&targetRole, &username, &orgID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if !canManageRole(r.Header.Get("X-User-Role"), targetRole) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM users WHERE id = ?", u.ID); err != nil {
+		log.Printf("Erro ao deletar usuário %d: %v", u.ID, err)
+		http.Error(w, "Erro ao deletar usuário", http.StatusInternalServerError)
+		return
+	}
+	hub.DisconnectUser(u.ID)
+
+	recordAudit(r, AuditEvent{Action: AuditUserDeleted, Target: auditTarget(TargetUser, u.ID),
+		Details: fmt.Sprintf("Deletou usuário ID %d (%s)", u.ID, username), OrgID: orgID})
 
 	w.WriteHeader(http.StatusOK)
 }
 
 func permissionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("X-User-Role") != "master" {
-		http.Error(w, "Forbidden", 403)
-		return
-	}
 	var p PermissionRequest
 	json.NewDecoder(r.Body).Decode(&p)
 
+	// Usuário e dispositivo precisam ser da mesma organização (e da do autor)
+	userOrg, uErr := recordOrg("users", p.UserID)
+	deviceOrg, dErr := recordOrg("devices", p.DeviceID)
+	if uErr != nil || dErr != nil || !inScope(r, userOrg) || !inScope(r, deviceOrg) {
+		http.Error(w, "Usuário ou dispositivo não encontrado", http.StatusNotFound)
+		return
+	}
+	if p.Action == "grant" && userOrg != deviceOrg {
+		http.Error(w, "Usuário e dispositivo são de organizações diferentes", http.StatusBadRequest)
+		return
+	}
+
+	if p.Level == "" {
+		p.Level = AccessViewer
+	}
+	if (p.Action != "grant" && p.Action != "revoke") || !validAccessLevel(p.Level) {
+		http.Error(w, "Ação ou nível de acesso inválido", http.StatusBadRequest)
+		return
+	}
+
+	// Nível atual (vazio = sem vínculo), para auditar a mudança
+	var oldLevel string
+	db.QueryRow("SELECT access_level FROM user_permissions WHERE user_id = ? AND device_id = ?", p.UserID, p.DeviceID).Scan(&oldLevel)
+
+	// Diff no vínculo: nível antes e depois (nil = sem vínculo)
+	action, details := AuditPermissionChange, ""
+	change := FieldChange{}
+	if oldLevel != "" {
+		change.From = oldLevel
+	}
 	if p.Action == "grant" {
-		db.Exec("INSERT IGNORE INTO user_permissions (user_id, device_id) VALUES (?, ?)", p.UserID, p.DeviceID)
-	} else if p.Action == "revoke" {
-		db.Exec("DELETE FROM user_permissions WHERE user_id = ? AND device_id = ?", p.UserID, p.DeviceID)
+		change.To = p.Level
+		_, err := db.Exec(`INSERT INTO user_permissions (user_id, device_id, access_level) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE access_level = VALUES(access_level)`, p.UserID, p.DeviceID, p.Level)
+		if err != nil {
+			log.Printf("Erro ao conceder permissão (user %d, device %d): %v", p.UserID, p.DeviceID, err)
+			http.Error(w, "Erro ao salvar permissão", http.StatusInternalServerError)
+			return
+		}
+		details = fmt.Sprintf("grant device %d para user %d (%s)", p.DeviceID, p.UserID, p.Level)
+		if oldLevel != "" {
+			action = AuditPermissionLevel
+			details = fmt.Sprintf("device %d, user %d: %s -> %s", p.DeviceID, p.UserID, oldLevel, p.Level)
+		}
+	} else {
+		// Sem vínculo não há o que revogar (nem o que auditar)
+		if oldLevel == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM user_permissions WHERE user_id = ? AND device_id = ?", p.UserID, p.DeviceID); err != nil {
+			log.Printf("Erro ao revogar permissão (user %d, device %d): %v", p.UserID, p.DeviceID, err)
+			http.Error(w, "Erro ao salvar permissão", http.StatusInternalServerError)
+			return
+		}
+		details = fmt.Sprintf("revoke device %d para user %d (era %s)", p.DeviceID, p.UserID, oldLevel)
 	}
 
-	actorID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
-	createAuditLog(actorID, "Master", "PERMISSION_CHANGE", fmt.Sprintf("%s device %d para user %d", p.Action, p.DeviceID, p.UserID), r.RemoteAddr)
+	// Conexões WebSocket abertas do usuário passam a ver (ou deixam de ver) o dispositivo
+	hub.RefreshUser(p.UserID)
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetUser, p.UserID), Details: details,
+		Changes: AuditChanges{fmt.Sprintf("device:%d", p.DeviceID): change}})
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// parseToken: Valida a assinatura/expiração do JWT e retorna as claims
+func parseToken(tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) { return jwtKey, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := r.Header.Get("Authorization")
@@ -582,14 +988,38 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 		tokenStr = strings.Replace(tokenStr, "Bearer ", "", 1)
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) { return jwtKey, nil })
-		if err != nil || !token.Valid {
+
+		// Cabeçalhos internos nunca vêm do cliente
+		r.Header.Del("X-API-Key-ID")
+		r.Header.Del("X-API-Scope")
+		r.Header.Del("X-Session-ID")
+		r.Header.Del("X-Username")
+
+		var claims *Claims
+		var err error
+		if isAPIKey(tokenStr) {
+			var scope string
+			var keyID int
+			claims, scope, keyID, err = authenticateAPIKey(tokenStr, clientIP(r))
+			if err == nil {
+				r.Header.Set("X-API-Key-ID", strconv.Itoa(keyID))
+				r.Header.Set("X-API-Scope", scope)
+			}
+		} else {
+			claims, err = authenticate(tokenStr)
+			if err == nil && claims.SessionID != "" {
+				r.Header.Set("X-Session-ID", claims.SessionID)
+				touchSession(claims.SessionID, clientIP(r))
+			}
+		}
+		if err != nil {
 			http.Error(w, "Invalid Token", 401)
 			return
 		}
 		r.Header.Set("X-User-ID", fmt.Sprintf("%d", claims.UserID))
 		r.Header.Set("X-User-Role", claims.Role)
+		r.Header.Set("X-Org-ID", strconv.Itoa(claims.OrgID))
+		r.Header.Set("X-Username", claims.Username)
 		next(w, r)
 	}
 }
@@ -607,12 +1037,51 @@ func main() {
 	jwtKey = []byte(jwtSecret)
 
 	initDB()
+	initAuditChain()
+	initMFA()
+	initPasswordPolicy()
+	ensureMasterAccount()
+
+	// Barramento de eventos (Redis se houver várias réplicas) e "carteiro" do WebSocket/SSE
+	bus := initPubSub()
+	hub.Bus = bus
+	go hub.Run(context.Background())
+	go realtime.Pump(context.Background(), broadcast, bus, func(err error) {
+		log.Printf("Erro ao publicar evento: %v", err)
+	})
 
-	// Inicia o "carteiro" do WebSocket em background
-	go handleMessages()
+	// Notificações (e-mail, webhook, SMS) com fila e novas tentativas
+	initNotifier()
 
 	// Serviço para processar XML da Globalstar (AGORA RECEBE O BROADCAST)
 	gsService := globalstar.NewService(db, broadcast)
+	// ESN novo entra na organização padrão: quem a enxerga inteira passa a recebê-lo ao vivo
+	gsService.OnNewDevice = func(int) { hub.RefreshAll() }
+
+	// Motor de alertas: avalia as regras logo após cada mensagem ser gravada
+	alertEngine = alerts.NewEngine(db, broadcast)
+	if err := alertEngine.Load(); err != nil {
+		log.Printf("Aviso: Falha ao carregar regras de alerta: %v", err)
+	}
+	escalator = alerts.NewEscalator(db, notifyEscalation, auditEscalation)
+	alertEngine.OnChange(onAlertChange)
+	go escalator.Run(30 * time.Second)
+	gsService.Use(alertEngine)
+
+	// Watchdog: alerta quando um dispositivo deixa de reportar no intervalo esperado
+	watchdog = alerts.NewWatchdog(alertEngine)
+	if err := watchdog.Load(); err != nil {
+		log.Printf("Aviso: Falha ao carregar status dos dispositivos: %v", err)
+	}
+	gsService.Use(watchdog)
+	go watchdog.Run(time.Minute)
+
+	// Geofences: eventos de entrada/saída para dispositivos com GPS
+	geofences = geofence.NewEvaluator(db, broadcast, alertEngine)
+	if err := geofences.Load(); err != nil {
+		log.Printf("Aviso: Falha ao carregar geofences: %v", err)
+	}
+	gsService.Use(geofences)
 
 	mux := http.NewServeMux()
 
@@ -620,19 +1089,73 @@ func main() {
 	mux.HandleFunc("/login", loginHandler)
 	mux.HandleFunc("/api/forgot-password", forgotPasswordHandler)
 	mux.HandleFunc("/api/reset-password", resetPasswordHandler)
+	mux.HandleFunc("/api/invite/accept", acceptInviteHandler)
+	mux.HandleFunc("/api/auth/refresh", refreshHandler)
+	mux.HandleFunc("/api/login/2fa", mfaLoginHandler)
+	mux.HandleFunc("/api/login/2fa/setup", mfaLoginSetupHandler)
+	mux.HandleFunc("/api/login/password", passwordChangeLoginHandler)
+	mux.HandleFunc("/api/password-policy", passwordPolicyHandler)
+	mux.HandleFunc("/api/me/email/verify", verifyEmailHandler)
+	mux.HandleFunc("/api/sso/start", ssoStartHandler)
+	mux.HandleFunc("/api/sso/callback", ssoCallbackHandler)
+	mux.HandleFunc("/api/sso/exchange", ssoExchangeHandler)
 	mux.HandleFunc("/globalstar/listener", gsService.StreamHandler)
-	mux.HandleFunc("/ws", handleConnections) // Endpoint WebSocket
+	mux.HandleFunc("/ws", handleConnections)     // Endpoint WebSocket
+	mux.HandleFunc("/api/stream", streamHandler) // Mesmo feed via SSE (autentica por conta própria)
 
 	// API Protegida
-	mux.HandleFunc("/api/messages", authMiddleware(apiMessagesHandler))
-	mux.HandleFunc("/api/device/update", authMiddleware(updateDeviceNameHandler))
-	mux.HandleFunc("/api/audit", authMiddleware(apiAuditLogsHandler))
-
-	// Master
-	mux.HandleFunc("/api/master/data", authMiddleware(masterDataHandler))
-	mux.HandleFunc("/api/master/user", authMiddleware(upsertUserHandler))
-	mux.HandleFunc("/api/master/user/delete", authMiddleware(deleteUserHandler))
-	mux.HandleFunc("/api/master/permission", authMiddleware(permissionHandler))
+	mux.HandleFunc("/api/logout", authMiddleware(requireSession(logoutHandler)))
+	mux.HandleFunc("/api/2fa", authMiddleware(requireSession(mfaStatusHandler)))
+	mux.HandleFunc("/api/2fa/setup", authMiddleware(requireSession(mfaSetupHandler)))
+	mux.HandleFunc("/api/2fa/enable", authMiddleware(requireSession(mfaEnableHandler)))
+	mux.HandleFunc("/api/2fa/disable", authMiddleware(requireSession(mfaDisableHandler)))
+	mux.HandleFunc("/api/2fa/recovery-codes", authMiddleware(requireSession(mfaRecoveryCodesHandler)))
+	mux.HandleFunc("/api/me", authMiddleware(requireSession(meHandler)))
+	mux.HandleFunc("/api/me/password", authMiddleware(requireSession(changePasswordHandler)))
+	mux.HandleFunc("/api/sso/link", authMiddleware(requireSession(ssoLinkHandler)))
+	mux.HandleFunc("/api/sessions", authMiddleware(requireSession(sessionsHandler)))
+	mux.HandleFunc("/api/sessions/revoke", authMiddleware(requireSession(revokeSessionHandler)))
+	mux.HandleFunc("/api/api-keys", authMiddleware(requireSession(apiKeysHandler)))
+	mux.HandleFunc("/api/api-keys/revoke", authMiddleware(requireSession(revokeAPIKeyHandler)))
+	mux.HandleFunc("/api/messages", authMiddleware(requirePermission(PermDevicesRead, apiMessagesHandler)))
+	mux.HandleFunc("/api/device/update", authMiddleware(requirePermission(PermDevicesRename, updateDeviceNameHandler)))
+	mux.HandleFunc("/api/audit", authMiddleware(requirePermission(PermAuditRead, apiAuditLogsHandler)))
+	mux.HandleFunc("/api/master/audit/verify", authMiddleware(requirePermission(PermAuditVerify, auditVerifyHandler)))
+	mux.HandleFunc("/api/alerts", authMiddleware(requirePermission(PermAlertsRead, apiAlertsHandler)))
+	mux.HandleFunc("/api/devices/status", authMiddleware(requirePermission(PermDevicesRead, deviceStatusHandler)))
+	mux.HandleFunc("/api/geofences/events", authMiddleware(requirePermission(PermGeofencesRead, geofenceEventsHandler)))
+	mux.HandleFunc("/api/notifications/preferences", authMiddleware(requireSession(notificationPreferencesHandler)))
+	mux.HandleFunc("/api/alerts/ack", authMiddleware(requirePermission(PermAlertsAck, alertAckHandler)))
+	mux.HandleFunc("/api/alerts/resolve", authMiddleware(requirePermission(PermAlertsAck, alertResolveHandler)))
+
+	// Administração (as permissões de cada papel ficam em rbac.go)
+	mux.HandleFunc("/api/master/data", authMiddleware(requirePermission(PermUsersRead, masterDataHandler)))
+	mux.HandleFunc("/api/master/user", authMiddleware(requirePermission(PermUsersManage, upsertUserHandler)))
+	mux.HandleFunc("/api/master/user/delete", authMiddleware(requirePermission(PermUsersManage, deleteUserHandler)))
+	mux.HandleFunc("/api/master/user/2fa/reset", authMiddleware(requirePermission(PermUsersManage, resetUserMFAHandler)))
+	mux.HandleFunc("/api/master/user/unlock", authMiddleware(requirePermission(PermUsersManage, unlockUserHandler)))
+	mux.HandleFunc("/api/master/user/invite/resend", authMiddleware(requirePermission(PermUsersManage, resendInviteHandler)))
+	mux.HandleFunc("/api/master/user/invite/revoke", authMiddleware(requirePermission(PermUsersManage, revokeInviteHandler)))
+	mux.HandleFunc("/api/master/sessions", authMiddleware(requirePermission(PermSessionsManage, userSessionsHandler)))
+	mux.HandleFunc("/api/master/sessions/revoke", authMiddleware(requirePermission(PermSessionsManage, revokeUserSessionHandler)))
+	mux.HandleFunc("/api/master/permission", authMiddleware(requirePermission(PermPermissionsManage, permissionHandler)))
+	mux.HandleFunc("/api/master/device/interval", authMiddleware(requirePermission(PermDevicesConfigure, deviceIntervalHandler)))
+	mux.HandleFunc("/api/master/alert-rules", authMiddleware(requirePermission(PermAlertsManage, alertRulesHandler)))
+	mux.HandleFunc("/api/master/alert-rules/delete", authMiddleware(requirePermission(PermAlertsManage, deleteAlertRuleHandler)))
+	mux.HandleFunc("/api/master/escalation-policies", authMiddleware(requirePermission(PermAlertsManage, escalationPoliciesHandler)))
+	mux.HandleFunc("/api/master/escalation-policies/delete", authMiddleware(requirePermission(PermAlertsManage, deleteEscalationPolicyHandler)))
+	mux.HandleFunc("/api/master/geofences", authMiddleware(requirePermission(PermGeofencesManage, geofencesHandler)))
+	mux.HandleFunc("/api/master/geofences/delete", authMiddleware(requirePermission(PermGeofencesManage, deleteGeofenceHandler)))
+	mux.HandleFunc("/api/master/groups", authMiddleware(requirePermission(PermDevicesManage, groupsHandler)))
+	mux.HandleFunc("/api/master/groups/delete", authMiddleware(requirePermission(PermDevicesManage, deleteGroupHandler)))
+	mux.HandleFunc("/api/master/notifications/log", authMiddleware(requirePermission(PermNotificationsRead, notificationLogHandler)))
+	mux.HandleFunc("/api/master/notifications/templates", authMiddleware(requirePermission(PermNotificationsManage, notificationTemplatesHandler)))
+	mux.HandleFunc("/api/master/organizations", authMiddleware(requirePermission(PermOrgsManage, organizationsHandler)))
+	mux.HandleFunc("/api/master/organizations/delete", authMiddleware(requirePermission(PermOrgsManage, deleteOrganizationHandler)))
+	mux.HandleFunc("/api/master/device/organization", authMiddleware(requirePermission(PermOrgsManage, deviceOrganizationHandler)))
+	mux.HandleFunc("/api/master/sso-providers", authMiddleware(requirePermission(PermSSOManage, ssoProvidersHandler)))
+	mux.HandleFunc("/api/master/sso-providers/delete", authMiddleware(requirePermission(PermSSOManage, deleteSSOProviderHandler)))
+	mux.HandleFunc("/api/master/sso-identities", authMiddleware(requirePermission(PermSSOManage, ssoIdentityLinkHandler)))
 
 	// ============================================================
 	// CORREÇÃO DO CORS: Adicionando os IPs permitidos (Frontend)
@@ -640,11 +1163,12 @@ func main() {
 	handler := cors.New(cors.Options{
 		// Permite acessos de qualquer IP (Resolve o problema de rodar local vs nuvem)
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "X-Requested-With"},
+		AllowedMethods:   []string{"GET", "POST", "OPTIONS", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "X-Requested-With", "Last-Event-ID", "X-Request-ID"},
+		ExposedHeaders:   []string{"X-Request-ID"},
 		AllowCredentials: true,
 		Debug:            false, // Pode colocar false agora para limpar os logs
-	}).Handler(mux)
+	}).Handler(withRequestID(mux))
 
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
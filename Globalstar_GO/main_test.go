@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestUpsertUserReportsUpdateErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'maria' for key 'username'"}, http.StatusConflict},
+		{errors.New("conexão perdida"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		mock := mockDB(t)
+		mock.ExpectQuery("SELECT id, username, role, full_name").WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "full_name", "email", "phone", "address",
+				"city", "state", "organization_id", "invite_pending"}).
+				AddRow(9, "joao", RoleUser, "João", "joao@empresa.com", "", "", "", "", 2, false))
+		mock.ExpectQuery("SELECT 1 FROM organizations WHERE id = \\?").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+		// Renomear para um usuário que já existe: nada de auditar um diff que não foi gravado
+		mock.ExpectExec("UPDATE users SET username=\\?").WillReturnError(c.err)
+
+		r := requestAs(http.MethodPost, "/api/master/user", RoleAdmin, 7, 2)
+		r.Body = io.NopCloser(strings.NewReader(`{"id":9,"username":"maria","role":"user","full_name":"João","email":"joao@empresa.com"}`))
+		w := httptest.NewRecorder()
+		upsertUserHandler(w, r)
+		if w.Code != c.code {
+			t.Fatalf("%v: status %d, esperado %d", c.err, w.Code, c.code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteUserReportsDeleteErrors(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT role, username, COALESCE\\(organization_id, 0\\) FROM users").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "username", "organization_id"}).AddRow(RoleUser, "joao", 2))
+	mock.ExpectExec("DELETE FROM users WHERE id = \\?").WithArgs(9).WillReturnError(errors.New("conexão perdida"))
+
+	r := requestAs(http.MethodDelete, "/api/master/user", RoleAdmin, 7, 2)
+	r.Body = io.NopCloser(strings.NewReader(`{"id":9}`))
+	w := httptest.NewRecorder()
+	deleteUserHandler(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, esperado 500", w.Code)
+	}
+}
+
+// expectPermissionLookup: Usuário 9 e dispositivo 5 na organização 2, vínculo atual oldLevel
+func expectPermissionLookup(mock sqlmock.Sqlmock, oldLevel string) {
+	mock.ExpectQuery("FROM users WHERE id = \\?").WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(2))
+	mock.ExpectQuery("FROM devices WHERE id = \\?").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(2))
+	level := mock.ExpectQuery("SELECT access_level FROM user_permissions").WithArgs(9, 5)
+	if oldLevel == "" {
+		level.WillReturnError(sql.ErrNoRows)
+	} else {
+		level.WillReturnRows(sqlmock.NewRows([]string{"access_level"}).AddRow(oldLevel))
+	}
+}
+
+func TestPermissionReportsWriteErrors(t *testing.T) {
+	cases := []struct {
+		body, oldLevel, exec string
+	}{
+		{`{"user_id":9,"device_id":5,"action":"grant","level":"operator"}`, "", "INSERT INTO user_permissions"},
+		{`{"user_id":9,"device_id":5,"action":"revoke"}`, AccessViewer, "DELETE FROM user_permissions"},
+	}
+	for _, c := range cases {
+		mock := mockDB(t)
+		expectPermissionLookup(mock, c.oldLevel)
+		mock.ExpectExec(c.exec).WillReturnError(errors.New("conexão perdida"))
+
+		r := requestAs(http.MethodPost, "/api/master/permission", RoleAdmin, 7, 2)
+		r.Body = io.NopCloser(strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+		permissionHandler(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: status %d, esperado 500", c.body, w.Code)
+		}
+	}
+}
+
+func TestRevokeWithoutPermissionIsNoop(t *testing.T) {
+	mock := mockDB(t)
+	// Sem vínculo: nenhum DELETE (o mock recusaria) e nada na auditoria
+	expectPermissionLookup(mock, "")
+
+	r := requestAs(http.MethodPost, "/api/master/permission", RoleAdmin, 7, 2)
+	r.Body = io.NopCloser(strings.NewReader(`{"user_id":9,"device_id":5,"action":"revoke"}`))
+	w := httptest.NewRecorder()
+	permissionHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, esperado 200", w.Code)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot_modulo1.0/pkg/totp"
+)
+
+// --- AUTENTICAÇÃO EM DOIS FATORES (TOTP) ---
+// Com o TOTP ativo, o /login não devolve tokens: devolve um desafio (mfa_token)
+// que é trocado pelos tokens em /api/login/2fa junto com o código do app ou um
+// código de recuperação. Papéis listados em MFA_REQUIRED_ROLES (ex:
+// "master,admin") que ainda não cadastraram o app recebem um desafio de
+// cadastro: /api/login/2fa/setup gera o segredo e o primeiro código confirma.
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+
+	mfaPurposeVerify = "verify"
+	mfaPurposeEnroll = "enroll"
+)
+
+var (
+	errMFAInvalidCode    = errors.New("código inválido")
+	errMFAAlreadyEnabled = errors.New("2FA já está ativo")
+	errMFANotStarted     = errors.New("cadastro do 2FA não iniciado")
+)
+
+// Nome exibido no app autenticador
+var mfaIssuer = "IoTData Cloud"
+
+// Papéis que não entram sem 2FA
+var mfaRequiredRoles = make(map[string]bool)
+
+type MFACodeRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// initMFA: Lê MFA_ISSUER e MFA_REQUIRED_ROLES
+func initMFA() {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		mfaIssuer = issuer
+	}
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			continue
+		}
+		if !validRole(role) {
+			log.Printf("Aviso: MFA_REQUIRED_ROLES com papel desconhecido: %s", role)
+			continue
+		}
+		mfaRequiredRoles[role] = true
+	}
+}
+
+// randomHex: n bytes aleatórios em hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// --- DESAFIOS DO LOGIN ---
+
+// newMFAChallenge: Desafio de uso único entre a senha e o segundo fator
+func newMFAChallenge(userID int, purpose string) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec("INSERT INTO mfa_challenges (user_id, token_hash, purpose, expires_at) VALUES (?, ?, ?, ?)",
+		userID, hashToken(token), purpose, time.Now().Add(mfaChallengeTTL))
+	return token, err
+}
+
+// loadMFAChallenge: Desafio válido (não expirado e com tentativas restantes)
+func loadMFAChallenge(token string) (userID int, purpose string, err error) {
+	var expiresAt time.Time
+	var attempts int
+	err = db.QueryRow("SELECT user_id, purpose, expires_at, attempts FROM mfa_challenges WHERE token_hash = ?", hashToken(token)).
+		Scan(&userID, &purpose, &expiresAt, &attempts)
+	if err != nil {
+		return 0, "", err
+	}
+	if time.Now().After(expiresAt) || attempts >= mfaMaxAttempts {
+		return 0, "", errTokenRevoked
+	}
+	return userID, purpose, nil
+}
+
+// failMFAChallenge: Conta a tentativa errada (o desafio morre em mfaMaxAttempts)
+func failMFAChallenge(token string) {
+	db.Exec("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = ?", hashToken(token))
+}
+
+// consumeMFAChallenge: Apaga o desafio; false se outra requisição já o usou
+func consumeMFAChallenge(token string) bool {
+	res, err := db.Exec("DELETE FROM mfa_challenges WHERE token_hash = ?", hashToken(token))
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	// Aproveita para limpar desafios abandonados
+	db.Exec("DELETE FROM mfa_challenges WHERE expires_at < NOW()")
+	return n > 0
+}
+
+// --- SEGUNDO FATOR ---
+
+// verifySecondFactor: Código do app (uma vez por passo de 30s) ou código de recuperação
+// (uma vez só). method = "totp" ou "recovery".
+func verifySecondFactor(userID int, code string) (method string, ok bool) {
+	var secret sql.NullString
+	var enabled bool
+	if err := db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", userID).Scan(&secret, &enabled); err != nil || !enabled || !secret.Valid {
+		return "", false
+	}
+
+	if step, valid := totp.Validate(secret.String, code, time.Now()); valid {
+		// Condicional: o mesmo código não serve duas vezes (replay)
+		res, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+		if err != nil {
+			return "", false
+		}
+		n, _ := res.RowsAffected()
+		return "totp", n > 0
+	}
+
+	res, err := db.Exec("UPDATE totp_recovery_codes SET used_at = NOW() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return "", false
+	}
+	n, _ := res.RowsAffected()
+	return "recovery", n > 0
+}
+
+// normalizeRecoveryCode: Aceita com ou sem hífen, maiúsculas ou minúsculas
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// generateRecoveryCodes: Substitui os códigos de recuperação (exibidos uma única vez)
+func generateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := randomHex(5)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashToken(raw)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, tx.Commit()
+}
+
+// recoveryCodesLeft: Códigos de recuperação ainda não usados
+func recoveryCodesLeft(userID int) int {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n
+}
+
+// startTOTPEnrollment: Gera um segredo novo (ainda inativo) e a URI do QR code
+func startTOTPEnrollment(userID int, username string) (secret, uri string, err error) {
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	// Não sobrescreve um 2FA já ativo
+	res, err := db.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ? AND totp_enabled = 0", secret, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", "", errMFAAlreadyEnabled
+	}
+	return secret, totp.ProvisioningURI(mfaIssuer, username, secret), nil
+}
+
+// activateTOTP: O primeiro código válido confirma o cadastro e gera os códigos de recuperação
+func activateTOTP(userID int, code string) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	if err := db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", userID).Scan(&secret, &enabled); err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, errMFAAlreadyEnabled
+	}
+	if !secret.Valid {
+		return nil, errMFANotStarted
+	}
+	step, ok := totp.Validate(secret.String, code, time.Now())
+	if !ok {
+		return nil, errMFAInvalidCode
+	}
+	if _, err := db.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		return nil, err
+	}
+	return generateRecoveryCodes(userID)
+}
+
+// clearTOTP: Remove segredo e códigos de recuperação
+func clearTOTP(userID int) {
+	db.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID)
+	db.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID)
+}
+
+// --- LOGIN EM DUAS ETAPAS ---
+
+// mfaLoginHandler: Troca o desafio do /login + código pelos tokens (POST /api/login/2fa).
+// No desafio de cadastro, o código confirma o app e a resposta traz os códigos de recuperação.
+func mfaLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	userID, purpose, err := loadMFAChallenge(req.MFAToken)
+	// Tickets do SSO dividem a tabela, mas não valem aqui
+	if err != nil || (purpose != mfaPurposeVerify && purpose != mfaPurposeEnroll) {
+		http.Error(w, "Desafio inválido ou expirado, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+
+	var username, role, fullName string
+	var version int
+	if err := db.QueryRow("SELECT username, role, full_name, token_version FROM users WHERE id = ?", userID).
+		Scan(&username, &role, &fullName, &version); err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+	// Falhas de código contam no mesmo limite das falhas de senha
+	ip, account := clientIP(r), accountKey(username)
+	if wait := loginWait(ip, account); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	var recoveryCodes []string
+	details := ""
+	if purpose == mfaPurposeEnroll {
+		recoveryCodes, err = activateTOTP(userID, req.Code)
+		if err != nil {
+			failMFAChallenge(req.MFAToken)
+			registerLoginFailure(r, userID, account)
+			recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditLoginMFAFailed, Target: auditTarget(TargetUser, userID), Details: "Código inválido no cadastro do 2FA"})
+			http.Error(w, "Código inválido", http.StatusUnauthorized)
+			return
+		}
+		recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditMFAEnrolled, Target: auditTarget(TargetUser, userID), Details: "2FA (TOTP) cadastrado no login obrigatório"})
+		details = "Login realizado com sucesso (2FA cadastrado)"
+	} else {
+		method, ok := verifySecondFactor(userID, req.Code)
+		if !ok {
+			failMFAChallenge(req.MFAToken)
+			registerLoginFailure(r, userID, account)
+			recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditLoginMFAFailed, Target: auditTarget(TargetUser, userID), Details: "Código 2FA inválido"})
+			http.Error(w, "Código inválido", http.StatusUnauthorized)
+			return
+		}
+		details = "Login realizado com sucesso (2FA via app)"
+		if method == "recovery" {
+			details = "Login realizado com sucesso (2FA via código de recuperação)"
+			recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditMFARecoveryCodeUsed, Target: auditTarget(TargetUser, userID),
+				Details: fmt.Sprintf("Código de recuperação usado; restam %d", recoveryCodesLeft(userID))})
+		}
+	}
+
+	if !consumeMFAChallenge(req.MFAToken) {
+		http.Error(w, "Desafio inválido ou expirado, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+	throttleClear(scopeLoginUser, account)
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditLogin, Target: auditTarget(TargetUser, userID), Details: details})
+
+	access, refresh, err := issueTokens(r, userID, role, version, "")
+	if err != nil {
+		http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+		return
+	}
+	resp := tokenResponse(access, refresh, role, username, fullName)
+	if recoveryCodes != nil {
+		resp["recovery_codes"] = recoveryCodes
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// mfaLoginSetupHandler: Gera o segredo no desafio de cadastro obrigatório (POST /api/login/2fa/setup)
+func mfaLoginSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	userID, purpose, err := loadMFAChallenge(req.MFAToken)
+	if err != nil || purpose != mfaPurposeEnroll {
+		http.Error(w, "Desafio inválido ou expirado, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+	writeEnrollment(w, userID)
+}
+
+// writeEnrollment: Resposta comum dos dois caminhos de cadastro
+func writeEnrollment(w http.ResponseWriter, userID int) {
+	var username string
+	db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	secret, uri, err := startTOTPEnrollment(userID, username)
+	if errors.Is(err, errMFAAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Erro ao gerar segredo", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret, "otpauth_uri": uri})
+}
+
+// --- AUTOATENDIMENTO (usuário autenticado) ---
+
+// mfaStatusHandler: Situação do 2FA do próprio usuário (GET /api/2fa)
+func mfaStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	var enabled bool
+	db.QueryRow("SELECT totp_enabled FROM users WHERE id = ?", userID).Scan(&enabled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":             enabled,
+		"required":            mfaRequiredRoles[r.Header.Get("X-User-Role")],
+		"recovery_codes_left": recoveryCodesLeft(userID),
+	})
+}
+
+// mfaSetupHandler: Inicia o cadastro do app (POST /api/2fa/setup)
+func mfaSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	writeEnrollment(w, userID)
+}
+
+// mfaEnableHandler: Confirma o cadastro com o primeiro código (POST /api/2fa/enable)
+func mfaEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MFACodeRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	codes, err := activateTOTP(userID, req.Code)
+	switch {
+	case errors.Is(err, errMFAAlreadyEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errMFANotStarted), errors.Is(err, errMFAInvalidCode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Erro ao ativar 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditMFAEnrolled, Target: auditTarget(TargetUser, userID), Details: "2FA (TOTP) ativado"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// mfaDisableHandler: Desativa o próprio 2FA mediante um código válido (POST /api/2fa/disable)
+func mfaDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mfaRequiredRoles[r.Header.Get("X-User-Role")] {
+		http.Error(w, "2FA é obrigatório para este papel", http.StatusForbidden)
+		return
+	}
+	var req MFACodeRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	if _, ok := verifySecondFactor(userID, req.Code); !ok {
+		recordAudit(r, AuditEvent{Action: AuditMFADisableFailed, Target: auditTarget(TargetUser, userID), Details: "Código 2FA inválido ao desativar"})
+		// 403 e não 401: a sessão é válida (401 faz o front renovar o token e repetir)
+		http.Error(w, "Código inválido", http.StatusForbidden)
+		return
+	}
+	clearTOTP(userID)
+	recordAudit(r, AuditEvent{Action: AuditMFADisabled, Target: auditTarget(TargetUser, userID), Details: "2FA desativado pelo próprio usuário"})
+	w.WriteHeader(http.StatusOK)
+}
+
+// mfaRecoveryCodesHandler: Gera novos códigos de recuperação (POST /api/2fa/recovery-codes)
+func mfaRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MFACodeRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	if _, ok := verifySecondFactor(userID, req.Code); !ok {
+		http.Error(w, "Código inválido", http.StatusForbidden)
+		return
+	}
+	codes, err := generateRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Erro ao gerar códigos", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, AuditEvent{Action: AuditMFARecoveryCodesRegen, Target: auditTarget(TargetUser, userID), Details: "Novos códigos de recuperação gerados"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// --- ADMINISTRAÇÃO (users:manage) ---
+
+// resetUserMFAHandler: Remove o 2FA de um usuário que perdeu o celular e os códigos.
+// Se o papel exige 2FA, ele cadastra de novo no próximo login.
+func resetUserMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var u UserData
+	json.NewDecoder(r.Body).Decode(&u)
+
+	var targetRole string
+	var orgID int
+	err := db.QueryRow("SELECT role, COALESCE(organization_id, 0) FROM users WHERE id = ?", u.ID).Scan(&targetRole, &orgID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if !canManageRole(r.Header.Get("X-User-Role"), targetRole) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	clearTOTP(u.ID)
+	// Sessões abertas com o fator antigo deixam de valer
+	revokeUserTokens(u.ID)
+
+	recordAudit(r, AuditEvent{Action: AuditMFAReset, Target: auditTarget(TargetUser, u.ID), Details: fmt.Sprintf("Removeu o 2FA do usuário ID %d", u.ID)})
+	w.WriteHeader(http.StatusOK)
+}
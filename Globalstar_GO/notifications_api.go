@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"iot_modulo1.0/pkg/alerts"
+	"iot_modulo1.0/pkg/notify"
+)
+
+// Despachante de notificações (e-mail, webhook, SMS)
+var notifier *notify.Dispatcher
+
+// initNotifier: Registra os canais configurados no ambiente e sobe os workers
+func initNotifier() {
+	notifier = notify.NewDispatcher(db)
+
+	if smtpChannel := notify.SMTPFromEnv(); smtpChannel != nil {
+		notifier.Register(smtpChannel)
+	} else {
+		log.Println("Aviso: SMTP não configurado, canal de e-mail desativado.")
+	}
+
+	// Webhook: a URL é de cada usuário; o segredo assina todas as chamadas
+	// (sem ele o receptor não teria como validar a origem, então o canal fica desligado)
+	if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		notifier.Register(&notify.WebhookChannel{Secret: []byte(secret)})
+	} else {
+		log.Println("Aviso: WEBHOOK_SECRET não configurado, canal de webhook desativado.")
+	}
+
+	if smsURL := os.Getenv("SMS_GATEWAY_URL"); smsURL != "" {
+		notifier.Register(&notify.SMSGatewayChannel{URL: smsURL, Token: os.Getenv("SMS_GATEWAY_TOKEN")})
+	}
+
+	notifier.Start(4)
+}
+
+// notifyAlertOpened: Avisa os usuários vinculados ao dispositivo, conforme as preferências
+func notifyAlertOpened(a alerts.Alert) {
+	if a.State != alerts.StateOpen {
+		return
+	}
+
+	// Vínculos são sempre da mesma organização; a condição só reforça o isolamento
+	rows, err := db.Query(`SELECT up.user_id FROM user_permissions up
+		JOIN users u ON u.id = up.user_id JOIN devices d ON d.id = up.device_id
+		WHERE up.device_id = ? AND COALESCE(u.organization_id, 0) = COALESCE(d.organization_id, 0)`, a.DeviceID)
+	if err != nil {
+		log.Printf("Erro ao buscar destinatários do alerta %d: %v", a.ID, err)
+		return
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		notifyUser(userID, "alert_opened", a.Severity, alertTemplateData(a))
+	}
+}
+
+// notifyEscalation: Notificação de uma etapa de escalonamento (ignora a severidade mínima,
+// pois o usuário foi escolhido explicitamente na política)
+func notifyEscalation(userID int, a alerts.Alert, step int) {
+	data := alertTemplateData(a)
+	data["Step"] = step
+	notifyUser(userID, "alert_escalation", "", data)
+}
+
+// auditEscalation: Etapa do escalonamento na auditoria, no alerta e na organização do
+// dispositivo (o admin da organização vê quem foi avisado)
+func auditEscalation(ev alerts.EscalationEvent) {
+	a := ev.Alert
+	if ev.Stopped {
+		recordAudit(nil, AuditEvent{Action: AuditAlertEscalationStopped, Target: auditTarget(TargetAlert, a.ID), OrgID: ev.OrgID,
+			Details: fmt.Sprintf("Alerta %d (%s): escalonamento encerrado (%s)", a.ID, a.ESN, ev.Reason)})
+		return
+	}
+	recordAudit(nil, AuditEvent{Action: AuditAlertEscalation, Target: auditTarget(TargetAlert, a.ID), OrgID: ev.OrgID,
+		Details: fmt.Sprintf("Alerta %d (%s) política %q etapa %d/%d ciclo %d: notificados %s",
+			a.ID, a.ESN, ev.Policy, ev.Step, ev.Steps, ev.Cycle, strings.Join(ev.Names, ", ")),
+		Changes: AuditChanges{
+			"notified": {To: ev.Notified},
+			"step":     {To: ev.Step},
+			"cycle":    {To: ev.Cycle},
+		}})
+}
+
+// notifyUser: Envia o template por todos os contatos ativos do usuário que aceitam a
+// severidade (severity vazia envia para todos os contatos ativos)
+func notifyUser(userID int, template, severity string, data map[string]interface{}) {
+	contacts, err := notifier.Contacts(userID)
+	if err != nil {
+		log.Printf("Erro ao buscar contatos do usuário %d: %v", userID, err)
+		return
+	}
+	for _, c := range contacts {
+		if !c.Enabled || (severity != "" && alerts.SeverityRank(severity) < alerts.SeverityRank(c.MinSeverity)) {
+			continue
+		}
+		err := notifier.Enqueue(notify.Notification{
+			UserID: userID, Channel: c.Channel, To: c.Address, Template: template, Data: data,
+		})
+		if err != nil {
+			log.Printf("Aviso: Notificação %s para usuário %d via %s não enfileirada: %v", template, userID, c.Channel, err)
+		}
+	}
+}
+
+func alertTemplateData(a alerts.Alert) map[string]interface{} {
+	return map[string]interface{}{
+		"AlertID":    a.ID,
+		"DeviceID":   a.DeviceID,
+		"ESN":        a.ESN,
+		"DeviceName": a.DeviceName,
+		"Kind":       a.Kind,
+		"Severity":   a.Severity,
+		"Message":    a.Message,
+		"OpenedAt":   a.OpenedAt,
+	}
+}
+
+// --- PREFERÊNCIAS DO USUÁRIO ---
+
+// notificationPreferencesHandler: GET/POST das preferências de contato do próprio usuário
+func notificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		contacts, err := notifier.Contacts(userID)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		json.NewEncoder(w).Encode(contacts)
+		return
+	}
+
+	var contacts []notify.Contact
+	if err := json.NewDecoder(r.Body).Decode(&contacts); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	for _, c := range contacts {
+		if err := validateContact(r.Context(), c); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	if err := notifier.SetContacts(userID, contacts); err != nil {
+		http.Error(w, "Erro ao salvar preferências", 500)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditNotificationPrefs, Target: auditTarget(TargetUser, userID), Details: fmt.Sprintf("%d contatos de notificação", len(contacts))})
+	json.NewEncoder(w).Encode(contacts)
+}
+
+// validateContact: Webhook só com o canal ativo e para endereço público (sem SSRF)
+func validateContact(ctx context.Context, c notify.Contact) error {
+	if c.Address == "" {
+		return fmt.Errorf("endereço obrigatório para o canal %s", c.Channel)
+	}
+	if c.MinSeverity != "" && alerts.SeverityRank(c.MinSeverity) == 0 {
+		return fmt.Errorf("severidade desconhecida: %s", c.MinSeverity)
+	}
+	switch c.Channel {
+	case notify.ChannelEmail, notify.ChannelSMS:
+		return nil
+	case notify.ChannelWebhook:
+		if c.Enabled && !notifier.Has(notify.ChannelWebhook) {
+			return fmt.Errorf("canal de webhook não configurado no servidor")
+		}
+		if err := notify.CheckWebhookURL(ctx, c.Address); err != nil {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("canal desconhecido: %s", c.Channel)
+}
+
+// --- ADMINISTRAÇÃO (notifications:read / notifications:manage) ---
+
+// notificationLogHandler: Histórico de envios (da organização, exceto para master)
+func notificationLogHandler(w http.ResponseWriter, r *http.Request) {
+	filter := notify.LogFilter{OrgID: orgOf(r), AllOrgs: crossTenant(r)}
+	filter.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := notifier.Log(filter)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// notificationTemplatesHandler: GET lista os templates, POST personaliza um deles
+func notificationTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		list, err := notifier.Templates()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	var t notify.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil || t.Name == "" || t.Body == "" {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	var before interface{}
+	if old, err := notifier.Template(t.Name); err == nil {
+		before = old
+	}
+	if err := notifier.SaveTemplate(t); err != nil {
+		http.Error(w, "Template inválido: "+err.Error(), 400)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: AuditTemplateUpdated, Target: auditTarget(TargetTemplate, t.Name),
+		Details: fmt.Sprintf("Template %s atualizado", t.Name), Changes: diffChanges(before, t)})
+
+	json.NewEncoder(w).Encode(t)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- ORGANIZAÇÕES (MULTI-TENANT) ---
+// Cada organização (fazenda, empresa) é dona dos seus usuários, dispositivos,
+// grupos, cercas e políticas de escalonamento. organization_id NULL (0 aqui) é
+// a organização padrão, onde ficam os dados de antes desta funcionalidade e os
+// ESNs recém-chegados. Só quem tem organizations:manage (master) enxerga e
+// administra todas; os demais papéis, mesmo com devices:read_all ou
+// users:manage, ficam restritos à própria organização.
+
+type Organization struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Users     int    `json:"users"`
+	Devices   int    `json:"devices"`
+	CreatedAt string `json:"created_at"`
+}
+
+type DeviceOrganizationUpdate struct {
+	DeviceID       int `json:"device_id"`
+	OrganizationID int `json:"organization_id"`
+}
+
+// Tabelas com coluna organization_id (regras de alerta herdam a do alvo)
+var orgOwnedTables = []string{"users", "devices", "device_groups", "geofences", "escalation_policies", "sso_providers"}
+
+// orgOf: Organização do usuário autenticado (definida pelo authMiddleware)
+func orgOf(r *http.Request) int {
+	id, _ := strconv.Atoi(r.Header.Get("X-Org-ID"))
+	return id
+}
+
+// crossTenant: Enxerga e administra todas as organizações
+func crossTenant(r *http.Request) bool {
+	return can(r, PermOrgsManage)
+}
+
+// inScope: Registros da organização orgID são visíveis para quem fez a requisição?
+func inScope(r *http.Request, orgID int) bool {
+	return crossTenant(r) || orgOf(r) == orgID
+}
+
+// orgCond: Condição SQL que restringe alias.organization_id à organização do
+// usuário (sempre verdadeira para master). Usar com os argumentos retornados.
+func orgCond(r *http.Request, alias string) (string, []interface{}) {
+	return "(? OR COALESCE(" + alias + ".organization_id, 0) = ?)", []interface{}{crossTenant(r), orgOf(r)}
+}
+
+// recordOrg: Organização de um registro de uma das orgOwnedTables
+func recordOrg(table string, id int) (int, error) {
+	var orgID int
+	err := db.QueryRow(fmt.Sprintf("SELECT COALESCE(organization_id, 0) FROM %s WHERE id = ?", table), id).Scan(&orgID)
+	return orgID, err
+}
+
+// recordInScope: O registro existe e pertence à organização de quem fez a requisição?
+func recordInScope(r *http.Request, table string, id int) bool {
+	orgID, err := recordOrg(table, id)
+	return err == nil && inScope(r, orgID)
+}
+
+// allInOrg: Todos os IDs existem na tabela e pertencem à organização orgID?
+func allInOrg(table string, ids []int, orgID int) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	unique := make(map[int]bool)
+	args := make([]interface{}, 0, len(ids)+1)
+	for _, id := range ids {
+		if !unique[id] {
+			unique[id] = true
+			args = append(args, id)
+		}
+	}
+	args = append(args, orgID)
+	var count int
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id IN (?%s) AND COALESCE(organization_id, 0) = ?",
+		table, strings.Repeat(", ?", len(unique)-1)), args...).Scan(&count)
+	return err == nil && count == len(unique)
+}
+
+// targetOrg: Organização para a qual o registro será gravado. Só master
+// escolhe (requested); os demais sempre gravam na própria organização.
+func targetOrg(r *http.Request, requested int) int {
+	if crossTenant(r) {
+		return requested
+	}
+	return orgOf(r)
+}
+
+// ownerOrg: Organização de um registro sendo gravado. Na criação, a de
+// targetOrg; na edição, a que ele já tem (registros não mudam de organização).
+// ok = false se a organização não existe ou o registro não está no escopo.
+func ownerOrg(r *http.Request, table string, id, requested int) (orgID int, ok bool) {
+	if id == 0 {
+		orgID = targetOrg(r, requested)
+		return orgID, organizationExists(orgID)
+	}
+	orgID, err := recordOrg(table, id)
+	return orgID, err == nil && inScope(r, orgID)
+}
+
+// ruleTargetOrg: Organização de uma regra de alerta (a do dispositivo ou grupo alvo)
+func ruleTargetOrg(deviceID, groupID int) (int, error) {
+	if deviceID != 0 {
+		return recordOrg("devices", deviceID)
+	}
+	return recordOrg("device_groups", groupID)
+}
+
+// storedRuleOrg: Organização de uma regra já gravada
+func storedRuleOrg(id int) (int, error) {
+	var orgID int
+	err := db.QueryRow(`SELECT COALESCE(d.organization_id, g.organization_id, 0) FROM alert_rules ar
+		LEFT JOIN devices d ON d.id = ar.device_id LEFT JOIN device_groups g ON g.id = ar.group_id
+		WHERE ar.id = ?`, id).Scan(&orgID)
+	return orgID, err
+}
+
+// organizationExists: 0 (organização padrão) sempre existe
+func organizationExists(id int) bool {
+	if id == 0 {
+		return true
+	}
+	var exists int
+	return db.QueryRow("SELECT 1 FROM organizations WHERE id = ?", id).Scan(&exists) == nil
+}
+
+// listOrganizations: Organizações com a contagem de usuários e dispositivos
+func listOrganizations() ([]Organization, error) {
+	rows, err := db.Query(`SELECT o.id, o.name, o.created_at,
+		(SELECT COUNT(*) FROM users u WHERE u.organization_id = o.id),
+		(SELECT COUNT(*) FROM devices d WHERE d.organization_id = o.id)
+		FROM organizations o ORDER BY o.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]Organization, 0)
+	for rows.Next() {
+		var o Organization
+		var t time.Time
+		rows.Scan(&o.ID, &o.Name, &t, &o.Users, &o.Devices)
+		o.CreatedAt = t.Format("02/01/2006 15:04:05")
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// --- CADASTRO (organizations:manage) ---
+
+// organizationsHandler: GET lista as organizações, POST cria/renomeia
+func organizationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		list, err := listOrganizations()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	var o Organization
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil || strings.TrimSpace(o.Name) == "" {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	o.Name = strings.TrimSpace(o.Name)
+
+	action := AuditOrganizationUpdated
+	changes := AuditChanges{}
+	var err error
+	if o.ID > 0 {
+		var oldName string
+		db.QueryRow("SELECT name FROM organizations WHERE id = ?", o.ID).Scan(&oldName)
+		if oldName != o.Name {
+			changes["name"] = FieldChange{From: oldName, To: o.Name}
+		}
+		_, err = db.Exec("UPDATE organizations SET name = ? WHERE id = ?", o.Name, o.ID)
+	} else {
+		action = AuditOrganizationCreated
+		changes["name"] = FieldChange{To: o.Name}
+		res, insErr := db.Exec("INSERT INTO organizations (name) VALUES (?)", o.Name)
+		err = insErr
+		if err == nil {
+			id, _ := res.LastInsertId()
+			o.ID = int(id)
+		}
+	}
+	if err != nil {
+		// Nome é UNIQUE
+		log.Printf("Erro ao salvar organização: %v", err)
+		http.Error(w, "Erro ao salvar organização (nome já existe?)", http.StatusConflict)
+		return
+	}
+
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetOrganization, o.ID), Details: fmt.Sprintf("Organização %d (%s)", o.ID, o.Name), Changes: changes})
+
+	json.NewEncoder(w).Encode(o)
+}
+
+// deleteOrganizationHandler: Só remove organizações vazias (nada é apagado em cascata)
+func deleteOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var o Organization
+	json.NewDecoder(r.Body).Decode(&o)
+	if !organizationExists(o.ID) || o.ID == 0 {
+		http.Error(w, "Organização não encontrada", http.StatusNotFound)
+		return
+	}
+
+	for _, table := range orgOwnedTables {
+		var count int
+		db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE organization_id = ?", table), o.ID).Scan(&count)
+		if count > 0 {
+			http.Error(w, fmt.Sprintf("Organização ainda possui registros em %s", table), http.StatusConflict)
+			return
+		}
+	}
+	// Chaves da organização não sobrevivem a ela
+	db.Exec("DELETE FROM api_keys WHERE organization_id = ?", o.ID)
+	db.Exec("DELETE FROM organizations WHERE id = ?", o.ID)
+
+	recordAudit(r, AuditEvent{Action: AuditOrganizationDeleted, Target: auditTarget(TargetOrganization, o.ID), Details: fmt.Sprintf("Deletou organização ID %d", o.ID)})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deviceOrganizationHandler: Transfere um dispositivo de organização. Vínculos
+// com usuários, grupos e cercas da organização anterior são desfeitos.
+func deviceOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeviceOrganizationUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	oldOrg, err := recordOrg("devices", req.DeviceID)
+	if err != nil {
+		http.Error(w, "Dispositivo não encontrado", http.StatusNotFound)
+		return
+	}
+	if !organizationExists(req.OrganizationID) {
+		http.Error(w, "Organização não encontrada", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Erro ao transferir dispositivo", 500)
+		return
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		"UPDATE devices SET organization_id = NULLIF(?, 0) WHERE id = ?",
+		`DELETE up FROM user_permissions up JOIN users u ON u.id = up.user_id
+			WHERE COALESCE(u.organization_id, 0) != ? AND up.device_id = ?`,
+		`DELETE m FROM device_group_members m JOIN device_groups g ON g.id = m.group_id
+			WHERE COALESCE(g.organization_id, 0) != ? AND m.device_id = ?`,
+		`DELETE l FROM geofence_links l JOIN geofences f ON f.id = l.geofence_id
+			WHERE COALESCE(f.organization_id, 0) != ? AND l.device_id = ?`,
+		// Regras do dispositivo vão junto, mas não podem apontar para política de outra organização
+		`UPDATE alert_rules ar JOIN escalation_policies p ON p.id = ar.escalation_policy_id
+			SET ar.escalation_policy_id = NULL WHERE COALESCE(p.organization_id, 0) != ? AND ar.device_id = ?`,
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(stmt, req.OrganizationID, req.DeviceID); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Erro ao transferir dispositivo %d: %v", req.DeviceID, err)
+		http.Error(w, "Erro ao transferir dispositivo", 500)
+		return
+	}
+
+	// Grupos e cercas mudaram de membros; conexões abertas passam a ver (ou não) o dispositivo
+	reloadGroupConsumers()
+	hub.RefreshAll()
+
+	recordAudit(r, AuditEvent{Action: AuditDeviceOrganization, Target: auditTarget(TargetDevice, req.DeviceID),
+		Details: fmt.Sprintf("Device %d: organização %d -> %d", req.DeviceID, oldOrg, req.OrganizationID),
+		Changes: AuditChanges{"organization_id": {From: oldOrg, To: req.OrganizationID}}})
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// --- POLÍTICA DE SENHAS ---
+// Toda senha definida por pessoa (cadastro pelo admin, redefinição por e-mail,
+// troca no login) passa por checkPassword: tamanho mínimo, lista local de
+// senhas vazadas, nome de usuário e as últimas N senhas da conta. Violações
+// voltam como 422 com a lista estruturada (writePasswordPolicyError).
+//
+// Contas criadas pelo admin ou pelo seed nascem com must_change_password; com
+// PASSWORD_MAX_AGE_DAYS a senha também expira. Nos dois casos o /login não
+// devolve tokens: devolve um change_token, trocado em /api/login/password junto
+// com a nova senha (e daí segue o 2FA normalmente).
+
+const (
+	passwordHashCost = 14
+	// bcrypt ignora o que passa de 72 bytes
+	passwordMaxBytes = 72
+
+	loginPurposePassword = "password"
+
+	passwordReasonFirstLogin = "first_login"
+	passwordReasonExpired    = "expired"
+)
+
+// Hash do seed antigo do init.sql (Admin-Master / "admin"): contas que ainda o
+// usam são obrigadas a trocar a senha
+const legacySeedHash = "$2a$14$K92F7sZrYY1fQBUOJ2LQheymCEQcDqELgWDIe2O7cRtS6FgbEfpA."
+
+//go:embed breached_passwords.txt
+var embeddedBreachedPasswords string
+
+type PasswordPolicy struct {
+	MinLength int `json:"min_length"`
+	// Senhas anteriores que não podem ser reutilizadas (a atual nunca pode)
+	History int `json:"history"`
+	// 0 = não expira
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// PasswordViolation: Item da resposta de erro (code estável para o frontend)
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var passwordPolicy = PasswordPolicy{MinLength: 10, History: 5}
+
+// Senhas vazadas/comuns (minúsculas)
+var breachedPasswords = make(map[string]bool)
+
+// initPasswordPolicy: Lê PASSWORD_MIN_LENGTH, PASSWORD_HISTORY, PASSWORD_MAX_AGE_DAYS
+// e PASSWORD_BREACHED_LIST (arquivo extra, uma senha por linha)
+func initPasswordPolicy() {
+	for env, field := range map[string]*int{
+		"PASSWORD_MIN_LENGTH":   &passwordPolicy.MinLength,
+		"PASSWORD_HISTORY":      &passwordPolicy.History,
+		"PASSWORD_MAX_AGE_DAYS": &passwordPolicy.MaxAgeDays,
+	} {
+		raw := os.Getenv(env)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Printf("Aviso: %s inválido: %s", env, raw)
+			continue
+		}
+		*field = n
+	}
+	if passwordPolicy.MinLength < 8 {
+		log.Printf("Aviso: PASSWORD_MIN_LENGTH abaixo de 8; usando 8")
+		passwordPolicy.MinLength = 8
+	}
+
+	loadBreachedPasswords(strings.NewReader(embeddedBreachedPasswords))
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Aviso: Falha ao abrir PASSWORD_BREACHED_LIST: %v", err)
+			return
+		}
+		defer f.Close()
+		loadBreachedPasswords(f)
+	}
+	log.Printf("Política de senhas: mínimo %d caracteres, histórico %d, %d senhas vazadas na lista",
+		passwordPolicy.MinLength, passwordPolicy.History, len(breachedPasswords))
+}
+
+func loadBreachedPasswords(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breachedPasswords[strings.ToLower(line)] = true
+	}
+}
+
+// --- VALIDAÇÃO ---
+
+// checkPassword: Violações da política (vazio = senha aceita). userID 0 = conta nova (sem histórico).
+func checkPassword(userID int, username, password string) []PasswordViolation {
+	violations := make([]PasswordViolation, 0)
+	add := func(code, msg string) {
+		violations = append(violations, PasswordViolation{Code: code, Message: msg})
+	}
+
+	if password == "" {
+		add("required", "Informe a senha")
+		return violations
+	}
+	if len([]rune(password)) < passwordPolicy.MinLength {
+		add("too_short", fmt.Sprintf("A senha deve ter pelo menos %d caracteres", passwordPolicy.MinLength))
+	}
+	if len(password) > passwordMaxBytes {
+		add("too_long", fmt.Sprintf("A senha deve ter no máximo %d bytes", passwordMaxBytes))
+	}
+	lower := strings.ToLower(password)
+	if breachedPasswords[lower] {
+		add("breached", "Esta senha aparece em listas de senhas vazadas; escolha outra")
+	}
+	if name := strings.ToLower(strings.TrimSpace(username)); len(name) >= 3 && strings.Contains(lower, name) {
+		add("contains_username", "A senha não pode conter o nome de usuário")
+	}
+	if userID > 0 && len(violations) == 0 && passwordReused(userID, password) {
+		add("reused", fmt.Sprintf("A senha não pode ser igual à atual nem às %d anteriores", passwordPolicy.History))
+	}
+	return violations
+}
+
+// passwordReused: A senha é a atual ou uma das N anteriores? (o histórico
+// guarda a atual também; contas antigas ainda não têm histórico)
+func passwordReused(userID int, password string) bool {
+	hashes := make([]string, 0, passwordPolicy.History+2)
+	var current string
+	if db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&current) == nil {
+		hashes = append(hashes, current)
+	}
+	rows, err := db.Query("SELECT password_hash FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?",
+		userID, passwordPolicy.History+1)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var h string
+			rows.Scan(&h)
+			hashes = append(hashes, h)
+		}
+	}
+	for _, h := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writePasswordPolicyError: 422 com as violações e a política vigente
+func writePasswordPolicyError(w http.ResponseWriter, violations []PasswordViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "password_policy",
+		"message":    "A senha não atende à política de senhas",
+		"violations": violations,
+		"policy":     passwordPolicy,
+	})
+}
+
+// passwordPolicyHandler: Política vigente (pública, para as dicas das telas de senha)
+func passwordPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passwordPolicy)
+}
+
+// --- GRAVAÇÃO ---
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+}
+
+// setPassword: Grava a nova senha (já validada), guarda no histórico e marca a data da troca
+func setPassword(userID int, password string, mustChange bool) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	// Conta convidada passa a ter senha: o convite deixa de estar pendente
+	_, err = db.Exec("UPDATE users SET password_hash = ?, must_change_password = ?, password_changed_at = NOW(), invite_pending = 0 WHERE id = ?",
+		hash, mustChange, userID)
+	if err != nil {
+		return err
+	}
+	recordPasswordHistory(userID, hash)
+	return nil
+}
+
+// recordPasswordHistory: Guarda o hash e descarta o que passou do limite do histórico
+func recordPasswordHistory(userID int, hash []byte) {
+	db.Exec("INSERT INTO password_history (user_id, password_hash) VALUES (?, ?)", userID, hash)
+	db.Exec(`DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+		SELECT id FROM (SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?) recent)`,
+		userID, userID, passwordPolicy.History+1)
+}
+
+// passwordChangeReason: Por que a senha precisa ser trocada antes de entrar ("" = não precisa)
+func passwordChangeReason(mustChange bool, changedAt sql.NullTime) string {
+	if mustChange {
+		return passwordReasonFirstLogin
+	}
+	if passwordPolicy.MaxAgeDays > 0 && changedAt.Valid &&
+		time.Since(changedAt.Time) > time.Duration(passwordPolicy.MaxAgeDays)*24*time.Hour {
+		return passwordReasonExpired
+	}
+	return ""
+}
+
+// --- CONTA MASTER INICIAL ---
+
+// ensureMasterAccount: Sem nenhum master, cria o Admin-Master com a senha de
+// MASTER_INITIAL_PASSWORD (ou uma aleatória, exibida uma vez no log) e troca
+// obrigatória no primeiro login. Também marca contas que ainda usam o seed antigo.
+func ensureMasterAccount() {
+	res, err := db.Exec("UPDATE users SET must_change_password = 1 WHERE password_hash = ? AND must_change_password = 0", legacySeedHash)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("Aviso: %d conta(s) com a senha padrão antiga; troca obrigatória no próximo login", n)
+		}
+	}
+
+	var masters int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", RoleMaster).Scan(&masters); err != nil || masters > 0 {
+		return
+	}
+
+	password := os.Getenv("MASTER_INITIAL_PASSWORD")
+	generated := password == ""
+	if generated {
+		if password, err = randomHex(8); err != nil {
+			log.Printf("Erro ao gerar senha do master: %v", err)
+			return
+		}
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Erro ao criptografar senha do master: %v", err)
+		return
+	}
+	res, err = db.Exec(`INSERT INTO users (username, password_hash, role, full_name, must_change_password)
+		VALUES ('Admin-Master', ?, ?, 'Administrador do Sistema', 1)`, hash, RoleMaster)
+	if err != nil {
+		log.Printf("Erro ao criar usuário master: %v", err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	recordPasswordHistory(int(id), hash)
+
+	if generated {
+		log.Printf("Usuário master criado: Admin-Master / %s (troque no primeiro login)", password)
+	} else {
+		log.Printf("Usuário master criado: Admin-Master (senha de MASTER_INITIAL_PASSWORD; troque no primeiro login)")
+	}
+	recordAudit(nil, AuditEvent{Action: AuditUserCreated, Target: auditTarget(TargetUser, int(id)), Details: "Conta master inicial criada (Admin-Master)"})
+}
+
+// --- TROCA OBRIGATÓRIA NO LOGIN ---
+
+// requirePasswordChange: Resposta do /login quando a senha precisa ser trocada antes de entrar
+func requirePasswordChange(w http.ResponseWriter, r *http.Request, userID int, username, reason string) {
+	token, err := newMFAChallenge(userID, loginPurposePassword)
+	if err != nil {
+		http.Error(w, "Erro ao iniciar troca de senha", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditPasswordChangeRequired, Target: auditTarget(TargetUser, userID),
+		Details: "Login exige troca de senha (" + reason + ")"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"password_change_required": true,
+		"reason":                   reason,
+		"change_token":             token,
+		"policy":                   passwordPolicy,
+	})
+}
+
+// passwordChangeLoginHandler: Troca o change_token + nova senha pela continuação do login (2FA ou tokens)
+func passwordChangeLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ChangeToken string `json:"change_token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChangeToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	userID, purpose, err := loadMFAChallenge(req.ChangeToken)
+	if err != nil || purpose != loginPurposePassword {
+		http.Error(w, "Sessão de troca de senha inválida ou expirada, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+
+	var username string
+	if err := db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+	// Violação não consome o desafio: o usuário corrige e tenta de novo
+	if violations := checkPassword(userID, username, req.NewPassword); len(violations) > 0 {
+		writePasswordPolicyError(w, violations)
+		return
+	}
+	if !consumeMFAChallenge(req.ChangeToken) {
+		http.Error(w, "Sessão de troca de senha inválida ou expirada, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+	if err := setPassword(userID, req.NewPassword, false); err != nil {
+		http.Error(w, "Erro ao atualizar senha", http.StatusInternalServerError)
+		return
+	}
+	revokeUserTokens(userID)
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: username, Action: AuditPasswordChanged, Target: auditTarget(TargetUser, userID), Details: "Senha trocada no login"})
+
+	var role, fullName string
+	var version int
+	var totpEnabled bool
+	err = db.QueryRow("SELECT role, full_name, token_version, totp_enabled FROM users WHERE id = ?", userID).
+		Scan(&role, &fullName, &version, &totpEnabled)
+	if err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+	finishLogin(w, r, userID, username, role, fullName, version, totpEnabled, "Login realizado com sucesso (senha trocada)")
+}
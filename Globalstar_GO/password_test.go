@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPasswordPolicy: Política padrão com a lista embutida de senhas vazadas
+func testPasswordPolicy(t *testing.T) {
+	old := passwordPolicy
+	passwordPolicy = PasswordPolicy{MinLength: 10, History: 5}
+	loadBreachedPasswords(strings.NewReader(embeddedBreachedPasswords))
+	t.Cleanup(func() { passwordPolicy = old })
+}
+
+func violationCodes(v []PasswordViolation) string {
+	codes := make([]string, 0, len(v))
+	for _, p := range v {
+		codes = append(codes, p.Code)
+	}
+	return strings.Join(codes, ",")
+}
+
+func TestCheckPasswordViolations(t *testing.T) {
+	testPasswordPolicy(t)
+	cases := []struct {
+		username, password, codes string
+	}{
+		{"joao", "", "required"},
+		{"joao", "Curta-1", "too_short"},
+		{"joao", strings.Repeat("ç", 37), "too_long"},
+		// Comparação sem diferenciar maiúsculas
+		{"joao", "QWERTYUIOP", "breached"},
+		{"Joao", "senha-do-JOAO-2026", "contains_username"},
+		// Nomes curtos demais não contam
+		{"jo", "jornada-longa-2026", ""},
+		{"joao", "Rio-Verde-Irrigacao", ""},
+	}
+	for _, c := range cases {
+		if got := violationCodes(checkPassword(0, c.username, c.password)); got != c.codes {
+			t.Errorf("checkPassword(%q, %q) = [%s], esperado [%s]", c.username, c.password, got, c.codes)
+		}
+	}
+}
+
+func TestCheckPasswordRejectsReuse(t *testing.T) {
+	testPasswordPolicy(t)
+	mock := mockDB(t)
+	hash := func(p string) string {
+		h, _ := bcrypt.GenerateFromPassword([]byte(p), bcrypt.MinCost)
+		return string(h)
+	}
+	current, previous := hash("Atual-Segura-2026"), hash("Anterior-Segura-2025")
+
+	cases := []struct {
+		password string
+		reused   bool
+	}{
+		{"Atual-Segura-2026", true},
+		{"Anterior-Segura-2025", true},
+		{"Nova-Segura-2027", false},
+	}
+	for _, c := range cases {
+		mock.ExpectQuery("SELECT password_hash FROM users WHERE id = \\?").WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(current))
+		// O histórico traz a atual e as N anteriores
+		mock.ExpectQuery("SELECT password_hash FROM password_history WHERE user_id = \\?").WithArgs(9, passwordPolicy.History+1).
+			WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(current).AddRow(previous))
+		got := violationCodes(checkPassword(9, "joao", c.password))
+		if (got == "reused") != c.reused || (!c.reused && got != "") {
+			t.Errorf("checkPassword(%q) = [%s], reutilizada = %v", c.password, got, c.reused)
+		}
+	}
+
+	// Com outra violação o histórico nem é consultado (bcrypt é caro)
+	if got := violationCodes(checkPassword(9, "joao", "curta")); got != "too_short" {
+		t.Errorf("checkPassword(curta) = [%s]", got)
+	}
+}
+
+func TestPasswordChangeReason(t *testing.T) {
+	testPasswordPolicy(t)
+	daysAgo := func(n int) sql.NullTime { return sql.NullTime{Time: time.Now().AddDate(0, 0, -n), Valid: true} }
+
+	if got := passwordChangeReason(true, daysAgo(1)); got != passwordReasonFirstLogin {
+		t.Errorf("must_change_password: %q", got)
+	}
+	// Sem PASSWORD_MAX_AGE_DAYS a senha não expira
+	if got := passwordChangeReason(false, daysAgo(1000)); got != "" {
+		t.Errorf("sem validade: %q", got)
+	}
+
+	passwordPolicy.MaxAgeDays = 90
+	cases := []struct {
+		changedAt sql.NullTime
+		reason    string
+	}{
+		{daysAgo(89), ""},
+		{daysAgo(91), passwordReasonExpired},
+		// Contas antigas sem data de troca não expiram de surpresa
+		{sql.NullTime{}, ""},
+	}
+	for _, c := range cases {
+		if got := passwordChangeReason(false, c.changedAt); got != c.reason {
+			t.Errorf("trocada em %v: %q, esperado %q", c.changedAt, got, c.reason)
+		}
+	}
+}
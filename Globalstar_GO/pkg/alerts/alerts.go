@@ -0,0 +1,355 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"iot_modulo1.0/pkg/globalstar"
+)
+
+// --- CONSTANTES ---
+
+// Condições suportadas pelas regras
+const (
+	CondAbove   = "above"   // valor acima do limite
+	CondBelow   = "below"   // valor abaixo do limite
+	CondRate    = "rate"    // variação por minuto (em módulo) acima do limite
+	CondChanged = "changed" // entrada digital mudou de estado
+)
+
+// Estados de uma instância de alerta
+const (
+	StateOpen         = "open"
+	StateAcknowledged = "acknowledged"
+	StateResolved     = "resolved"
+)
+
+// Severidades
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// SeverityRank: Ordem das severidades (para filtros de "severidade mínima")
+func SeverityRank(severity string) int {
+	switch severity {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// Tipo de alerta gerado por regra de telemetria
+const KindRule = "RULE"
+
+// Formato de data usado nas respostas da API
+const timeLayout = "02/01/2006 15:04:05"
+
+var (
+	ErrNotFound     = errors.New("alerta não encontrado")
+	ErrInvalidState = errors.New("transição de estado inválida")
+	ErrInvalidRule  = errors.New("regra inválida")
+)
+
+// --- ESTRUTURAS ---
+
+// Rule: Condição avaliada sobre uma métrica decodificada
+type Rule struct {
+	ID              int     `json:"id"`
+	Name            string  `json:"name"`
+	DeviceID        int     `json:"device_id,omitempty"`
+	GroupID         int     `json:"group_id,omitempty"`
+	Metric          string  `json:"metric"`
+	Condition       string  `json:"condition"`
+	Threshold       float64 `json:"threshold"`
+	Hysteresis      float64 `json:"hysteresis"`
+	CooldownSeconds int     `json:"cooldown_seconds"`
+	Severity        string  `json:"severity"`
+	Enabled         bool    `json:"enabled"`
+	// Política de escalonamento (0 = política padrão, se houver)
+	EscalationPolicyID int `json:"escalation_policy_id,omitempty"`
+}
+
+// Alert: Instância de alerta (aberta por regra ou por outro subsistema)
+type Alert struct {
+	ID             int      `json:"id"`
+	RuleID         int      `json:"rule_id,omitempty"`
+	GeofenceID     int      `json:"geofence_id,omitempty"`
+	DeviceID       int      `json:"device_id"`
+	ESN            string   `json:"esn,omitempty"`
+	DeviceName     string   `json:"device_name,omitempty"`
+	Kind           string   `json:"kind"`
+	Severity       string   `json:"severity"`
+	State          string   `json:"state"`
+	Message        string   `json:"message"`
+	Value          *float64 `json:"value,omitempty"`
+	OpenedAt       string   `json:"opened_at"`
+	AcknowledgedAt string   `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy int      `json:"acknowledged_by,omitempty"`
+	ResolvedAt     string   `json:"resolved_at,omitempty"`
+}
+
+// Validate: Confere os campos obrigatórios da regra
+func (r Rule) Validate() error {
+	if r.Name == "" || r.Metric == "" {
+		return fmt.Errorf("%w: nome e métrica são obrigatórios", ErrInvalidRule)
+	}
+	if (r.DeviceID == 0) == (r.GroupID == 0) {
+		return fmt.Errorf("%w: informe device_id ou group_id", ErrInvalidRule)
+	}
+	switch r.Condition {
+	case CondAbove, CondBelow, CondRate, CondChanged:
+	default:
+		return fmt.Errorf("%w: condição desconhecida %q", ErrInvalidRule, r.Condition)
+	}
+	switch r.Severity {
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("%w: severidade desconhecida %q", ErrInvalidRule, r.Severity)
+	}
+	if r.Hysteresis < 0 || r.CooldownSeconds < 0 {
+		return fmt.Errorf("%w: histerese e cooldown não podem ser negativos", ErrInvalidRule)
+	}
+	return nil
+}
+
+// ruleState: Memória da avaliação de uma regra para um dispositivo
+type ruleState struct {
+	active    bool
+	hasLast   bool
+	lastValue float64
+	lastAt    time.Time
+	lastFired time.Time
+}
+
+type stateKey struct {
+	ruleID   int
+	deviceID int
+}
+
+// --- MOTOR DE ALERTAS ---
+
+// Engine: Avalia as regras a cada mensagem e gerencia o ciclo de vida dos alertas
+type Engine struct {
+	DB *sql.DB
+	// Canal para enviar atualizações em tempo real (apenas escrita)
+	Broadcast chan<- interface{}
+	// Chamados a cada abertura/mudança de estado (notificações, escalonamento...)
+	Listeners []func(Alert)
+
+	mu           sync.Mutex
+	rules        []Rule
+	deviceGroups map[int][]int
+	states       map[stateKey]*ruleState
+}
+
+// Construtor: Cria o motor (chamar Load antes de processar mensagens)
+func NewEngine(db *sql.DB, broadcast chan<- interface{}) *Engine {
+	return &Engine{
+		DB:           db,
+		Broadcast:    broadcast,
+		deviceGroups: make(map[int][]int),
+		states:       make(map[stateKey]*ruleState),
+	}
+}
+
+// OnChange: Registra um ouvinte para alterações de alertas
+func (e *Engine) OnChange(fn func(Alert)) {
+	e.Listeners = append(e.Listeners, fn)
+}
+
+// Load: Carrega regras, grupos e os alertas ainda abertos (para a histerese
+// continuar valendo após um restart)
+func (e *Engine) Load() error {
+	rules, err := e.ListRules()
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[int][]int)
+	rows, err := e.DB.Query("SELECT group_id, device_id FROM device_group_members")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var groupID, deviceID int
+		rows.Scan(&groupID, &deviceID)
+		groups[deviceID] = append(groups[deviceID], groupID)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+	e.deviceGroups = groups
+
+	openRows, err := e.DB.Query("SELECT rule_id, device_id FROM alerts WHERE kind = ? AND state != ? AND rule_id IS NOT NULL", KindRule, StateResolved)
+	if err != nil {
+		return err
+	}
+	defer openRows.Close()
+	for openRows.Next() {
+		var ruleID, deviceID int
+		openRows.Scan(&ruleID, &deviceID)
+		e.state(ruleID, deviceID).active = true
+	}
+	return nil
+}
+
+// Process: Implementa globalstar.Processor
+func (e *Engine) Process(ev globalstar.Event) {
+	e.mu.Lock()
+	var fired []Alert
+	var cleared []int
+	for _, r := range e.rules {
+		if !r.Enabled || !e.appliesTo(r, ev.DeviceID) {
+			continue
+		}
+		value, ok := ev.Reading.Metrics[r.Metric]
+		if !ok {
+			continue
+		}
+
+		st := e.state(r.ID, ev.DeviceID)
+		fire, clear := evaluate(r, st, value, ev.ReceivedAt)
+		if fire {
+			if r.CooldownSeconds > 0 && !st.lastFired.IsZero() &&
+				ev.ReceivedAt.Sub(st.lastFired) < time.Duration(r.CooldownSeconds)*time.Second {
+				fire = false
+			} else {
+				st.lastFired = ev.ReceivedAt
+				// "changed" é pontual: não mantém a regra ativa
+				st.active = r.Condition != CondChanged
+				v := value
+				fired = append(fired, Alert{
+					RuleID:   r.ID,
+					DeviceID: ev.DeviceID,
+					ESN:      ev.ESN,
+					Kind:     KindRule,
+					Severity: r.Severity,
+					Message:  describe(r, value),
+					Value:    &v,
+				})
+			}
+		}
+		if clear {
+			st.active = false
+			cleared = append(cleared, r.ID)
+		}
+
+		st.hasLast = true
+		st.lastValue = value
+		st.lastAt = ev.ReceivedAt
+	}
+	e.mu.Unlock()
+
+	// Banco e ouvintes fora do lock
+	for _, a := range fired {
+		if _, err := e.Open(a); err != nil {
+			log.Printf("Erro ao abrir alerta (regra %d): %v", a.RuleID, err)
+		}
+	}
+	for _, ruleID := range cleared {
+		if err := e.resolveRule(ruleID, ev.DeviceID); err != nil {
+			log.Printf("Erro ao resolver alerta (regra %d): %v", ruleID, err)
+		}
+	}
+}
+
+// evaluate: Decide se a regra dispara ou se normaliza (histerese)
+func evaluate(r Rule, st *ruleState, value float64, at time.Time) (fire, clear bool) {
+	switch r.Condition {
+	case CondAbove:
+		if !st.active {
+			return value > r.Threshold, false
+		}
+		return false, value <= r.Threshold-r.Hysteresis
+
+	case CondBelow:
+		if !st.active {
+			return value < r.Threshold, false
+		}
+		return false, value >= r.Threshold+r.Hysteresis
+
+	case CondRate:
+		if !st.hasLast {
+			return false, false
+		}
+		minutes := at.Sub(st.lastAt).Minutes()
+		if minutes <= 0 {
+			return false, false
+		}
+		rate := math.Abs(value-st.lastValue) / minutes
+		if !st.active {
+			return rate > r.Threshold, false
+		}
+		return false, rate <= r.Threshold-r.Hysteresis
+
+	case CondChanged:
+		return st.hasLast && value != st.lastValue, false
+	}
+	return false, false
+}
+
+func describe(r Rule, value float64) string {
+	switch r.Condition {
+	case CondAbove:
+		return fmt.Sprintf("%s: %s = %.2f acima de %.2f", r.Name, r.Metric, value, r.Threshold)
+	case CondBelow:
+		return fmt.Sprintf("%s: %s = %.2f abaixo de %.2f", r.Name, r.Metric, value, r.Threshold)
+	case CondRate:
+		return fmt.Sprintf("%s: variação de %s acima de %.2f/min", r.Name, r.Metric, r.Threshold)
+	case CondChanged:
+		return fmt.Sprintf("%s: %s mudou para %.0f", r.Name, r.Metric, value)
+	}
+	return r.Name
+}
+
+// appliesTo: Regra do próprio dispositivo ou de um grupo do qual ele faz parte
+// (chamar com e.mu travado)
+func (e *Engine) appliesTo(r Rule, deviceID int) bool {
+	if r.DeviceID != 0 {
+		return r.DeviceID == deviceID
+	}
+	for _, g := range e.deviceGroups[deviceID] {
+		if g == r.GroupID {
+			return true
+		}
+	}
+	return false
+}
+
+// state: Estado da regra para o dispositivo (chamar com e.mu travado)
+func (e *Engine) state(ruleID, deviceID int) *ruleState {
+	k := stateKey{ruleID, deviceID}
+	st, ok := e.states[k]
+	if !ok {
+		st = &ruleState{}
+		e.states[k] = st
+	}
+	return st
+}
+
+// notify: Envia a alteração para o WebSocket e para os ouvintes
+func (e *Engine) notify(a Alert) {
+	if e.Broadcast != nil {
+		e.Broadcast <- map[string]interface{}{
+			"type":      "ALERT_UPDATE",
+			"device_id": a.DeviceID,
+			"alert":     a,
+		}
+	}
+	for _, fn := range e.Listeners {
+		fn(a)
+	}
+}
@@ -0,0 +1,121 @@
+package alerts
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"iot_modulo1.0/pkg/globalstar"
+)
+
+func TestEvaluate(t *testing.T) {
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	above := Rule{Condition: CondAbove, Threshold: 80, Hysteresis: 5}
+	below := Rule{Condition: CondBelow, Threshold: 10, Hysteresis: 2}
+	rate := Rule{Condition: CondRate, Threshold: 5, Hysteresis: 1}
+	changed := Rule{Condition: CondChanged}
+	// Última leitura: 100 há 2 minutos
+	last := func(active bool, v float64) ruleState {
+		return ruleState{active: active, hasLast: true, lastValue: v, lastAt: t0.Add(-2 * time.Minute)}
+	}
+
+	cases := []struct {
+		name        string
+		rule        Rule
+		st          ruleState
+		value       float64
+		fire, clear bool
+	}{
+		{"acima: no limite não dispara", above, ruleState{}, 80, false, false},
+		{"acima: passou do limite", above, ruleState{}, 80.1, true, false},
+		{"acima: ativo segue disparado", above, ruleState{active: true}, 90, false, false},
+		{"acima: dentro da histerese não rearma", above, ruleState{active: true}, 75.1, false, false},
+		{"acima: rearma em limite - histerese", above, ruleState{active: true}, 75, false, true},
+		{"abaixo: no limite não dispara", below, ruleState{}, 10, false, false},
+		{"abaixo: passou do limite", below, ruleState{}, 9.9, true, false},
+		{"abaixo: dentro da histerese não rearma", below, ruleState{active: true}, 11.9, false, false},
+		{"abaixo: rearma em limite + histerese", below, ruleState{active: true}, 12, false, true},
+		{"taxa: sem leitura anterior", rate, ruleState{}, 500, false, false},
+		{"taxa: mesmo instante", rate, ruleState{hasLast: true, lastValue: 100, lastAt: t0}, 500, false, false},
+		{"taxa: 5/min não dispara", rate, last(false, 100), 110, false, false},
+		{"taxa: 5,5/min dispara", rate, last(false, 100), 111, true, false},
+		{"taxa: queda conta em módulo", rate, last(false, 100), 89, true, false},
+		{"taxa: 4,1/min não rearma", rate, last(true, 100), 108.2, false, false},
+		{"taxa: rearma em 4/min", rate, last(true, 100), 108, false, true},
+		{"mudou: primeira leitura", changed, ruleState{}, 1, false, false},
+		{"mudou: mesmo estado", changed, last(false, 1), 1, false, false},
+		{"mudou: novo estado", changed, last(false, 0), 1, true, false},
+	}
+	for _, c := range cases {
+		st := c.st
+		fire, clear := evaluate(c.rule, &st, c.value, t0)
+		if fire != c.fire || clear != c.clear {
+			t.Errorf("%s: fire=%v clear=%v, esperado fire=%v clear=%v", c.name, fire, clear, c.fire, c.clear)
+		}
+	}
+}
+
+func TestProcessCooldownSuppressesReopen(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	broadcast := make(chan interface{}, 8)
+	e := NewEngine(db, broadcast)
+	e.rules = []Rule{{ID: 3, Name: "Pressão", DeviceID: 42, Metric: "pressure", Condition: CondAbove, Threshold: 80,
+		CooldownSeconds: 600, Severity: SeverityWarning, Enabled: true}}
+
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	send := func(at time.Duration, v float64) {
+		e.Process(globalstar.Event{DeviceID: 42, ESN: "0-1234567", ReceivedAt: t0.Add(at),
+			Reading: globalstar.Reading{Metrics: map[string]float64{"pressure": v}}})
+	}
+
+	mock.ExpectExec("INSERT INTO alerts").WillReturnResult(sqlmock.NewResult(1, 1))
+	send(0, 90)
+	// Normaliza: resolve o alerta aberto
+	mock.ExpectQuery("SELECT id FROM alerts WHERE rule_id = \\? AND device_id = \\?").WithArgs(3, 42, StateResolved).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	send(time.Minute, 70)
+	// Volta a passar do limite dentro do cooldown: nada é aberto
+	send(2*time.Minute, 95)
+	if len(broadcast) != 1 {
+		t.Fatalf("%d alertas abertos dentro do cooldown, esperado 1", len(broadcast))
+	}
+	// Passado o cooldown, dispara de novo
+	mock.ExpectExec("INSERT INTO alerts").WillReturnResult(sqlmock.NewResult(2, 1))
+	send(11*time.Minute, 96)
+	if len(broadcast) != 2 {
+		t.Fatalf("%d alertas abertos após o cooldown, esperado 2", len(broadcast))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuleValidateRequiresDeviceOrGroup(t *testing.T) {
+	base := Rule{Name: "Pressão", Metric: "pressure", Condition: CondAbove, Severity: SeverityWarning}
+	cases := []struct {
+		device, group int
+		ok            bool
+	}{
+		{42, 0, true},
+		{0, 7, true},
+		{42, 7, false},
+		{0, 0, false},
+	}
+	for _, c := range cases {
+		r := base
+		r.DeviceID, r.GroupID = c.device, c.group
+		err := r.Validate()
+		if c.ok && err != nil {
+			t.Errorf("device %d, grupo %d: %v", c.device, c.group, err)
+		}
+		if !c.ok && !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("device %d, grupo %d: aceita, esperado ErrInvalidRule", c.device, c.group)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package alerts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// --- POLÍTICAS DE ESCALONAMENTO ---
+
+// Step: Etapa da política (atraso contado a partir da etapa anterior)
+type Step struct {
+	DelayMinutes int      `json:"delay_minutes"`
+	UserIDs      []int    `json:"user_ids"`
+	Roles        []string `json:"roles"`
+}
+
+// Policy: Sequência de etapas repetida RepeatCount vezes enquanto o alerta
+// não for reconhecido. Com IsDefault, vale para alertas sem política própria
+// (ex: dispositivo silencioso) a partir de MinSeverity. Só vale para alertas
+// de dispositivos da mesma organização (OrgID 0 = organização padrão).
+type Policy struct {
+	ID          int    `json:"id"`
+	OrgID       int    `json:"organization_id"`
+	Name        string `json:"name"`
+	RepeatCount int    `json:"repeat_count"`
+	IsDefault   bool   `json:"is_default"`
+	MinSeverity string `json:"min_severity"`
+	Steps       []Step `json:"steps"`
+}
+
+// Validate: Confere a política antes de gravar
+func (p Policy) Validate() error {
+	if p.Name == "" || len(p.Steps) == 0 {
+		return fmt.Errorf("%w: nome e ao menos uma etapa são obrigatórios", ErrInvalidRule)
+	}
+	if p.RepeatCount < 0 {
+		return fmt.Errorf("%w: repeat_count não pode ser negativo", ErrInvalidRule)
+	}
+	if p.IsDefault && SeverityRank(p.MinSeverity) == 0 {
+		return fmt.Errorf("%w: política padrão exige min_severity", ErrInvalidRule)
+	}
+	for i, s := range p.Steps {
+		if s.DelayMinutes < 0 || (len(s.UserIDs) == 0 && len(s.Roles) == 0) {
+			return fmt.Errorf("%w: etapa %d sem destinatários ou com atraso negativo", ErrInvalidRule, i+1)
+		}
+	}
+	return nil
+}
+
+// EscalationEvent: Etapa notificada (ou encerramento do escalonamento) para a auditoria
+type EscalationEvent struct {
+	Alert Alert
+	// Organização do dispositivo do alerta (quem enxerga o registro)
+	OrgID int
+	// Encerrado por reconhecimento/resolução (Reason diz por quem)
+	Stopped bool
+	Reason  string
+	// Etapa notificada (1..Steps) e ciclo (1..), só quando Stopped = false
+	Policy   string
+	Step     int
+	Steps    int
+	Cycle    int
+	Notified []int
+	Names    []string
+}
+
+// Escalator: Avança as etapas dos alertas abertos até alguém reconhecer
+type Escalator struct {
+	DB *sql.DB
+	// Entrega a notificação de uma etapa a um usuário
+	Notify func(userID int, a Alert, step int)
+	// Registra cada etapa (e o encerramento) na auditoria
+	Audit func(ev EscalationEvent)
+
+	// Acorda o Run antes do próximo ciclo (etapa imediata)
+	wake chan struct{}
+}
+
+// Construtor
+func NewEscalator(db *sql.DB, notify func(userID int, a Alert, step int), audit func(ev EscalationEvent)) *Escalator {
+	return &Escalator{DB: db, Notify: notify, Audit: audit, wake: make(chan struct{}, 1)}
+}
+
+// Start: Inicia o escalonamento de um alerta recém-aberto.
+// Retorna false se nenhuma política se aplica.
+func (e *Escalator) Start(a Alert) bool {
+	p, ok := e.policyFor(a)
+	if !ok {
+		return false
+	}
+	nextAt := time.Now().Add(time.Duration(p.Steps[0].DelayMinutes) * time.Minute)
+	_, err := e.DB.Exec(`INSERT INTO alert_escalations (alert_id, policy_id, next_step, cycle, next_at) VALUES (?, ?, 0, 0, ?)
+		ON DUPLICATE KEY UPDATE policy_id = VALUES(policy_id), next_step = 0, cycle = 0, next_at = VALUES(next_at)`,
+		a.ID, p.ID, nextAt)
+	if err != nil {
+		log.Printf("Erro ao iniciar escalonamento do alerta %d: %v", a.ID, err)
+		return false
+	}
+
+	// Etapa imediata: acorda o Run em vez de notificar aqui (Start roda na
+	// goroutine da ingestão, que não pode esperar consultas e envios)
+	if p.Steps[0].DelayMinutes == 0 {
+		select {
+		case e.wake <- struct{}{}:
+		default: // já há um aviso pendente
+		}
+	}
+	return true
+}
+
+// Stop: Interrompe o escalonamento (alerta reconhecido ou resolvido)
+func (e *Escalator) Stop(a Alert, by string) {
+	res, err := e.DB.Exec("DELETE FROM alert_escalations WHERE alert_id = ?", a.ID)
+	if err != nil {
+		log.Printf("Erro ao parar escalonamento do alerta %d: %v", a.ID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		e.Audit(EscalationEvent{Alert: a, OrgID: e.deviceOrg(a.DeviceID), Stopped: true, Reason: by})
+	}
+}
+
+// Run: Verifica periodicamente as etapas vencidas, ou na hora quando Start
+// pede uma etapa imediata (bloqueante)
+func (e *Escalator) Run(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.Tick(now)
+		case <-e.wake:
+			e.Tick(time.Now())
+		}
+	}
+}
+
+// Tick: Executa todas as etapas com next_at vencido
+func (e *Escalator) Tick(now time.Time) {
+	rows, err := e.DB.Query(`SELECT ae.alert_id, ae.policy_id, ae.next_step, ae.cycle FROM alert_escalations ae
+		JOIN alerts a ON a.id = ae.alert_id WHERE ae.next_at <= ? AND a.state = ?`, now, StateOpen)
+	if err != nil {
+		log.Printf("Erro ao buscar escalonamentos: %v", err)
+		return
+	}
+	type due struct{ alertID, policyID, step, cycle int }
+	var list []due
+	for rows.Next() {
+		var d due
+		rows.Scan(&d.alertID, &d.policyID, &d.step, &d.cycle)
+		list = append(list, d)
+	}
+	rows.Close()
+
+	for _, d := range list {
+		p, err := e.Policy(d.policyID)
+		if err != nil || d.step >= len(p.Steps) {
+			e.DB.Exec("DELETE FROM alert_escalations WHERE alert_id = ?", d.alertID)
+			continue
+		}
+		a, err := e.alert(d.alertID)
+		if err != nil {
+			continue
+		}
+
+		// Avança antes de notificar: se dois ticks rodarem juntos, só um vence o UPDATE
+		nextStep, nextCycle := d.step+1, d.cycle
+		if nextStep >= len(p.Steps) {
+			nextStep, nextCycle = 0, d.cycle+1
+		}
+		var res sql.Result
+		if nextCycle > p.RepeatCount {
+			res, err = e.DB.Exec("DELETE FROM alert_escalations WHERE alert_id = ? AND next_step = ? AND cycle = ?", d.alertID, d.step, d.cycle)
+		} else {
+			nextAt := now.Add(time.Duration(p.Steps[nextStep].DelayMinutes) * time.Minute)
+			res, err = e.DB.Exec("UPDATE alert_escalations SET next_step = ?, cycle = ?, next_at = ? WHERE alert_id = ? AND next_step = ? AND cycle = ?",
+				nextStep, nextCycle, nextAt, d.alertID, d.step, d.cycle)
+		}
+		if err != nil {
+			log.Printf("Erro ao avançar escalonamento do alerta %d: %v", d.alertID, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		ev := EscalationEvent{Alert: a, OrgID: p.OrgID, Policy: p.Name, Step: d.step + 1, Steps: len(p.Steps), Cycle: d.cycle + 1,
+			Notified: []int{}, Names: []string{}}
+		for _, u := range e.recipients(p.Steps[d.step], p.OrgID) {
+			e.Notify(u.id, a, d.step+1)
+			ev.Notified = append(ev.Notified, u.id)
+			ev.Names = append(ev.Names, u.name)
+		}
+		e.Audit(ev)
+	}
+}
+
+type recipient struct {
+	id   int
+	name string
+}
+
+// recipients: Usuários listados na etapa mais os que têm os papéis indicados,
+// sempre dentro da organização da política
+func (e *Escalator) recipients(s Step, orgID int) []recipient {
+	seen := make(map[int]bool)
+	var list []recipient
+
+	var args []interface{}
+	var cond []string
+	if len(s.UserIDs) > 0 {
+		cond = append(cond, "id IN (?"+strings.Repeat(", ?", len(s.UserIDs)-1)+")")
+		for _, id := range s.UserIDs {
+			args = append(args, id)
+		}
+	}
+	if len(s.Roles) > 0 {
+		cond = append(cond, "role IN (?"+strings.Repeat(", ?", len(s.Roles)-1)+")")
+		for _, r := range s.Roles {
+			args = append(args, r)
+		}
+	}
+	if len(cond) == 0 {
+		return nil
+	}
+
+	args = append(args, orgID)
+	rows, err := e.DB.Query("SELECT id, username FROM users WHERE ("+strings.Join(cond, " OR ")+") AND COALESCE(organization_id, 0) = ?", args...)
+	if err != nil {
+		log.Printf("Erro ao buscar destinatários do escalonamento: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u recipient
+		rows.Scan(&u.id, &u.name)
+		if !seen[u.id] {
+			seen[u.id] = true
+			list = append(list, u)
+		}
+	}
+	return list
+}
+
+// policyFor: Política da regra do alerta ou, na falta dela, a política padrão
+// da organização do dispositivo
+func (e *Escalator) policyFor(a Alert) (Policy, bool) {
+	var policyID int
+	if a.RuleID != 0 {
+		e.DB.QueryRow("SELECT COALESCE(escalation_policy_id, 0) FROM alert_rules WHERE id = ?", a.RuleID).Scan(&policyID)
+	}
+	if policyID == 0 {
+		orgID := e.deviceOrg(a.DeviceID)
+		rows, err := e.DB.Query("SELECT id, min_severity FROM escalation_policies WHERE is_default = 1 AND COALESCE(organization_id, 0) = ? ORDER BY id", orgID)
+		if err != nil {
+			return Policy{}, false
+		}
+		for rows.Next() {
+			var id int
+			var minSeverity string
+			rows.Scan(&id, &minSeverity)
+			if policyID == 0 && SeverityRank(a.Severity) >= SeverityRank(minSeverity) {
+				policyID = id
+			}
+		}
+		rows.Close()
+	}
+	if policyID == 0 {
+		return Policy{}, false
+	}
+	p, err := e.Policy(policyID)
+	if err != nil || len(p.Steps) == 0 {
+		return Policy{}, false
+	}
+	return p, true
+}
+
+// deviceOrg: Organização do dispositivo (0 = padrão)
+func (e *Escalator) deviceOrg(deviceID int) int {
+	var orgID int
+	e.DB.QueryRow("SELECT COALESCE(organization_id, 0) FROM devices WHERE id = ?", deviceID).Scan(&orgID)
+	return orgID
+}
+
+func (e *Escalator) alert(id int) (Alert, error) {
+	row := e.DB.QueryRow("SELECT "+alertColumns+" FROM alerts a JOIN devices d ON d.id = a.device_id WHERE a.id = ?", id)
+	return scanAlert(row)
+}
+
+// --- PERSISTÊNCIA DE POLÍTICAS ---
+
+// Policy: Busca uma política com as etapas em ordem
+func (e *Escalator) Policy(id int) (Policy, error) {
+	var p Policy
+	err := e.DB.QueryRow("SELECT id, COALESCE(organization_id, 0), name, repeat_count, is_default, COALESCE(min_severity, '') FROM escalation_policies WHERE id = ?", id).
+		Scan(&p.ID, &p.OrgID, &p.Name, &p.RepeatCount, &p.IsDefault, &p.MinSeverity)
+	if err != nil {
+		return p, err
+	}
+	p.Steps, err = e.steps(id)
+	return p, err
+}
+
+func (e *Escalator) steps(policyID int) ([]Step, error) {
+	rows, err := e.DB.Query("SELECT delay_minutes, user_ids, roles FROM escalation_steps WHERE policy_id = ? ORDER BY step_order", policyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := make([]Step, 0)
+	for rows.Next() {
+		var s Step
+		var userIDs, roles string
+		rows.Scan(&s.DelayMinutes, &userIDs, &roles)
+		json.Unmarshal([]byte(userIDs), &s.UserIDs)
+		json.Unmarshal([]byte(roles), &s.Roles)
+		steps = append(steps, s)
+	}
+	return steps, rows.Err()
+}
+
+// Policies: Todas as políticas cadastradas
+func (e *Escalator) Policies() ([]Policy, error) {
+	rows, err := e.DB.Query("SELECT id FROM escalation_policies ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	list := make([]Policy, 0, len(ids))
+	for _, id := range ids {
+		p, err := e.Policy(id)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// SavePolicy: Cria (ID = 0) ou atualiza a política, substituindo as etapas
+func (e *Escalator) SavePolicy(p Policy) (Policy, error) {
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+
+	if p.ID > 0 {
+		_, err = tx.Exec("UPDATE escalation_policies SET organization_id = NULLIF(?, 0), name = ?, repeat_count = ?, is_default = ?, min_severity = ? WHERE id = ?",
+			p.OrgID, p.Name, p.RepeatCount, p.IsDefault, p.MinSeverity, p.ID)
+	} else {
+		var res sql.Result
+		res, err = tx.Exec("INSERT INTO escalation_policies (organization_id, name, repeat_count, is_default, min_severity) VALUES (NULLIF(?, 0), ?, ?, ?, ?)",
+			p.OrgID, p.Name, p.RepeatCount, p.IsDefault, p.MinSeverity)
+		if err == nil {
+			id, _ := res.LastInsertId()
+			p.ID = int(id)
+		}
+	}
+	if err != nil {
+		return p, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM escalation_steps WHERE policy_id = ?", p.ID); err != nil {
+		return p, err
+	}
+	for i, s := range p.Steps {
+		userIDs, _ := json.Marshal(s.UserIDs)
+		roles, _ := json.Marshal(s.Roles)
+		if _, err := tx.Exec("INSERT INTO escalation_steps (policy_id, step_order, delay_minutes, user_ids, roles) VALUES (?, ?, ?, ?, ?)",
+			p.ID, i, s.DelayMinutes, string(userIDs), string(roles)); err != nil {
+			return p, err
+		}
+	}
+	return p, tx.Commit()
+}
+
+// DeletePolicy: Remove a política (escalonamentos em andamento são cancelados)
+func (e *Escalator) DeletePolicy(id int) error {
+	if _, err := e.DB.Exec("UPDATE alert_rules SET escalation_policy_id = NULL WHERE escalation_policy_id = ?", id); err != nil {
+		return err
+	}
+	_, err := e.DB.Exec("DELETE FROM escalation_policies WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,295 @@
+package alerts
+
+import (
+	"database/sql"
+	"time"
+)
+
+// --- PERSISTÊNCIA DE ALERTAS ---
+
+const alertColumns = `a.id, COALESCE(a.rule_id, 0), COALESCE(a.geofence_id, 0), a.device_id, d.esn, COALESCE(d.name, ''), a.kind, a.severity,
+	a.state, COALESCE(a.message, ''), a.metric_value, a.opened_at, a.acknowledged_at,
+	COALESCE(a.acknowledged_by, 0), a.resolved_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAlert(row scanner) (Alert, error) {
+	var a Alert
+	var value sql.NullFloat64
+	var openedAt time.Time
+	var ackAt, resolvedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.RuleID, &a.GeofenceID, &a.DeviceID, &a.ESN, &a.DeviceName, &a.Kind, &a.Severity,
+		&a.State, &a.Message, &value, &openedAt, &ackAt, &a.AcknowledgedBy, &resolvedAt)
+	if err != nil {
+		return a, err
+	}
+	if value.Valid {
+		v := value.Float64
+		a.Value = &v
+	}
+	a.OpenedAt = openedAt.Format(timeLayout)
+	if ackAt.Valid {
+		a.AcknowledgedAt = ackAt.Time.Format(timeLayout)
+	}
+	if resolvedAt.Valid {
+		a.ResolvedAt = resolvedAt.Time.Format(timeLayout)
+	}
+	return a, nil
+}
+
+// Get: Busca um alerta pelo ID
+func (e *Engine) Get(id int) (Alert, error) {
+	row := e.DB.QueryRow("SELECT "+alertColumns+" FROM alerts a JOIN devices d ON d.id = a.device_id WHERE a.id = ?", id)
+	a, err := scanAlert(row)
+	if err == sql.ErrNoRows {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+// ListFilter: Filtros da listagem. UserID = 0 lista todos os dispositivos da
+// organização OrgID (0 = organização padrão), ou de todas com AllOrgs.
+type ListFilter struct {
+	UserID  int
+	OrgID   int
+	AllOrgs bool
+	State   string
+	Limit   int
+}
+
+// List: Alertas mais recentes, restritos aos dispositivos do usuário
+func (e *Engine) List(f ListFilter) ([]Alert, error) {
+	query := "SELECT " + alertColumns + " FROM alerts a JOIN devices d ON d.id = a.device_id"
+	var args []interface{}
+	var where []string
+
+	if f.UserID != 0 {
+		query += " JOIN user_permissions up ON up.device_id = a.device_id"
+		where = append(where, "up.user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if !f.AllOrgs {
+		where = append(where, "COALESCE(d.organization_id, 0) = ?")
+		args = append(args, f.OrgID)
+	}
+	if f.State != "" {
+		where = append(where, "a.state = ?")
+		args = append(args, f.State)
+	}
+	for i, w := range where {
+		if i == 0 {
+			query += " WHERE " + w
+		} else {
+			query += " AND " + w
+		}
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 500
+	}
+	query += " ORDER BY a.opened_at DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := e.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]Alert, 0)
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// Open: Grava um novo alerta aberto e avisa os ouvintes
+func (e *Engine) Open(a Alert) (Alert, error) {
+	now := time.Now()
+	var ruleID, geofenceID interface{}
+	if a.RuleID != 0 {
+		ruleID = a.RuleID
+	}
+	if a.GeofenceID != 0 {
+		geofenceID = a.GeofenceID
+	}
+	if a.Severity == "" {
+		a.Severity = SeverityWarning
+	}
+
+	res, err := e.DB.Exec(`INSERT INTO alerts (rule_id, geofence_id, device_id, kind, severity, state, message, metric_value, opened_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, ruleID, geofenceID, a.DeviceID, a.Kind, a.Severity, StateOpen, a.Message, a.Value, now)
+	if err != nil {
+		return a, err
+	}
+	id, _ := res.LastInsertId()
+	a.ID = int(id)
+	a.State = StateOpen
+	a.OpenedAt = now.Format(timeLayout)
+
+	e.notify(a)
+	return a, nil
+}
+
+// Acknowledge: Marca o alerta como reconhecido por um usuário
+func (e *Engine) Acknowledge(id, userID int) (Alert, error) {
+	res, err := e.DB.Exec("UPDATE alerts SET state = ?, acknowledged_at = ?, acknowledged_by = ? WHERE id = ? AND state = ?",
+		StateAcknowledged, time.Now(), userID, id, StateOpen)
+	if err != nil {
+		return Alert{}, err
+	}
+	return e.afterTransition(id, res)
+}
+
+// Resolve: Encerra o alerta manualmente
+func (e *Engine) Resolve(id int) (Alert, error) {
+	res, err := e.DB.Exec("UPDATE alerts SET state = ?, resolved_at = ? WHERE id = ? AND state != ?",
+		StateResolved, time.Now(), id, StateResolved)
+	if err != nil {
+		return Alert{}, err
+	}
+	a, err := e.afterTransition(id, res)
+	if err == nil && a.RuleID != 0 {
+		// Permite que a regra volte a disparar na próxima violação
+		e.mu.Lock()
+		e.state(a.RuleID, a.DeviceID).active = false
+		e.mu.Unlock()
+	}
+	return a, err
+}
+
+// ResolveOpen: Resolve automaticamente os alertas ativos de um tipo para o dispositivo
+func (e *Engine) ResolveOpen(deviceID int, kind string) error {
+	return e.resolveWhere("device_id = ? AND kind = ?", deviceID, kind)
+}
+
+// ResolveGeofence: Resolve os alertas de saída da cerca para o dispositivo (os
+// anteriores à coluna geofence_id, sem cerca conhecida, também)
+func (e *Engine) ResolveGeofence(deviceID, geofenceID int, kind string) error {
+	return e.resolveWhere("device_id = ? AND kind = ? AND (geofence_id = ? OR geofence_id IS NULL)", deviceID, kind, geofenceID)
+}
+
+func (e *Engine) resolveRule(ruleID, deviceID int) error {
+	return e.resolveWhere("rule_id = ? AND device_id = ?", ruleID, deviceID)
+}
+
+func (e *Engine) resolveWhere(cond string, args ...interface{}) error {
+	rows, err := e.DB.Query("SELECT id FROM alerts WHERE "+cond+" AND state != ?", append(args, StateResolved)...)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		res, err := e.DB.Exec("UPDATE alerts SET state = ?, resolved_at = ? WHERE id = ? AND state != ?",
+			StateResolved, time.Now(), id, StateResolved)
+		if err != nil {
+			return err
+		}
+		if _, err := e.afterTransition(id, res); err != nil && err != ErrInvalidState {
+			return err
+		}
+	}
+	return nil
+}
+
+// afterTransition: Confere se o UPDATE mudou algo e notifica o novo estado
+func (e *Engine) afterTransition(id int, res sql.Result) (Alert, error) {
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := e.Get(id); err != nil {
+			return Alert{}, err
+		}
+		return Alert{}, ErrInvalidState
+	}
+	a, err := e.Get(id)
+	if err != nil {
+		return a, err
+	}
+	e.notify(a)
+	return a, nil
+}
+
+// --- PERSISTÊNCIA DE REGRAS ---
+
+// ListRules: Todas as regras cadastradas
+func (e *Engine) ListRules() ([]Rule, error) {
+	rows, err := e.DB.Query(`SELECT id, name, COALESCE(device_id, 0), COALESCE(group_id, 0), metric, condition_type,
+		threshold, hysteresis, cooldown_seconds, severity, enabled, COALESCE(escalation_policy_id, 0) FROM alert_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]Rule, 0)
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(&r.ID, &r.Name, &r.DeviceID, &r.GroupID, &r.Metric, &r.Condition,
+			&r.Threshold, &r.Hysteresis, &r.CooldownSeconds, &r.Severity, &r.Enabled, &r.EscalationPolicyID); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// SaveRule: Cria (ID = 0) ou atualiza uma regra e recarrega o motor
+func (e *Engine) SaveRule(r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	var deviceID, groupID, policyID interface{}
+	if r.DeviceID != 0 {
+		deviceID = r.DeviceID
+	}
+	if r.GroupID != 0 {
+		groupID = r.GroupID
+	}
+	if r.EscalationPolicyID != 0 {
+		policyID = r.EscalationPolicyID
+	}
+
+	if r.ID > 0 {
+		_, err := e.DB.Exec(`UPDATE alert_rules SET name=?, device_id=?, group_id=?, metric=?, condition_type=?, threshold=?,
+			hysteresis=?, cooldown_seconds=?, severity=?, enabled=?, escalation_policy_id=? WHERE id=?`,
+			r.Name, deviceID, groupID, r.Metric, r.Condition, r.Threshold, r.Hysteresis, r.CooldownSeconds, r.Severity, r.Enabled, policyID, r.ID)
+		if err != nil {
+			return r, err
+		}
+	} else {
+		res, err := e.DB.Exec(`INSERT INTO alert_rules (name, device_id, group_id, metric, condition_type, threshold,
+			hysteresis, cooldown_seconds, severity, enabled, escalation_policy_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.Name, deviceID, groupID, r.Metric, r.Condition, r.Threshold, r.Hysteresis, r.CooldownSeconds, r.Severity, r.Enabled, policyID)
+		if err != nil {
+			return r, err
+		}
+		id, _ := res.LastInsertId()
+		r.ID = int(id)
+	}
+	return r, e.Load()
+}
+
+// DeleteRule: Remove a regra (alertas antigos ficam com rule_id NULL)
+func (e *Engine) DeleteRule(id int) error {
+	if _, err := e.DB.Exec("DELETE FROM alert_rules WHERE id = ?", id); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	for k := range e.states {
+		if k.ruleID == id {
+			delete(e.states, k)
+		}
+	}
+	e.mu.Unlock()
+	return e.Load()
+}
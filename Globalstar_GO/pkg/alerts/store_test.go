@@ -0,0 +1,28 @@
+package alerts
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestResolveGeofenceOnlyTouchesThatFence(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	e := NewEngine(db, nil)
+
+	// A busca filtra pela cerca; nada aberto para ela, nada é resolvido
+	mock.ExpectQuery("SELECT id FROM alerts WHERE device_id = \\? AND kind = \\? AND \\(geofence_id = \\? OR geofence_id IS NULL\\) AND state != \\?").
+		WithArgs(42, "GEOFENCE_EXIT", 7, StateResolved).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if err := e.ResolveGeofence(42, 7, "GEOFENCE_EXIT"); err != nil {
+		t.Fatalf("ResolveGeofence: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,279 @@
+package alerts
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"iot_modulo1.0/pkg/globalstar"
+)
+
+// Tipo de alerta aberto pelo watchdog
+const KindDeviceSilent = "DEVICE_SILENT"
+
+// Status de comunicação do dispositivo
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// DeviceStatus: Situação de comunicação de um dispositivo monitorado
+type DeviceStatus struct {
+	DeviceID        int    `json:"device_id"`
+	ESN             string `json:"esn"`
+	IntervalMinutes int    `json:"expected_interval_minutes"`
+	LastSeen        string `json:"last_seen,omitempty"`
+	Status          string `json:"status"`
+
+	lastSeen time.Time
+}
+
+// --- WATCHDOG DE SILÊNCIO (HEARTBEAT PERDIDO) ---
+
+// Watchdog: Compara o último received_at de cada dispositivo com o intervalo
+// esperado, abre alerta quando ele atrasa e resolve quando volta a reportar.
+type Watchdog struct {
+	Engine *Engine
+
+	mu      sync.Mutex
+	devices map[int]*DeviceStatus
+	started time.Time
+}
+
+// Construtor: O watchdog usa o motor para abrir/resolver alertas e fazer o broadcast
+func NewWatchdog(engine *Engine) *Watchdog {
+	return &Watchdog{
+		Engine:  engine,
+		devices: make(map[int]*DeviceStatus),
+		started: time.Now(),
+	}
+}
+
+// Load: Lê intervalos, última mensagem e alertas de silêncio ainda abertos
+func (w *Watchdog) Load() error {
+	rows, err := w.Engine.DB.Query(`SELECT d.id, d.esn, d.expected_interval_minutes, MAX(m.received_at)
+		FROM devices d LEFT JOIN messages m ON m.device_id = d.id
+		GROUP BY d.id, d.esn, d.expected_interval_minutes`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	devices := make(map[int]*DeviceStatus)
+	for rows.Next() {
+		var d DeviceStatus
+		var last sql.NullTime
+		if err := rows.Scan(&d.DeviceID, &d.ESN, &d.IntervalMinutes, &last); err != nil {
+			return err
+		}
+		if last.Valid {
+			d.lastSeen = last.Time
+		}
+		d.Status = StatusOnline
+		devices[d.DeviceID] = &d
+	}
+
+	silentRows, err := w.Engine.DB.Query("SELECT DISTINCT device_id FROM alerts WHERE kind = ? AND state != ?", KindDeviceSilent, StateResolved)
+	if err != nil {
+		return err
+	}
+	defer silentRows.Close()
+	for silentRows.Next() {
+		var id int
+		silentRows.Scan(&id)
+		if d, ok := devices[id]; ok {
+			d.Status = StatusOffline
+		}
+	}
+
+	w.mu.Lock()
+	w.devices = devices
+	w.mu.Unlock()
+	return nil
+}
+
+// SetInterval: Atualiza o intervalo esperado (minutos, 0 desliga o monitoramento)
+func (w *Watchdog) SetInterval(deviceID int, minutes int) error {
+	var esn string
+	if err := w.Engine.DB.QueryRow("SELECT esn FROM devices WHERE id = ?", deviceID).Scan(&esn); err != nil {
+		return err
+	}
+	if _, err := w.Engine.DB.Exec("UPDATE devices SET expected_interval_minutes = ? WHERE id = ?", minutes, deviceID); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	d, ok := w.devices[deviceID]
+	if !ok {
+		d = &DeviceStatus{DeviceID: deviceID, ESN: esn, Status: StatusOnline}
+		w.devices[deviceID] = d
+	}
+	d.IntervalMinutes = minutes
+	wasOffline := minutes == 0 && d.Status == StatusOffline
+	if wasOffline {
+		d.Status = StatusOnline
+	}
+	w.mu.Unlock()
+
+	// Monitoramento desligado: encerra o alerta pendente
+	if wasOffline {
+		if err := w.Engine.ResolveOpen(deviceID, KindDeviceSilent); err != nil {
+			return err
+		}
+		w.broadcastStatus(deviceID, esn, StatusOnline)
+	}
+	return nil
+}
+
+// Statuses: Situação atual de todos os dispositivos conhecidos
+func (w *Watchdog) Statuses() []DeviceStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := make([]DeviceStatus, 0, len(w.devices))
+	for _, d := range w.devices {
+		s := *d
+		if !d.lastSeen.IsZero() {
+			s.LastSeen = d.lastSeen.Format(timeLayout)
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+// Process: Implementa globalstar.Processor (registra o "sinal de vida")
+func (w *Watchdog) Process(ev globalstar.Event) {
+	w.mu.Lock()
+	d, ok := w.devices[ev.DeviceID]
+	if !ok {
+		d = &DeviceStatus{DeviceID: ev.DeviceID, ESN: ev.ESN, Status: StatusOnline}
+		w.devices[ev.DeviceID] = d
+	}
+	d.lastSeen = ev.ReceivedAt
+	recovered := d.Status == StatusOffline
+	d.Status = StatusOnline
+	w.mu.Unlock()
+
+	if recovered {
+		if err := w.Engine.ResolveOpen(ev.DeviceID, KindDeviceSilent); err != nil {
+			log.Printf("Erro ao resolver alerta de silêncio (device %d): %v", ev.DeviceID, err)
+		}
+		w.broadcastStatus(ev.DeviceID, ev.ESN, StatusOnline)
+	}
+}
+
+// Run: Verifica periodicamente os dispositivos atrasados (bloqueante)
+func (w *Watchdog) Run(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		w.Check(now)
+	}
+}
+
+// Check: Abre alerta para cada dispositivo cujo intervalo esperado já passou.
+//
+// Com várias réplicas, cada uma roda o watchdog, mas só vê as mensagens que ela
+// mesma recebeu. Por isso o atraso visto em memória é só uma suspeita: antes de
+// abrir o alerta (e fazer o broadcast, que já chega a todas as réplicas pelo
+// PubSub) o último received_at, o intervalo e o alerta de silêncio aberto são
+// relidos do banco. Se outra réplica já abriu o alerta, esta só marca offline.
+func (w *Watchdog) Check(now time.Time) {
+	var suspects []int
+	w.mu.Lock()
+	for _, d := range w.devices {
+		if d.IntervalMinutes > 0 && d.Status != StatusOffline && w.overdue(d, now) {
+			suspects = append(suspects, d.DeviceID)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, id := range suspects {
+		interval, last, silentOpen, err := w.stored(id)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Erro ao reler dispositivo %d no watchdog: %v", id, err)
+				continue
+			}
+			// Removido (por esta ou outra réplica)
+			w.mu.Lock()
+			delete(w.devices, id)
+			w.mu.Unlock()
+			continue
+		}
+
+		w.mu.Lock()
+		d, ok := w.devices[id]
+		if !ok || d.Status == StatusOffline {
+			w.mu.Unlock()
+			continue
+		}
+		d.IntervalMinutes = interval
+		if last.After(d.lastSeen) {
+			d.lastSeen = last
+		}
+		if interval <= 0 || !w.overdue(d, now) {
+			w.mu.Unlock()
+			continue
+		}
+		d.Status = StatusOffline
+		s := *d
+		if s.lastSeen.IsZero() {
+			s.lastSeen = w.started
+		}
+		w.mu.Unlock()
+
+		if silentOpen {
+			continue
+		}
+		msg := fmt.Sprintf("Sem comunicação há %s (esperado a cada %d min)",
+			now.Sub(s.lastSeen).Round(time.Minute), s.IntervalMinutes)
+		_, err = w.Engine.Open(Alert{
+			DeviceID: s.DeviceID,
+			ESN:      s.ESN,
+			Kind:     KindDeviceSilent,
+			Severity: SeverityCritical,
+			Message:  msg,
+		})
+		if err != nil {
+			log.Printf("Erro ao abrir alerta de silêncio (device %d): %v", s.DeviceID, err)
+		}
+		w.broadcastStatus(s.DeviceID, s.ESN, StatusOffline)
+	}
+}
+
+// overdue: O intervalo esperado já passou? Sem nenhuma mensagem ainda, conta a
+// partir da subida do servidor (chamar com w.mu travado)
+func (w *Watchdog) overdue(d *DeviceStatus, now time.Time) bool {
+	last := d.lastSeen
+	if last.IsZero() {
+		last = w.started
+	}
+	return now.Sub(last) > time.Duration(d.IntervalMinutes)*time.Minute
+}
+
+// stored: Intervalo, última mensagem e alerta de silêncio aberto segundo o banco
+func (w *Watchdog) stored(deviceID int) (interval int, last time.Time, silentOpen bool, err error) {
+	var lastNull sql.NullTime
+	err = w.Engine.DB.QueryRow(`SELECT d.expected_interval_minutes,
+		(SELECT MAX(m.received_at) FROM messages m WHERE m.device_id = d.id),
+		EXISTS(SELECT 1 FROM alerts a WHERE a.device_id = d.id AND a.kind = ? AND a.state != ?)
+		FROM devices d WHERE d.id = ?`, KindDeviceSilent, StateResolved, deviceID).Scan(&interval, &lastNull, &silentOpen)
+	if lastNull.Valid {
+		last = lastNull.Time
+	}
+	return interval, last, silentOpen, err
+}
+
+func (w *Watchdog) broadcastStatus(deviceID int, esn, status string) {
+	if w.Engine.Broadcast == nil {
+		return
+	}
+	w.Engine.Broadcast <- map[string]interface{}{
+		"type":      "DEVICE_STATUS",
+		"device_id": deviceID,
+		"esn":       esn,
+		"status":    status,
+	}
+}
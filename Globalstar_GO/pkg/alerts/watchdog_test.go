@@ -0,0 +1,75 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// silentWatchdog: Dispositivo 42 (intervalo de 10 min) visto há 30 min por esta réplica
+func silentWatchdog(t *testing.T, now time.Time) (*Watchdog, sqlmock.Sqlmock, chan interface{}) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	broadcast := make(chan interface{}, 4)
+	w := NewWatchdog(NewEngine(db, broadcast))
+	w.devices[42] = &DeviceStatus{DeviceID: 42, ESN: "0-1234567", IntervalMinutes: 10, Status: StatusOnline,
+		lastSeen: now.Add(-30 * time.Minute)}
+	return w, mock, broadcast
+}
+
+func expectStored(mock sqlmock.Sqlmock, interval int, last time.Time, silentOpen bool) {
+	mock.ExpectQuery("SELECT d.expected_interval_minutes").WithArgs(KindDeviceSilent, StateResolved, 42).
+		WillReturnRows(sqlmock.NewRows([]string{"expected_interval_minutes", "last", "silent"}).AddRow(interval, last, silentOpen))
+}
+
+func TestWatchdogRereadsMessagesFromOtherReplicas(t *testing.T) {
+	now := time.Now()
+	w, mock, broadcast := silentWatchdog(t, now)
+	// A mensagem chegou a outra réplica há 2 min: nada de alerta
+	expectStored(mock, 10, now.Add(-2*time.Minute), false)
+
+	w.Check(now)
+	if s := w.devices[42]; s.Status != StatusOnline || !s.lastSeen.Equal(now.Add(-2*time.Minute)) {
+		t.Fatalf("dispositivo ativo em outra réplica ficou %s (visto %v)", s.Status, s.lastSeen)
+	}
+	if len(broadcast) != 0 {
+		t.Fatal("broadcast de um dispositivo que está reportando")
+	}
+}
+
+func TestWatchdogSkipsAlertOpenedByOtherReplica(t *testing.T) {
+	now := time.Now()
+	w, mock, broadcast := silentWatchdog(t, now)
+	// Outra réplica já abriu o alerta (e fez o broadcast pelo PubSub)
+	expectStored(mock, 10, now.Add(-30*time.Minute), true)
+
+	w.Check(now)
+	if w.devices[42].Status != StatusOffline {
+		t.Fatal("dispositivo em silêncio deveria ficar offline")
+	}
+	if len(broadcast) != 0 {
+		t.Fatal("alerta duplicado de outra réplica")
+	}
+}
+
+func TestWatchdogOpensSilentAlert(t *testing.T) {
+	now := time.Now()
+	w, mock, broadcast := silentWatchdog(t, now)
+	expectStored(mock, 10, now.Add(-30*time.Minute), false)
+	mock.ExpectExec("INSERT INTO alerts").WillReturnResult(sqlmock.NewResult(7, 1))
+
+	w.Check(now)
+	if w.devices[42].Status != StatusOffline || len(broadcast) != 2 {
+		t.Fatalf("status %s, %d broadcasts (esperado ALERT_UPDATE e DEVICE_STATUS)", w.devices[42].Status, len(broadcast))
+	}
+}
@@ -0,0 +1,404 @@
+package auditchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --- CADEIA DE AUDITORIA ---
+// Cada registro de audit_logs recebe um número de sequência, o hash do
+// registro anterior e o hash do próprio conteúdo (que inclui o anterior).
+// Editar ou apagar um registro quebra a cadeia a partir dele. Checkpoints
+// assinados (Ed25519) fixam periodicamente a cabeça da cadeia, para que nem
+// quem tem acesso ao banco consiga reescrever o fim da cadeia sem a chave.
+//
+// Tabelas (criadas pelo esquema do backend): audit_logs (chain_seq,
+// prev_hash, entry_hash), audit_chain_head (linha única, trava as inserções)
+// e audit_checkpoints.
+
+// GenesisHash: "Hash anterior" do primeiro registro da cadeia
+var GenesisHash = strings.Repeat("0", 64)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// Entry: Conteúdo coberto pelo hash. Campos novos devem ser omitempty para
+// não mudar o hash dos registros gravados antes deles.
+type Entry struct {
+	Seq       int64     `json:"seq"`
+	PrevHash  string    `json:"prev"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Details   string    `json:"details"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"-"`
+
+	// Eventos estruturados: alvo, diff (JSON), requisição e navegador
+	TargetType string `json:"target_type,omitempty"`
+	TargetID   string `json:"target_id,omitempty"`
+	Changes    string `json:"changes,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	// Organização que enxerga o registro (a do alvo); 0 = plataforma
+	OrgID int `json:"org_id,omitempty"`
+}
+
+// entryColumns: Colunas de audit_logs cobertas pelo hash, na ordem de Entry.fields
+const entryColumns = `COALESCE(user_id, 0), COALESCE(username, ''), COALESCE(action, ''), COALESCE(details, ''),
+	COALESCE(ip_address, ''), created_at, COALESCE(target_type, ''), COALESCE(target_id, ''), COALESCE(changes, ''),
+	COALESCE(request_id, ''), COALESCE(user_agent, ''), COALESCE(organization_id, 0)`
+
+func (e *Entry) fields() []interface{} {
+	return []interface{}{&e.UserID, &e.Username, &e.Action, &e.Details, &e.IP, &e.CreatedAt,
+		&e.TargetType, &e.TargetID, &e.Changes, &e.RequestID, &e.UserAgent, &e.OrgID}
+}
+
+// fit: Ajusta cada campo ao limite da coluna (VARCHAR conta caracteres, TEXT
+// conta bytes), sem cortar no meio de um caractere nem gravar UTF-8 inválido
+func (e *Entry) fit() {
+	e.Username = fitChars(e.Username, 50)
+	e.Action = fitChars(e.Action, 100)
+	e.IP = fitChars(e.IP, 45)
+	e.TargetType = fitChars(e.TargetType, 30)
+	e.TargetID = fitChars(e.TargetID, 64)
+	e.RequestID = fitChars(e.RequestID, 64)
+	e.UserAgent = fitChars(e.UserAgent, 255)
+	e.Details = fitBytes(e.Details, 65535)
+	e.Changes = fitBytes(e.Changes, 65535)
+}
+
+func fitChars(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+func fitBytes(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Hash: SHA-256 do JSON canônico do registro (data em UTC, sem frações)
+func (e Entry) Hash() string {
+	payload := struct {
+		Entry
+		CreatedAt string `json:"created_at"`
+	}{e, e.CreatedAt.UTC().Format(timeLayout)}
+	raw, _ := json.Marshal(payload)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Checkpoint: Cabeça da cadeia assinada em um instante
+type Checkpoint struct {
+	ID        int64     `json:"id"`
+	Seq       int64     `json:"seq"`
+	EntryHash string    `json:"entry_hash"`
+	KeyID     string    `json:"key_id"`
+	Signature string    `json:"signature"`
+	SignedAt  time.Time `json:"signed_at"`
+}
+
+// message: O que a assinatura cobre
+func (c Checkpoint) message() []byte {
+	return []byte(fmt.Sprintf("audit-checkpoint|%d|%s|%s", c.Seq, c.EntryHash, c.SignedAt.UTC().Format(timeLayout)))
+}
+
+// KeyID: Identificador curto da chave pública (para saber qual chave assinou)
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Chain: Grava e verifica a cadeia. Sem chave privada, grava registros mas não assina checkpoints.
+type Chain struct {
+	db   *sql.DB
+	key  ed25519.PrivateKey
+	pub  ed25519.PublicKey
+	Logf func(format string, args ...interface{})
+}
+
+func NewChain(db *sql.DB, key ed25519.PrivateKey) *Chain {
+	c := &Chain{db: db, key: key, Logf: log.Printf}
+	if key != nil {
+		c.pub = key.Public().(ed25519.PublicKey)
+	}
+	return c
+}
+
+// NewVerifier: Só verificação (ex: auditor externo com a chave pública)
+func NewVerifier(db *sql.DB, pub ed25519.PublicKey) *Chain {
+	return &Chain{db: db, pub: pub, Logf: log.Printf}
+}
+
+// PublicKey: Chave pública em base64 (para publicar e verificar fora do sistema)
+func (c *Chain) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(c.pub)
+}
+
+// Append: Grava o registro encadeado. A linha de audit_chain_head é travada
+// na transação, o que serializa as inserções mesmo com várias réplicas.
+// O hash é calculado sobre a linha como ficou gravada (lida de volta), a
+// mesma que Verify vai ler.
+func (c *Chain) Append(e Entry) error {
+	e.fit()
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lastSeq int64
+	var lastHash string
+	if err := tx.QueryRow("SELECT last_seq, last_hash FROM audit_chain_head WHERE id = 1 FOR UPDATE").Scan(&lastSeq, &lastHash); err != nil {
+		return fmt.Errorf("cabeça da cadeia: %w", err)
+	}
+	if lastSeq == 0 {
+		lastHash = GenesisHash
+	}
+
+	res, err := tx.Exec(`INSERT INTO audit_logs (user_id, username, action, details, ip_address,
+		target_type, target_id, changes, request_id, user_agent, organization_id)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?)`,
+		e.UserID, e.Username, e.Action, e.Details, e.IP, e.TargetType, e.TargetID, e.Changes, e.RequestID, e.UserAgent, e.OrgID)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	// A data é a do banco (mesma dos registros anteriores à cadeia)
+	if err := tx.QueryRow("SELECT "+entryColumns+" FROM audit_logs WHERE id = ?", id).Scan(e.fields()...); err != nil {
+		return err
+	}
+
+	e.Seq = lastSeq + 1
+	e.PrevHash = lastHash
+	hash := e.Hash()
+	if _, err := tx.Exec("UPDATE audit_logs SET chain_seq = ?, prev_hash = ?, entry_hash = ? WHERE id = ?", e.Seq, e.PrevHash, hash, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE audit_chain_head SET last_seq = ?, last_hash = ? WHERE id = 1", e.Seq, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Checkpoint: Assina a cabeça atual, se ela avançou desde o último checkpoint (nil = nada novo)
+func (c *Chain) Checkpoint() (*Checkpoint, error) {
+	if c.key == nil {
+		return nil, errors.New("checkpoint sem chave de assinatura")
+	}
+	var cp Checkpoint
+	if err := c.db.QueryRow("SELECT last_seq, last_hash FROM audit_chain_head WHERE id = 1").Scan(&cp.Seq, &cp.EntryHash); err != nil {
+		return nil, err
+	}
+	var lastSigned int64
+	c.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM audit_checkpoints").Scan(&lastSigned)
+	if cp.Seq == 0 || cp.Seq <= lastSigned {
+		return nil, nil
+	}
+
+	cp.SignedAt = time.Now().UTC().Truncate(time.Second)
+	cp.KeyID = KeyID(c.pub)
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, cp.message()))
+	res, err := c.db.Exec("INSERT INTO audit_checkpoints (seq, entry_hash, key_id, signature, signed_at) VALUES (?, ?, ?, ?, ?)",
+		cp.Seq, cp.EntryHash, cp.KeyID, cp.Signature, cp.SignedAt)
+	if err != nil {
+		return nil, err
+	}
+	cp.ID, _ = res.LastInsertId()
+	return &cp, nil
+}
+
+// Run: Checkpoint periódico (e um logo na inicialização)
+func (c *Chain) Run(interval time.Duration) {
+	for {
+		if cp, err := c.Checkpoint(); err != nil {
+			c.Logf("Erro ao assinar checkpoint da auditoria: %v", err)
+		} else if cp != nil {
+			c.Logf("Checkpoint da auditoria assinado: seq %d", cp.Seq)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// --- VERIFICAÇÃO ---
+
+// Break: Primeiro elo quebrado encontrado
+type Break struct {
+	Seq    int64  `json:"seq"`
+	LogID  int64  `json:"log_id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Report: Resultado da verificação
+type Report struct {
+	OK      bool  `json:"ok"`
+	Entries int64 `json:"entries"`
+	// Registros anteriores à cadeia (não verificáveis)
+	Legacy         int64       `json:"legacy"`
+	Checkpoints    int         `json:"checkpoints"`
+	LastCheckpoint *Checkpoint `json:"last_checkpoint,omitempty"`
+	FirstBroken    *Break      `json:"first_broken,omitempty"`
+	KeyID          string      `json:"key_id,omitempty"`
+	VerifiedAt     time.Time   `json:"verified_at"`
+}
+
+func (rep *Report) fail(seq, logID int64, reason string) {
+	if rep.FirstBroken == nil {
+		rep.OK = false
+		rep.FirstBroken = &Break{Seq: seq, LogID: logID, Reason: reason}
+	}
+}
+
+// Verify: Percorre a cadeia inteira e confere os checkpoints. Para no primeiro elo quebrado.
+func (c *Chain) Verify() (Report, error) {
+	rep := Report{OK: true, VerifiedAt: time.Now()}
+	if c.pub != nil {
+		rep.KeyID = KeyID(c.pub)
+	}
+
+	// Checkpoints por seq (a assinatura é conferida antes de confiar no hash)
+	checkpoints := make(map[int64]Checkpoint)
+	var maxSigned int64
+	cpRows, err := c.db.Query("SELECT id, seq, entry_hash, key_id, signature, signed_at FROM audit_checkpoints ORDER BY seq")
+	if err != nil {
+		return rep, err
+	}
+	var badCheckpoint *Checkpoint
+	for cpRows.Next() {
+		var cp Checkpoint
+		cpRows.Scan(&cp.ID, &cp.Seq, &cp.EntryHash, &cp.KeyID, &cp.Signature, &cp.SignedAt)
+		rep.Checkpoints++
+		if !c.validSignature(cp) {
+			if badCheckpoint == nil {
+				bad := cp
+				badCheckpoint = &bad
+			}
+			continue
+		}
+		checkpoints[cp.Seq] = cp
+		if cp.Seq > maxSigned {
+			maxSigned = cp.Seq
+			last := cp
+			rep.LastCheckpoint = &last
+		}
+	}
+	cpRows.Close()
+
+	c.db.QueryRow("SELECT COUNT(*) FROM audit_logs WHERE chain_seq IS NULL").Scan(&rep.Legacy)
+
+	rows, err := c.db.Query("SELECT id, chain_seq, prev_hash, entry_hash, " + entryColumns +
+		" FROM audit_logs WHERE chain_seq IS NOT NULL ORDER BY chain_seq")
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+
+	prevHash := GenesisHash
+	var expected int64 = 1
+	var firstID int64
+	for rows.Next() {
+		var id int64
+		var e Entry
+		var stored string
+		if err := rows.Scan(append([]interface{}{&id, &e.Seq, &e.PrevHash, &stored}, e.fields()...)...); err != nil {
+			return rep, err
+		}
+		if firstID == 0 {
+			firstID = id
+		}
+		switch {
+		case e.Seq != expected:
+			rep.fail(expected, 0, fmt.Sprintf("registro ausente (a cadeia pula para seq %d)", e.Seq))
+		case e.PrevHash != prevHash:
+			rep.fail(e.Seq, id, "hash anterior não confere (registro anterior alterado ou removido)")
+		case e.Hash() != stored:
+			rep.fail(e.Seq, id, "conteúdo alterado (hash não confere)")
+		}
+		if cp, ok := checkpoints[e.Seq]; ok && cp.EntryHash != stored {
+			rep.fail(e.Seq, id, fmt.Sprintf("diverge do checkpoint assinado em %s", cp.SignedAt.Format(timeLayout)))
+		}
+		if rep.FirstBroken != nil {
+			return rep, nil
+		}
+		rep.Entries++
+		prevHash = stored
+		expected++
+	}
+	if err := rows.Err(); err != nil {
+		return rep, err
+	}
+
+	// Fim da cadeia apagado: o checkpoint assinado ou a cabeça apontam além do último registro
+	last := expected - 1
+	var headSeq int64
+	c.db.QueryRow("SELECT last_seq FROM audit_chain_head WHERE id = 1").Scan(&headSeq)
+	if maxSigned > last {
+		rep.fail(last+1, 0, fmt.Sprintf("registros removidos do fim da cadeia (checkpoint assinado até seq %d)", maxSigned))
+	} else if headSeq > last {
+		rep.fail(last+1, 0, fmt.Sprintf("registros removidos do fim da cadeia (cabeça em seq %d)", headSeq))
+	}
+	// Registro inserido direto no banco, fora da cadeia, depois que ela começou
+	if firstID > 0 {
+		var outside int64
+		if err := c.db.QueryRow("SELECT id FROM audit_logs WHERE chain_seq IS NULL AND id > ? ORDER BY id LIMIT 1", firstID).Scan(&outside); err == nil {
+			rep.fail(0, outside, "registro fora da cadeia (inserido sem hash)")
+		}
+	}
+	if badCheckpoint != nil {
+		rep.fail(badCheckpoint.Seq, 0, fmt.Sprintf("checkpoint %d com assinatura inválida ou de outra chave (%s)", badCheckpoint.ID, badCheckpoint.KeyID))
+	}
+	return rep, nil
+}
+
+func (c *Chain) validSignature(cp Checkpoint) bool {
+	if c.pub == nil || cp.KeyID != KeyID(c.pub) {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(cp.Signature)
+	return err == nil && ed25519.Verify(c.pub, cp.message(), sig)
+}
+
+// --- CHAVES ---
+
+// ParsePrivateKey: Semente Ed25519 de 32 bytes em base64
+func ParsePrivateKey(b64 string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("chave de assinatura deve ser uma semente de %d bytes em base64", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParsePublicKey: Chave pública Ed25519 em base64
+func ParsePublicKey(b64 string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("chave pública deve ter %d bytes em base64", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// DeriveKey: Chave derivada de um segredo (ex: JWT_SECRET) quando não há chave própria
+func DeriveKey(secret string) ed25519.PrivateKey {
+	seed := sha256.Sum256([]byte("audit-checkpoint:" + secret))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
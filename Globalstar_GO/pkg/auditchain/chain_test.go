@@ -0,0 +1,201 @@
+package auditchain
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var testTime = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return conn, mock
+}
+
+// sampleChain: n registros encadeados como o Append grava
+func sampleChain(n int) (entries []Entry, hashes []string) {
+	prev := GenesisHash
+	for i := 1; i <= n; i++ {
+		e := Entry{Seq: int64(i), PrevHash: prev, UserID: 1, Username: "master", Action: "UPDATE_USER",
+			Details: "Editou usuário", IP: "10.0.0.1", CreatedAt: testTime.Add(time.Duration(i) * time.Minute),
+			TargetType: "user", TargetID: "9", OrgID: 2}
+		prev = e.Hash()
+		entries = append(entries, e)
+		hashes = append(hashes, prev)
+	}
+	return entries, hashes
+}
+
+func logRows(entries []Entry, hashes []string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "chain_seq", "prev_hash", "entry_hash", "user_id", "username", "action", "details",
+		"ip_address", "created_at", "target_type", "target_id", "changes", "request_id", "user_agent", "organization_id"})
+	for i, e := range entries {
+		rows.AddRow(100+e.Seq, e.Seq, e.PrevHash, hashes[i], e.UserID, e.Username, e.Action, e.Details,
+			e.IP, e.CreatedAt, e.TargetType, e.TargetID, e.Changes, e.RequestID, e.UserAgent, e.OrgID)
+	}
+	return rows
+}
+
+// signed: Checkpoint assinado com a chave informada
+func signed(key ed25519.PrivateKey, seq int64, hash string) Checkpoint {
+	cp := Checkpoint{ID: seq, Seq: seq, EntryHash: hash, KeyID: KeyID(key.Public().(ed25519.PublicKey)), SignedAt: testTime.Add(time.Hour)}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, cp.message()))
+	return cp
+}
+
+// expectVerify: Consultas do Verify; toEnd = a cadeia é percorrida até o fim
+func expectVerify(mock sqlmock.Sqlmock, cps []Checkpoint, entries []Entry, hashes []string, head int64, toEnd bool) {
+	cpRows := sqlmock.NewRows([]string{"id", "seq", "entry_hash", "key_id", "signature", "signed_at"})
+	for _, cp := range cps {
+		cpRows.AddRow(cp.ID, cp.Seq, cp.EntryHash, cp.KeyID, cp.Signature, cp.SignedAt)
+	}
+	mock.ExpectQuery("FROM audit_checkpoints ORDER BY seq").WillReturnRows(cpRows)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM audit_logs WHERE chain_seq IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectQuery("FROM audit_logs WHERE chain_seq IS NOT NULL ORDER BY chain_seq").WillReturnRows(logRows(entries, hashes))
+	if toEnd {
+		mock.ExpectQuery("SELECT last_seq FROM audit_chain_head").WillReturnRows(sqlmock.NewRows([]string{"last_seq"}).AddRow(head))
+		mock.ExpectQuery("WHERE chain_seq IS NULL AND id > \\?").WillReturnError(sql.ErrNoRows)
+	}
+}
+
+func verify(t *testing.T, conn *sql.DB, key ed25519.PrivateKey) Report {
+	t.Helper()
+	rep, err := NewVerifier(conn, key.Public().(ed25519.PublicKey)).Verify()
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return rep
+}
+
+func expectBreak(t *testing.T, rep Report, seq int64, reason string) {
+	t.Helper()
+	if rep.OK || rep.FirstBroken == nil {
+		t.Fatalf("cadeia deveria estar quebrada: %+v", rep)
+	}
+	if rep.FirstBroken.Seq != seq || !strings.Contains(rep.FirstBroken.Reason, reason) {
+		t.Fatalf("quebra inesperada: seq %d (%s), esperado seq %d (%s)", rep.FirstBroken.Seq, rep.FirstBroken.Reason, seq, reason)
+	}
+}
+
+func TestVerifyIntactChain(t *testing.T) {
+	conn, mock := newMock(t)
+	key := DeriveKey("teste")
+	entries, hashes := sampleChain(3)
+	expectVerify(mock, []Checkpoint{signed(key, 3, hashes[2])}, entries, hashes, 3, true)
+
+	rep := verify(t, conn, key)
+	if !rep.OK || rep.Entries != 3 || rep.Checkpoints != 1 {
+		t.Fatalf("cadeia íntegra reprovada: %+v (%+v)", rep, rep.FirstBroken)
+	}
+}
+
+func TestVerifyDetectsEditedEntry(t *testing.T) {
+	conn, mock := newMock(t)
+	key := DeriveKey("teste")
+	entries, hashes := sampleChain(3)
+	entries[1].Details = "Nada aconteceu aqui"
+	expectVerify(mock, nil, entries, hashes, 3, false)
+
+	expectBreak(t, verify(t, conn, key), 2, "conteúdo alterado")
+}
+
+func TestVerifyDetectsDeletedEntry(t *testing.T) {
+	conn, mock := newMock(t)
+	key := DeriveKey("teste")
+	entries, hashes := sampleChain(3)
+	entries = append(entries[:1], entries[2:]...)
+	hashes = append(hashes[:1], hashes[2:]...)
+	expectVerify(mock, nil, entries, hashes, 3, false)
+
+	expectBreak(t, verify(t, conn, key), 2, "registro ausente")
+}
+
+func TestVerifyDetectsTailTruncation(t *testing.T) {
+	key := DeriveKey("teste")
+	entries, hashes := sampleChain(3)
+
+	// O checkpoint assinado lembra do seq 3 mesmo com a cabeça reescrita
+	conn, mock := newMock(t)
+	expectVerify(mock, []Checkpoint{signed(key, 3, hashes[2])}, entries[:2], hashes[:2], 2, true)
+	expectBreak(t, verify(t, conn, key), 3, "checkpoint assinado até seq 3")
+
+	// Sem checkpoint, a cabeça da cadeia aponta além do último registro
+	conn, mock = newMock(t)
+	expectVerify(mock, nil, entries[:2], hashes[:2], 3, true)
+	expectBreak(t, verify(t, conn, key), 3, "cabeça em seq 3")
+}
+
+func TestVerifyRejectsForgedCheckpoint(t *testing.T) {
+	conn, mock := newMock(t)
+	key := DeriveKey("teste")
+	entries, hashes := sampleChain(3)
+
+	// Assinado por outra chave, mas declarando o key_id da chave verdadeira
+	forged := signed(DeriveKey("atacante"), 3, hashes[2])
+	forged.KeyID = KeyID(key.Public().(ed25519.PublicKey))
+	expectVerify(mock, []Checkpoint{forged}, entries, hashes, 3, true)
+
+	expectBreak(t, verify(t, conn, key), 3, "assinatura inválida")
+}
+
+func TestAppendHashesTheStoredRow(t *testing.T) {
+	conn, mock := newMock(t)
+	chain := NewChain(conn, nil)
+
+	longName := strings.Repeat("ç", 60)
+	agent := "Mozilla/5.0 \xff" + strings.Repeat("á", 300)
+	stored := Entry{UserID: 1, Username: strings.Repeat("ç", 50), Action: "UPDATE_USER", Details: "Editou",
+		IP: "10.0.0.1", CreatedAt: testTime, TargetType: "user", TargetID: "9", OrgID: 2}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT last_seq, last_hash FROM audit_chain_head").
+		WillReturnRows(sqlmock.NewRows([]string{"last_seq", "last_hash"}).AddRow(0, ""))
+	// Cada campo chega ajustado à coluna, sem cortar caracteres ao meio
+	mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(1, stored.Username, "UPDATE_USER", "Editou", "10.0.0.1", "user", "9", "", "", sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	// O hash cobre a linha lida de volta, a mesma que o Verify vai ler
+	mock.ExpectQuery("FROM audit_logs WHERE id = \\?").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "action", "details", "ip_address", "created_at",
+			"target_type", "target_id", "changes", "request_id", "user_agent", "organization_id"}).
+			AddRow(1, stored.Username, "UPDATE_USER", "Editou", "10.0.0.1", testTime, "user", "9", "", "", "", 2))
+	stored.Seq, stored.PrevHash = 1, GenesisHash
+	mock.ExpectExec("UPDATE audit_logs SET chain_seq").WithArgs(1, GenesisHash, stored.Hash(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE audit_chain_head").WithArgs(1, stored.Hash()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := chain.Append(Entry{UserID: 1, Username: longName, Action: "UPDATE_USER", Details: "Editou",
+		IP: "10.0.0.1", TargetType: "user", TargetID: "9", UserAgent: agent, OrgID: 2})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+}
+
+func TestFitKeepsValidUTF8(t *testing.T) {
+	e := Entry{UserAgent: "Mozilla/5.0 \xff" + strings.Repeat("á", 300), Details: strings.Repeat("é", 40000)}
+	e.fit()
+	if !utf8.ValidString(e.UserAgent) || utf8.RuneCountInString(e.UserAgent) != 255 {
+		t.Fatalf("user_agent: %d caracteres, válido %v", utf8.RuneCountInString(e.UserAgent), utf8.ValidString(e.UserAgent))
+	}
+	if !utf8.ValidString(e.Details) || len(e.Details) > 65535 {
+		t.Fatalf("details: %d bytes, válido %v", len(e.Details), utf8.ValidString(e.Details))
+	}
+}
@@ -0,0 +1,403 @@
+package geofence
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"iot_modulo1.0/pkg/alerts"
+	"iot_modulo1.0/pkg/globalstar"
+)
+
+// Eventos emitidos na travessia da cerca
+const (
+	EventEnter = "enter"
+	EventExit  = "exit"
+)
+
+// Tipo de alerta aberto quando o equipamento sai da cerca
+const KindGeofenceExit = "GEOFENCE_EXIT"
+
+// Formato de data usado nas respostas da API
+const timeLayout = "02/01/2006 15:04:05"
+
+// --- ESTRUTURAS ---
+
+// Fence: Cerca virtual vinculada a dispositivos e/ou grupos
+type Fence struct {
+	ID          int             `json:"id"`
+	OrgID       int             `json:"organization_id"` // 0 = organização padrão
+	Name        string          `json:"name"`
+	GeoJSON     json.RawMessage `json:"geojson"`
+	AlertOnExit bool            `json:"alert_on_exit"`
+	DeviceIDs   []int           `json:"device_ids"`
+	GroupIDs    []int           `json:"group_ids"`
+
+	shape Shape
+}
+
+// Event: Entrada ou saída registrada
+type Event struct {
+	ID         int     `json:"id"`
+	FenceID    int     `json:"geofence_id"`
+	FenceName  string  `json:"geofence_name"`
+	DeviceID   int     `json:"device_id"`
+	ESN        string  `json:"esn"`
+	Event      string  `json:"event"`
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	OccurredAt string  `json:"occurred_at"`
+}
+
+type stateKey struct {
+	fenceID  int
+	deviceID int
+}
+
+// --- AVALIADOR ---
+
+// Evaluator: Confere cada posição decodificada contra as cercas do
+// dispositivo e emite eventos de entrada/saída
+type Evaluator struct {
+	DB *sql.DB
+	// Canal para enviar atualizações em tempo real (apenas escrita)
+	Broadcast chan<- interface{}
+	// Opcional: abre/resolve alertas para cercas com AlertOnExit
+	Alerts *alerts.Engine
+
+	mu           sync.Mutex
+	fences       map[int]*Fence
+	deviceGroups map[int][]int
+	inside       map[stateKey]bool
+}
+
+// Construtor (chamar Load antes de processar mensagens)
+func NewEvaluator(db *sql.DB, broadcast chan<- interface{}, engine *alerts.Engine) *Evaluator {
+	return &Evaluator{
+		DB:           db,
+		Broadcast:    broadcast,
+		Alerts:       engine,
+		fences:       make(map[int]*Fence),
+		deviceGroups: make(map[int][]int),
+		inside:       make(map[stateKey]bool),
+	}
+}
+
+// Load: Lê as cercas, os grupos e o último evento de cada par cerca/dispositivo
+func (e *Evaluator) Load() error {
+	list, err := e.List()
+	if err != nil {
+		return err
+	}
+	fences := make(map[int]*Fence, len(list))
+	for i := range list {
+		f := list[i]
+		shape, err := ParseShape(f.GeoJSON)
+		if err != nil {
+			log.Printf("Aviso: Geofence %d (%s) ignorada: %v", f.ID, f.Name, err)
+			continue
+		}
+		f.shape = shape
+		fences[f.ID] = &f
+	}
+
+	groups := make(map[int][]int)
+	rows, err := e.DB.Query("SELECT group_id, device_id FROM device_group_members")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var groupID, deviceID int
+		rows.Scan(&groupID, &deviceID)
+		groups[deviceID] = append(groups[deviceID], groupID)
+	}
+	rows.Close()
+
+	inside := make(map[stateKey]bool)
+	stateRows, err := e.DB.Query(`SELECT ge.geofence_id, ge.device_id, ge.event FROM geofence_events ge
+		JOIN (SELECT geofence_id, device_id, MAX(id) AS id FROM geofence_events GROUP BY geofence_id, device_id) last
+		ON last.id = ge.id`)
+	if err != nil {
+		return err
+	}
+	for stateRows.Next() {
+		var k stateKey
+		var ev string
+		stateRows.Scan(&k.fenceID, &k.deviceID, &ev)
+		inside[k] = ev == EventEnter
+	}
+	stateRows.Close()
+
+	e.mu.Lock()
+	e.fences = fences
+	e.deviceGroups = groups
+	// Mantém o que já foi observado em memória (primeiras leituras ainda sem evento)
+	for k, v := range e.inside {
+		if _, ok := inside[k]; !ok {
+			inside[k] = v
+		}
+	}
+	e.inside = inside
+	e.mu.Unlock()
+	return nil
+}
+
+// Process: Implementa globalstar.Processor
+func (e *Evaluator) Process(ev globalstar.Event) {
+	pos := ev.Reading.Position
+	if pos == nil {
+		return
+	}
+
+	var emitted []Event
+	var fenceByID = make(map[int]Fence)
+	e.mu.Lock()
+	for _, f := range e.fences {
+		if !e.appliesTo(f, ev.DeviceID) {
+			continue
+		}
+		now := f.shape.Contains(pos.Lat, pos.Lon)
+		k := stateKey{f.ID, ev.DeviceID}
+		before, known := e.inside[k]
+		e.inside[k] = now
+
+		// Primeira posição só define a referência
+		if !known || before == now {
+			continue
+		}
+		kind := EventExit
+		if now {
+			kind = EventEnter
+		}
+		emitted = append(emitted, Event{
+			FenceID: f.ID, FenceName: f.Name, DeviceID: ev.DeviceID, ESN: ev.ESN,
+			Event: kind, Lat: pos.Lat, Lon: pos.Lon, OccurredAt: ev.ReceivedAt.Format(timeLayout),
+		})
+		fenceByID[f.ID] = *f
+	}
+	e.mu.Unlock()
+
+	for _, gev := range emitted {
+		e.emit(gev, fenceByID[gev.FenceID], ev.ReceivedAt)
+	}
+}
+
+// emit: Grava o evento, faz o broadcast e trata o alerta de saída
+func (e *Evaluator) emit(gev Event, f Fence, at time.Time) {
+	res, err := e.DB.Exec("INSERT INTO geofence_events (geofence_id, device_id, event, lat, lon, occurred_at) VALUES (?, ?, ?, ?, ?, ?)",
+		gev.FenceID, gev.DeviceID, gev.Event, gev.Lat, gev.Lon, at)
+	if err != nil {
+		log.Printf("Erro ao gravar evento de geofence: %v", err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	gev.ID = int(id)
+
+	if e.Broadcast != nil {
+		e.Broadcast <- map[string]interface{}{
+			"type":      "GEOFENCE_EVENT",
+			"device_id": gev.DeviceID,
+			"event":     gev,
+		}
+	}
+
+	if e.Alerts == nil || !f.AlertOnExit {
+		return
+	}
+	if gev.Event == EventExit {
+		_, err = e.Alerts.Open(alerts.Alert{
+			DeviceID:   gev.DeviceID,
+			GeofenceID: gev.FenceID,
+			ESN:        gev.ESN,
+			Kind:       KindGeofenceExit,
+			Severity:   alerts.SeverityWarning,
+			Message:    fmt.Sprintf("Saiu da cerca %s (%.5f, %.5f)", f.Name, gev.Lat, gev.Lon),
+		})
+	} else {
+		// Só a saída desta cerca: o dispositivo pode estar fora de outra
+		err = e.Alerts.ResolveGeofence(gev.DeviceID, gev.FenceID, KindGeofenceExit)
+	}
+	if err != nil {
+		log.Printf("Erro ao atualizar alerta de geofence: %v", err)
+	}
+}
+
+// appliesTo: Cerca do próprio dispositivo ou de um grupo dele (chamar com e.mu travado)
+func (e *Evaluator) appliesTo(f *Fence, deviceID int) bool {
+	for _, id := range f.DeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	for _, g := range e.deviceGroups[deviceID] {
+		for _, fg := range f.GroupIDs {
+			if g == fg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// --- PERSISTÊNCIA ---
+
+// List: Todas as cercas com seus vínculos
+func (e *Evaluator) List() ([]Fence, error) {
+	rows, err := e.DB.Query("SELECT id, COALESCE(organization_id, 0), name, geojson, alert_on_exit FROM geofences ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	fences := make([]Fence, 0)
+	for rows.Next() {
+		var f Fence
+		var raw string
+		rows.Scan(&f.ID, &f.OrgID, &f.Name, &raw, &f.AlertOnExit)
+		f.GeoJSON = json.RawMessage(raw)
+		f.DeviceIDs, f.GroupIDs = []int{}, []int{}
+		fences = append(fences, f)
+	}
+	rows.Close()
+
+	for i := range fences {
+		lRows, err := e.DB.Query("SELECT COALESCE(device_id, 0), COALESCE(group_id, 0) FROM geofence_links WHERE geofence_id = ?", fences[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for lRows.Next() {
+			var deviceID, groupID int
+			lRows.Scan(&deviceID, &groupID)
+			if deviceID != 0 {
+				fences[i].DeviceIDs = append(fences[i].DeviceIDs, deviceID)
+			}
+			if groupID != 0 {
+				fences[i].GroupIDs = append(fences[i].GroupIDs, groupID)
+			}
+		}
+		lRows.Close()
+	}
+	return fences, nil
+}
+
+// Save: Valida o GeoJSON, grava a cerca com os vínculos e recarrega
+func (e *Evaluator) Save(f Fence) (Fence, error) {
+	if strings.TrimSpace(f.Name) == "" {
+		return f, fmt.Errorf("%w: nome obrigatório", ErrInvalidGeoJSON)
+	}
+	if _, err := ParseShape(f.GeoJSON); err != nil {
+		return f, err
+	}
+
+	tx, err := e.DB.Begin()
+	if err != nil {
+		return f, err
+	}
+	defer tx.Rollback()
+
+	if f.ID > 0 {
+		_, err = tx.Exec("UPDATE geofences SET organization_id = NULLIF(?, 0), name = ?, geojson = ?, alert_on_exit = ? WHERE id = ?",
+			f.OrgID, f.Name, string(f.GeoJSON), f.AlertOnExit, f.ID)
+	} else {
+		var res sql.Result
+		res, err = tx.Exec("INSERT INTO geofences (organization_id, name, geojson, alert_on_exit) VALUES (NULLIF(?, 0), ?, ?, ?)",
+			f.OrgID, f.Name, string(f.GeoJSON), f.AlertOnExit)
+		if err == nil {
+			id, _ := res.LastInsertId()
+			f.ID = int(id)
+		}
+	}
+	if err != nil {
+		return f, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM geofence_links WHERE geofence_id = ?", f.ID); err != nil {
+		return f, err
+	}
+	for _, id := range f.DeviceIDs {
+		if _, err := tx.Exec("INSERT INTO geofence_links (geofence_id, device_id) VALUES (?, ?)", f.ID, id); err != nil {
+			return f, err
+		}
+	}
+	for _, id := range f.GroupIDs {
+		if _, err := tx.Exec("INSERT INTO geofence_links (geofence_id, group_id) VALUES (?, ?)", f.ID, id); err != nil {
+			return f, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return f, err
+	}
+	return f, e.Load()
+}
+
+// Delete: Remove a cerca (eventos são apagados em cascata)
+func (e *Evaluator) Delete(id int) error {
+	if _, err := e.DB.Exec("DELETE FROM geofences WHERE id = ?", id); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	for k := range e.inside {
+		if k.fenceID == id {
+			delete(e.inside, k)
+		}
+	}
+	e.mu.Unlock()
+	return e.Load()
+}
+
+// EventFilter: Filtros da listagem de eventos. UserID = 0 lista todos os
+// dispositivos da organização OrgID, ou de todas com AllOrgs; DeviceID = 0 todos.
+type EventFilter struct {
+	UserID   int
+	OrgID    int
+	AllOrgs  bool
+	DeviceID int
+	Limit    int
+}
+
+// Events: Eventos mais recentes
+func (e *Evaluator) Events(f EventFilter) ([]Event, error) {
+	query := `SELECT ge.id, ge.geofence_id, g.name, ge.device_id, d.esn, ge.event, ge.lat, ge.lon, ge.occurred_at
+		FROM geofence_events ge JOIN geofences g ON g.id = ge.geofence_id JOIN devices d ON d.id = ge.device_id`
+	var where []string
+	var args []interface{}
+	if f.UserID != 0 {
+		query += " JOIN user_permissions up ON up.device_id = ge.device_id"
+		where = append(where, "up.user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if !f.AllOrgs {
+		where = append(where, "COALESCE(d.organization_id, 0) = ?")
+		args = append(args, f.OrgID)
+	}
+	if f.DeviceID != 0 {
+		where = append(where, "ge.device_id = ?")
+		args = append(args, f.DeviceID)
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 200
+	}
+	query += " ORDER BY ge.id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := e.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var ev Event
+		var t time.Time
+		rows.Scan(&ev.ID, &ev.FenceID, &ev.FenceName, &ev.DeviceID, &ev.ESN, &ev.Event, &ev.Lat, &ev.Lon, &t)
+		ev.OccurredAt = t.Format(timeLayout)
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,241 @@
+package geofence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// --- GEOMETRIA ---
+
+// Raio médio da Terra (metros)
+const earthRadius = 6371008.8
+
+// Tolerância (graus) para considerar um ponto sobre a borda
+const edgeEpsilon = 1e-9
+
+var ErrInvalidGeoJSON = errors.New("GeoJSON inválido")
+
+// Point: Coordenada na ordem do GeoJSON ([lon, lat])
+type Point struct {
+	Lon float64
+	Lat float64
+}
+
+// Shape: Área geográfica. Pontos sobre a borda contam como dentro.
+type Shape interface {
+	Contains(lat, lon float64) bool
+}
+
+// Polygon: Primeiro anel é o contorno externo, os demais são buracos
+type Polygon struct {
+	Rings [][]Point
+}
+
+// MultiPolygon: Vários polígonos (ex: talhões separados da mesma fazenda)
+type MultiPolygon []Polygon
+
+// Circle: Raio em metros a partir do centro
+type Circle struct {
+	Center       Point
+	RadiusMeters float64
+}
+
+func (p Polygon) Contains(lat, lon float64) bool {
+	if len(p.Rings) == 0 {
+		return false
+	}
+	if inside, _ := ringContains(p.Rings[0], lat, lon); !inside {
+		return false
+	}
+	// Dentro do buraco (mas não na borda dele) fica fora do polígono
+	for _, hole := range p.Rings[1:] {
+		if inside, edge := ringContains(hole, lat, lon); inside && !edge {
+			return false
+		}
+	}
+	return true
+}
+
+func (m MultiPolygon) Contains(lat, lon float64) bool {
+	for _, p := range m {
+		if p.Contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Circle) Contains(lat, lon float64) bool {
+	return haversine(c.Center.Lat, c.Center.Lon, lat, lon) <= c.RadiusMeters+1e-6
+}
+
+// ringContains: Ray casting sobre o anel "desenrolado". Anéis que cruzam o
+// antimeridiano (ex: 179° -> -179°) têm as longitudes ajustadas para ficarem
+// contínuas, e o ponto é testado também deslocado de ±360°.
+func ringContains(ring []Point, lat, lon float64) (inside, edge bool) {
+	pts := unwrap(ring)
+	for _, shift := range []float64{0, 360, -360} {
+		if in, e := pointInRing(pts, lon+shift, lat); in {
+			return true, e
+		}
+	}
+	return false, false
+}
+
+// unwrap: Garante que vértices consecutivos nunca distem mais de 180° em longitude
+func unwrap(ring []Point) []Point {
+	pts := make([]Point, len(ring))
+	copy(pts, ring)
+	for i := 1; i < len(pts); i++ {
+		for pts[i].Lon-pts[i-1].Lon > 180 {
+			pts[i].Lon -= 360
+		}
+		for pts[i].Lon-pts[i-1].Lon < -180 {
+			pts[i].Lon += 360
+		}
+	}
+	return pts
+}
+
+func pointInRing(pts []Point, x, y float64) (inside, edge bool) {
+	n := len(pts)
+	if n < 3 {
+		return false, false
+	}
+	j := n - 1
+	for i := 0; i < n; i++ {
+		xi, yi := pts[i].Lon, pts[i].Lat
+		xj, yj := pts[j].Lon, pts[j].Lat
+		if onSegment(xi, yi, xj, yj, x, y) {
+			return true, true
+		}
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside, false
+}
+
+func onSegment(x1, y1, x2, y2, x, y float64) bool {
+	if x < math.Min(x1, x2)-edgeEpsilon || x > math.Max(x1, x2)+edgeEpsilon ||
+		y < math.Min(y1, y2)-edgeEpsilon || y > math.Max(y1, y2)+edgeEpsilon {
+		return false
+	}
+	cross := (x-x1)*(y2-y1) - (y-y1)*(x2-x1)
+	length := math.Hypot(x2-x1, y2-y1)
+	if length == 0 {
+		return math.Hypot(x-x1, y-y1) <= edgeEpsilon
+	}
+	return math.Abs(cross)/length <= edgeEpsilon
+}
+
+// haversine: Distância em metros entre duas coordenadas
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// --- LEITURA DE GEOJSON ---
+
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Properties  struct {
+		Radius float64 `json:"radius"` // metros (círculo = Point + radius)
+	} `json:"properties"`
+}
+
+// ParseShape: Aceita Polygon, MultiPolygon ou Feature com uma dessas geometrias.
+// Círculos são representados como Feature de geometria Point com
+// properties.radius em metros.
+func ParseShape(raw []byte) (Shape, error) {
+	var g geoJSON
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+	}
+
+	radius := 0.0
+	if g.Type == "Feature" {
+		if g.Geometry == nil {
+			return nil, fmt.Errorf("%w: Feature sem geometria", ErrInvalidGeoJSON)
+		}
+		radius = g.Properties.Radius
+		g = *g.Geometry
+	}
+
+	switch g.Type {
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		return parsePolygon(coords)
+
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		multi := make(MultiPolygon, 0, len(coords))
+		for _, c := range coords {
+			p, err := parsePolygon(c)
+			if err != nil {
+				return nil, err
+			}
+			multi = append(multi, p)
+		}
+		return multi, nil
+
+	case "Point":
+		var coords []float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		center, err := parsePoint(coords)
+		if err != nil {
+			return nil, err
+		}
+		if radius <= 0 {
+			return nil, fmt.Errorf("%w: círculo exige properties.radius > 0", ErrInvalidGeoJSON)
+		}
+		return Circle{Center: center, RadiusMeters: radius}, nil
+	}
+	return nil, fmt.Errorf("%w: tipo %q não suportado", ErrInvalidGeoJSON, g.Type)
+}
+
+func parsePolygon(coords [][][]float64) (Polygon, error) {
+	if len(coords) == 0 {
+		return Polygon{}, fmt.Errorf("%w: polígono vazio", ErrInvalidGeoJSON)
+	}
+	p := Polygon{Rings: make([][]Point, 0, len(coords))}
+	for _, ring := range coords {
+		if len(ring) < 4 {
+			return Polygon{}, fmt.Errorf("%w: anel com menos de 4 posições", ErrInvalidGeoJSON)
+		}
+		pts := make([]Point, 0, len(ring))
+		for _, c := range ring {
+			pt, err := parsePoint(c)
+			if err != nil {
+				return Polygon{}, err
+			}
+			pts = append(pts, pt)
+		}
+		p.Rings = append(p.Rings, pts)
+	}
+	return p, nil
+}
+
+func parsePoint(c []float64) (Point, error) {
+	if len(c) < 2 || c[1] < -90 || c[1] > 90 || c[0] < -180 || c[0] > 180 {
+		return Point{}, fmt.Errorf("%w: posição fora do intervalo %v", ErrInvalidGeoJSON, c)
+	}
+	return Point{Lon: c[0], Lat: c[1]}, nil
+}
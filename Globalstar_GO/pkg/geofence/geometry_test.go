@@ -0,0 +1,154 @@
+package geofence
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// square: Anel fechado [lon, lat] de (lon0, lat0) a (lon1, lat1)
+func square(lon0, lat0, lon1, lat1 float64) []Point {
+	return []Point{{lon0, lat0}, {lon1, lat0}, {lon1, lat1}, {lon0, lat1}, {lon0, lat0}}
+}
+
+func TestPolygonContains(t *testing.T) {
+	// Quadrado 0..10 com buraco 3..7
+	p := Polygon{Rings: [][]Point{square(0, 0, 10, 10), square(3, 3, 7, 7)}}
+
+	cases := []struct {
+		name     string
+		lat, lon float64
+		want     bool
+	}{
+		{"dentro", 1, 1, true},
+		{"fora", 11, 5, false},
+		{"fora à esquerda", 5, -0.001, false},
+		{"sobre a borda inferior", 0, 5, true},
+		{"sobre a borda direita", 5, 10, true},
+		{"vértice", 0, 0, true},
+		{"vértice oposto", 10, 10, true},
+		{"dentro do buraco", 5, 5, false},
+		{"sobre a borda do buraco", 3, 5, true},
+		{"vértice do buraco", 7, 7, true},
+		{"entre o buraco e o contorno", 8, 8, true},
+	}
+	for _, c := range cases {
+		if got := p.Contains(c.lat, c.lon); got != c.want {
+			t.Errorf("%s: Contains(%v, %v) = %v, esperado %v", c.name, c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func TestPolygonCrossingAntimeridian(t *testing.T) {
+	// Faixa de 170°E a 170°W (20° de largura passando por ±180°)
+	ring := []Point{{170, -10}, {-170, -10}, {-170, 10}, {170, 10}, {170, -10}}
+	p := Polygon{Rings: [][]Point{ring}}
+
+	cases := []struct {
+		name     string
+		lat, lon float64
+		want     bool
+	}{
+		{"sobre 180", 0, 180, true},
+		{"sobre -180", 0, -180, true},
+		{"lado leste", 0, 175, true},
+		{"lado oeste", 0, -175, true},
+		{"borda em 170E", 0, 170, true},
+		{"borda em 170W", 5, -170, true},
+		{"fora a oeste de 170E", 0, 169, false},
+		{"fora a leste de 170W", 0, -169, false},
+		{"meridiano de Greenwich", 0, 0, false},
+		{"acima da faixa", 11, 180, false},
+	}
+	for _, c := range cases {
+		if got := p.Contains(c.lat, c.lon); got != c.want {
+			t.Errorf("%s: Contains(%v, %v) = %v, esperado %v", c.name, c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func TestPolygonDegenerateRing(t *testing.T) {
+	if (Polygon{}).Contains(0, 0) {
+		t.Error("polígono sem anéis não contém nada")
+	}
+	line := Polygon{Rings: [][]Point{{{0, 0}, {1, 1}}}}
+	if line.Contains(0, 0) {
+		t.Error("anel com menos de 3 pontos não contém nada")
+	}
+}
+
+func TestMultiPolygonContains(t *testing.T) {
+	m := MultiPolygon{
+		{Rings: [][]Point{square(0, 0, 1, 1)}},
+		{Rings: [][]Point{square(5, 5, 6, 6)}},
+	}
+	if !m.Contains(0.5, 0.5) || !m.Contains(5.5, 5.5) {
+		t.Error("ponto dentro de um dos talhões deveria estar dentro")
+	}
+	if m.Contains(3, 3) {
+		t.Error("ponto entre os talhões deveria estar fora")
+	}
+}
+
+func TestCircleContainsAtRadius(t *testing.T) {
+	c := Circle{Center: Point{Lon: -47.06, Lat: -22.9}, RadiusMeters: 1000}
+	// Deslocamento em latitude correspondente a d metros ao longo do meridiano
+	north := func(d float64) float64 { return c.Center.Lat + d/earthRadius*180/math.Pi }
+
+	cases := []struct {
+		name string
+		lat  float64
+		want bool
+	}{
+		{"centro", c.Center.Lat, true},
+		{"exatamente no raio", north(1000), true},
+		{"meio metro antes", north(999.5), true},
+		{"meio metro depois", north(1000.5), false},
+	}
+	for _, tc := range cases {
+		if got := c.Contains(tc.lat, c.Center.Lon); got != tc.want {
+			t.Errorf("%s: Contains = %v, esperado %v (distância %.3f m)", tc.name, got, tc.want,
+				haversine(c.Center.Lat, c.Center.Lon, tc.lat, c.Center.Lon))
+		}
+	}
+}
+
+func TestCircleAcrossAntimeridian(t *testing.T) {
+	c := Circle{Center: Point{Lon: 179.999, Lat: 0}, RadiusMeters: 1000}
+	// -179.999 fica a ~222 m do centro, do outro lado de ±180°
+	if !c.Contains(0, -179.999) {
+		t.Error("ponto a oeste de -180 deveria estar dentro do círculo")
+	}
+	if c.Contains(0, -179.98) {
+		t.Error("ponto a ~2,4 km deveria estar fora do círculo")
+	}
+}
+
+func TestParseShape(t *testing.T) {
+	valid := map[string]string{
+		"Polygon":      `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`,
+		"MultiPolygon": `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[5,5],[6,5],[6,6],[5,5]]]]}`,
+		"Feature":      `{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}`,
+		"Círculo":      `{"type":"Feature","geometry":{"type":"Point","coordinates":[-47,-22]},"properties":{"radius":500}}`,
+	}
+	for name, raw := range valid {
+		if _, err := ParseShape([]byte(raw)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"JSON quebrado":         `{"type":`,
+		"tipo desconhecido":     `{"type":"LineString","coordinates":[[0,0],[1,1]]}`,
+		"anel curto":            `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`,
+		"latitude fora":         `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,91],[0,0]]]}`,
+		"longitude fora":        `{"type":"Polygon","coordinates":[[[0,0],[181,0],[1,1],[0,0]]]}`,
+		"círculo sem raio":      `{"type":"Feature","geometry":{"type":"Point","coordinates":[0,0]}}`,
+		"Feature sem geometria": `{"type":"Feature"}`,
+	}
+	for name, raw := range invalid {
+		if _, err := ParseShape([]byte(raw)); !errors.Is(err, ErrInvalidGeoJSON) {
+			t.Errorf("%s: esperado ErrInvalidGeoJSON, veio %v", name, err)
+		}
+	}
+}
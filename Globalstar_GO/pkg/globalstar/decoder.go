@@ -0,0 +1,116 @@
+package globalstar
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// --- DECODIFICAÇÃO DE PAYLOAD ---
+
+// Position: Coordenada GPS extraída de uma mensagem
+type Position struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Reading: Métricas numéricas decodificadas de um payload
+type Reading struct {
+	Metrics  map[string]float64
+	Position *Position
+}
+
+// Tamanho da mensagem padrão do SmartOne C (9 bytes)
+const smartOneStandardLen = 9
+
+// DecodePayload: Converte o payload bruto recebido da Globalstar em métricas.
+// Suporta a mensagem padrão de 9 bytes do SmartOne (hexadecimal "0x...") e
+// payloads JSON simples ({"temp": 21.5, "bomba": true}) enviados por gateways.
+func DecodePayload(payload string) Reading {
+	reading := Reading{Metrics: make(map[string]float64)}
+	raw := strings.TrimSpace(payload)
+
+	if strings.HasPrefix(raw, "{") {
+		decodeJSON(raw, &reading)
+		return reading
+	}
+
+	raw = strings.TrimPrefix(strings.TrimPrefix(raw, "0x"), "0X")
+	data, err := hex.DecodeString(raw)
+	if err != nil {
+		return reading
+	}
+	if len(data) == smartOneStandardLen {
+		decodeSmartOne(data, &reading)
+	}
+	return reading
+}
+
+// decodeSmartOne: Layout da mensagem padrão
+//
+//	byte 0    bits 0-1 tipo da mensagem, bit 2 bateria fraca, bit 3 GPS inválido
+//	bytes 1-3 latitude  (inteiro 24 bits com sinal, graus = valor * 90 / 2^23)
+//	bytes 4-6 longitude (inteiro 24 bits com sinal, graus = valor * 180 / 2^23)
+//	byte 7    bit 0 entrada 1 mudou, bit 1 estado entrada 1,
+//	          bit 2 entrada 2 mudou, bit 3 estado entrada 2, bits 4-7 subtipo
+//	byte 8    reservado
+func decodeSmartOne(data []byte, reading *Reading) {
+	flags := data[0]
+	inputs := data[7]
+
+	reading.Metrics["msg_type"] = float64(flags & 0x03)
+	reading.Metrics["battery_low"] = bit(flags, 2)
+	reading.Metrics["gps_valid"] = 1 - bit(flags, 3)
+	reading.Metrics["input1_changed"] = bit(inputs, 0)
+	reading.Metrics["input1"] = bit(inputs, 1)
+	reading.Metrics["input2_changed"] = bit(inputs, 2)
+	reading.Metrics["input2"] = bit(inputs, 3)
+	reading.Metrics["subtype"] = float64(inputs >> 4)
+
+	if reading.Metrics["gps_valid"] == 1 {
+		lat := float64(int24(data[1:4])) * 90 / (1 << 23)
+		lon := float64(int24(data[4:7])) * 180 / (1 << 23)
+		reading.Metrics["lat"] = lat
+		reading.Metrics["lon"] = lon
+		reading.Position = &Position{Lat: lat, Lon: lon}
+	}
+}
+
+// decodeJSON: Aceita números e booleanos; "lat"/"lon" viram posição
+func decodeJSON(raw string, reading *Reading) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return
+	}
+	for k, v := range values {
+		switch val := v.(type) {
+		case float64:
+			reading.Metrics[k] = val
+		case bool:
+			if val {
+				reading.Metrics[k] = 1
+			} else {
+				reading.Metrics[k] = 0
+			}
+		}
+	}
+
+	lat, okLat := reading.Metrics["lat"]
+	lon, okLon := reading.Metrics["lon"]
+	if okLat && okLon {
+		reading.Position = &Position{Lat: lat, Lon: lon}
+	}
+}
+
+func bit(b byte, n uint) float64 {
+	return float64((b >> n) & 1)
+}
+
+// int24: Inteiro big-endian de 24 bits com sinal
+func int24(b []byte) int32 {
+	v := int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	if v&0x800000 != 0 {
+		v -= 1 << 24
+	}
+	return v
+}
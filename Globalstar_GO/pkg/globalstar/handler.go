@@ -24,6 +24,26 @@ type Service struct {
 	CacheMutex  sync.RWMutex
 	// Canal para enviar atualizações em tempo real (apenas escrita)
 	Broadcast chan<- interface{}
+	// Etapas executadas após gravar cada mensagem (alertas, geofences...)
+	Processors []Processor
+	// Chamado (em goroutine própria) quando um ESN desconhecido é cadastrado
+	OnNewDevice func(deviceID int)
+}
+
+// Event: Mensagem já persistida, entregue aos processadores do pipeline
+type Event struct {
+	MessageID  int64
+	DeviceID   int
+	ESN        string
+	Payload    string
+	ReceivedAt time.Time
+	Reading    Reading
+}
+
+// Processor: Etapa extra do pipeline de ingestão. Process é chamado
+// inline pelo worker, então não deve bloquear por muito tempo.
+type Processor interface {
+	Process(ev Event)
 }
 
 // Construtor: Cria uma nova instância do serviço
@@ -37,6 +57,11 @@ func NewService(db *sql.DB, broadcast chan<- interface{}) *Service {
 
 // --- MÉTODOS DO SERVIÇO ---
 
+// Use: Registra um processador no pipeline (chamar antes de receber tráfego)
+func (s *Service) Use(p Processor) {
+	s.Processors = append(s.Processors, p)
+}
+
 // 1. GetDeviceID
 func (s *Service) getDeviceID(esn string) (int, error) {
 	s.CacheMutex.RLock()
@@ -69,6 +94,9 @@ func (s *Service) getDeviceID(esn string) (int, error) {
 	lid, _ := res.LastInsertId()
 	id = int(lid)
 	s.DeviceCache[esn] = id
+	if s.OnNewDevice != nil {
+		go s.OnNewDevice(id)
+	}
 	return id, nil
 }
 
@@ -93,27 +121,41 @@ func (s *Service) worker(id int, jobs <-chan StuMessage, wg *sync.WaitGroup) {
 
 		// 1. Executa a inserção no banco
 		now := time.Now()
-		_, err = stmt.Exec(deviceID, msg.Payload, now)
+		res, err := stmt.Exec(deviceID, msg.Payload, now)
 		if err != nil {
 			log.Printf("Erro ao salvar mensagem: %v", err)
 			continue
 		}
+		messageID, _ := res.LastInsertId()
 
 		// 2. Envia para o WebSocket (Tempo Real) se o canal estiver disponível
 		if s.Broadcast != nil {
 			updateMsg := map[string]interface{}{
 				"type":        "NEW_MESSAGE",
-				"id":          0,
+				"id":          messageID,
 				"esn":         msg.ESN,
 				"payload":     msg.Payload,
 				"received_at": now.Format("02/01/2006 15:04:05"),
 				"device_id":   deviceID,
 			}
 
-			// Envia sem bloquear
-			select {
-			case s.Broadcast <- updateMsg:
-			default:
+			// Canal com buffer: bloqueia só se o hub estiver muito atrasado,
+			// em vez de descartar a leitura
+			s.Broadcast <- updateMsg
+		}
+
+		// 3. Processadores do pipeline (regras de alerta etc.)
+		if len(s.Processors) > 0 {
+			ev := Event{
+				MessageID:  messageID,
+				DeviceID:   deviceID,
+				ESN:        msg.ESN,
+				Payload:    msg.Payload,
+				ReceivedAt: now,
+				Reading:    DecodePayload(msg.Payload),
+			}
+			for _, p := range s.Processors {
+				p.Process(ev)
 			}
 		}
 	}
@@ -0,0 +1,120 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// --- PROTEÇÃO CONTRA SSRF ---
+// URLs cadastradas por usuários (webhooks, emissores OIDC) não podem fazer o
+// backend chamar serviços internos (127.0.0.1, rede privada, 169.254.169.254).
+// O endereço é conferido no cadastro e de novo na conexão (o DNS pode mudar).
+
+var ErrPrivateAddress = errors.New("endereço de rede interna não permitido")
+
+// Faixas fora da internet pública além das que net.IP já reconhece
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",      // "esta rede"
+	"100.64.0.0/10",  // CGNAT
+	"192.0.0.0/24",   // atribuições do IETF
+	"198.18.0.0/15",  // testes de desempenho
+	"240.0.0.0/4",    // reservado
+	"64:ff9b:1::/48", // tradução local IPv4/IPv6
+	"2001:db8::/32",  // documentação
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// PublicIP: Falso para loopback, redes privadas (RFC 1918, fc00::/7), link-local,
+// multicast, não especificado e as faixas reservadas acima
+func PublicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicOrLoopbackIP: PublicIP mais loopback (IdP de teste em desenvolvimento)
+func PublicOrLoopbackIP(ip net.IP) bool {
+	return ip.IsLoopback() || PublicIP(ip)
+}
+
+// CheckHost: O host (nome ou IP) resolve só para endereços públicos?
+func CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("host não encontrado: %s", host)
+	}
+	for _, a := range addrs {
+		if !PublicIP(a.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// allowOnly: Recusa a conexão se o IP já resolvido não passar no filtro
+func allowOnly(allow func(net.IP) bool) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !allow(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+}
+
+// PublicHTTPClient: Cliente que só conecta em endereços públicos (vale também
+// para redirecionamentos) e ignora HTTP_PROXY, que contornaria o filtro
+func PublicHTTPClient(timeout time.Duration) *http.Client {
+	return HTTPClient(timeout, PublicIP)
+}
+
+// HTTPClient: Como PublicHTTPClient, com o filtro de endereços informado
+func HTTPClient(timeout time.Duration, allow func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: allowOnly(allow)}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	cases := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"200.160.2.3", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.0.10", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, c := range cases {
+		if got := PublicIP(net.ParseIP(c.ip)); got != c.public {
+			t.Errorf("PublicIP(%s) = %v, esperado %v", c.ip, got, c.public)
+		}
+	}
+}
+
+func TestDialChecksResolvedAddress(t *testing.T) {
+	// Nome (e não IP) na URL: a checagem na conexão vale para o endereço resolvido,
+	// o que cobre DNS que muda depois do cadastro e redirecionamentos
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a requisição chegou ao servidor interno")
+	}))
+	defer srv.Close()
+
+	target := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	if _, err := PublicHTTPClient(0).Do(req); !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("esperava ErrPrivateAddress, veio %v", err)
+	}
+}
+
+func TestLoopbackClientForDevelopment(t *testing.T) {
+	// IdP de teste local: o filtro com loopback conecta, mas segue recusando a rede privada
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if _, err := HTTPClient(0, PublicOrLoopbackIP).Get(srv.URL); err != nil {
+		t.Fatalf("loopback recusado: %v", err)
+	}
+	if PublicOrLoopbackIP(net.ParseIP("10.1.2.3")) || PublicOrLoopbackIP(net.ParseIP("169.254.169.254")) {
+		t.Fatal("rede privada aceita")
+	}
+}
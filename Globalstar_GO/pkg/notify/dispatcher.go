@@ -0,0 +1,380 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Situação de cada envio no notification_log
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// Formato de data usado nas respostas da API
+const timeLayout = "02/01/2006 15:04:05"
+
+// Notification: Pedido de envio (o template é renderizado no Enqueue)
+type Notification struct {
+	UserID   int
+	Channel  string
+	To       string
+	Template string
+	Data     map[string]interface{}
+}
+
+// Contact: Preferência de contato de um usuário para um canal
+type Contact struct {
+	Channel     string `json:"channel"`
+	Address     string `json:"address"`
+	Enabled     bool   `json:"enabled"`
+	MinSeverity string `json:"min_severity"`
+}
+
+// LogEntry: Linha do histórico de envios
+type LogEntry struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id,omitempty"`
+	Channel   string `json:"channel"`
+	Address   string `json:"address"`
+	Template  string `json:"template"`
+	Subject   string `json:"subject"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	CreatedAt string `json:"created_at"`
+	SentAt    string `json:"sent_at,omitempty"`
+}
+
+type job struct {
+	logID    int64
+	attempts int
+	channel  Channel
+	msg      Message
+}
+
+// --- DESPACHANTE ---
+
+// Dispatcher: Renderiza templates, registra no notification_log e entrega
+// pelos canais em segundo plano. Quem chama nunca espera pelo envio: a fila em
+// memória só acelera; o agendamento vale pelo banco (next_attempt_at), de onde
+// saem as novas tentativas (backoff exponencial) e o que não coube na fila.
+type Dispatcher struct {
+	DB           *sql.DB
+	Channels     map[string]Channel
+	MaxAttempts  int
+	Backoff      time.Duration
+	Timeout      time.Duration
+	PollInterval time.Duration
+
+	queue chan job
+}
+
+// Construtor: Sem canais registrados; use Register para cada canal configurado
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:           db,
+		Channels:     make(map[string]Channel),
+		MaxAttempts:  5,
+		Backoff:      2 * time.Second,
+		Timeout:      15 * time.Second,
+		PollInterval: 5 * time.Second,
+		queue:        make(chan job, 256),
+	}
+}
+
+// Register: Disponibiliza um canal
+func (d *Dispatcher) Register(c Channel) {
+	d.Channels[c.Name()] = c
+}
+
+// Has: Indica se o canal está configurado
+func (d *Dispatcher) Has(channel string) bool {
+	_, ok := d.Channels[channel]
+	return ok
+}
+
+// Start: Sobe os workers e o laço que busca no banco os envios vencidos
+// (novas tentativas, pendentes de antes de reiniciar e os que não couberam na fila)
+func (d *Dispatcher) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	go func() {
+		for {
+			d.Poll(time.Now())
+			time.Sleep(d.PollInterval)
+		}
+	}()
+}
+
+// lease: Tempo em que um envio reservado fica fora do Poll (cobre o timeout do canal)
+func (d *Dispatcher) lease() time.Duration {
+	return 2 * d.Timeout
+}
+
+// Enqueue: Renderiza o template, grava como pendente e agenda o envio (não bloqueia)
+func (d *Dispatcher) Enqueue(n Notification) error {
+	channel, ok := d.Channels[n.Channel]
+	if !ok {
+		return ErrUnknownChannel
+	}
+	tpl, err := d.Template(n.Template)
+	if err != nil {
+		return err
+	}
+	subject, body, err := tpl.render(n.Data)
+	if err != nil {
+		return err
+	}
+	msg := Message{To: n.To, Subject: subject, Body: body, HTML: tpl.HTML, Data: n.Data}
+
+	var userID interface{}
+	if n.UserID != 0 {
+		userID = n.UserID
+	}
+	payload, _ := json.Marshal(n.Data)
+	// Já nasce reservado para a fila; se não couber, o Poll pega quando a reserva vencer
+	res, err := d.DB.Exec(`INSERT INTO notification_log (user_id, channel, address, template, subject, body, is_html, payload, status, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, userID, n.Channel, n.To, n.Template, subject, body, tpl.HTML, string(payload),
+		StatusPending, time.Now().Add(d.lease()))
+	if err != nil {
+		return err
+	}
+	logID, _ := res.LastInsertId()
+
+	select {
+	case d.queue <- job{logID: logID, channel: channel, msg: msg}:
+	default:
+		log.Printf("Aviso: Fila de notificações cheia; envio %d fica para o agendador", logID)
+	}
+	return nil
+}
+
+// Poll: Reserva e põe na fila os envios vencidos até now (das últimas 24h),
+// só enquanto houver espaço. A reserva condicional evita envio duplo entre réplicas.
+func (d *Dispatcher) Poll(now time.Time) int {
+	free := cap(d.queue) - len(d.queue)
+	if free <= 0 {
+		return 0
+	}
+	rows, err := d.DB.Query(`SELECT id, channel, address, subject, body, is_html, COALESCE(payload, ''), attempts
+		FROM notification_log WHERE status = ? AND COALESCE(next_attempt_at, created_at) <= ? AND created_at > ?
+		ORDER BY COALESCE(next_attempt_at, created_at) LIMIT ?`, StatusPending, now, now.Add(-24*time.Hour), free)
+	if err != nil {
+		log.Printf("Aviso: Falha ao buscar notificações pendentes: %v", err)
+		return 0
+	}
+	var due []job
+	for rows.Next() {
+		var j job
+		var channel, payload string
+		rows.Scan(&j.logID, &channel, &j.msg.To, &j.msg.Subject, &j.msg.Body, &j.msg.HTML, &payload, &j.attempts)
+		if payload != "" {
+			json.Unmarshal([]byte(payload), &j.msg.Data)
+		}
+		if c, ok := d.Channels[channel]; ok {
+			j.channel = c
+			due = append(due, j)
+		}
+	}
+	rows.Close()
+
+	queued := 0
+	for _, j := range due {
+		res, err := d.DB.Exec(`UPDATE notification_log SET next_attempt_at = ?
+			WHERE id = ? AND status = ? AND COALESCE(next_attempt_at, created_at) <= ?`, now.Add(d.lease()), j.logID, StatusPending, now)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // outra réplica reservou antes
+		}
+		select {
+		case d.queue <- j:
+			queued++
+		default:
+			// Encheu entre a contagem e agora: devolve para o próximo Poll
+			d.DB.Exec("UPDATE notification_log SET next_attempt_at = ? WHERE id = ?", now, j.logID)
+		}
+	}
+	return queued
+}
+
+// worker: Uma tentativa por vez; a falha reagenda no banco em vez de dormir aqui
+func (d *Dispatcher) worker() {
+	for j := range d.queue {
+		d.deliver(j)
+	}
+}
+
+// deliver: Envia e registra o resultado. Após MaxAttempts falhas o envio é
+// marcado como failed; antes disso volta a pending com Backoff * 2^(tentativas-1).
+func (d *Dispatcher) deliver(j job) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	err := j.channel.Send(ctx, j.msg)
+	cancel()
+
+	attempt := j.attempts + 1
+	if err == nil {
+		d.DB.Exec("UPDATE notification_log SET status = ?, attempts = ?, last_error = NULL, next_attempt_at = NULL, sent_at = ? WHERE id = ?",
+			StatusSent, attempt, time.Now(), j.logID)
+		return
+	}
+
+	log.Printf("Aviso: Falha ao enviar notificação %d via %s (tentativa %d): %v", j.logID, j.channel.Name(), attempt, err)
+	if attempt >= d.MaxAttempts {
+		d.DB.Exec("UPDATE notification_log SET status = ?, attempts = ?, last_error = ?, next_attempt_at = NULL WHERE id = ?",
+			StatusFailed, attempt, err.Error(), j.logID)
+		return
+	}
+	d.DB.Exec("UPDATE notification_log SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		StatusPending, attempt, err.Error(), time.Now().Add(d.retryDelay(attempt)), j.logID)
+}
+
+// retryDelay: Backoff dobra a cada falha
+func (d *Dispatcher) retryDelay(attempt int) time.Duration {
+	return d.Backoff << uint(attempt-1)
+}
+
+// Template: Versão do banco (se houver) ou o template padrão
+func (d *Dispatcher) Template(name string) (Template, error) {
+	t := Template{Name: name}
+	err := d.DB.QueryRow("SELECT subject, body, is_html FROM notification_templates WHERE name = ?", name).Scan(&t.Subject, &t.Body, &t.HTML)
+	if err == nil {
+		return t, nil
+	}
+	if def, ok := DefaultTemplates[name]; ok {
+		return def, nil
+	}
+	return t, ErrUnknownTemplate
+}
+
+// Templates: Padrões mesclados com as versões personalizadas
+func (d *Dispatcher) Templates() ([]Template, error) {
+	merged := make(map[string]Template)
+	for k, t := range DefaultTemplates {
+		merged[k] = t
+	}
+	rows, err := d.DB.Query("SELECT name, subject, body, is_html FROM notification_templates")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Template
+		rows.Scan(&t.Name, &t.Subject, &t.Body, &t.HTML)
+		merged[t.Name] = t
+	}
+
+	list := make([]Template, 0, len(merged))
+	for _, t := range merged {
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// SaveTemplate: Valida e grava uma versão personalizada do template
+func (d *Dispatcher) SaveTemplate(t Template) error {
+	if _, _, err := t.render(map[string]interface{}{}); err != nil {
+		return err
+	}
+	_, err := d.DB.Exec(`INSERT INTO notification_templates (name, subject, body, is_html) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE subject = VALUES(subject), body = VALUES(body), is_html = VALUES(is_html)`,
+		t.Name, t.Subject, t.Body, t.HTML)
+	return err
+}
+
+// --- PREFERÊNCIAS DE CONTATO ---
+
+// Contacts: Preferências do usuário. Sem nenhuma cadastrada, usa o e-mail do perfil.
+func (d *Dispatcher) Contacts(userID int) ([]Contact, error) {
+	rows, err := d.DB.Query("SELECT channel, address, enabled, min_severity FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := make([]Contact, 0)
+	for rows.Next() {
+		var c Contact
+		rows.Scan(&c.Channel, &c.Address, &c.Enabled, &c.MinSeverity)
+		contacts = append(contacts, c)
+	}
+	if len(contacts) > 0 {
+		return contacts, rows.Err()
+	}
+
+	var email sql.NullString
+	d.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email)
+	if email.String != "" {
+		contacts = append(contacts, Contact{Channel: ChannelEmail, Address: email.String, Enabled: true, MinSeverity: "warning"})
+	}
+	return contacts, nil
+}
+
+// SetContacts: Substitui as preferências do usuário
+func (d *Dispatcher) SetContacts(userID int, contacts []Contact) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM notification_preferences WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		if c.MinSeverity == "" {
+			c.MinSeverity = "warning"
+		}
+		if _, err := tx.Exec("INSERT INTO notification_preferences (user_id, channel, address, enabled, min_severity) VALUES (?, ?, ?, ?, ?)",
+			userID, c.Channel, c.Address, c.Enabled, c.MinSeverity); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// --- HISTÓRICO ---
+
+// LogFilter: Envios para usuários da organização OrgID, ou todos com AllOrgs
+// (envios sem usuário, como webhooks do sistema, só aparecem com AllOrgs)
+type LogFilter struct {
+	OrgID   int
+	AllOrgs bool
+	Limit   int
+}
+
+// Log: Últimos envios registrados
+func (d *Dispatcher) Log(f LogFilter) ([]LogEntry, error) {
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+	rows, err := d.DB.Query(`SELECT n.id, COALESCE(n.user_id, 0), n.channel, n.address, n.template, n.subject, n.status, n.attempts,
+		COALESCE(n.last_error, ''), n.created_at, n.sent_at FROM notification_log n LEFT JOIN users u ON u.id = n.user_id
+		WHERE ? OR (u.id IS NOT NULL AND COALESCE(u.organization_id, 0) = ?) ORDER BY n.id DESC LIMIT ?`, f.AllOrgs, f.OrgID, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]LogEntry, 0)
+	for rows.Next() {
+		var e LogEntry
+		var createdAt time.Time
+		var sentAt sql.NullTime
+		rows.Scan(&e.ID, &e.UserID, &e.Channel, &e.Address, &e.Template, &e.Subject, &e.Status, &e.Attempts,
+			&e.LastError, &createdAt, &sentAt)
+		e.CreatedAt = createdAt.Format(timeLayout)
+		if sentAt.Valid {
+			e.SentAt = sentAt.Time.Format(timeLayout)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeChannel: Canal que devolve o erro configurado e registra as mensagens
+type fakeChannel struct {
+	err  error
+	sent chan Message
+}
+
+func (c *fakeChannel) Name() string { return "fake" }
+
+func (c *fakeChannel) Send(ctx context.Context, msg Message) error {
+	if c.sent != nil {
+		c.sent <- msg
+	}
+	return c.err
+}
+
+// around: Casa um time.Time a até 2s de want (horário calculado dentro do despachante)
+type around struct{ want time.Time }
+
+func (a around) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	d := t.Sub(a.want)
+	return d > -2*time.Second && d < 2*time.Second
+}
+
+func newMockDispatcher(t *testing.T) (*Dispatcher, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewDispatcher(db), mock
+}
+
+func TestEnqueueDoesNotBlockWhenQueueIsFull(t *testing.T) {
+	d, mock := newMockDispatcher(t)
+	d.queue = make(chan job, 1)
+	d.queue <- job{} // fila cheia, nenhum worker rodando
+	d.Register(&fakeChannel{})
+
+	mock.ExpectQuery("SELECT subject, body, is_html FROM notification_templates").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO notification_log").
+		WithArgs(nil, "fake", "ana@exemplo.com", "password_reset", sqlmock.AnyArg(), sqlmock.AnyArg(), true, sqlmock.AnyArg(),
+			StatusPending, around{time.Now().Add(d.lease())}).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Enqueue(Notification{Channel: "fake", To: "ana@exemplo.com", Template: "password_reset",
+			Data: map[string]interface{}{"Link": "http://x"}})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Enqueue bloqueou com a fila cheia")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliverFailureSchedulesRetryInDB(t *testing.T) {
+	d, mock := newMockDispatcher(t)
+	d.Backoff = time.Minute
+
+	// Terceira falha: pending de novo, próxima tentativa em Backoff * 2^2
+	mock.ExpectExec("UPDATE notification_log SET status = \\?, attempts = \\?, last_error = \\?, next_attempt_at = \\? WHERE id = \\?").
+		WithArgs(StatusPending, 3, "smtp fora do ar", around{time.Now().Add(4 * time.Minute)}, int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	start := time.Now()
+	d.deliver(job{logID: 9, attempts: 2, channel: &fakeChannel{err: errors.New("smtp fora do ar")}})
+	if time.Since(start) > time.Second {
+		t.Fatal("deliver esperou o backoff em vez de reagendar")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliverGivesUpAfterMaxAttempts(t *testing.T) {
+	d, mock := newMockDispatcher(t)
+	mock.ExpectExec("UPDATE notification_log SET status = \\?, attempts = \\?, last_error = \\?, next_attempt_at = NULL WHERE id = \\?").
+		WithArgs(StatusFailed, d.MaxAttempts, "recusado", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	d.deliver(job{logID: 3, attempts: d.MaxAttempts - 1, channel: &fakeChannel{err: errors.New("recusado")}})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollClaimsDueRowsOnce(t *testing.T) {
+	d, mock := newMockDispatcher(t)
+	d.Register(&fakeChannel{})
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT id, channel, address, subject, body, is_html, COALESCE\\(payload, ''\\), attempts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "channel", "address", "subject", "body", "is_html", "payload", "attempts"}).
+			AddRow(1, "fake", "a@x", "s", "b", false, `{"k":"v"}`, 2).
+			AddRow(2, "fake", "b@x", "s", "b", false, "", 0).
+			AddRow(3, "desconhecido", "c@x", "s", "b", false, "", 0))
+	// Linha 1 reservada aqui; linha 2 já reservada por outra réplica; canal da 3 não existe
+	mock.ExpectExec("UPDATE notification_log SET next_attempt_at").
+		WithArgs(around{now.Add(d.lease())}, int64(1), StatusPending, now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE notification_log SET next_attempt_at").
+		WithArgs(around{now.Add(d.lease())}, int64(2), StatusPending, now).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if n := d.Poll(now); n != 1 {
+		t.Fatalf("Poll enfileirou %d, esperado 1", n)
+	}
+	j := <-d.queue
+	if j.logID != 1 || j.attempts != 2 || j.msg.To != "a@x" || j.msg.Data["k"] != "v" {
+		t.Fatalf("job inesperado: %+v", j)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnqueueDeliversThroughFakeSMTP(t *testing.T) {
+	host, port, mails := fakeSMTP(t)
+	d, mock := newMockDispatcher(t)
+	d.Register(&SMTPChannel{Host: host, Port: port, From: "noreply@exemplo.com"})
+
+	mock.ExpectQuery("SELECT subject, body, is_html FROM notification_templates").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO notification_log").WillReturnResult(sqlmock.NewResult(5, 1))
+	sent := make(chan struct{})
+	mock.ExpectExec("UPDATE notification_log SET status = \\?, attempts = \\?, last_error = NULL").
+		WithArgs(StatusSent, 1, sqlmock.AnyArg(), int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	go func() {
+		d.deliver(<-d.queue)
+		close(sent)
+	}()
+	err := d.Enqueue(Notification{Channel: ChannelEmail, To: "ana@exemplo.com", Template: "password_reset",
+		Data: map[string]interface{}{"Link": "https://app/reset?token=abc"}})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	select {
+	case raw := <-mails:
+		if !strings.Contains(raw, "https://app/reset?token=abc") {
+			t.Errorf("link ausente no e-mail:\n%s", raw)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("e-mail não chegou ao servidor SMTP")
+	}
+	<-sent
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"iot_modulo1.0/pkg/netguard"
+)
+
+// --- PROTEÇÃO CONTRA SSRF ---
+// A URL do webhook é cadastrada pelo usuário: o filtro de endereços
+// (pkg/netguard) vale no cadastro e de novo na conexão.
+
+// CheckWebhookURL: http(s) com host que resolve só para endereços públicos
+func CheckWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("URL de webhook inválida")
+	}
+	if u.User != nil {
+		return fmt.Errorf("URL de webhook não pode conter credenciais")
+	}
+	return netguard.CheckHost(ctx, u.Hostname())
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"iot_modulo1.0/pkg/netguard"
+)
+
+// Cliente padrão dos canais HTTP (o gateway de SMS é configurado pelo operador)
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhooks apontam para URLs de usuários: só endereços públicos (pkg/netguard)
+var webhookHTTPClient = netguard.PublicHTTPClient(10 * time.Second)
+
+// --- WEBHOOK GENÉRICO ---
+
+// WebhookChannel: POST JSON para a URL cadastrada pelo usuário, assinado com
+// HMAC-SHA256. O receptor valida com:
+//
+//	X-Signature: sha256=hex(HMAC(secret, X-Timestamp + "." + corpo))
+//
+// Sem Client, usa um cliente que recusa endereços internos.
+type WebhookChannel struct {
+	Secret []byte
+	Client *http.Client
+}
+
+func (c *WebhookChannel) Name() string { return ChannelWebhook }
+
+func (c *WebhookChannel) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"subject": msg.Subject,
+		"message": msg.Body,
+		"data":    msg.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.To, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", "sha256="+Sign(c.Secret, ts, body))
+
+	client := c.Client
+	if client == nil {
+		client = webhookHTTPClient
+	}
+	return doRequest(client, req)
+}
+
+// Sign: Assinatura HMAC-SHA256 de "timestamp.corpo" em hexadecimal
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// --- GATEWAY DE SMS ---
+
+// SMSGatewayChannel: Adaptador para gateways de SMS com API HTTP.
+// Envia {"to": "+55...", "message": "..."} com token Bearer.
+type SMSGatewayChannel struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (c *SMSGatewayChannel) Name() string { return ChannelSMS }
+
+func (c *SMSGatewayChannel) Send(ctx context.Context, msg Message) error {
+	text := msg.Body
+	if msg.Subject != "" {
+		text = msg.Subject + ": " + msg.Body
+	}
+	body, err := json.Marshal(map[string]string{"to": msg.To, "message": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return doRequest(c.Client, req)
+}
+
+// doRequest: Qualquer resposta fora de 2xx é tratada como falha (e será reenviada)
+func doRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(snippet))
+	}
+	return nil
+}
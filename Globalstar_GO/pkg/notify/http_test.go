@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"iot_modulo1.0/pkg/netguard"
+)
+
+func TestWebhookChannelSignsPayload(t *testing.T) {
+	secret := []byte("segredo")
+	var got struct {
+		Subject string                 `json:"subject"`
+		Message string                 `json:"message"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + Sign(secret, r.Header.Get("X-Timestamp"), body)
+		if r.Header.Get("X-Signature") != want {
+			http.Error(w, "assinatura inválida", http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// Cliente do teste: o padrão recusa 127.0.0.1
+	c := &WebhookChannel{Secret: secret, Client: srv.Client()}
+	msg := Message{To: srv.URL, Subject: "Alerta", Body: "Bomba parada", Data: map[string]interface{}{"esn": "0-123"}}
+	if err := c.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got.Subject != "Alerta" || got.Message != "Bomba parada" || got.Data["esn"] != "0-123" {
+		t.Fatalf("payload inesperado: %+v", got)
+	}
+}
+
+func TestWebhookChannelNon2xxIsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fora do ar", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := &WebhookChannel{Secret: []byte("s"), Client: srv.Client()}
+	err := c.Send(context.Background(), Message{To: srv.URL})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+		t.Fatalf("esperava erro HTTP 503, veio %v", err)
+	}
+}
+
+func TestWebhookChannelDefaultClientRefusesLoopback(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	c := &WebhookChannel{Secret: []byte("s")}
+	err := c.Send(context.Background(), Message{To: srv.URL})
+	if !errors.Is(err, netguard.ErrPrivateAddress) {
+		t.Fatalf("esperava ErrPrivateAddress, veio %v", err)
+	}
+	if called {
+		t.Fatal("a requisição chegou ao servidor interno")
+	}
+}
+
+func TestSMSGatewayChannel(t *testing.T) {
+	var auth string
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	c := &SMSGatewayChannel{URL: srv.URL, Token: "tk", Client: srv.Client()}
+	if err := c.Send(context.Background(), Message{To: "+5511999999999", Subject: "Alerta", Body: "Bomba parada"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if auth != "Bearer tk" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if got["to"] != "+5511999999999" || got["message"] != "Alerta: Bomba parada" {
+		t.Errorf("corpo inesperado: %v", got)
+	}
+}
@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"context"
+	"errors"
+)
+
+// --- CANAIS DE NOTIFICAÇÃO ---
+
+// Nomes dos canais suportados (também usados em notification_preferences)
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelSMS     = "sms"
+)
+
+var (
+	ErrUnknownChannel  = errors.New("canal de notificação não configurado")
+	ErrUnknownTemplate = errors.New("template de notificação não encontrado")
+)
+
+// Message: Conteúdo já renderizado, pronto para envio
+type Message struct {
+	To      string                 // e-mail, URL do webhook ou telefone
+	Subject string                 // assunto (e-mail) / título (webhook)
+	Body    string                 // texto ou HTML
+	HTML    bool                   // corpo em HTML (apenas e-mail)
+	Data    map[string]interface{} // dados estruturados do evento (webhook)
+}
+
+// Channel: Meio de entrega de uma mensagem
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
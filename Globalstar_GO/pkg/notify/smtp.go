@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// SMTPChannel: Envio de e-mail via servidor SMTP
+type SMTPChannel struct {
+	Host string
+	Port string
+	User string
+	Pass string
+	From string
+}
+
+// SMTPFromEnv: Lê SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASS e SMTP_FROM.
+// Retorna nil se o SMTP não estiver configurado.
+func SMTPFromEnv() *SMTPChannel {
+	c := &SMTPChannel{
+		Host: os.Getenv("SMTP_HOST"),
+		Port: os.Getenv("SMTP_PORT"),
+		User: os.Getenv("SMTP_USER"),
+		Pass: os.Getenv("SMTP_PASS"),
+		From: os.Getenv("SMTP_FROM"),
+	}
+	if c.Host == "" || c.Port == "" {
+		return nil
+	}
+	return c
+}
+
+func (c *SMTPChannel) Name() string { return ChannelEmail }
+
+func (c *SMTPChannel) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("cabeçalho de e-mail inválido")
+	}
+
+	// Sem usuário configurado (ex: relay interno ou servidor de teste) não autentica
+	var auth smtp.Auth
+	if c.User != "" {
+		auth = smtp.PlainAuth("", c.User, c.Pass, c.Host)
+	}
+
+	contentType := "text/plain"
+	if msg.HTML {
+		contentType = "text/html"
+	}
+	raw := "From: " + c.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-version: 1.0\r\n" +
+		"Content-Type: " + contentType + "; charset=\"UTF-8\"\r\n\r\n" +
+		msg.Body
+
+	// smtp.SendMail não aceita contexto: roda em goroutine e respeita o cancelamento
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(c.Host, c.Port), auth, c.From, []string{msg.To}, []byte(raw))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP: Servidor SMTP mínimo (sem TLS nem AUTH) que entrega cada DATA recebido no canal
+func fakeSMTP(t *testing.T) (host, port string, mails <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	out := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, out)
+		}
+	}()
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port, out
+}
+
+func serveSMTP(conn net.Conn, out chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"), strings.HasPrefix(cmd, "RSET"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 fim com <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			out <- data.String()
+			reply("250 OK: na fila")
+		case cmd == "QUIT":
+			reply("221 tchau")
+			return
+		default:
+			reply("502 não implementado")
+		}
+	}
+}
+
+func TestSMTPChannelSend(t *testing.T) {
+	host, port, mails := fakeSMTP(t)
+	c := &SMTPChannel{Host: host, Port: port, From: "noreply@exemplo.com"}
+
+	msg := Message{To: "ana@exemplo.com", Subject: "Alerta", Body: "<b>Bomba parada</b>", HTML: true}
+	if err := c.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	select {
+	case raw := <-mails:
+		for _, want := range []string{
+			"From: noreply@exemplo.com\r\n",
+			"To: ana@exemplo.com\r\n",
+			"Subject: Alerta\r\n",
+			"Content-Type: text/html; charset=\"UTF-8\"",
+			"<b>Bomba parada</b>",
+		} {
+			if !strings.Contains(raw, want) {
+				t.Errorf("mensagem sem %q:\n%s", want, raw)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("servidor SMTP não recebeu a mensagem")
+	}
+}
+
+func TestSMTPChannelRejectsHeaderInjection(t *testing.T) {
+	host, port, mails := fakeSMTP(t)
+	c := &SMTPChannel{Host: host, Port: port, From: "noreply@exemplo.com"}
+
+	for _, msg := range []Message{
+		{To: "ana@exemplo.com\r\nBcc: todos@exemplo.com", Subject: "x", Body: "x"},
+		{To: "ana@exemplo.com", Subject: "x\r\nBcc: todos@exemplo.com", Body: "x"},
+	} {
+		if err := c.Send(context.Background(), msg); err == nil {
+			t.Errorf("cabeçalho com quebra de linha aceito: %+v", msg)
+		}
+	}
+	select {
+	case raw := <-mails:
+		t.Fatalf("mensagem inválida chegou ao servidor:\n%s", raw)
+	default:
+	}
+}
+
+func TestSMTPChannelServerDown(t *testing.T) {
+	// Porta fechada: erro devolvido para o despachante reagendar
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	c := &SMTPChannel{Host: host, Port: port, From: "noreply@exemplo.com"}
+	if err := c.Send(context.Background(), Message{To: "ana@exemplo.com", Subject: "x", Body: "x"}); err == nil {
+		t.Fatal("esperava erro com o servidor fora do ar")
+	}
+}
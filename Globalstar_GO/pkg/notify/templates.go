@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+)
+
+// --- TEMPLATES ---
+
+// Template: Assunto e corpo no formato text/template (html/template se HTML)
+type Template struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	HTML    bool   `json:"html"`
+}
+
+// Templates padrão. Podem ser sobrescritos pela tabela notification_templates.
+var DefaultTemplates = map[string]Template{
+	"password_reset": {
+		Name:    "password_reset",
+		Subject: "Redefinir sua senha - Data Frontier",
+		HTML:    true,
+		Body: `<html><body>
+			<h2>Data Frontier - Recuperação de Senha</h2>
+			<p>Você solicitou a redefinição da sua senha.</p>
+			<p>Clique no link abaixo para criar uma nova senha:</p>
+			<p><a href="{{.Link}}" style="background-color: #2563EB; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Redefinir Senha</a></p>
+			<p>Se você não solicitou isso, ignore este e-mail. Este link expira em 1 hora.</p>
+		</body></html>`,
+	},
+	"user_invite": {
+		Name:    "user_invite",
+		Subject: "Você foi convidado - Data Frontier",
+		HTML:    true,
+		Body: `<html><body>
+			<h2>Data Frontier - Convite</h2>
+			<p>Olá, {{.Name}}. Uma conta foi criada para você na plataforma.</p>
+			<p>Clique no link abaixo para escolher sua senha e ativar o acesso:</p>
+			<p><a href="{{.Link}}" style="background-color: #2563EB; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Ativar Conta</a></p>
+			<p>Este link é de uso único e expira em 72 horas. Se não esperava este convite, ignore este e-mail.</p>
+		</body></html>`,
+	},
+	"email_verification": {
+		Name:    "email_verification",
+		Subject: "Confirme seu novo e-mail - Data Frontier",
+		HTML:    true,
+		Body: `<html><body>
+			<h2>Data Frontier - Confirmação de E-mail</h2>
+			<p>Olá, {{.Name}}. Recebemos um pedido para usar este endereço na sua conta.</p>
+			<p>Clique no link abaixo para confirmar:</p>
+			<p><a href="{{.Link}}" style="background-color: #2563EB; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Confirmar E-mail</a></p>
+			<p>Se você não fez esse pedido, ignore este e-mail. Este link expira em 24 horas.</p>
+		</body></html>`,
+	},
+	"email_change_requested": {
+		Name:    "email_change_requested",
+		Subject: "Pedido de troca de e-mail - Data Frontier",
+		HTML:    true,
+		Body: `<html><body>
+			<h2>Data Frontier - Troca de E-mail</h2>
+			<p>Olá, {{.Name}}. Foi pedida a troca do e-mail da sua conta para {{.NewEmail}}.</p>
+			<p>A troca só vale depois da confirmação enviada ao novo endereço.</p>
+			<p>Se não foi você, altere sua senha e entre em contato com o administrador imediatamente.</p>
+		</body></html>`,
+	},
+	"email_changed": {
+		Name:    "email_changed",
+		Subject: "Seu e-mail foi alterado - Data Frontier",
+		HTML:    true,
+		Body: `<html><body>
+			<h2>Data Frontier - E-mail Alterado</h2>
+			<p>Olá, {{.Name}}. O e-mail da sua conta foi alterado para {{.NewEmail}}.</p>
+			<p>Se não foi você, entre em contato com o administrador imediatamente.</p>
+		</body></html>`,
+	},
+	"alert_opened": {
+		Name:    "alert_opened",
+		Subject: "[{{.Severity}}] Alerta {{if .DeviceName}}{{.DeviceName}}{{else}}{{.ESN}}{{end}}",
+		Body:    "{{.Message}} (ESN {{.ESN}}, {{.OpenedAt}})",
+	},
+	"alert_escalation": {
+		Name:    "alert_escalation",
+		Subject: "[{{.Severity}}] SEM RECONHECIMENTO - Alerta {{if .DeviceName}}{{.DeviceName}}{{else}}{{.ESN}}{{end}}",
+		Body:    "Escalonamento etapa {{.Step}}: {{.Message}} (ESN {{.ESN}}, aberto em {{.OpenedAt}}). Reconheça o alerta no painel.",
+	},
+}
+
+// render: Aplica os dados ao template
+func (t Template) render(data interface{}) (subject, body string, err error) {
+	subject, err = execText(t.Subject, data)
+	if err != nil {
+		return "", "", err
+	}
+	if !t.HTML {
+		body, err = execText(t.Body, data)
+		return subject, body, err
+	}
+
+	tpl, err := htmltemplate.New(t.Name).Parse(t.Body)
+	if err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	return subject, buf.String(), nil
+}
+
+func execText(text string, data interface{}) (string, error) {
+	tpl, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// --- IdP DE TESTE ---
+//
+// Provedor OIDC mínimo para desenvolvimento local (cmd/mockidp): a tela de
+// autorização pede e-mail, nome e grupos e emite um id_token RS256 com esses
+// dados. Valida redirect_uri e PKCE como um IdP real; não confere senha nem
+// client_secret. Nunca usar em produção.
+
+type MockIdP struct {
+	Issuer   string
+	ClientID string
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	name        string
+	groups      []string
+	expires     time.Time
+}
+
+func NewMockIdP(issuer, clientID string) (*MockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &MockIdP{
+		Issuer:   strings.TrimRight(issuer, "/"),
+		ClientID: clientID,
+		key:      key,
+		kid:      "mock-1",
+		codes:    make(map[string]mockGrant),
+	}, nil
+}
+
+// ServeHTTP: Descoberta, autorização, token e JWKS
+func (m *MockIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		m.writeJSON(w, map[string]interface{}{
+			"issuer":                                m.Issuer,
+			"authorization_endpoint":                m.Issuer + "/authorize",
+			"token_endpoint":                        m.Issuer + "/token",
+			"jwks_uri":                              m.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		m.writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": m.kid,
+			"n": base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var mockLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Mock IdP</title></head>
+<body style="font-family:sans-serif;max-width:360px;margin:60px auto">
+<h2>Mock IdP</h2>
+<form method="post">
+{{range $k, $v := .Query}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}
+<p><label>E-mail<br><input name="email" value="usuario@empresa.com" style="width:100%"></label></p>
+<p><label>Nome<br><input name="name" value="Usuário de Teste" style="width:100%"></label></p>
+<p><label>Grupos (separados por vírgula)<br><input name="groups" value="" style="width:100%"></label></p>
+<button type="submit">Entrar</button>
+</form></body></html>`))
+
+// authorize: GET mostra o formulário; POST emite o código e volta ao redirect_uri
+func (m *MockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		mockLoginPage.Execute(w, map[string]interface{}{"Query": r.URL.Query()})
+		return
+	}
+	r.ParseForm()
+	if r.Form.Get("client_id") != m.ClientID || r.Form.Get("response_type") != "code" {
+		http.Error(w, "client_id ou response_type inválido", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		http.Error(w, "PKCE S256 obrigatório", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "redirect_uri inválido", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := RandomString(24)
+	var groups []string
+	for _, g := range strings.Split(r.Form.Get("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	m.mu.Lock()
+	m.codes[code] = mockGrant{
+		redirectURI: redirect.String(),
+		challenge:   r.Form.Get("code_challenge"),
+		nonce:       r.Form.Get("nonce"),
+		email:       r.Form.Get("email"),
+		name:        r.Form.Get("name"),
+		groups:      groups,
+		expires:     time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token: Troca o código de uso único pelo id_token (confere redirect_uri e PKCE)
+func (m *MockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	code := r.Form.Get("code")
+	m.mu.Lock()
+	grant, ok := m.codes[code]
+	delete(m.codes, code)
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || time.Now().After(grant.expires) || r.Form.Get("grant_type") != "authorization_code" ||
+		r.Form.Get("client_id") != m.ClientID || r.Form.Get("redirect_uri") != grant.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		m.writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.Issuer,
+		"aud":            m.ClientID,
+		"sub":            "mock|" + strings.ToLower(grant.email),
+		"email":          grant.email,
+		"email_verified": true,
+		"name":           grant.name,
+		"groups":         grant.groups,
+		"nonce":          grant.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = m.kid
+	signed, err := tok.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.writeJSON(w, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (m *MockIdP) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
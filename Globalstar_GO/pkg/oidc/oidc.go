@@ -0,0 +1,332 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"iot_modulo1.0/pkg/netguard"
+)
+
+// --- OPENID CONNECT (CLIENTE) ---
+//
+// Fluxo authorization code com PKCE (S256). O documento de descoberta e as
+// chaves públicas (JWKS) de cada emissor ficam em cache; chave desconhecida
+// (rotação no IdP) força uma nova busca do JWKS.
+
+// Config: Cadastro de um provedor (IdP) para uma organização
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // padrão: openid email profile
+}
+
+// IDToken: Claims já verificadas do id_token
+type IDToken struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Claims            jwt.MapClaims
+}
+
+var (
+	ErrDiscovery = errors.New("oidc: falha na descoberta do provedor")
+	ErrExchange  = errors.New("oidc: falha ao trocar o código")
+	ErrIDToken   = errors.New("oidc: id_token inválido")
+)
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type cachedDoc struct {
+	doc     discovery
+	fetched time.Time
+}
+
+// Client: Cache compartilhado por todos os provedores
+type Client struct {
+	HTTP     *http.Client
+	CacheTTL time.Duration
+
+	mu   sync.Mutex
+	docs map[string]cachedDoc
+	keys map[string]map[string]*rsa.PublicKey // jwks_uri -> kid -> chave
+}
+
+// NewClient: Só conecta em endereços públicos. O emissor é cadastrado pelo admin
+// da organização e o documento de descoberta indica os demais endpoints: sem o
+// filtro, daria para sondar a rede interna pelo backend.
+func NewClient() *Client {
+	return &Client{
+		HTTP:     netguard.PublicHTTPClient(10 * time.Second),
+		CacheTTL: time.Hour,
+		docs:     make(map[string]cachedDoc),
+		keys:     make(map[string]map[string]*rsa.PublicKey),
+	}
+}
+
+// NewPKCE: code_verifier aleatório e o code_challenge S256 correspondente
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString: n bytes aleatórios em base64url (state, nonce, verifier)
+func RandomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// discover: .well-known/openid-configuration do emissor (em cache)
+func (c *Client) discover(ctx context.Context, issuer string) (discovery, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	c.mu.Lock()
+	cached, ok := c.docs[issuer]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetched) < c.CacheTTL {
+		return cached.doc, nil
+	}
+
+	var doc discovery
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return doc, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// O emissor anunciado precisa ser exatamente o cadastrado
+	if strings.TrimRight(doc.Issuer, "/") != issuer || doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return doc, fmt.Errorf("%w: documento incompleto ou emissor divergente (%s)", ErrDiscovery, doc.Issuer)
+	}
+
+	c.mu.Lock()
+	c.docs[issuer] = cachedDoc{doc: doc, fetched: time.Now()}
+	c.mu.Unlock()
+	return doc, nil
+}
+
+// Check: O emissor publica um documento de descoberta válido?
+func (c *Client) Check(ctx context.Context, issuer string) error {
+	_, err := c.discover(ctx, issuer)
+	return err
+}
+
+// AuthCodeURL: URL para onde o navegador é enviado no início do login
+func (c *Client) AuthCodeURL(ctx context.Context, cfg Config, state, nonce, challenge string) (string, error) {
+	doc, err := c.discover(ctx, cfg.Issuer)
+	if err != nil {
+		return "", err
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", cfg.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange: Troca o código (com o verifier do PKCE) e devolve o id_token bruto
+func (c *Client) Exchange(ctx context.Context, cfg Config, code, verifier string) (string, error) {
+	doc, err := c.discover(ctx, cfg.Issuer)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("code_verifier", verifier)
+	if cfg.ClientSecret != "" {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s", ErrExchange, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.IDToken == "" {
+		return "", fmt.Errorf("%w: resposta sem id_token", ErrExchange)
+	}
+	return tok.IDToken, nil
+}
+
+// VerifyIDToken: Assinatura (RS256 via JWKS), emissor, audiência, expiração e nonce
+func (c *Client) VerifyIDToken(ctx context.Context, cfg Config, raw, nonce string) (*IDToken, error) {
+	doc, err := c.discover(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, doc.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIDToken, err)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce divergente", ErrIDToken)
+	}
+
+	t := &IDToken{Claims: claims}
+	t.Subject, _ = claims["sub"].(string)
+	t.Email, _ = claims["email"].(string)
+	t.Name, _ = claims["name"].(string)
+	t.PreferredUsername, _ = claims["preferred_username"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		t.EmailVerified = v
+	case string: // alguns IdPs mandam "true"
+		t.EmailVerified = v == "true"
+	}
+	if t.Subject == "" {
+		return nil, fmt.Errorf("%w: sem sub", ErrIDToken)
+	}
+	return t, nil
+}
+
+// Strings: Claim como lista (aceita array ou string separada por espaço/vírgula, ex: groups)
+func (t *IDToken) Strings(name string) []string {
+	switch v := t.Claims[name].(type) {
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+	}
+	return nil
+}
+
+// key: Chave pública do kid; busca o JWKS de novo se o kid for desconhecido
+func (c *Client) key(ctx context.Context, jwksURI, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	k, ok := c.keys[jwksURI][kid]
+	c.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	c.mu.Lock()
+	c.keys[jwksURI] = keys
+	c.mu.Unlock()
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	// Sem kid no cabeçalho e uma única chave publicada: usa ela
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("chave %q não encontrada no JWKS", kid)
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,88 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// --- CONTROLE ENTRE RÉPLICAS ---
+//
+// Recarregar permissões e derrubar conexões precisa alcançar os clientes de
+// todas as réplicas, não só os da instância que atendeu a requisição. O pedido
+// trafega pelo mesmo barramento dos eventos e cada Hub o aplica aos seus
+// clientes; nunca é entregue aos navegadores nem entra no replay.
+
+// Tipo dos eventos de controle no barramento
+const ControlEventType = "HUB_CONTROL"
+
+// Ações de controle
+const (
+	controlRefreshUser       = "refresh_user"
+	controlRefreshAll        = "refresh_all"
+	controlDisconnectUser    = "disconnect_user"
+	controlDisconnectSession = "disconnect_session"
+)
+
+// Prazo para publicar um pedido de controle
+const controlTimeout = 5 * time.Second
+
+type controlMessage struct {
+	Action    string `json:"action"`
+	UserID    int    `json:"user_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// publishControl: Envia o pedido a todas as réplicas. Sem barramento (ou se a
+// publicação falhar) aplica só nesta instância.
+func (h *Hub) publishControl(m controlMessage) {
+	if h.Bus != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+		defer cancel()
+		err := h.Bus.Publish(ctx, map[string]interface{}{
+			"type":       ControlEventType,
+			"action":     m.Action,
+			"user_id":    m.UserID,
+			"session_id": m.SessionID,
+		})
+		if err == nil {
+			return
+		}
+		log.Printf("Erro ao publicar controle do Hub (%s): %v", m.Action, err)
+	}
+	h.applyControl(m)
+}
+
+// handleControl: Chamado pela goroutine do Hub; as consultas de permissão
+// rodam fora dela
+func (h *Hub) handleControl(e Event) {
+	var m controlMessage
+	if err := json.Unmarshal(e.Data, &m); err != nil {
+		log.Printf("Erro ao decodificar controle do Hub: %v", err)
+		return
+	}
+	go h.applyControl(m)
+}
+
+// applyControl: Executa o pedido nos clientes desta instância
+func (h *Hub) applyControl(m controlMessage) {
+	switch m.Action {
+	case controlRefreshUser:
+		h.permissions <- permissionUpdate{userID: m.UserID, devices: h.Permissions(m.UserID)}
+	case controlRefreshAll:
+		reply := make(chan []int, 1)
+		h.connected <- reply
+		for _, userID := range <-reply {
+			h.permissions <- permissionUpdate{userID: userID, devices: h.Permissions(userID)}
+		}
+	case controlDisconnectUser:
+		h.disconnect <- disconnectRequest{userID: m.UserID}
+	case controlDisconnectSession:
+		if m.SessionID != "" {
+			h.disconnect <- disconnectRequest{userID: m.UserID, sessionID: m.SessionID}
+		}
+	default:
+		log.Printf("Aviso: Controle do Hub desconhecido: %q", m.Action)
+	}
+}
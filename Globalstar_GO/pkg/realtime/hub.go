@@ -0,0 +1,459 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- PARÂMETROS DA CONEXÃO ---
+const (
+	// Tempo máximo para escrever uma mensagem no socket
+	writeWait = 10 * time.Second
+	// Tempo máximo sem receber pong do cliente
+	pongWait = 60 * time.Second
+	// Intervalo dos pings (precisa ser menor que pongWait)
+	pingPeriod = (pongWait * 9) / 10
+	// Tamanho máximo das mensagens enviadas pelo cliente
+	maxMessageSize = 4096
+	// Fila de saída por cliente; cheia = consumidor lento, conexão derrubada
+	sendBuffer = 256
+	// Espera entre tentativas de reassinar o barramento (dobra até o máximo)
+	resubscribeMin = time.Second
+	resubscribeMax = 30 * time.Second
+)
+
+// Identity: Quem é o dono da conexão
+type Identity struct {
+	UserID int
+	// Vê todos os dispositivos (ex: master)
+	SeesAll bool
+	// Sessão de login (sid do token); vazio em tokens anteriores às sessões
+	SessionID string
+}
+
+// Client: Conexão (WebSocket ou SSE) com fila de envio própria
+type Client struct {
+	hub *Hub
+	// nil para clientes SSE
+	conn *websocket.Conn
+	send chan frame
+
+	Identity
+	// Dispositivos permitidos e tópicos inscritos (só acessados pela goroutine do Hub)
+	devices map[int]bool
+	subs    subscriptions
+	// resume_from informado na conexão; aplicado na primeira inscrição
+	resumeFrom int64
+}
+
+// frame: Mensagem na fila de saída (id = event_id, 0 nas respostas do protocolo)
+type frame struct {
+	id   int64
+	data []byte
+}
+
+type permissionUpdate struct {
+	userID  int
+	devices map[int]bool
+}
+
+// disconnectRequest: sessionID vazio derruba todas as conexões do usuário
+type disconnectRequest struct {
+	userID    int
+	sessionID string
+}
+
+// --- HUB ---
+
+// Hub: Único dono do conjunto de clientes. Registro, remoção e distribuição
+// passam por canais e são tratados pela goroutine de Run, então não há
+// acesso concorrente ao mapa. Cada cliente tem uma goroutine de escrita.
+type Hub struct {
+	// Carrega os dispositivos que o usuário pode ver
+	Permissions func(userID int) map[int]bool
+	// Carrega os dispositivos de um grupo (tópicos "group:<id>")
+	GroupDevices func(groupID int) map[int]bool
+	// Eventos mantidos para replay (0 desliga)
+	ReplaySize int
+	// Barramento assinado por Run e usado pelos pedidos de controle
+	// (RefreshUser, RefreshAll, DisconnectUser). nil = só esta instância.
+	Bus PubSub
+
+	register    chan *Client
+	unregister  chan *Client
+	permissions chan permissionUpdate
+	disconnect  chan disconnectRequest
+	subscribe   chan subscriptionChange
+	connected   chan chan []int
+	clients     map[*Client]bool
+
+	lastID int64
+	replay []bufferedEvent
+	// Último ID recebido do barramento (inclusive controle), para detectar lacunas
+	seenID int64
+}
+
+// Construtor
+func NewHub(permissions, groupDevices func(id int) map[int]bool) *Hub {
+	return &Hub{
+		Permissions:  permissions,
+		GroupDevices: groupDevices,
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		permissions:  make(chan permissionUpdate),
+		disconnect:   make(chan disconnectRequest),
+		subscribe:    make(chan subscriptionChange),
+		connected:    make(chan chan []int),
+		clients:      make(map[*Client]bool),
+		ReplaySize:   DefaultReplaySize,
+	}
+}
+
+// Run: Assina o barramento (h.Bus) e distribui os eventos (bloqueante)
+func (h *Hub) Run(ctx context.Context) {
+	events := make(chan Event)
+	go h.follow(ctx, events)
+	h.serve(events)
+}
+
+// follow: Mantém a assinatura do barramento. Se ela cair, assina de novo com
+// espera crescente; os eventos do intervalo são detectados como lacuna em serve.
+func (h *Hub) follow(ctx context.Context, out chan<- Event) {
+	defer close(out)
+	wait := resubscribeMin
+	for {
+		events, err := h.Bus.Subscribe(ctx)
+		if err != nil {
+			log.Printf("Erro ao assinar eventos: %v", err)
+		} else {
+			for ev := range events {
+				wait = resubscribeMin
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Aviso: Assinatura de eventos encerrada; nova tentativa em %v", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		wait = min(wait*2, resubscribeMax)
+	}
+}
+
+// serve: Laço da goroutine do Hub
+func (h *Hub) serve(events <-chan Event) {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+
+		case c := <-h.unregister:
+			h.remove(c)
+
+		case u := <-h.permissions:
+			for c := range h.clients {
+				if c.UserID == u.userID {
+					c.devices = u.devices
+				}
+			}
+
+		case d := <-h.disconnect:
+			for c := range h.clients {
+				if c.UserID == d.userID && (d.sessionID == "" || c.SessionID == d.sessionID) {
+					h.remove(c)
+				}
+			}
+
+		case ch := <-h.subscribe:
+			h.changeSubscriptions(ch)
+
+		case reply := <-h.connected:
+			seen := make(map[int]bool)
+			var users []int
+			for c := range h.clients {
+				if !seen[c.UserID] {
+					seen[c.UserID] = true
+					users = append(users, c.UserID)
+				}
+			}
+			reply <- users
+
+		case ev, ok := <-events:
+			if !ok {
+				// ctx de Run cancelado: continua atendendo registros/remoções, sem eventos
+				log.Println("Aviso: Distribuição de eventos encerrada")
+				events = nil
+				continue
+			}
+			if h.seenID > 0 && ev.ID > h.seenID+1 {
+				h.resyncAll()
+			}
+			if ev.ID > h.seenID {
+				h.seenID = ev.ID
+			}
+			if ev.Type == ControlEventType {
+				h.handleControl(ev)
+				continue
+			}
+			h.dispatch(ev)
+		}
+	}
+}
+
+// resyncAll: Eventos se perderam (assinatura caiu): o replay não cobre mais a
+// lacuna e todos os clientes recarregam pela API REST
+func (h *Hub) resyncAll() {
+	log.Printf("Aviso: Lacuna nos eventos após o ID %d; clientes devem recarregar", h.seenID)
+	h.replay = h.replay[:0]
+	for c := range h.clients {
+		h.reply(c, map[string]interface{}{
+			"type":          "RESYNC_REQUIRED",
+			"last_event_id": h.lastID,
+		})
+	}
+}
+
+// dispatch: Guarda para replay e enfileira para cada cliente interessado
+func (h *Hub) dispatch(e Event) {
+	ev := h.record(e)
+	for c := range h.clients {
+		if c.wants(ev) {
+			h.enqueue(c, frame{id: ev.id, data: ev.data})
+		}
+	}
+}
+
+// changeSubscriptions: Aplica o comando do cliente, confirma e faz o replay pendente
+func (h *Hub) changeSubscriptions(ch subscriptionChange) {
+	c := ch.client
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	if ch.err != "" {
+		h.reply(c, map[string]interface{}{"type": "ERROR", "message": ch.err})
+		return
+	}
+
+	c.subs.apply(ch)
+	if !h.reply(c, map[string]interface{}{
+		"type":          "SUBSCRIPTIONS",
+		"topics":        c.subs.list(),
+		"last_event_id": h.lastID,
+	}) {
+		return
+	}
+
+	if ch.action != ActionSubscribe {
+		return
+	}
+	from := ch.resumeFrom
+	if from == 0 {
+		from = c.resumeFrom
+	}
+	c.resumeFrom = 0
+	if from > 0 {
+		h.replayTo(c, from)
+	}
+}
+
+// reply: Mensagem direta a um cliente (confirmações e erros do protocolo)
+func (h *Hub) reply(c *Client, msg interface{}) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Erro WS Marshal: %v", err)
+		return false
+	}
+	return h.enqueue(c, frame{data: data})
+}
+
+// enqueue: Retorna false se o cliente foi removido
+func (h *Hub) enqueue(c *Client, f frame) bool {
+	select {
+	case c.send <- f:
+		return true
+	default:
+		// Consumidor lento: não pode travar os demais
+		log.Printf("Aviso: Cliente WS do usuário %d removido (fila cheia)", c.UserID)
+		h.remove(c)
+		return false
+	}
+}
+
+// remove: Fecha a fila (a goroutine de escrita encerra a conexão)
+func (h *Hub) remove(c *Client) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// RefreshUser: Recarrega as permissões das conexões abertas do usuário (em todas as réplicas)
+func (h *Hub) RefreshUser(userID int) {
+	h.publishControl(controlMessage{Action: controlRefreshUser, UserID: userID})
+}
+
+// RefreshAll: Recarrega as permissões de todos os usuários conectados (ex: um
+// dispositivo novo ou que mudou de organização). As consultas rodam fora do Hub.
+func (h *Hub) RefreshAll() {
+	h.publishControl(controlMessage{Action: controlRefreshAll})
+}
+
+// DisconnectUser: Derruba todas as conexões do usuário (em todas as réplicas)
+func (h *Hub) DisconnectUser(userID int) {
+	h.publishControl(controlMessage{Action: controlDisconnectUser, UserID: userID})
+}
+
+// DisconnectSession: Derruba só as conexões abertas com os tokens da sessão
+func (h *Hub) DisconnectSession(userID int, sessionID string) {
+	h.publishControl(controlMessage{Action: controlDisconnectSession, UserID: userID, SessionID: sessionID})
+}
+
+// ServeClient: Registra a conexão já autenticada e bloqueia até ela fechar.
+// resumeFrom > 0 pede o replay dos eventos posteriores a esse ID.
+func (h *Hub) ServeClient(conn *websocket.Conn, id Identity, resumeFrom int64) {
+	c := &Client{
+		hub:  h,
+		conn: conn,
+		// Espaço extra para o replay não derrubar o cliente que reconecta
+		send:       make(chan frame, sendBuffer+h.ReplaySize),
+		Identity:   id,
+		subs:       newSubscriptions(),
+		resumeFrom: resumeFrom,
+	}
+	if !id.SeesAll {
+		c.devices = h.Permissions(id.UserID)
+	}
+	h.register <- c
+
+	go c.writePump()
+	c.readPump()
+}
+
+// EventDeviceID: Extrai o device_id de um evento do broadcast
+func EventDeviceID(msg interface{}) (int, bool) {
+	m, ok := msg.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	switch id := m["device_id"].(type) {
+	case int:
+		return id, true
+	case int64:
+		return int(id), true
+	}
+	return 0, false
+}
+
+// EventType: Extrai o campo "type" de um evento do broadcast
+func EventType(msg interface{}) string {
+	if m, ok := msg.(map[string]interface{}); ok {
+		if t, ok := m["type"].(string); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+// --- BOMBAS DE LEITURA/ESCRITA ---
+
+// readPump: Recebe os comandos de inscrição, mantém o deadline com os pongs
+// e detecta a desconexão
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.handleCommand(data)
+	}
+}
+
+// handleCommand: Valida o comando e repassa a alteração ao Hub. Os membros
+// dos grupos são carregados aqui para não bloquear a goroutine do Hub.
+func (c *Client) handleCommand(data []byte) {
+	var cmd ClientMessage
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		c.hub.replyError(c, "Comando inválido")
+		return
+	}
+	if cmd.Action != ActionSubscribe && cmd.Action != ActionUnsubscribe {
+		c.hub.replyError(c, "Ação desconhecida: "+cmd.Action)
+		return
+	}
+
+	ch := subscriptionChange{
+		client:       c,
+		action:       cmd.Action,
+		resumeFrom:   cmd.ResumeFrom,
+		groupDevices: make(map[int]map[int]bool),
+	}
+	for _, raw := range cmd.Topics {
+		t, err := parseTopic(raw)
+		if err != nil {
+			c.hub.replyError(c, err.Error())
+			return
+		}
+		if t.kind == "group" && cmd.Action == ActionSubscribe && c.hub.GroupDevices != nil {
+			ch.groupDevices[t.id] = c.hub.GroupDevices(t.id)
+		}
+		ch.topics = append(ch.topics, t)
+	}
+	c.hub.subscribe <- ch
+}
+
+func (h *Hub) replyError(c *Client, message string) {
+	h.subscribe <- subscriptionChange{client: c, err: message}
+}
+
+// writePump: Única goroutine que escreve no socket (mensagens e pings)
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case f, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// Hub fechou a fila
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, f.data); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Permissões dos testes: usuário 1 vê o dispositivo 10, usuário 2 vê o 20
+var testPermissions = map[int]map[int]bool{
+	1: {10: true},
+	2: {20: true},
+}
+
+func newTestHub(t *testing.T) (*Hub, chan Event) {
+	t.Helper()
+	h := NewHub(func(userID int) map[int]bool { return testPermissions[userID] }, nil)
+	events := make(chan Event)
+	go h.serve(events)
+	return h, events
+}
+
+// wsServer: Servidor WebSocket que identifica o usuário por ?user=<id>&all=1
+func wsServer(t *testing.T, h *Hub) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user"))
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		h.ServeClient(conn, Identity{UserID: userID, SeesAll: r.URL.Query().Get("all") == "1"}, 0)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial: Conecta e assina os tópicos, esperando a confirmação
+func dial(t *testing.T, srv *httptest.Server, query string, topics ...string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.WriteJSON(ClientMessage{Action: ActionSubscribe, Topics: topics}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if msg := readMessage(t, conn); msg["type"] != "SUBSCRIPTIONS" {
+		t.Fatalf("esperava SUBSCRIPTIONS, veio %v", msg)
+	}
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]interface{}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	return msg
+}
+
+func deviceEvent(id int64, deviceID int) Event {
+	ev, _ := NewEvent(id, map[string]interface{}{"type": "NEW_MESSAGE", "device_id": deviceID})
+	return ev
+}
+
+// connectedUsers: Pergunta ao Hub quem está conectado (sincroniza com a goroutine de Run)
+func connectedUsers(h *Hub) []int {
+	reply := make(chan []int, 1)
+	h.connected <- reply
+	return <-reply
+}
+
+// newTestClient: Cliente sem socket, lido direto da fila (como o SSE)
+func newTestClient(h *Hub, id Identity, buffer int) *Client {
+	c := &Client{hub: h, send: make(chan frame, buffer), Identity: id, subs: newSubscriptions()}
+	if !id.SeesAll {
+		c.devices = h.Permissions(id.UserID)
+	}
+	return c
+}
+
+func TestHubRegisterAndUnregister(t *testing.T) {
+	h, _ := newTestHub(t)
+	srv := wsServer(t, h)
+
+	conn := dial(t, srv, "user=1", "*")
+	if users := connectedUsers(h); len(users) != 1 || users[0] != 1 {
+		t.Fatalf("conectados = %v, esperado [1]", users)
+	}
+
+	// Fechar o socket encerra o readPump, que remove o cliente do Hub
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(connectedUsers(h)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cliente continuou registrado após fechar a conexão")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubFanOutRespectsPermissionsAndTopics(t *testing.T) {
+	h, events := newTestHub(t)
+	srv := wsServer(t, h)
+
+	user1 := dial(t, srv, "user=1", "*")
+	user2 := dial(t, srv, "user=2", "*")
+	master := dial(t, srv, "user=99&all=1", "*")
+	onlyAlerts := dial(t, srv, "user=1", "event:ALERT_UPDATE")
+
+	events <- deviceEvent(100, 10)
+	events <- deviceEvent(101, 20)
+	alert, _ := NewEvent(102, map[string]interface{}{"type": "ALERT_UPDATE", "device_id": 10})
+	events <- alert
+
+	// Cada um recebe, em ordem, só o que pode ver e assinou
+	want := map[*websocket.Conn][]float64{
+		user1:      {100, 102},
+		user2:      {101},
+		master:     {100, 101, 102},
+		onlyAlerts: {102},
+	}
+	for conn, ids := range want {
+		for _, id := range ids {
+			if msg := readMessage(t, conn); msg["event_id"] != id {
+				t.Fatalf("esperava event_id %v, veio %v", id, msg)
+			}
+		}
+	}
+
+	// Nada além disso: o próximo evento visível a todos é o próximo a chegar
+	events <- deviceEvent(103, 10)
+	events <- deviceEvent(104, 20)
+	for conn, id := range map[*websocket.Conn]float64{user1: 103, user2: 104, master: 103} {
+		if msg := readMessage(t, conn); msg["event_id"] != id {
+			t.Fatalf("esperava event_id %v, veio %v", id, msg)
+		}
+	}
+}
+
+func TestHubEvictsSlowConsumer(t *testing.T) {
+	h, events := newTestHub(t)
+	h.ReplaySize = 0
+
+	slow := newTestClient(h, Identity{UserID: 1}, 2)
+	fast := newTestClient(h, Identity{UserID: 1}, 16)
+	for _, c := range []*Client{slow, fast} {
+		h.register <- c
+		h.subscribe <- subscriptionChange{client: c, action: ActionSubscribe, topics: []topic{{kind: "*"}}}
+	}
+
+	// O lento nunca lê: confirmação + 1 evento enchem a fila, o 2º o derruba
+	for id := int64(1); id <= 3; id++ {
+		events <- deviceEvent(id, 10)
+	}
+	if users := connectedUsers(h); len(users) != 1 {
+		t.Fatalf("conectados = %v, esperado só o cliente rápido", users)
+	}
+
+	var got []int64
+	for f := range slow.send {
+		got = append(got, f.id)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Fatalf("fila do cliente lento = %v, esperado [0 1] e fechada", got)
+	}
+
+	// O rápido não foi afetado
+	<-fast.send // SUBSCRIPTIONS
+	for id := int64(1); id <= 3; id++ {
+		if f := <-fast.send; f.id != id {
+			t.Fatalf("cliente rápido recebeu %d, esperado %d", f.id, id)
+		}
+	}
+}
+
+func TestHubConcurrentSubscribe(t *testing.T) {
+	h, events := newTestHub(t)
+	const n = 50
+
+	// Eventos chegando enquanto os clientes se registram e assinam
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for id := int64(1); ; id++ {
+			select {
+			case events <- deviceEvent(id, 10):
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := newTestClient(h, Identity{UserID: 1}, sendBuffer)
+			h.register <- c
+			c.handleCommand([]byte(fmt.Sprintf(`{"action":"subscribe","topics":["device:%d"]}`, 10+i%2)))
+			// Sem tópico nada é entregue antes da confirmação
+			f := <-c.send
+			var msg map[string]interface{}
+			if err := json.Unmarshal(f.data, &msg); err != nil || f.id != 0 || msg["type"] != "SUBSCRIPTIONS" {
+				errs <- fmt.Errorf("cliente %d: primeira mensagem inesperada %s", i, f.data)
+			}
+			h.unregister <- c
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-done
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if users := connectedUsers(h); len(users) != 0 {
+		t.Fatalf("conectados = %v, esperado nenhum", users)
+	}
+}
+
+// flakyBus: Barramento em memória cuja assinatura o teste derruba
+type flakyBus struct {
+	*MemoryPubSub
+	mu   sync.Mutex
+	drop context.CancelFunc
+}
+
+func (b *flakyBus) Subscribe(ctx context.Context) (<-chan Event, error) {
+	sub, cancel := context.WithCancel(ctx)
+	b.mu.Lock()
+	b.drop = cancel
+	b.mu.Unlock()
+	return b.MemoryPubSub.Subscribe(sub)
+}
+
+func (b *flakyBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.MemoryPubSub.mu.Lock()
+	defer b.MemoryPubSub.mu.Unlock()
+	return len(b.subs)
+}
+
+func (b *flakyBus) waitSubscribers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); b.subscribers() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("esperava %d assinante(s), há %d", n, b.subscribers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubResubscribesAndRequestsResync(t *testing.T) {
+	bus := &flakyBus{MemoryPubSub: NewMemoryPubSub()}
+	h := NewHub(func(int) map[int]bool { return nil }, nil)
+	h.Bus = bus
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.Run(ctx)
+	bus.waitSubscribers(t, 1)
+
+	c := newTestClient(h, Identity{UserID: 99, SeesAll: true}, 16)
+	h.register <- c
+	h.subscribe <- subscriptionChange{client: c, action: ActionSubscribe, topics: []topic{{kind: "*"}}}
+	<-c.send // SUBSCRIPTIONS
+
+	publish := func(deviceID int) {
+		if err := bus.Publish(ctx, map[string]interface{}{"type": "NEW_MESSAGE", "device_id": deviceID}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	publish(1)
+	first := <-c.send
+
+	// Assinatura cai; o evento publicado enquanto isso se perde
+	bus.mu.Lock()
+	bus.drop()
+	bus.mu.Unlock()
+	bus.waitSubscribers(t, 0)
+	publish(2)
+
+	// O Hub assina de novo sozinho e avisa o cliente da lacuna antes do próximo evento
+	bus.waitSubscribers(t, 1)
+	publish(3)
+
+	var msg map[string]interface{}
+	if f := <-c.send; json.Unmarshal(f.data, &msg) != nil || msg["type"] != "RESYNC_REQUIRED" {
+		t.Fatalf("esperava RESYNC_REQUIRED, veio %s", f.data)
+	}
+	if f := <-c.send; f.id != first.id+2 {
+		t.Fatalf("esperava o evento %d, veio %d", first.id+2, f.id)
+	}
+
+	// O replay não atravessa a lacuna: quem retoma de antes dela também recarrega
+	late := newTestClient(h, Identity{UserID: 99, SeesAll: true}, 16)
+	h.register <- late
+	h.subscribe <- subscriptionChange{client: late, action: ActionSubscribe, topics: []topic{{kind: "*"}}, resumeFrom: first.id}
+	<-late.send // SUBSCRIPTIONS
+	if f := <-late.send; json.Unmarshal(f.data, &msg) != nil || msg["type"] != "RESYNC_REQUIRED" {
+		t.Fatalf("esperava RESYNC_REQUIRED no replay, veio %s", f.data)
+	}
+}
+
+func TestHubDisconnectSessionKeepsOtherSessions(t *testing.T) {
+	h, _ := newTestHub(t)
+	phone := newTestClient(h, Identity{UserID: 1, SessionID: "celular"}, 4)
+	laptop := newTestClient(h, Identity{UserID: 1, SessionID: "notebook"}, 4)
+	other := newTestClient(h, Identity{UserID: 2, SessionID: "celular"}, 4)
+	for _, c := range []*Client{phone, laptop, other} {
+		h.register <- c
+	}
+
+	// Sem barramento o pedido vale só para esta instância (aplicado na hora)
+	h.DisconnectSession(1, "celular")
+	if _, ok := <-phone.send; ok {
+		t.Fatal("a conexão da sessão revogada continuou aberta")
+	}
+	users := connectedUsers(h)
+	if len(users) != 2 {
+		t.Fatalf("conectados = %v, esperado o notebook do usuário 1 e o usuário 2", users)
+	}
+
+	h.DisconnectUser(1)
+	if _, ok := <-laptop.send; ok {
+		t.Fatal("DisconnectUser deveria derrubar todas as sessões do usuário")
+	}
+	if users := connectedUsers(h); len(users) != 1 || users[0] != 2 {
+		t.Fatalf("conectados = %v, esperado [2]", users)
+	}
+}
+
+// resume: Conecta e assina tudo retomando do event_id informado
+func resume(t *testing.T, srv *httptest.Server, query string, from int64) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/?"+query, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(ClientMessage{Action: ActionSubscribe, Topics: []string{"*"}, ResumeFrom: from}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if msg := readMessage(t, conn); msg["type"] != "SUBSCRIPTIONS" {
+		t.Fatalf("esperava SUBSCRIPTIONS, veio %v", msg)
+	}
+	return conn
+}
+
+func TestHubResumeReplaysMissedEvents(t *testing.T) {
+	h, events := newTestHub(t)
+	h.ReplaySize = 3
+	srv := wsServer(t, h)
+
+	// O buffer guarda só os três últimos: 101, 102 e 103
+	events <- deviceEvent(100, 10)
+	events <- deviceEvent(101, 20)
+	events <- deviceEvent(102, 10)
+	events <- deviceEvent(103, 10)
+
+	// Dentro do buffer: só o que perdeu, filtrado pela permissão e em ordem;
+	// depois, o próximo evento que ele pode ver
+	clients := []struct {
+		conn   *websocket.Conn
+		replay []float64
+		next   float64
+	}{
+		{resume(t, srv, "user=1", 100), []float64{102, 103}, 104},
+		{resume(t, srv, "user=2", 100), []float64{101}, 105},
+		{resume(t, srv, "user=99&all=1", 101), []float64{102, 103}, 104},
+	}
+	events <- deviceEvent(104, 10)
+	events <- deviceEvent(105, 20)
+	for _, c := range clients {
+		for _, id := range append(c.replay, c.next) {
+			if msg := readMessage(t, c.conn); msg["event_id"] != id || msg["type"] != "NEW_MESSAGE" {
+				t.Fatalf("esperava o evento %v, veio %v", id, msg)
+			}
+		}
+	}
+
+	// Anterior ao buffer (o 100 já saiu): recarregar pela API REST
+	old := resume(t, srv, "user=1", 99)
+	if msg := readMessage(t, old); msg["type"] != "RESYNC_REQUIRED" || msg["last_event_id"] != float64(105) {
+		t.Fatalf("esperava RESYNC_REQUIRED, veio %v", msg)
+	}
+}
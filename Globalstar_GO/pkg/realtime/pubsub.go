@@ -0,0 +1,144 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// --- PUB/SUB ---
+//
+// Os produtores (worker, alertas, watchdog, cercas...) continuam enviando no
+// canal de broadcast; uma goroutine (Pump) publica cada mensagem no PubSub e o
+// Hub de cada instância consome a assinatura. Em memória serve a uma instância
+// só; com Redis todas as réplicas atrás do balanceador recebem todos os eventos
+// e numeram com a mesma sequência (o replay funciona em qualquer réplica).
+
+// PubSub: Barramento de eventos entre instâncias do backend
+type PubSub interface {
+	// Publish: Numera o evento e entrega a todos os assinantes (de todas as instâncias)
+	Publish(ctx context.Context, msg interface{}) error
+	// Subscribe: Eventos em ordem crescente de ID até o ctx ser cancelado
+	Subscribe(ctx context.Context) (<-chan Event, error)
+	Close() error
+}
+
+// Event: Evento numerado como trafega no barramento
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	DeviceID  int             `json:"device_id"`
+	HasDevice bool            `json:"has_device"`
+	Data      json.RawMessage `json:"data"` // mensagem original (sem event_id)
+}
+
+// NewEvent: Serializa a mensagem e extrai os campos usados na filtragem
+func NewEvent(id int64, msg interface{}) (Event, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return Event{}, err
+	}
+	ev := Event{ID: id, Type: EventType(msg), Data: data}
+	ev.DeviceID, ev.HasDevice = EventDeviceID(msg)
+	return ev, nil
+}
+
+// withEventID: Insere "event_id" no objeto JSON sem decodificá-lo de novo
+func withEventID(data []byte, id int64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	out := make([]byte, 0, len(data)+32)
+	out = append(out, `{"event_id":`...)
+	out = strconv.AppendInt(out, id, 10)
+	if len(data) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...)
+}
+
+// Pump: Publica no barramento tudo o que chega no canal de broadcast (bloqueante)
+func Pump(ctx context.Context, broadcast <-chan interface{}, ps PubSub, onError func(error)) {
+	for {
+		select {
+		case msg := <-broadcast:
+			if err := ps.Publish(ctx, msg); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// --- EM MEMÓRIA ---
+
+// Fila de cada assinante em memória
+const memoryBuffer = 1024
+
+// MemoryPubSub: Barramento local (uma instância)
+type MemoryPubSub struct {
+	mu     sync.Mutex
+	lastID int64
+	subs   map[chan Event]bool
+}
+
+// Construtor: IDs partem do relógio para continuarem crescendo após reinício
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{
+		lastID: firstEventID(),
+		subs:   make(map[chan Event]bool),
+	}
+}
+
+func (m *MemoryPubSub) Publish(ctx context.Context, msg interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ev, err := NewEvent(m.lastID+1, msg)
+	if err != nil {
+		return err
+	}
+	m.lastID = ev.ID
+
+	// Entrega sob o lock: a ordem de ID é a ordem de chegada. Bloqueia (não
+	// descarta) se um assinante estiver atrasado.
+	for ch := range m.subs {
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPubSub) Subscribe(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, memoryBuffer)
+	m.mu.Lock()
+	m.subs[ch] = true
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// Close pode já ter encerrado a assinatura
+		if m.subs[ch] {
+			delete(m.subs, ch)
+			close(ch)
+		}
+	}()
+	return ch, nil
+}
+
+func (m *MemoryPubSub) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subs {
+		delete(m.subs, ch)
+		close(ch)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package realtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- REDIS ---
+
+// Chaves padrão (prefixo configurável para separar ambientes no mesmo Redis)
+const (
+	redisSeqSuffix     = ":event_id"
+	redisChannelSuffix = ":events"
+	redisBuffer        = 1024
+)
+
+// publishScript: Numera e publica de forma atômica, então a ordem de chegada
+// nas réplicas é sempre a ordem dos IDs
+var publishScript = redis.NewScript(`
+local id = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', KEYS[2], id .. ' ' .. ARGV[1])
+return id
+`)
+
+// RedisPubSub: Barramento compartilhado entre réplicas
+type RedisPubSub struct {
+	Client  *redis.Client
+	seqKey  string
+	channel string
+}
+
+// Construtor: url no formato redis://[:senha@]host:porta/db
+func NewRedisPubSub(ctx context.Context, url, prefix string) (*RedisPubSub, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL inválida: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	r := &RedisPubSub{
+		Client:  client,
+		seqKey:  prefix + redisSeqSuffix,
+		channel: prefix + redisChannelSuffix,
+	}
+	// Sequência nova parte do relógio (mesmo critério da versão em memória)
+	if err := client.SetNX(ctx, r.seqKey, firstEventID(), 0).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RedisPubSub) Publish(ctx context.Context, msg interface{}) error {
+	// O ID só existe depois do INCR; vai na frente do payload e é preenchido no assinante
+	ev, err := NewEvent(0, msg)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return publishScript.Run(ctx, r.Client, []string{r.seqKey, r.channel}, payload).Err()
+}
+
+func (r *RedisPubSub) Subscribe(ctx context.Context) (<-chan Event, error) {
+	sub := r.Client.Subscribe(ctx, r.channel)
+	// Confirma a assinatura antes de retornar (nenhum evento posterior se perde)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	out := make(chan Event, redisBuffer)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		// A biblioteca reconecta sozinha se a conexão com o Redis cair
+		msgs := sub.Channel(redis.WithChannelSize(redisBuffer))
+		for {
+			select {
+			case m, ok := <-msgs:
+				if !ok {
+					return
+				}
+				ev, err := decodeRedisEvent(m.Payload)
+				if err != nil {
+					log.Printf("Erro ao decodificar evento do Redis: %v", err)
+					continue
+				}
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (r *RedisPubSub) Close() error {
+	return r.Client.Close()
+}
+
+// decodeRedisEvent: "<id> <json do Event>"
+func decodeRedisEvent(payload string) (Event, error) {
+	raw := []byte(payload)
+	i := bytes.IndexByte(raw, ' ')
+	if i <= 0 {
+		return Event{}, fmt.Errorf("payload sem ID")
+	}
+	id, err := strconv.ParseInt(string(raw[:i]), 10, 64)
+	if err != nil {
+		return Event{}, err
+	}
+	var ev Event
+	if err := json.Unmarshal(raw[i+1:], &ev); err != nil {
+		return Event{}, err
+	}
+	ev.ID = id
+	return ev, nil
+}
@@ -0,0 +1,161 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedis: Duas "réplicas" apontando para o mesmo Redis
+func newTestRedis(t *testing.T) (a, b *RedisPubSub) {
+	t.Helper()
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	for _, ps := range []**RedisPubSub{&a, &b} {
+		bus, err := NewRedisPubSub(ctx, "redis://"+srv.Addr(), "teste")
+		if err != nil {
+			t.Fatalf("NewRedisPubSub: %v", err)
+		}
+		t.Cleanup(func() { bus.Close() })
+		*ps = bus
+	}
+	return a, b
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("evento não chegou")
+	}
+	return Event{}
+}
+
+func TestRedisPubSubSharesSequenceAcrossReplicas(t *testing.T) {
+	a, b := newTestRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subA, err := a.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	subB, err := b.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if err := a.Publish(ctx, map[string]interface{}{"type": "NEW_MESSAGE", "device_id": 10}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := b.Publish(ctx, map[string]interface{}{"type": "ALERT_UPDATE", "device_id": 20}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	// As duas réplicas veem os dois eventos, na mesma ordem e com IDs consecutivos
+	for _, sub := range []<-chan Event{subA, subB} {
+		first, second := nextEvent(t, sub), nextEvent(t, sub)
+		if first.Type != "NEW_MESSAGE" || !first.HasDevice || first.DeviceID != 10 {
+			t.Fatalf("primeiro evento inesperado: %+v", first)
+		}
+		if second.Type != "ALERT_UPDATE" || second.ID != first.ID+1 {
+			t.Fatalf("segundo evento inesperado: %+v (primeiro %d)", second, first.ID)
+		}
+	}
+}
+
+// mutablePermissions: Permissões que o teste altera entre um refresh e outro
+type mutablePermissions struct {
+	mu      sync.Mutex
+	devices map[int]map[int]bool
+}
+
+func (p *mutablePermissions) load(userID int) map[int]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[int]bool)
+	for id := range p.devices[userID] {
+		out[id] = true
+	}
+	return out
+}
+
+func (p *mutablePermissions) set(userID int, devices ...int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.devices[userID] = make(map[int]bool)
+	for _, id := range devices {
+		p.devices[userID][id] = true
+	}
+}
+
+func TestHubControlReachesOtherReplica(t *testing.T) {
+	busA, busB := newTestRedis(t)
+	perms := &mutablePermissions{devices: map[int]map[int]bool{}}
+	perms.set(1, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hubA := NewHub(perms.load, nil)
+	hubA.Bus = busA
+	hubB := NewHub(perms.load, nil)
+	hubB.Bus = busB
+	go hubA.Run(ctx)
+	go hubB.Run(ctx)
+
+	// O usuário 1 está conectado só na réplica B
+	c := newTestClient(hubB, Identity{UserID: 1}, 16)
+	hubB.register <- c
+	hubB.subscribe <- subscriptionChange{client: c, action: ActionSubscribe, topics: []topic{{kind: "*"}}}
+	<-c.send // SUBSCRIPTIONS
+
+	// Espera as duas réplicas assinarem o canal
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if n, _ := busA.Client.PubSubNumSub(ctx, busA.channel).Result(); n[busA.channel] == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("réplicas não assinaram o barramento")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Permissão nova aplicada pela réplica A chega ao cliente da réplica B
+	perms.set(1, 10, 20)
+	hubA.RefreshUser(1)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		busA.Publish(ctx, map[string]interface{}{"type": "NEW_MESSAGE", "device_id": 20})
+		select {
+		case f := <-c.send:
+			if f.id == 0 {
+				t.Fatalf("mensagem inesperada: %s", f.data)
+			}
+		case <-time.After(50 * time.Millisecond):
+			if time.Now().After(deadline) {
+				t.Fatal("RefreshUser da réplica A não chegou à réplica B")
+			}
+			continue
+		}
+		break
+	}
+
+	// Desconexão pedida na réplica A derruba a conexão da réplica B
+	hubA.DisconnectUser(1)
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-c.send:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("DisconnectUser da réplica A não chegou à réplica B")
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package realtime
+
+import "time"
+
+// --- IDS DE EVENTO E REPLAY ---
+//
+// Todo evento recebe um "event_id" crescente atribuído pelo PubSub e fica num
+// buffer circular em cada Hub. O cliente que reconecta informa o último ID visto
+// (resume_from) e recebe o que perdeu. Se o buffer já não cobre a lacuna (ou o
+// servidor reiniciou), recebe RESYNC_REQUIRED e deve recarregar pela API REST.
+
+// Quantidade de eventos mantidos para replay
+const DefaultReplaySize = 1000
+
+// bufferedEvent: Evento já serializado, com os campos usados na filtragem
+type bufferedEvent struct {
+	id        int64
+	eventType string
+	deviceID  int
+	hasDevice bool
+	data      []byte
+}
+
+// firstEventID: Os IDs partem do relógio (microssegundos) para continuarem
+// crescendo após um reinício do servidor
+func firstEventID() int64 {
+	return time.Now().UnixMicro()
+}
+
+// record: Guarda o evento (já numerado pelo PubSub) no buffer de replay
+func (h *Hub) record(e Event) bufferedEvent {
+	ev := bufferedEvent{
+		id:        e.ID,
+		eventType: e.Type,
+		deviceID:  e.DeviceID,
+		hasDevice: e.HasDevice,
+		data:      withEventID(e.Data, e.ID),
+	}
+	if e.ID > h.lastID {
+		h.lastID = e.ID
+	}
+
+	if h.ReplaySize > 0 {
+		if len(h.replay) >= h.ReplaySize {
+			copy(h.replay, h.replay[1:])
+			h.replay = h.replay[:len(h.replay)-1]
+		}
+		h.replay = append(h.replay, ev)
+	}
+	return ev
+}
+
+// replayTo: Reenvia ao cliente os eventos posteriores a "from" que ele pode ver
+func (h *Hub) replayTo(c *Client, from int64) {
+	if from == h.lastID {
+		return
+	}
+	oldest := h.lastID + 1
+	if len(h.replay) > 0 {
+		oldest = h.replay[0].id
+	}
+	if from > h.lastID || from+1 < oldest {
+		h.reply(c, map[string]interface{}{
+			"type":          "RESYNC_REQUIRED",
+			"last_event_id": h.lastID,
+		})
+		return
+	}
+	for _, ev := range h.replay {
+		if ev.id > from && c.wants(ev) {
+			if !h.enqueue(c, frame{id: ev.id, data: ev.data}) {
+				return
+			}
+		}
+	}
+}
+
+// wants: Permissão + inscrição
+func (c *Client) wants(ev bufferedEvent) bool {
+	if !c.SeesAll && (!ev.hasDevice || !c.devices[ev.deviceID]) {
+		return false
+	}
+	return c.subs.matches(ev.eventType, ev.deviceID, ev.hasDevice)
+}
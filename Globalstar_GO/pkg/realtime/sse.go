@@ -0,0 +1,111 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// --- SERVER-SENT EVENTS ---
+//
+// Alternativa ao WebSocket para redes que bloqueiam o upgrade. O cliente SSE
+// passa pelo mesmo Hub (permissões, tópicos, IDs e replay), mas como o canal é
+// só de descida os tópicos são fixados na abertura. Cada evento sai como
+//
+//	id: <event_id>
+//	data: <json>
+//
+// e o navegador/cliente reenvia o último id no cabeçalho Last-Event-ID ao reconectar.
+
+// Intervalo de reconexão sugerido ao cliente (ms)
+const sseRetry = 3000
+
+// ServeStream: Mantém a resposta SSE aberta até o cliente sair. Sem tópicos,
+// assina "*". lastEventID > 0 pede o replay do que foi perdido. Só retorna erro
+// antes de escrever a resposta (tópico inválido), para o handler responder 400.
+func (h *Hub) ServeStream(w http.ResponseWriter, r *http.Request, id Identity, topics []string, lastEventID int64) error {
+	if len(topics) == 0 {
+		topics = []string{"*"}
+	}
+	ch := subscriptionChange{
+		action:       ActionSubscribe,
+		resumeFrom:   lastEventID,
+		groupDevices: make(map[int]map[int]bool),
+	}
+	for _, raw := range topics {
+		t, err := parseTopic(raw)
+		if err != nil {
+			return err
+		}
+		if t.kind == "group" && h.GroupDevices != nil {
+			ch.groupDevices[t.id] = h.GroupDevices(t.id)
+		}
+		ch.topics = append(ch.topics, t)
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Proxies (nginx) não devem segurar os eventos em buffer
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(format string, args ...interface{}) bool {
+		// Prazo por escrita (substitui o WriteTimeout global do servidor)
+		if err := rc.SetWriteDeadline(time.Now().Add(writeWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !write("retry: %d\n\n", sseRetry) {
+		return nil
+	}
+
+	c := &Client{
+		hub:      h,
+		send:     make(chan frame, sendBuffer+h.ReplaySize),
+		Identity: id,
+		subs:     newSubscriptions(),
+	}
+	if !id.SeesAll {
+		c.devices = h.Permissions(id.UserID)
+	}
+	ch.client = c
+	h.register <- c
+	h.subscribe <- ch
+	defer func() { h.unregister <- c }()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case f, ok := <-c.send:
+			if !ok {
+				// Hub removeu o cliente (fila cheia ou usuário desconectado)
+				return nil
+			}
+			if f.id > 0 {
+				if !write("id: %d\ndata: %s\n\n", f.id, f.data) {
+					return nil
+				}
+			} else if !write("data: %s\n\n", f.data) {
+				return nil
+			}
+
+		case <-ticker.C:
+			// Comentário SSE: mantém proxies e balanceadores com a conexão viva
+			if !write(": ping\n\n") {
+				return nil
+			}
+
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseServer: ServeStream do usuário ?user=<id>, retomando do cabeçalho Last-Event-ID
+func sseServer(t *testing.T, h *Hub) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user"))
+		lastEventID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		if err := h.ServeStream(w, r, Identity{UserID: userID}, nil, lastEventID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// readFrame: Campos de um bloco SSE (até a linha em branco)
+func readFrame(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("leitura do stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fields
+		}
+		if name, value, ok := strings.Cut(line, ": "); ok {
+			fields[name] = value
+		}
+	}
+}
+
+func TestServeStreamFramesAndReplay(t *testing.T) {
+	h, events := newTestHub(t)
+	srv := sseServer(t, h)
+
+	// Usuário 1 vê só o dispositivo 10; perdeu o que veio depois do 100
+	events <- deviceEvent(100, 10)
+	events <- deviceEvent(101, 20)
+	events <- deviceEvent(102, 10)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/?user=1", nil)
+	req.Header.Set("Last-Event-ID", "100")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	if f := readFrame(t, stream); f["retry"] != strconv.Itoa(sseRetry) {
+		t.Fatalf("esperava retry: %d, veio %v", sseRetry, f)
+	}
+	// Confirmação do protocolo: sem id, para não mexer no Last-Event-ID do cliente
+	if f := readFrame(t, stream); f["id"] != "" || !strings.Contains(f["data"], `"SUBSCRIPTIONS"`) {
+		t.Fatalf("esperava SUBSCRIPTIONS, veio %v", f)
+	}
+
+	// Replay da lacuna (o 101 é de outro dispositivo), depois os eventos novos
+	events <- deviceEvent(103, 20)
+	events <- deviceEvent(104, 10)
+	for _, id := range []int64{102, 104} {
+		f := readFrame(t, stream)
+		var msg map[string]interface{}
+		if f["id"] != strconv.FormatInt(id, 10) || json.Unmarshal([]byte(f["data"]), &msg) != nil {
+			t.Fatalf("esperava id: %d com data JSON, veio %v", id, f)
+		}
+		if msg["event_id"] != float64(id) || msg["device_id"] != float64(10) {
+			t.Fatalf("evento %d inesperado: %v", id, msg)
+		}
+	}
+}
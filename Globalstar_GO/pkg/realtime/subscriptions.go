@@ -0,0 +1,167 @@
+package realtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- PROTOCOLO CLIENTE -> SERVIDOR ---
+//
+//	{"action": "subscribe",   "topics": ["device:12", "event:ALERT_UPDATE"]}
+//	{"action": "unsubscribe", "topics": ["device:12"]}
+//	{"action": "subscribe",   "topics": ["*"], "resume_from": 1712345678901234}
+//
+// Tópicos:
+//
+//	"*"              tudo o que o usuário tem permissão de ver
+//	"device:<id>"    eventos de um dispositivo
+//	"group:<id>"     eventos dos dispositivos de um grupo
+//	"event:<TIPO>"   tipo de evento (NEW_MESSAGE, DEVICE_UPDATE, ALERT_UPDATE...)
+//
+// Tópicos de dispositivo/grupo e de tipo se combinam: "device:12" + "event:ALERT_UPDATE"
+// entrega só os alertas do dispositivo 12. Sem nenhum tópico, nada é entregue.
+
+// Ações aceitas
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// ClientMessage: Comando enviado pelo navegador
+type ClientMessage struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+	// Último event_id visto (opcional; alternativa ao ?resume_from= da conexão)
+	ResumeFrom int64 `json:"resume_from,omitempty"`
+}
+
+type topic struct {
+	kind string // "*", "device", "group", "event"
+	id   int
+	name string
+}
+
+func parseTopic(raw string) (topic, error) {
+	if raw == "*" {
+		return topic{kind: "*"}, nil
+	}
+	kind, value, ok := strings.Cut(raw, ":")
+	if !ok || value == "" {
+		return topic{}, fmt.Errorf("tópico inválido: %q", raw)
+	}
+	switch kind {
+	case "device", "group":
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return topic{}, fmt.Errorf("tópico inválido: %q", raw)
+		}
+		return topic{kind: kind, id: id}, nil
+	case "event":
+		return topic{kind: kind, name: strings.ToUpper(value)}, nil
+	}
+	return topic{}, fmt.Errorf("tópico inválido: %q", raw)
+}
+
+// subscriptions: Tópicos de um cliente (só acessado pela goroutine do Hub)
+type subscriptions struct {
+	all     bool
+	devices map[int]bool
+	groups  map[int]map[int]bool // grupo -> dispositivos
+	events  map[string]bool
+}
+
+func newSubscriptions() subscriptions {
+	return subscriptions{
+		devices: make(map[int]bool),
+		groups:  make(map[int]map[int]bool),
+		events:  make(map[string]bool),
+	}
+}
+
+// subscriptionChange: Pedido de inscrição/cancelamento repassado ao Hub
+type subscriptionChange struct {
+	client *Client
+	action string
+	topics []topic
+	// Dispositivos de cada grupo citado (resolvidos fora da goroutine do Hub)
+	groupDevices map[int]map[int]bool
+	resumeFrom   int64
+	// Comando rejeitado: só devolve o erro ao cliente
+	err string
+}
+
+func (s *subscriptions) apply(ch subscriptionChange) {
+	add := ch.action == ActionSubscribe
+	for _, t := range ch.topics {
+		switch t.kind {
+		case "*":
+			s.all = add
+		case "device":
+			if add {
+				s.devices[t.id] = true
+			} else {
+				delete(s.devices, t.id)
+			}
+		case "group":
+			if add {
+				s.groups[t.id] = ch.groupDevices[t.id]
+			} else {
+				delete(s.groups, t.id)
+			}
+		case "event":
+			if add {
+				s.events[t.name] = true
+			} else {
+				delete(s.events, t.name)
+			}
+		}
+	}
+}
+
+// list: Tópicos ativos (para a confirmação enviada ao cliente)
+func (s *subscriptions) list() []string {
+	topics := make([]string, 0)
+	if s.all {
+		topics = append(topics, "*")
+	}
+	for id := range s.devices {
+		topics = append(topics, "device:"+strconv.Itoa(id))
+	}
+	for id := range s.groups {
+		topics = append(topics, "group:"+strconv.Itoa(id))
+	}
+	for name := range s.events {
+		topics = append(topics, "event:"+name)
+	}
+	return topics
+}
+
+// matches: O evento (já autorizado) interessa ao cliente?
+func (s *subscriptions) matches(eventType string, deviceID int, hasDevice bool) bool {
+	if s.all {
+		return true
+	}
+	scoped := len(s.devices) > 0 || len(s.groups) > 0
+	if !scoped && len(s.events) == 0 {
+		return false
+	}
+	if len(s.events) > 0 && !s.events[eventType] {
+		return false
+	}
+	if !scoped {
+		return true
+	}
+	if !hasDevice {
+		return false
+	}
+	if s.devices[deviceID] {
+		return true
+	}
+	for _, members := range s.groups {
+		if members[deviceID] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Segredo SHA-1 do Apêndice B da RFC 6238 ("12345678901234567890")
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// Vetores do Apêndice B (8 dígitos); os 6 últimos são o código de 6 dígitos
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeAtRFC6238Vectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt: %v", err)
+		}
+		if want := v.code[len(v.code)-Digits:]; got != want {
+			t.Errorf("T=%d: código %s, esperado %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	code, _ := CodeAt(rfcSecret, Step(at))
+
+	cases := []struct {
+		name string
+		t    time.Time
+		ok   bool
+	}{
+		{"mesmo passo", at, true},
+		{"relógio do servidor um passo adiantado", at.Add(Period), true},
+		{"relógio do servidor um passo atrasado", at.Add(-Period), true},
+		{"dois passos de diferença", at.Add(2 * Period), false},
+	}
+	for _, c := range cases {
+		step, ok := Validate(rfcSecret, code, c.t)
+		if ok != c.ok {
+			t.Errorf("%s: ok = %v, esperado %v", c.name, ok, c.ok)
+		}
+		if ok && step != Step(at) {
+			t.Errorf("%s: passo aceito %d, esperado %d", c.name, step, Step(at))
+		}
+	}
+
+	// Espaços (como os apps exibem) são ignorados; tamanho errado nunca passa
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], at); !ok {
+		t.Error("código com espaço deveria ser aceito")
+	}
+	if _, ok := Validate(rfcSecret, code[1:], at); ok {
+		t.Error("código com 5 dígitos não pode ser aceito")
+	}
+}
+
+func TestSecretCaseAndInvalid(t *testing.T) {
+	want, _ := CodeAt(rfcSecret, 1)
+	if got, err := CodeAt(" "+strings.ToLower(rfcSecret)+" ", 1); err != nil || got != want {
+		t.Errorf("segredo em minúsculas: %q, %v (esperado %q)", got, err, want)
+	}
+	if _, err := CodeAt("não-é-base32!", 1); err == nil {
+		t.Error("segredo inválido deveria dar erro")
+	}
+	secret, err := GenerateSecret()
+	if err != nil || len(secret) != 32 {
+		t.Errorf("GenerateSecret: %q, %v (esperado 32 caracteres base32)", secret, err)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"iot_modulo1.0/pkg/notify"
+)
+
+// --- PERFIL DO PRÓPRIO USUÁRIO ---
+// /api/me lê e altera os dados de contato de quem está logado (qualquer papel).
+// Nome de usuário, papel e organização continuam só com o admin. Trocar o
+// e-mail exige a senha atual e não vale na hora: um link de confirmação vai para
+// o endereço novo e só depois dele o e-mail muda (o antigo é avisado do pedido e
+// da troca). Trocar a senha exige a senha atual e encerra as outras sessões.
+
+const emailVerificationTTL = 24 * time.Hour
+
+type Profile struct {
+	ID                int      `json:"id"`
+	Username          string   `json:"username"`
+	FullName          string   `json:"full_name"`
+	Email             string   `json:"email"`
+	Phone             string   `json:"phone"`
+	Address           string   `json:"address"`
+	City              string   `json:"city"`
+	State             string   `json:"state"`
+	Role              string   `json:"role"`
+	OrganizationID    int      `json:"organization_id"`
+	Permissions       []string `json:"permissions"`
+	TOTPEnabled       bool     `json:"totp_enabled"`
+	PasswordChangedAt string   `json:"password_changed_at"`
+	// Novo e-mail aguardando confirmação ("" se nenhum)
+	PendingEmail string `json:"pending_email"`
+}
+
+// ProfileRequest: PATCH parcial (campo ausente = não muda)
+type ProfileRequest struct {
+	FullName *string `json:"full_name"`
+	Email    *string `json:"email"`
+	Phone    *string `json:"phone"`
+	Address  *string `json:"address"`
+	City     *string `json:"city"`
+	State    *string `json:"state"`
+	// Obrigatória só para trocar o e-mail
+	CurrentPassword string `json:"current_password"`
+}
+
+func loadProfile(userID int) (Profile, error) {
+	var p Profile
+	var changedAt sql.NullTime
+	err := db.QueryRow(`SELECT u.id, u.username, COALESCE(u.full_name, ''), COALESCE(u.email, ''), COALESCE(u.phone, ''),
+		COALESCE(u.address, ''), COALESCE(u.city, ''), COALESCE(u.state, ''), u.role, COALESCE(u.organization_id, 0),
+		u.totp_enabled, u.password_changed_at,
+		COALESCE((SELECT v.new_email FROM email_verifications v WHERE v.user_id = u.id AND v.expires_at > NOW()
+			ORDER BY v.id DESC LIMIT 1), '')
+		FROM users u WHERE u.id = ?`, userID).
+		Scan(&p.ID, &p.Username, &p.FullName, &p.Email, &p.Phone, &p.Address, &p.City, &p.State, &p.Role,
+			&p.OrganizationID, &p.TOTPEnabled, &changedAt, &p.PendingEmail)
+	p.Permissions = permissionsOf(p.Role)
+	p.PasswordChangedAt = formatNullTime(changedAt)
+	return p, err
+}
+
+// meHandler: GET devolve o perfil; PATCH altera nome e contatos (e-mail passa pela confirmação)
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	current, err := loadProfile(userID)
+	if err != nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(current)
+		return
+	}
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	// Campo -> (destino, valor enviado, limite da coluna)
+	updated := current
+	fields := []struct {
+		label  string
+		target *string
+		value  *string
+		max    int
+	}{
+		{"nome", &updated.FullName, req.FullName, 100},
+		{"telefone", &updated.Phone, req.Phone, 20},
+		{"endereço", &updated.Address, req.Address, 255},
+		{"cidade", &updated.City, req.City, 100},
+		{"estado", &updated.State, req.State, 2},
+	}
+	var changed []string
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		v := strings.TrimSpace(*f.value)
+		if len([]rune(v)) > f.max {
+			http.Error(w, fmt.Sprintf("Campo %s excede %d caracteres", f.label, f.max), http.StatusBadRequest)
+			return
+		}
+		if v != *f.target {
+			*f.target = v
+			changed = append(changed, f.label)
+		}
+	}
+
+	var newEmail string
+	if req.Email != nil {
+		newEmail = strings.ToLower(strings.TrimSpace(*req.Email))
+		if newEmail == strings.ToLower(current.Email) {
+			newEmail = ""
+		} else if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail || len(newEmail) > 100 {
+			http.Error(w, "E-mail inválido", http.StatusBadRequest)
+			return
+		} else if _, ok := verifyCurrentPassword(w, r, userID, req.CurrentPassword, AuditEmailChangeFailed); !ok {
+			// Sessão roubada não redireciona a recuperação de senha para outro endereço
+			return
+		} else if emailInUse(newEmail, userID) {
+			// O e-mail identifica a conta na recuperação de senha
+			http.Error(w, "E-mail já está em uso", http.StatusConflict)
+			return
+		}
+	}
+
+	if len(changed) > 0 {
+		_, err := db.Exec("UPDATE users SET full_name = ?, phone = ?, address = ?, city = ?, state = ? WHERE id = ?",
+			updated.FullName, updated.Phone, updated.Address, updated.City, updated.State, userID)
+		if err != nil {
+			http.Error(w, "Erro ao salvar perfil", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, AuditEvent{Action: AuditProfileUpdated, Target: auditTarget(TargetUser, userID),
+			Details: "Atualizou o próprio perfil: " + strings.Join(changed, ", "), Changes: diffChanges(current, updated)})
+	}
+	if newEmail != "" {
+		if err := requestEmailChange(updated, newEmail); err != nil {
+			http.Error(w, "Erro ao enviar confirmação de e-mail", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, AuditEvent{Action: AuditEmailChangeRequested, Target: auditTarget(TargetUser, userID),
+			Details: fmt.Sprintf("Pediu troca de e-mail de %q para %q (aguardando confirmação)", current.Email, newEmail)})
+	}
+
+	profile, _ := loadProfile(userID)
+	json.NewEncoder(w).Encode(profile)
+}
+
+// emailInUse: Outra conta já usa o e-mail?
+func emailInUse(email string, exceptUserID int) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = ? AND id != ?", email, exceptUserID).Scan(&n)
+	return n > 0
+}
+
+// requestEmailChange: Guarda o pedido (só o hash do token) e manda o link para o endereço novo
+func requestEmailChange(p Profile, newEmail string) error {
+	token, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	// Um pedido pendente por vez: o último vale
+	db.Exec("DELETE FROM email_verifications WHERE user_id = ?", p.ID)
+	_, err = db.Exec("INSERT INTO email_verifications (user_id, new_email, token_hash, expires_at) VALUES (?, ?, ?, ?)",
+		p.ID, newEmail, hashToken(token), time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return err
+	}
+
+	if !notifier.Has(notify.ChannelEmail) {
+		log.Printf("Aviso: SMTP não configurado. Token de confirmação de e-mail para %s: %s", newEmail, token)
+		return nil
+	}
+	err = notifier.Enqueue(notify.Notification{
+		UserID:   p.ID,
+		Channel:  notify.ChannelEmail,
+		To:       newEmail,
+		Template: "email_verification",
+		Data: map[string]interface{}{
+			"Name": p.FullName,
+			"Link": fmt.Sprintf("%s/verify-email?token=%s", os.Getenv("FRONTEND_URL"), token),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// O dono do endereço atual fica sabendo já no pedido, antes da confirmação
+	if p.Email != "" {
+		err := notifier.Enqueue(notify.Notification{
+			UserID:   p.ID,
+			Channel:  notify.ChannelEmail,
+			To:       p.Email,
+			Template: "email_change_requested",
+			Data:     map[string]interface{}{"Name": p.FullName, "NewEmail": newEmail},
+		})
+		if err != nil {
+			log.Printf("Aviso: Falha ao avisar o e-mail atual: %v", err)
+		}
+	}
+	return nil
+}
+
+// verifyEmailHandler: Confirma a troca pelo token do link (POST /api/me/email/verify, público)
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var newEmail string
+	var expiresAt time.Time
+	err := db.QueryRow("SELECT user_id, new_email, expires_at FROM email_verifications WHERE token_hash = ?", hashToken(req.Token)).
+		Scan(&userID, &newEmail, &expiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		http.Error(w, "Link inválido ou expirado", http.StatusBadRequest)
+		return
+	}
+
+	p, err := loadProfile(userID)
+	if err != nil {
+		http.Error(w, "Link inválido ou expirado", http.StatusBadRequest)
+		return
+	}
+	if emailInUse(newEmail, userID) {
+		http.Error(w, "E-mail já está em uso", http.StatusConflict)
+		return
+	}
+	if _, err := db.Exec("UPDATE users SET email = ? WHERE id = ?", newEmail, userID); err != nil {
+		http.Error(w, "Erro ao atualizar e-mail", http.StatusInternalServerError)
+		return
+	}
+	db.Exec("DELETE FROM email_verifications WHERE user_id = ?", userID)
+	// Links de recuperação enviados ao endereço antigo deixam de valer
+	if p.Email != "" {
+		db.Exec("DELETE FROM password_resets WHERE email = ?", p.Email)
+	}
+
+	if p.Email != "" && notifier.Has(notify.ChannelEmail) {
+		err := notifier.Enqueue(notify.Notification{
+			UserID:   userID,
+			Channel:  notify.ChannelEmail,
+			To:       p.Email,
+			Template: "email_changed",
+			Data:     map[string]interface{}{"Name": p.FullName, "NewEmail": newEmail},
+		})
+		if err != nil {
+			log.Printf("Aviso: Falha ao avisar o e-mail antigo: %v", err)
+		}
+	}
+
+	recordAudit(r, AuditEvent{ActorID: userID, Actor: p.Username, Action: AuditEmailChanged, Target: auditTarget(TargetUser, userID),
+		Details: fmt.Sprintf("E-mail alterado de %q para %q (confirmado pelo link)", p.Email, newEmail),
+		Changes: AuditChanges{"email": {From: p.Email, To: newEmail}}})
+	w.WriteHeader(http.StatusOK)
+}
+
+// changePasswordHandler: Troca a própria senha com a senha atual (POST /api/me/password)
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	username, ok := verifyCurrentPassword(w, r, userID, req.CurrentPassword, AuditPasswordChangeFailed)
+	if !ok {
+		return
+	}
+	if violations := checkPassword(userID, username, req.NewPassword); len(violations) > 0 {
+		writePasswordPolicyError(w, violations)
+		return
+	}
+
+	if err := setPassword(userID, req.NewPassword, false); err != nil {
+		http.Error(w, "Erro ao atualizar senha", http.StatusInternalServerError)
+		return
+	}
+	// A sessão atual continua; as outras precisam entrar com a senha nova
+	n, _ := revokeOtherSessions(userID, r.Header.Get("X-Session-ID"))
+	recordAudit(r, AuditEvent{Action: AuditPasswordChanged, Target: auditTarget(TargetUser, userID),
+		Details: fmt.Sprintf("Senha alterada pelo próprio usuário; %d outra(s) sessão(ões) encerrada(s)", n)})
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyCurrentPassword: Confere a senha atual de quem está logado (troca de senha ou
+// de e-mail). Se falhar, já respondeu; o erro conta como falha de login (sessão
+// roubada não vira força bruta).
+func verifyCurrentPassword(w http.ResponseWriter, r *http.Request, userID int, password string, failed AuditAction) (username string, ok bool) {
+	var storedHash string
+	if err := db.QueryRow("SELECT username, password_hash FROM users WHERE id = ?", userID).Scan(&username, &storedHash); err != nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return "", false
+	}
+	ip, account := clientIP(r), accountKey(username)
+	if wait := loginWait(ip, account); wait > 0 {
+		tooManyAttempts(w, wait)
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)) != nil {
+		registerLoginFailure(r, userID, account)
+		recordAudit(r, AuditEvent{Action: failed, Target: auditTarget(TargetUser, userID), Details: "Senha atual incorreta"})
+		// 403 e não 401: o frontend trata 401 como sessão expirada
+		http.Error(w, "Senha atual incorreta", http.StatusForbidden)
+		return "", false
+	}
+	return username, true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// --- CONTROLE DE ACESSO POR PAPEL (RBAC) ---
+// Os handlers não comparam mais o papel diretamente: cada rota exige uma
+// permissão (requirePermission) e as decisões internas usam can().
+// Papéis (init.sql): master, admin, support, user.
+
+type Permission string
+
+const (
+	// Dispositivos vinculados ao usuário (user_permissions)
+	PermDevicesRead Permission = "devices:read"
+	// Todos os dispositivos da organização, sem precisar de vínculo
+	PermDevicesReadAll Permission = "devices:read_all"
+	PermDevicesRename  Permission = "devices:rename"
+	// Intervalo de reporte (vínculo "owner" ou devices:read_all)
+	PermDevicesConfigure Permission = "devices:configure"
+	// Grupos de dispositivos
+	PermDevicesManage Permission = "devices:manage"
+
+	PermUsersRead   Permission = "users:read"
+	PermUsersManage Permission = "users:manage"
+	// Vínculos usuário <-> dispositivo
+	PermPermissionsManage Permission = "permissions:manage"
+
+	PermAuditRead Permission = "audit:read"
+	// Integridade da cadeia de auditoria (abrange todas as organizações)
+	PermAuditVerify Permission = "audit:verify"
+
+	PermAlertsRead   Permission = "alerts:read"
+	PermAlertsAck    Permission = "alerts:ack"
+	PermAlertsManage Permission = "alerts:manage" // regras e políticas de escalonamento
+
+	PermGeofencesRead   Permission = "geofences:read"
+	PermGeofencesManage Permission = "geofences:manage"
+
+	PermNotificationsRead   Permission = "notifications:read" // histórico de envios
+	PermNotificationsManage Permission = "notifications:manage"
+
+	// Cadastro de organizações e visão de todas elas (sem isso, tudo fica
+	// restrito à organização do usuário, ver organizations_api.go)
+	PermOrgsManage Permission = "organizations:manage"
+
+	// Chaves de API da organização e de outros usuários (as próprias, todos criam)
+	PermAPIKeysManage Permission = "apikeys:manage"
+
+	// Provedores de login único (OIDC) da organização
+	PermSSOManage Permission = "sso:manage"
+
+	// Sessões de qualquer usuário (as próprias, todos gerenciam)
+	PermSessionsManage Permission = "sessions:manage"
+)
+
+// Papéis
+const (
+	RoleMaster  = "master"
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleUser    = "user"
+)
+
+// allPermissions: Tudo o que existe (papel master)
+var allPermissions = []Permission{
+	PermDevicesRead, PermDevicesReadAll, PermDevicesRename, PermDevicesConfigure, PermDevicesManage,
+	PermUsersRead, PermUsersManage, PermPermissionsManage,
+	PermAuditRead, PermAuditVerify,
+	PermAlertsRead, PermAlertsAck, PermAlertsManage,
+	PermGeofencesRead, PermGeofencesManage,
+	PermNotificationsRead, PermNotificationsManage,
+	PermOrgsManage,
+	PermAPIKeysManage,
+	PermSSOManage,
+	PermSessionsManage,
+}
+
+// userPermissions: O que cada vínculo permite de fato depende do nível (ver accessRank)
+var userPermissions = []Permission{
+	PermDevicesRead, PermDevicesRename, PermDevicesConfigure,
+	PermAlertsRead, PermAlertsAck,
+	PermGeofencesRead,
+}
+
+// rolePermissions: Política central. Admin administra usuários e a operação
+// da sua organização, mas não a plataforma (organizações, templates de
+// notificação, contas master).
+var rolePermissions = map[string][]Permission{
+	RoleMaster: allPermissions,
+	RoleAdmin: append([]Permission{
+		PermDevicesReadAll, PermDevicesManage,
+		PermUsersRead, PermUsersManage, PermPermissionsManage,
+		PermAuditRead,
+		PermAlertsManage,
+		PermGeofencesManage,
+		PermNotificationsRead,
+		PermAPIKeysManage,
+		PermSSOManage,
+	}, userPermissions...),
+	RoleSupport: {
+		PermDevicesRead, PermDevicesReadAll,
+		PermUsersRead,
+		PermAuditRead,
+		PermAlertsRead, PermAlertsAck,
+		PermGeofencesRead,
+		PermNotificationsRead,
+	},
+	RoleUser: userPermissions,
+}
+
+// --- NÍVEIS DE ACESSO POR DISPOSITIVO ---
+// Cada vínculo em user_permissions tem um nível. A permissão do papel libera a
+// rota; o nível do vínculo decide o que o usuário faz naquele dispositivo.
+// Papéis com devices:read_all não dependem de vínculo (contam como owner dentro
+// da organização) e ficam limitados só pelas permissões do papel.
+const (
+	AccessViewer   = "viewer"   // mensagens, status, alertas e eventos de geofence
+	AccessOperator = "operator" // + renomear, reconhecer/resolver alertas
+	AccessOwner    = "owner"    // + configurar (intervalo de reporte)
+)
+
+// accessRank: Ordem dos níveis (mesma ordem do FIELD() em hasDevicePermission)
+var accessRank = map[string]int{AccessViewer: 1, AccessOperator: 2, AccessOwner: 3}
+
+func validAccessLevel(level string) bool {
+	_, ok := accessRank[level]
+	return ok
+}
+
+// validRole: Papel conhecido pela política
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// roleHas: O papel concede a permissão?
+func roleHas(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// can: Atalho para o papel do usuário autenticado (definido pelo authMiddleware).
+// Com chave de API, vale só o que o papel E o escopo da chave permitem.
+func can(r *http.Request, perm Permission) bool {
+	if scope := r.Header.Get("X-API-Scope"); scope != "" && !scopeAllows(scope, perm) {
+		return false
+	}
+	return roleHas(r.Header.Get("X-User-Role"), perm)
+}
+
+// actorName: Username do autor nos logs de auditoria (chaves de API aparecem
+// com o ID da chave; chave da organização, sem dono, só com o ID)
+func actorName(r *http.Request) string {
+	username := r.Header.Get("X-Username")
+	if keyID := r.Header.Get("X-API-Key-ID"); keyID != "" {
+		if username == "" {
+			return "APIKey:" + keyID
+		}
+		return username + " (APIKey:" + keyID + ")"
+	}
+	if username == "" {
+		return "User:" + r.Header.Get("X-User-ID")
+	}
+	return username
+}
+
+// canManageRole: Só master cria, edita ou remove contas master (ou promove alguém a master)
+func canManageRole(actorRole, targetRole string) bool {
+	return actorRole == RoleMaster || targetRole != RoleMaster
+}
+
+// permissionsOf: Lista enviada ao frontend no login (para exibir só o que é permitido)
+func permissionsOf(role string) []string {
+	list := make([]string, 0, len(rolePermissions[role]))
+	for _, p := range rolePermissions[role] {
+		list = append(list, string(p))
+	}
+	sort.Strings(list)
+	return list
+}
+
+// requirePermission: Middleware (usar depois do authMiddleware)
+func requirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !can(r, perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// --- ESQUEMA DO BANCO ---
+// Tabelas criadas na inicialização (além das do init.sql), para que
+// instalações existentes recebam as novas funcionalidades sem migração manual.
+var schemaStatements = []string{
+	// Recuperação de senha
+	`CREATE TABLE IF NOT EXISTS password_resets (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		email VARCHAR(255) NOT NULL,
+		token VARCHAR(255) NOT NULL UNIQUE,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+	// Grupos de dispositivos (ex: "Pivôs Fazenda Norte")
+	`CREATE TABLE IF NOT EXISTS device_groups (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS device_group_members (
+		group_id INT NOT NULL,
+		device_id INT NOT NULL,
+		PRIMARY KEY (group_id, device_id),
+		FOREIGN KEY (group_id) REFERENCES device_groups(id) ON DELETE CASCADE,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+	)`,
+
+	// Regras de alerta (por dispositivo ou por grupo)
+	`CREATE TABLE IF NOT EXISTS alert_rules (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		device_id INT NULL,
+		group_id INT NULL,
+		metric VARCHAR(50) NOT NULL,
+		condition_type VARCHAR(20) NOT NULL, -- 'above', 'below', 'rate', 'changed'
+		threshold DOUBLE NOT NULL DEFAULT 0,
+		hysteresis DOUBLE NOT NULL DEFAULT 0,
+		cooldown_seconds INT NOT NULL DEFAULT 0,
+		severity VARCHAR(20) NOT NULL DEFAULT 'warning', -- 'info', 'warning', 'critical'
+		enabled TINYINT(1) NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE,
+		FOREIGN KEY (group_id) REFERENCES device_groups(id) ON DELETE CASCADE
+	)`,
+
+	// Instâncias de alerta
+	`CREATE TABLE IF NOT EXISTS alerts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		rule_id INT NULL,
+		device_id INT NOT NULL,
+		kind VARCHAR(30) NOT NULL DEFAULT 'RULE',
+		severity VARCHAR(20) NOT NULL,
+		state VARCHAR(20) NOT NULL DEFAULT 'open', -- 'open', 'acknowledged', 'resolved'
+		message VARCHAR(255),
+		metric_value DOUBLE NULL,
+		opened_at DATETIME NOT NULL,
+		acknowledged_at DATETIME NULL,
+		acknowledged_by INT NULL,
+		resolved_at DATETIME NULL,
+		INDEX idx_alerts_state (state),
+		FOREIGN KEY (rule_id) REFERENCES alert_rules(id) ON DELETE SET NULL,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+	)`,
+
+	// Notificações: preferências de contato, templates personalizados e histórico
+	`CREATE TABLE IF NOT EXISTS notification_preferences (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		channel VARCHAR(20) NOT NULL, -- 'email', 'webhook', 'sms'
+		address VARCHAR(500) NOT NULL,
+		enabled TINYINT(1) NOT NULL DEFAULT 1,
+		min_severity VARCHAR(20) NOT NULL DEFAULT 'warning',
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS notification_templates (
+		name VARCHAR(50) PRIMARY KEY,
+		subject VARCHAR(255) NOT NULL,
+		body TEXT NOT NULL,
+		is_html TINYINT(1) NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS notification_log (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NULL,
+		channel VARCHAR(20) NOT NULL,
+		address VARCHAR(500) NOT NULL,
+		template VARCHAR(50) NOT NULL,
+		subject VARCHAR(255),
+		body TEXT,
+		is_html TINYINT(1) NOT NULL DEFAULT 0,
+		payload TEXT,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'sent', 'failed'
+		attempts INT NOT NULL DEFAULT 0,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		sent_at DATETIME NULL,
+		INDEX idx_notification_status (status)
+	)`,
+
+	// Escalonamento de alertas: políticas, etapas e andamento por alerta
+	`CREATE TABLE IF NOT EXISTS escalation_policies (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		repeat_count INT NOT NULL DEFAULT 0,
+		is_default TINYINT(1) NOT NULL DEFAULT 0,
+		min_severity VARCHAR(20) NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS escalation_steps (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		policy_id INT NOT NULL,
+		step_order INT NOT NULL,
+		delay_minutes INT NOT NULL DEFAULT 0,
+		user_ids TEXT NOT NULL, -- JSON: [1, 2]
+		roles TEXT NOT NULL,    -- JSON: ["support"]
+		FOREIGN KEY (policy_id) REFERENCES escalation_policies(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS alert_escalations (
+		alert_id INT PRIMARY KEY,
+		policy_id INT NOT NULL,
+		next_step INT NOT NULL DEFAULT 0,
+		cycle INT NOT NULL DEFAULT 0,
+		next_at DATETIME NOT NULL,
+		FOREIGN KEY (alert_id) REFERENCES alerts(id) ON DELETE CASCADE,
+		FOREIGN KEY (policy_id) REFERENCES escalation_policies(id) ON DELETE CASCADE
+	)`,
+
+	// Geofences (GeoJSON) vinculadas a dispositivos ou grupos, e eventos de entrada/saída
+	`CREATE TABLE IF NOT EXISTS geofences (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		geojson MEDIUMTEXT NOT NULL,
+		alert_on_exit TINYINT(1) NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS geofence_links (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		geofence_id INT NOT NULL,
+		device_id INT NULL,
+		group_id INT NULL,
+		FOREIGN KEY (geofence_id) REFERENCES geofences(id) ON DELETE CASCADE,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE,
+		FOREIGN KEY (group_id) REFERENCES device_groups(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS geofence_events (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		geofence_id INT NOT NULL,
+		device_id INT NOT NULL,
+		event VARCHAR(10) NOT NULL, -- 'enter', 'exit'
+		lat DOUBLE NOT NULL,
+		lon DOUBLE NOT NULL,
+		occurred_at DATETIME NOT NULL,
+		INDEX idx_geofence_events_device (device_id),
+		FOREIGN KEY (geofence_id) REFERENCES geofences(id) ON DELETE CASCADE,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+	)`,
+
+	// Refresh tokens (só o SHA-256 é guardado). Cada uso gera um novo token na
+	// mesma família; reapresentar um token já trocado revoga a família inteira.
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		family_id CHAR(36) NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		revoked_at DATETIME NULL,
+		INDEX idx_refresh_tokens_family (family_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+
+	// Sessões de login: uma por família de refresh token (id = family_id)
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id CHAR(36) PRIMARY KEY,
+		user_id INT NOT NULL,
+		ip_address VARCHAR(45),
+		user_agent VARCHAR(255),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		revoked_at DATETIME NULL,
+		INDEX idx_sessions_user (user_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+
+	// Organizações (fazendas, empresas): donas de usuários, dispositivos, grupos,
+	// cercas e políticas. organization_id NULL = organização padrão.
+	`CREATE TABLE IF NOT EXISTS organizations (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+	// 2FA: códigos de recuperação de uso único (só o SHA-256 é guardado)
+	`CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		code_hash CHAR(64) NOT NULL,
+		used_at DATETIME NULL,
+		INDEX idx_totp_recovery_codes_user (user_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	// Senhas anteriores (hash), para impedir a reutilização
+	`CREATE TABLE IF NOT EXISTS password_history (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		password_hash VARCHAR(255) NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_password_history_user (user_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+
+	// Troca de e-mail pelo próprio usuário: vale só depois do link de confirmação
+	`CREATE TABLE IF NOT EXISTS email_verifications (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		new_email VARCHAR(100) NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+
+	// Desafios de uso único do login: segundo fator ('verify', 'enroll'), ticket
+	// do SSO ('sso') e troca obrigatória de senha ('password')
+	`CREATE TABLE IF NOT EXISTS mfa_challenges (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		purpose VARCHAR(10) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+
+	// Contadores anti força bruta por IP/conta (login e recuperação de senha)
+	`CREATE TABLE IF NOT EXISTS auth_throttle (
+		scope VARCHAR(20) NOT NULL, -- 'login_ip', 'login_user', 'reset_ip', 'reset_user'
+		subject VARCHAR(255) NOT NULL,
+		hits INT NOT NULL DEFAULT 0,
+		window_start DATETIME NOT NULL,
+		blocked_until DATETIME NULL,
+		PRIMARY KEY (scope, subject)
+	)`,
+
+	// Chaves de API: pessoais (user_id) ou da organização (user_id NULL).
+	// Só o SHA-256 é guardado; prefix é o início da chave, para exibição.
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		user_id INT NULL,
+		organization_id INT NULL,
+		scope VARCHAR(10) NOT NULL DEFAULT 'read', -- 'read', 'write'
+		created_by INT NULL,
+		expires_at DATETIME NULL,
+		last_used_at DATETIME NULL,
+		last_used_ip VARCHAR(45) NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		revoked_at DATETIME NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+
+	// Login único (OIDC): provedor por organização, identidades vinculadas
+	// (sub do IdP -> usuário) e o state/nonce/PKCE de cada login em andamento
+	`CREATE TABLE IF NOT EXISTS sso_providers (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		organization_id INT NULL,
+		name VARCHAR(100) NOT NULL,
+		issuer VARCHAR(255) NOT NULL,
+		client_id VARCHAR(255) NOT NULL,
+		client_secret VARCHAR(255) NOT NULL DEFAULT '',
+		email_domains VARCHAR(255) NOT NULL DEFAULT '',
+		role_claim VARCHAR(50) NOT NULL DEFAULT '',
+		role_mapping TEXT,
+		default_role VARCHAR(20) NOT NULL DEFAULT 'user',
+		jit_enabled TINYINT(1) NOT NULL DEFAULT 1,
+		enabled TINYINT(1) NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS sso_identities (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		provider_id INT NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login_at DATETIME NULL,
+		UNIQUE KEY uq_sso_identity (provider_id, subject),
+		FOREIGN KEY (provider_id) REFERENCES sso_providers(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS sso_states (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		state_hash CHAR(64) NOT NULL UNIQUE,
+		provider_id INT NOT NULL,
+		nonce VARCHAR(64) NOT NULL,
+		code_verifier VARCHAR(64) NOT NULL,
+		expires_at DATETIME NOT NULL,
+		FOREIGN KEY (provider_id) REFERENCES sso_providers(id) ON DELETE CASCADE
+	)`,
+
+	// Cadeia de auditoria (pkg/auditchain): cabeça em linha única, travada a
+	// cada inserção, e checkpoints assinados
+	`CREATE TABLE IF NOT EXISTS audit_chain_head (
+		id TINYINT PRIMARY KEY,
+		last_seq BIGINT NOT NULL DEFAULT 0,
+		last_hash CHAR(64) NOT NULL DEFAULT ''
+	)`,
+	`INSERT IGNORE INTO audit_chain_head (id, last_seq, last_hash) VALUES (1, 0, '')`,
+	`CREATE TABLE IF NOT EXISTS audit_checkpoints (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		seq BIGINT NOT NULL,
+		entry_hash CHAR(64) NOT NULL,
+		key_id CHAR(16) NOT NULL,
+		signature VARCHAR(128) NOT NULL,
+		signed_at DATETIME NOT NULL,
+		INDEX idx_audit_checkpoints_seq (seq)
+	)`,
+}
+
+// Colunas adicionadas a tabelas já existentes: tabela, coluna, definição
+var schemaColumns = [][3]string{
+	// Intervalo esperado entre mensagens (0 = sem monitoramento de silêncio)
+	{"devices", "expected_interval_minutes", "INT NOT NULL DEFAULT 0"},
+	{"alert_rules", "escalation_policy_id", "INT NULL"},
+	// Cerca que abriu o alerta de saída (a entrada resolve só os alertas dela)
+	{"alerts", "geofence_id", "INT NULL"},
+	// Incrementada para invalidar todos os access tokens do usuário
+	{"users", "token_version", "INT NOT NULL DEFAULT 0"},
+	// Organização dona do registro (regras de alerta herdam a do dispositivo/grupo)
+	{"users", "organization_id", "INT NULL"},
+	{"devices", "organization_id", "INT NULL"},
+	{"device_groups", "organization_id", "INT NULL"},
+	{"geofences", "organization_id", "INT NULL"},
+	{"escalation_policies", "organization_id", "INT NULL"},
+	// Nível do vínculo: viewer, operator, owner. Vínculos anteriores continuam
+	// podendo renomear (operator); novos vínculos partem de viewer.
+	{"user_permissions", "access_level", "VARCHAR(20) NOT NULL DEFAULT 'operator'"},
+	// 2FA (TOTP): segredo em base32, ativo só após o primeiro código válido;
+	// último passo aceito impede reutilizar o mesmo código
+	{"users", "totp_secret", "VARCHAR(64) NULL"},
+	{"users", "totp_enabled", "TINYINT(1) NOT NULL DEFAULT 0"},
+	{"users", "totp_last_step", "BIGINT NOT NULL DEFAULT 0"},
+	// Política de senhas: troca obrigatória no próximo login e data da última troca
+	// (contas existentes partem da data da migração)
+	{"users", "must_change_password", "TINYINT(1) NOT NULL DEFAULT 0"},
+	{"users", "password_changed_at", "DATETIME NULL DEFAULT CURRENT_TIMESTAMP"},
+	// Convites: link de ativação na mesma tabela da recuperação de senha
+	// (purpose reset/invite); a conta fica pendente até o convidado escolher a senha
+	{"password_resets", "purpose", "VARCHAR(10) NOT NULL DEFAULT 'reset'"},
+	{"users", "invite_pending", "TINYINT(1) NOT NULL DEFAULT 0"},
+	// Próxima tentativa de envio (reserva do worker ou backoff após falha)
+	{"notification_log", "next_attempt_at", "DATETIME NULL"},
+	// Encadeamento dos registros de auditoria (NULL = anterior à cadeia)
+	{"audit_logs", "chain_seq", "BIGINT NULL UNIQUE"},
+	{"audit_logs", "prev_hash", "CHAR(64) NULL"},
+	{"audit_logs", "entry_hash", "CHAR(64) NULL"},
+	// Eventos estruturados: alvo, diff dos campos alterados, requisição e navegador
+	{"audit_logs", "target_type", "VARCHAR(30) NULL"},
+	{"audit_logs", "target_id", "VARCHAR(64) NULL"},
+	{"audit_logs", "changes", "TEXT NULL"},
+	{"audit_logs", "request_id", "VARCHAR(64) NULL"},
+	{"audit_logs", "user_agent", "VARCHAR(255) NULL"},
+	// Organização do alvo (filtro da listagem; NULL nos registros antigos)
+	{"audit_logs", "organization_id", "INT NULL"},
+	// Login SSO que é um vínculo explícito pedido pelo próprio usuário logado
+	{"sso_states", "link_user_id", "INT NULL"},
+}
+
+// ensureSchema: Executa os CREATE TABLE e ALTER TABLE acima (idempotente)
+func ensureSchema() {
+	for _, stmt := range schemaStatements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Printf("Aviso: Falha ao aplicar esquema: %v", err)
+		}
+	}
+	for _, c := range schemaColumns {
+		ensureColumn(c[0], c[1], c[2])
+	}
+}
+
+// ensureColumn: MySQL não tem "ADD COLUMN IF NOT EXISTS", então consulta o information_schema
+func ensureColumn(table, column, definition string) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		log.Printf("Aviso: Falha ao adicionar coluna %s.%s: %v", table, column, err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- SESSÕES ---
+// Cada login abre uma sessão (a família do refresh token) com IP, navegador e
+// horários. O access token carrega o ID da sessão (sid): revogar a sessão
+// encerra os refresh tokens da família e derruba o access token na hora (ver
+// authenticate). O próprio usuário lista e encerra as suas; com
+// sessions:manage (master) é possível encerrar as de qualquer um.
+
+type Session struct {
+	ID         string `json:"id"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username,omitempty"`
+	IPAddress  string `json:"ip_address"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	// A sessão desta requisição
+	Current bool `json:"current"`
+}
+
+type SessionRequest struct {
+	ID     string `json:"id"`
+	UserID int    `json:"user_id"`
+	// Encerra todas as sessões menos a atual
+	Others bool `json:"others"`
+}
+
+// recordSession: Abre a sessão no login ou atualiza o último acesso na renovação
+// (famílias anteriores às sessões ganham o registro na primeira renovação)
+func recordSession(r *http.Request, sessionID string, userID int) error {
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	_, err := db.Exec(`INSERT INTO sessions (id, user_id, ip_address, user_agent) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE last_seen_at = NOW(), ip_address = VALUES(ip_address)`,
+		sessionID, userID, clientIP(r), userAgent)
+	return err
+}
+
+// touchSession: Último acesso registrado no máximo uma vez por minuto por sessão
+func touchSession(sessionID, ip string) {
+	db.Exec(`UPDATE sessions SET last_seen_at = NOW(), ip_address = ?
+		WHERE id = ? AND last_seen_at < NOW() - INTERVAL 1 MINUTE`, ip, sessionID)
+}
+
+// revokeSession: Encerra a sessão e os refresh tokens da família
+func revokeSession(sessionID string, userID int) {
+	db.Exec("UPDATE sessions SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL", sessionID)
+	db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = ? AND revoked_at IS NULL", sessionID)
+	// Só as conexões em tempo real desta sessão caem; as dos outros aparelhos seguem abertas
+	hub.DisconnectSession(userID, sessionID)
+}
+
+// revokeOtherSessions: Encerra todas as sessões do usuário menos a informada (ex: a atual)
+func revokeOtherSessions(userID int, keepID string) (int, error) {
+	rows, err := db.Query("SELECT id FROM sessions WHERE user_id = ? AND id != ? AND revoked_at IS NULL", userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		revokeSession(id, userID)
+	}
+	// Refresh tokens de famílias anteriores às sessões
+	db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = ? AND family_id != ? AND revoked_at IS NULL", userID, keepID)
+	return len(ids), nil
+}
+
+// listSessions: Sessões ativas (não revogadas e com refresh token ainda válido)
+func listSessions(userID int, currentID string) ([]Session, error) {
+	rows, err := db.Query(`SELECT s.id, s.user_id, u.username, COALESCE(s.ip_address, ''), COALESCE(s.user_agent, ''),
+		s.created_at, s.last_seen_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = ? AND s.revoked_at IS NULL
+		AND EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = s.id AND t.revoked_at IS NULL AND t.expires_at > NOW())
+		ORDER BY s.last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]Session, 0)
+	for rows.Next() {
+		var s Session
+		var createdAt, lastSeen time.Time
+		rows.Scan(&s.ID, &s.UserID, &s.Username, &s.IPAddress, &s.UserAgent, &createdAt, &lastSeen)
+		s.CreatedAt = createdAt.Format("02/01/2006 15:04:05")
+		s.LastSeenAt = lastSeen.Format("02/01/2006 15:04:05")
+		s.Current = s.ID == currentID
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// --- HANDLERS ---
+
+// sessionsHandler: GET lista as sessões do próprio usuário
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	list, err := listSessions(userID, r.Header.Get("X-Session-ID"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// revokeSessionHandler: Encerra uma sessão do próprio usuário ({"id"}) ou todas menos a atual ({"others": true})
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req SessionRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	current := r.Header.Get("X-Session-ID")
+
+	if req.Others {
+		n, err := revokeOtherSessions(userID, current)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		recordAudit(r, AuditEvent{Action: AuditSessionsRevoked, Target: auditTarget(TargetUser, userID), Details: fmt.Sprintf("Encerrou %d sessão(ões) em outros dispositivos", n)})
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var owner int
+	if err := db.QueryRow("SELECT user_id FROM sessions WHERE id = ?", req.ID).Scan(&owner); err != nil || owner != userID {
+		http.Error(w, "Sessão não encontrada", http.StatusNotFound)
+		return
+	}
+	revokeSession(req.ID, userID)
+	recordAudit(r, AuditEvent{Action: AuditSessionRevoked, Target: auditTarget(TargetSession, req.ID), Details: "Encerrou a sessão " + shortSessionID(req.ID)})
+	w.WriteHeader(http.StatusOK)
+}
+
+// userSessionsHandler: GET ?user_id= lista as sessões de qualquer usuário (sessions:manage)
+func userSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if !recordInScope(r, "users", userID) {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	list, err := listSessions(userID, r.Header.Get("X-Session-ID"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// revokeUserSessionHandler: Encerra a sessão de outro usuário ({"id"}) ou todas dele ({"user_id"})
+func revokeUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req SessionRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if req.ID == "" {
+		if !recordInScope(r, "users", req.UserID) {
+			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+			return
+		}
+		revokeUserTokens(req.UserID)
+		recordAudit(r, AuditEvent{Action: AuditUserSessionsRevoked, Target: auditTarget(TargetUser, req.UserID),
+			Details: fmt.Sprintf("Encerrou todas as sessões do usuário ID %d", req.UserID)})
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var owner int
+	if err := db.QueryRow("SELECT user_id FROM sessions WHERE id = ?", req.ID).Scan(&owner); err != nil || !recordInScope(r, "users", owner) {
+		http.Error(w, "Sessão não encontrada", http.StatusNotFound)
+		return
+	}
+	revokeSession(req.ID, owner)
+	recordAudit(r, AuditEvent{Action: AuditUserSessionRevoked, Target: auditTarget(TargetSession, req.ID),
+		Details: fmt.Sprintf("Encerrou a sessão %s do usuário ID %d", shortSessionID(req.ID), owner)})
+	w.WriteHeader(http.StatusOK)
+}
+
+// shortSessionID: Começo do ID, suficiente para identificar a sessão na auditoria
+func shortSessionID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
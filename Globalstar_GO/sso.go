@@ -0,0 +1,703 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot_modulo1.0/pkg/netguard"
+	"iot_modulo1.0/pkg/oidc"
+)
+
+// --- LOGIN ÚNICO (SSO / OPENID CONNECT) ---
+// Cada organização pode cadastrar o IdP corporativo (Azure AD, Google, Keycloak...).
+// O login começa em /api/sso/start?email=fulano@empresa.com (o domínio escolhe o
+// provedor), segue para o IdP (authorization code + PKCE) e volta em
+// /api/sso/callback. Lá o id_token é verificado e ligado a um usuário local:
+// pela identidade já vinculada (sub), pelo e-mail verificado dentro da mesma
+// organização ou, com jit_enabled, criando o usuário na hora. O papel vem do
+// mapeamento da claim configurada (ex: groups) e nunca é master.
+//
+// O vínculo automático pelo e-mail só vale para papéis que o SSO concede
+// (ssoRoleRank): uma conta master nunca fica sob o controle de um IdP de
+// organização sem um vínculo explícito, feito pelo próprio dono logado
+// (/api/sso/link) ou por um master (/api/master/sso-identities).
+//
+// Tokens não trafegam na URL: o callback manda o navegador para
+// FRONTEND_URL/sso#sso=<ticket> e o frontend troca o ticket (uso único, 5 min)
+// em /api/sso/exchange, passando pelo 2FA local como no login com senha.
+// Para testar localmente: go run ./cmd/mockidp, com SSO_DEV_LOCAL_ISSUER=true
+// (emissor http em loopback; nunca em produção).
+
+const (
+	ssoStateTTL     = 10 * time.Minute
+	loginPurposeSSO = "sso"
+)
+
+// Ordem dos papéis que um IdP pode conceder (o maior mapeado vence)
+var ssoRoleRank = map[string]int{RoleUser: 1, RoleSupport: 2, RoleAdmin: 3}
+
+// Desenvolvimento: aceita emissor http em localhost (IdP de teste)
+var ssoDevLocalIssuer = os.Getenv("SSO_DEV_LOCAL_ISSUER") == "true"
+
+var oidcClient = newOIDCClient()
+
+// newOIDCClient: Só endereços públicos; loopback apenas com SSO_DEV_LOCAL_ISSUER
+func newOIDCClient() *oidc.Client {
+	c := oidc.NewClient()
+	if ssoDevLocalIssuer {
+		c.HTTP = netguard.HTTPClient(10*time.Second, netguard.PublicOrLoopbackIP)
+	}
+	return c
+}
+
+// validIssuer: https; http em loopback só em desenvolvimento
+func validIssuer(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || u.User != nil {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	if !ssoDevLocalIssuer || u.Scheme != "http" {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	return u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+type SSOProvider struct {
+	ID             int    `json:"id"`
+	OrganizationID int    `json:"organization_id"`
+	Name           string `json:"name"`
+	Issuer         string `json:"issuer"`
+	ClientID       string `json:"client_id"`
+	// Só na gravação (vazio na edição mantém o atual); nunca é devolvido
+	ClientSecret    string `json:"client_secret,omitempty"`
+	HasClientSecret bool   `json:"has_client_secret"`
+	// Domínios de e-mail atendidos, separados por vírgula (ex: "empresa.com,empresa.com.br")
+	EmailDomains string `json:"email_domains"`
+	// Claim com os grupos/papéis do IdP e o mapeamento valor -> papel local
+	RoleClaim   string            `json:"role_claim"`
+	RoleMapping map[string]string `json:"role_mapping"`
+	DefaultRole string            `json:"default_role"`
+	JITEnabled  bool              `json:"jit_enabled"`
+	Enabled     bool              `json:"enabled"`
+}
+
+var errSSOUser = errors.New("usuário não autorizado para este provedor")
+
+// normalizeEmailDomains: Lista de domínios em minúsculas, sem espaços nem repetidos
+func normalizeEmailDomains(raw string) ([]string, error) {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, d := range strings.Split(raw, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" || seen[d] {
+			continue
+		}
+		if strings.ContainsAny(d, "@ /") || !strings.Contains(d, ".") {
+			return nil, fmt.Errorf("domínio de e-mail inválido: %q", d)
+		}
+		seen[d] = true
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// ssoRedirectURL: URL pública de /api/sso/callback cadastrada no IdP ("" = SSO desligado)
+func ssoRedirectURL() string {
+	return os.Getenv("SSO_REDIRECT_URL")
+}
+
+// config: Parâmetros do cliente OIDC para este provedor
+func (p SSOProvider) config() oidc.Config {
+	return oidc.Config{Issuer: p.Issuer, ClientID: p.ClientID, ClientSecret: p.ClientSecret, RedirectURL: ssoRedirectURL()}
+}
+
+const ssoProviderColumns = `id, COALESCE(organization_id, 0), name, issuer, client_id, client_secret, email_domains,
+	role_claim, role_mapping, default_role, jit_enabled, enabled`
+
+func scanSSOProvider(row interface{ Scan(...interface{}) error }) (SSOProvider, error) {
+	var p SSOProvider
+	var mapping string
+	err := row.Scan(&p.ID, &p.OrganizationID, &p.Name, &p.Issuer, &p.ClientID, &p.ClientSecret, &p.EmailDomains,
+		&p.RoleClaim, &mapping, &p.DefaultRole, &p.JITEnabled, &p.Enabled)
+	if err != nil {
+		return p, err
+	}
+	p.RoleMapping = make(map[string]string)
+	json.Unmarshal([]byte(mapping), &p.RoleMapping)
+	p.HasClientSecret = p.ClientSecret != ""
+	return p, nil
+}
+
+// loadSSOProvider: Provedor ativo pelo ID
+func loadSSOProvider(id int) (SSOProvider, error) {
+	return scanSSOProvider(db.QueryRow("SELECT "+ssoProviderColumns+" FROM sso_providers WHERE id = ? AND enabled = 1", id))
+}
+
+// ssoProviderForEmail: Provedor ativo que atende o domínio do e-mail
+func ssoProviderForEmail(email string) (SSOProvider, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return SSOProvider{}, sql.ErrNoRows
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	return scanSSOProvider(db.QueryRow("SELECT "+ssoProviderColumns+` FROM sso_providers
+		WHERE enabled = 1 AND FIND_IN_SET(?, REPLACE(LOWER(email_domains), ' ', '')) > 0 ORDER BY id LIMIT 1`, domain))
+}
+
+// ssoRole: Maior papel mapeado a partir da claim do IdP ("" se nenhum valor é mapeado)
+func ssoRole(p SSOProvider, tok *oidc.IDToken) string {
+	best := ""
+	if p.RoleClaim == "" {
+		return best
+	}
+	for _, value := range tok.Strings(p.RoleClaim) {
+		if role, ok := p.RoleMapping[value]; ok && ssoRoleRank[role] > ssoRoleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// ssoFail: O navegador volta para o login com a mensagem (o fluxo é por redirecionamento)
+func ssoFail(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, os.Getenv("FRONTEND_URL")+"/sso#sso_error="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// --- FLUXO DE LOGIN ---
+
+// ssoStartHandler: Escolhe o provedor (por e-mail ou ID) e redireciona para o IdP
+func ssoStartHandler(w http.ResponseWriter, r *http.Request) {
+	if ssoRedirectURL() == "" {
+		ssoFail(w, r, "SSO não configurado")
+		return
+	}
+	var p SSOProvider
+	var err error
+	if id, convErr := strconv.Atoi(r.URL.Query().Get("provider")); convErr == nil {
+		p, err = loadSSOProvider(id)
+	} else {
+		p, err = ssoProviderForEmail(r.URL.Query().Get("email"))
+	}
+	if err != nil {
+		ssoFail(w, r, "Nenhum provedor de SSO para este e-mail")
+		return
+	}
+
+	authURL, err := beginSSO(r, p, 0)
+	if err != nil {
+		ssoFail(w, r, err.Error())
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// beginSSO: Gera state, nonce e PKCE, grava o login em andamento e devolve a URL do IdP.
+// linkUserID > 0 marca um vínculo explícito pedido pelo próprio usuário logado.
+func beginSSO(r *http.Request, p SSOProvider, linkUserID int) (string, error) {
+	state, errS := oidc.RandomString(24)
+	nonce, errN := oidc.RandomString(24)
+	verifier, challenge, errP := oidc.NewPKCE()
+	if errS != nil || errN != nil || errP != nil {
+		return "", errors.New("Erro ao iniciar SSO")
+	}
+	authURL, err := oidcClient.AuthCodeURL(r.Context(), p.config(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("Erro no SSO (provedor %d): %v", p.ID, err)
+		return "", errors.New("Provedor de identidade indisponível")
+	}
+	_, err = db.Exec("INSERT INTO sso_states (state_hash, provider_id, nonce, code_verifier, expires_at, link_user_id) VALUES (?, ?, ?, ?, ?, NULLIF(?, 0))",
+		hashToken(state), p.ID, nonce, verifier, time.Now().Add(ssoStateTTL), linkUserID)
+	if err != nil {
+		return "", errors.New("Erro ao iniciar SSO")
+	}
+	return authURL, nil
+}
+
+// ssoCallbackHandler: Retorno do IdP. Verifica o id_token, resolve o usuário local e
+// devolve o navegador ao frontend com um ticket de uso único.
+func ssoCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		ssoFail(w, r, "Login cancelado no provedor ("+e+")")
+		return
+	}
+
+	// O state é de uso único: apaga antes de usar
+	var providerID, linkUserID int
+	var nonce, verifier string
+	var expiresAt time.Time
+	stateHash := hashToken(q.Get("state"))
+	err := db.QueryRow("SELECT provider_id, nonce, code_verifier, expires_at, COALESCE(link_user_id, 0) FROM sso_states WHERE state_hash = ?", stateHash).
+		Scan(&providerID, &nonce, &verifier, &expiresAt, &linkUserID)
+	res, delErr := db.Exec("DELETE FROM sso_states WHERE state_hash = ?", stateHash)
+	db.Exec("DELETE FROM sso_states WHERE expires_at < NOW()")
+	if err != nil || delErr != nil || time.Now().After(expiresAt) {
+		ssoFail(w, r, "Sessão de login expirada, tente novamente")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		ssoFail(w, r, "Sessão de login expirada, tente novamente")
+		return
+	}
+
+	p, err := loadSSOProvider(providerID)
+	if err != nil {
+		ssoFail(w, r, "Provedor de SSO desativado")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	raw, err := oidcClient.Exchange(ctx, p.config(), q.Get("code"), verifier)
+	var tok *oidc.IDToken
+	if err == nil {
+		tok, err = oidcClient.VerifyIDToken(ctx, p.config(), raw, nonce)
+	}
+	if err != nil {
+		log.Printf("Erro no SSO (provedor %d): %v", p.ID, err)
+		recordAudit(r, AuditEvent{Actor: "SSO:" + p.Name, Action: AuditSSOLoginFailed, Target: auditTarget(TargetSSOProvider, p.ID),
+			Details: fmt.Sprintf("Provedor %d: %v", p.ID, err)})
+		ssoFail(w, r, "Não foi possível validar o login no provedor")
+		return
+	}
+
+	if linkUserID > 0 {
+		// O link do IdP pode ter sido repassado a outra pessoa: só vincula a identidade
+		// cujo e-mail verificado é o da própria conta
+		var email string
+		db.QueryRow("SELECT COALESCE(email, '') FROM users WHERE id = ?", linkUserID).Scan(&email)
+		if !tok.EmailVerified || email == "" || !strings.EqualFold(tok.Email, email) {
+			err = errors.New("o e-mail da identidade não é o da conta")
+		} else {
+			err = linkSSOIdentity(r, p, tok.Subject, linkUserID, linkUserID)
+		}
+		if err != nil {
+			recordAudit(r, AuditEvent{ActorID: linkUserID, Action: AuditSSOLoginFailed, Target: auditTarget(TargetSSOProvider, p.ID),
+				Details: fmt.Sprintf("Provedor %d (sub %s): vínculo recusado: %v", p.ID, tok.Subject, err)})
+			ssoFail(w, r, "Não foi possível vincular a identidade: "+err.Error())
+			return
+		}
+		http.Redirect(w, r, os.Getenv("FRONTEND_URL")+"/sso#sso_linked="+url.QueryEscape(p.Name), http.StatusFound)
+		return
+	}
+
+	userID, err := resolveSSOUser(r, p, tok)
+	if err != nil {
+		recordAudit(r, AuditEvent{Actor: tok.Email, Action: AuditSSOLoginFailed, Target: auditTarget(TargetSSOProvider, p.ID),
+			Details: fmt.Sprintf("Provedor %d (sub %s): %v", p.ID, tok.Subject, err)})
+		ssoFail(w, r, "Usuário não autorizado")
+		return
+	}
+
+	ticket, err := newMFAChallenge(userID, loginPurposeSSO)
+	if err != nil {
+		ssoFail(w, r, "Erro ao concluir login")
+		return
+	}
+	http.Redirect(w, r, os.Getenv("FRONTEND_URL")+"/sso#sso="+ticket, http.StatusFound)
+}
+
+// ssoExchangeHandler: Troca o ticket do callback pelos tokens (ou pelo desafio do 2FA)
+func ssoExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Ticket == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	userID, purpose, err := loadMFAChallenge(req.Ticket)
+	if err != nil || purpose != loginPurposeSSO || !consumeMFAChallenge(req.Ticket) {
+		http.Error(w, "Ticket inválido ou expirado, faça login novamente", http.StatusUnauthorized)
+		return
+	}
+
+	var username, role, fullName string
+	var version int
+	var totpEnabled bool
+	err = db.QueryRow("SELECT username, role, full_name, token_version, totp_enabled FROM users WHERE id = ?", userID).
+		Scan(&username, &role, &fullName, &version, &totpEnabled)
+	if err != nil {
+		http.Error(w, "Invalid Token", http.StatusUnauthorized)
+		return
+	}
+	finishLogin(w, r, userID, username, role, fullName, version, totpEnabled, "Login realizado via SSO")
+}
+
+// resolveSSOUser: Identidade vinculada -> e-mail verificado na organização -> criação (JIT).
+// Sincroniza o papel quando a claim mapeia algum (contas master não são tocadas).
+func resolveSSOUser(r *http.Request, p SSOProvider, tok *oidc.IDToken) (int, error) {
+	var userID, orgID int
+	var role string
+	err := db.QueryRow(`SELECT u.id, u.role, COALESCE(u.organization_id, 0) FROM sso_identities i
+		JOIN users u ON u.id = i.user_id WHERE i.provider_id = ? AND i.subject = ?`, p.ID, tok.Subject).
+		Scan(&userID, &role, &orgID)
+
+	switch {
+	case err == nil:
+		if orgID != p.OrganizationID {
+			return 0, errSSOUser
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if tok.Email == "" || !tok.EmailVerified {
+			return 0, fmt.Errorf("%w: e-mail ausente ou não verificado", errSSOUser)
+		}
+		err = db.QueryRow("SELECT id, role, COALESCE(organization_id, 0) FROM users WHERE email = ? ORDER BY id LIMIT 1", tok.Email).
+			Scan(&userID, &role, &orgID)
+		switch {
+		case err == nil:
+			// E-mail de outra organização nunca é vinculado
+			if orgID != p.OrganizationID {
+				return 0, fmt.Errorf("%w: e-mail cadastrado em outra organização", errSSOUser)
+			}
+			// Papel que o SSO não concede (master): só com vínculo explícito
+			if _, ok := ssoRoleRank[role]; !ok {
+				return 0, fmt.Errorf("%w: conta com papel %s exige vínculo explícito da identidade", errSSOUser, role)
+			}
+			recordAudit(r, AuditEvent{ActorID: userID, Actor: tok.Email, Action: AuditSSOIdentityLinked, Target: auditTarget(TargetUser, userID),
+				Details: fmt.Sprintf("Conta vinculada ao provedor %s (%d)", p.Name, p.ID)})
+		case errors.Is(err, sql.ErrNoRows):
+			if !p.JITEnabled {
+				return 0, fmt.Errorf("%w: usuário inexistente e provisionamento desativado", errSSOUser)
+			}
+			role = ssoRole(p, tok)
+			if role == "" {
+				role = p.DefaultRole
+			}
+			if userID, err = provisionSSOUser(p, tok, role); err != nil {
+				return 0, err
+			}
+			recordAudit(r, AuditEvent{ActorID: userID, Actor: tok.Email, Action: AuditSSOUserProvisioned, Target: auditTarget(TargetUser, userID),
+				Details: fmt.Sprintf("Usuário criado via SSO (%s) com papel %s", p.Name, role)})
+		default:
+			return 0, err
+		}
+		if _, err := db.Exec("INSERT INTO sso_identities (provider_id, subject, user_id) VALUES (?, ?, ?)", p.ID, tok.Subject, userID); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+
+	// Papel acompanha o IdP (exceto os que ele não concede, como master, que só mudam localmente)
+	if mapped := ssoRole(p, tok); mapped != "" && mapped != role && ssoRoleRank[role] > 0 {
+		db.Exec("UPDATE users SET role = ? WHERE id = ?", mapped, userID)
+		revokeUserTokens(userID)
+		recordAudit(r, AuditEvent{ActorID: userID, Actor: tok.Email, Action: AuditSSORoleChange, Target: auditTarget(TargetUser, userID),
+			Details: fmt.Sprintf("Papel %s -> %s pelo provedor %s", role, mapped, p.Name),
+			Changes: AuditChanges{"role": {From: role, To: mapped}}})
+	}
+	db.Exec("UPDATE sso_identities SET last_login_at = NOW() WHERE provider_id = ? AND subject = ?", p.ID, tok.Subject)
+	return userID, nil
+}
+
+// provisionSSOUser: Cria o usuário na organização do provedor (senha aleatória: só entra via SSO
+// ou após redefinir a senha)
+func provisionSSOUser(p SSOProvider, tok *oidc.IDToken, role string) (int, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := hashPassword(secret)
+	if err != nil {
+		return 0, err
+	}
+	fullName := tok.Name
+	if fullName == "" {
+		fullName = tok.Email
+	}
+
+	base := tok.PreferredUsername
+	if base == "" {
+		base = tok.Email
+	}
+	if len(base) > 45 {
+		base = base[:45]
+	}
+	// username é UNIQUE: tenta base, base2, base3...
+	for i := 1; i <= 5; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s%d", base, i)
+		}
+		res, err := db.Exec(`INSERT INTO users (username, password_hash, role, full_name, email, phone, address, city, state, organization_id)
+			VALUES (?, ?, ?, ?, ?, '', '', '', '', NULLIF(?, 0))`, username, hash, role, fullName, tok.Email, p.OrganizationID)
+		if err == nil {
+			id, _ := res.LastInsertId()
+			recordPasswordHistory(int(id), hash)
+			return int(id), nil
+		}
+	}
+	return 0, fmt.Errorf("não foi possível criar o usuário %s", base)
+}
+
+// linkSSOIdentity: Vínculo explícito do sub ao usuário (pelo dono ou por um master).
+// O usuário precisa ser da organização do provedor e o sub não pode ser de outra conta.
+func linkSSOIdentity(r *http.Request, p SSOProvider, subject string, userID, actorID int) error {
+	if subject == "" {
+		return errors.New("identidade sem sub")
+	}
+	orgID, err := recordOrg("users", userID)
+	if err != nil {
+		return errors.New("usuário não encontrado")
+	}
+	if orgID != p.OrganizationID {
+		return errors.New("o provedor é de outra organização")
+	}
+	var owner int
+	err = db.QueryRow("SELECT user_id FROM sso_identities WHERE provider_id = ? AND subject = ?", p.ID, subject).Scan(&owner)
+	switch {
+	case err == nil && owner == userID:
+		return nil
+	case err == nil:
+		return errors.New("identidade já vinculada a outra conta")
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	if _, err := db.Exec("INSERT INTO sso_identities (provider_id, subject, user_id) VALUES (?, ?, ?)", p.ID, subject, userID); err != nil {
+		return err
+	}
+	recordAudit(r, AuditEvent{ActorID: actorID, Action: AuditSSOIdentityLinked, Target: auditTarget(TargetUser, userID),
+		Details: fmt.Sprintf("Identidade %s do provedor %s (%d) vinculada explicitamente", subject, p.Name, p.ID)})
+	return nil
+}
+
+// ssoLinkHandler: POST {"provider_id"} (opcional: sem ele vale o domínio do e-mail da conta).
+// Devolve a URL do IdP; ao voltar, a identidade autenticada fica vinculada a quem pediu.
+func ssoLinkHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ssoRedirectURL() == "" {
+		http.Error(w, "SSO não configurado", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		ProviderID int `json:"provider_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	userID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+
+	var p SSOProvider
+	var err error
+	if req.ProviderID > 0 {
+		p, err = loadSSOProvider(req.ProviderID)
+	} else {
+		var email string
+		if err = db.QueryRow("SELECT COALESCE(email, '') FROM users WHERE id = ?", userID).Scan(&email); err == nil {
+			p, err = ssoProviderForEmail(email)
+		}
+	}
+	if err != nil || p.OrganizationID != orgOf(r) {
+		http.Error(w, "Nenhum provedor de SSO para esta conta", http.StatusNotFound)
+		return
+	}
+
+	authURL, err := beginSSO(r, p, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"url": authURL})
+}
+
+// ssoIdentityLinkHandler: POST {"provider_id", "user_id", "subject"} vincula a identidade
+// sem passar pelo e-mail (só master: é o caminho para contas que o SSO não concede)
+func ssoIdentityLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !crossTenant(r) {
+		http.Error(w, "Só master vincula identidades manualmente", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		ProviderID int    `json:"provider_id"`
+		UserID     int    `json:"user_id"`
+		Subject    string `json:"subject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Subject) == "" {
+		http.Error(w, "Informe provider_id, user_id e subject", http.StatusBadRequest)
+		return
+	}
+	p, err := loadSSOProvider(req.ProviderID)
+	if err != nil {
+		http.Error(w, "Provedor não encontrado", http.StatusNotFound)
+		return
+	}
+	actorID, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err := linkSSOIdentity(r, p, strings.TrimSpace(req.Subject), req.UserID, actorID); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// --- CADASTRO DE PROVEDORES (sso:manage) ---
+
+// ssoProvidersHandler: GET lista os provedores da organização, POST cria/edita
+func ssoProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		cond, args := orgCond(r, "p")
+		rows, err := db.Query("SELECT "+ssoProviderColumns+" FROM sso_providers p WHERE "+cond+" ORDER BY p.name", args...)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer rows.Close()
+		list := make([]SSOProvider, 0)
+		for rows.Next() {
+			p, err := scanSSOProvider(rows)
+			if err != nil {
+				continue
+			}
+			p.ClientSecret = ""
+			list = append(list, p)
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	var p SSOProvider
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "JSON inválido", 400)
+		return
+	}
+	p.Name, p.Issuer, p.ClientID = strings.TrimSpace(p.Name), strings.TrimRight(strings.TrimSpace(p.Issuer), "/"), strings.TrimSpace(p.ClientID)
+	if p.Name == "" || p.ClientID == "" {
+		http.Error(w, "Nome e client_id são obrigatórios", 400)
+		return
+	}
+	if !validIssuer(p.Issuer) {
+		http.Error(w, "Emissor deve ser uma URL https", 400)
+		return
+	}
+	if p.DefaultRole == "" {
+		p.DefaultRole = RoleUser
+	}
+	if _, ok := ssoRoleRank[p.DefaultRole]; !ok {
+		http.Error(w, "Papel padrão inválido (user, support ou admin)", 400)
+		return
+	}
+	for value, role := range p.RoleMapping {
+		if _, ok := ssoRoleRank[role]; !ok {
+			http.Error(w, fmt.Sprintf("Papel inválido no mapeamento de %q (user, support ou admin)", value), 400)
+			return
+		}
+	}
+
+	domains, err := normalizeEmailDomains(p.EmailDomains)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	p.EmailDomains = strings.Join(domains, ",")
+
+	orgID, ok := ownerOrg(r, "sso_providers", p.ID, p.OrganizationID)
+	if !ok {
+		http.Error(w, "Provedor ou organização não encontrada", http.StatusNotFound)
+		return
+	}
+	p.OrganizationID = orgID
+
+	// Cada domínio escolhe um único provedor no login: não pode ser atendido por dois
+	for _, d := range domains {
+		var other int
+		err := db.QueryRow(`SELECT id FROM sso_providers WHERE id != ? AND FIND_IN_SET(?, REPLACE(LOWER(email_domains), ' ', '')) > 0 LIMIT 1`,
+			p.ID, d).Scan(&other)
+		if err == nil {
+			http.Error(w, fmt.Sprintf("O domínio %s já é atendido por outro provedor", d), http.StatusConflict)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	// Confere a descoberta antes de gravar (erro de digitação no emissor aparece aqui)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := oidcClient.Check(ctx, p.Issuer); err != nil {
+		// O detalhe (status, DNS, conexão recusada) fica no log: devolvê-lo faria do
+		// cadastro um scanner da rede
+		log.Printf("SSO: descoberta do emissor %s falhou: %v", p.Issuer, err)
+		http.Error(w, "Emissor inválido: documento de descoberta OIDC não encontrado", 400)
+		return
+	}
+
+	mapping, _ := json.Marshal(p.RoleMapping)
+	action := AuditSSOProviderUpdated
+	var before interface{}
+	if p.ID > 0 {
+		if old, scanErr := scanSSOProvider(db.QueryRow("SELECT "+ssoProviderColumns+" FROM sso_providers WHERE id = ?", p.ID)); scanErr == nil {
+			before = old
+		}
+		_, err = db.Exec(`UPDATE sso_providers SET name = ?, issuer = ?, client_id = ?, client_secret = IF(? = '', client_secret, ?),
+			email_domains = ?, role_claim = ?, role_mapping = ?, default_role = ?, jit_enabled = ?, enabled = ? WHERE id = ?`,
+			p.Name, p.Issuer, p.ClientID, p.ClientSecret, p.ClientSecret, p.EmailDomains, p.RoleClaim, string(mapping),
+			p.DefaultRole, p.JITEnabled, p.Enabled, p.ID)
+	} else {
+		action = AuditSSOProviderCreated
+		res, insErr := db.Exec(`INSERT INTO sso_providers (organization_id, name, issuer, client_id, client_secret, email_domains,
+			role_claim, role_mapping, default_role, jit_enabled, enabled) VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.OrganizationID, p.Name, p.Issuer, p.ClientID, p.ClientSecret, p.EmailDomains, p.RoleClaim, string(mapping),
+			p.DefaultRole, p.JITEnabled, p.Enabled)
+		err = insErr
+		if err == nil {
+			id, _ := res.LastInsertId()
+			p.ID = int(id)
+		}
+	}
+	if err != nil {
+		log.Printf("Erro ao salvar provedor SSO: %v", err)
+		http.Error(w, "Erro ao salvar provedor", 500)
+		return
+	}
+
+	saved, _ := scanSSOProvider(db.QueryRow("SELECT "+ssoProviderColumns+" FROM sso_providers WHERE id = ?", p.ID))
+	recordAudit(r, AuditEvent{Action: action, Target: auditTarget(TargetSSOProvider, p.ID),
+		Details: fmt.Sprintf("Provedor SSO %d (%s, %s) da organização %d", p.ID, p.Name, p.Issuer, p.OrganizationID),
+		Changes: diffChanges(before, saved)})
+	saved.ClientSecret = ""
+	json.NewEncoder(w).Encode(saved)
+}
+
+// deleteSSOProviderHandler: Remove o provedor (os vínculos de identidade vão junto; os usuários ficam)
+func deleteSSOProviderHandler(w http.ResponseWriter, r *http.Request) {
+	var p SSOProvider
+	json.NewDecoder(r.Body).Decode(&p)
+	orgID, err := recordOrg("sso_providers", p.ID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Provedor não encontrado", http.StatusNotFound)
+		return
+	}
+	db.Exec("DELETE FROM sso_providers WHERE id = ?", p.ID)
+
+	recordAudit(r, AuditEvent{Action: AuditSSOProviderDeleted, Target: auditTarget(TargetSSOProvider, p.ID), Details: fmt.Sprintf("Deletou provedor SSO ID %d", p.ID), OrgID: orgID})
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"iot_modulo1.0/pkg/auditchain"
+	"iot_modulo1.0/pkg/oidc"
+)
+
+var quietAuditOnce sync.Once
+
+// quietAuditChain: recordAudit grava em segundo plano; nos testes vai para um banco descartável
+func quietAuditChain() {
+	quietAuditOnce.Do(func() {
+		conn, _, _ := sqlmock.New()
+		auditChain = auditchain.NewChain(conn, nil)
+		auditChain.Logf = func(string, ...interface{}) {}
+	})
+}
+
+// captureArg: Guarda o argumento gravado (ex: o code_verifier do PKCE)
+type captureArg struct{ value *string }
+
+func (c captureArg) Match(v driver.Value) bool {
+	*c.value, _ = v.(string)
+	return true
+}
+
+// newSSOTest: IdP de teste (pkg/oidc/mock.go) e o provedor da organização 2 apontando para ele
+func newSSOTest(t *testing.T) (sqlmock.Sqlmock, SSOProvider) {
+	t.Helper()
+	quietAuditChain()
+	var idp *oidc.MockIdP
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { idp.ServeHTTP(w, r) }))
+	t.Cleanup(srv.Close)
+	var err error
+	if idp, err = oidc.NewMockIdP(srv.URL, "iotdata"); err != nil {
+		t.Fatal(err)
+	}
+	// O cliente de produção recusa loopback (pkg/netguard)
+	old := oidcClient
+	oidcClient = oidc.NewClient()
+	oidcClient.HTTP = srv.Client()
+	t.Cleanup(func() { oidcClient = old })
+	t.Setenv("SSO_REDIRECT_URL", "http://app.test/api/sso/callback")
+	t.Setenv("FRONTEND_URL", "http://front.test")
+
+	p := SSOProvider{ID: 5, OrganizationID: 2, Name: "Empresa", Issuer: srv.URL, ClientID: "iotdata",
+		EmailDomains: "empresa.com", RoleClaim: "groups", RoleMapping: map[string]string{"iot-admins": RoleAdmin},
+		DefaultRole: RoleUser, JITEnabled: true, Enabled: true}
+	return mockDB(t), p
+}
+
+func providerRow(p SSOProvider) *sqlmock.Rows {
+	mapping, _ := json.Marshal(p.RoleMapping)
+	return sqlmock.NewRows([]string{"id", "organization_id", "name", "issuer", "client_id", "client_secret", "email_domains",
+		"role_claim", "role_mapping", "default_role", "jit_enabled", "enabled"}).
+		AddRow(p.ID, p.OrganizationID, p.Name, p.Issuer, p.ClientID, "", p.EmailDomains, p.RoleClaim, string(mapping),
+			p.DefaultRole, p.JITEnabled, p.Enabled)
+}
+
+// ssoLogin: Início do login (state/nonce/PKCE gravados) e a autenticação no IdP.
+// Devolve o state e o code do retorno, o nonce enviado e o verifier gravado.
+func ssoLogin(t *testing.T, mock sqlmock.Sqlmock, p SSOProvider, linkUserID int, email, groups string) (state, code, nonce, verifier string) {
+	t.Helper()
+	mock.ExpectExec("INSERT INTO sso_states").
+		WithArgs(sqlmock.AnyArg(), p.ID, sqlmock.AnyArg(), captureArg{&verifier}, sqlmock.AnyArg(), linkUserID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	authURL, err := beginSSO(httptest.NewRequest(http.MethodGet, "/api/sso/start", nil), p, linkUserID)
+	if err != nil {
+		t.Fatalf("beginSSO: %v", err)
+	}
+
+	u, _ := url.Parse(authURL)
+	form := u.Query()
+	form.Set("email", email)
+	form.Set("name", "Fulano")
+	form.Set("groups", groups)
+	u.RawQuery = ""
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(u.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || back.Query().Get("code") == "" {
+		t.Fatalf("IdP não devolveu o código (status %d)", resp.StatusCode)
+	}
+	return back.Query().Get("state"), back.Query().Get("code"), form.Get("nonce"), verifier
+}
+
+// expectState: O callback consome o state gravado (deleted = linhas apagadas)
+func expectState(mock sqlmock.Sqlmock, p SSOProvider, state, nonce, verifier string, linkUserID int, deleted int64) {
+	mock.ExpectQuery("SELECT provider_id, nonce, code_verifier, expires_at, COALESCE\\(link_user_id, 0\\) FROM sso_states").
+		WithArgs(hashToken(state)).
+		WillReturnRows(sqlmock.NewRows([]string{"provider_id", "nonce", "code_verifier", "expires_at", "link_user_id"}).
+			AddRow(p.ID, nonce, verifier, time.Now().Add(time.Minute), linkUserID))
+	mock.ExpectExec("DELETE FROM sso_states WHERE state_hash").WithArgs(hashToken(state)).WillReturnResult(sqlmock.NewResult(0, deleted))
+	mock.ExpectExec("DELETE FROM sso_states WHERE expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	if deleted > 0 {
+		mock.ExpectQuery("FROM sso_providers WHERE id = \\? AND enabled = 1").WithArgs(p.ID).WillReturnRows(providerRow(p))
+	}
+}
+
+// expectRefused: Falha auditada no provedor
+func expectRefused(mock sqlmock.Sqlmock, p SSOProvider) {
+	mock.ExpectQuery("SELECT COALESCE\\(organization_id, 0\\) FROM sso_providers WHERE id = \\?").
+		WithArgs(p.ID).WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(p.OrganizationID))
+}
+
+// callback: Chama /api/sso/callback e devolve o fragmento do redirecionamento ao frontend
+func callback(t *testing.T, state, code string) url.Values {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ssoCallbackHandler(w, httptest.NewRequest(http.MethodGet, "/api/sso/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil))
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil || !strings.HasPrefix(loc.String(), "http://front.test/sso#") {
+		t.Fatalf("redirecionamento inesperado %d: %s", w.Code, w.Header().Get("Location"))
+	}
+	frag, _ := url.ParseQuery(loc.Fragment)
+	return frag
+}
+
+func TestSSOCallbackProvisionsUserWithMappedRole(t *testing.T) {
+	mock, p := newSSOTest(t)
+	state, code, nonce, verifier := ssoLogin(t, mock, p, 0, "novo@empresa.com", "iot-admins,outros")
+	expectState(mock, p, state, nonce, verifier, 0, 1)
+
+	mock.ExpectQuery("FROM sso_identities i\\s+JOIN users u").WithArgs(p.ID, "mock|novo@empresa.com").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT id, role, COALESCE\\(organization_id, 0\\) FROM users WHERE email = \\?").
+		WithArgs("novo@empresa.com").WillReturnError(sql.ErrNoRows)
+	// Grupo iot-admins mapeado para admin, na organização do provedor
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs("novo@empresa.com", sqlmock.AnyArg(), RoleAdmin, "Fulano", "novo@empresa.com", p.OrganizationID).
+		WillReturnResult(sqlmock.NewResult(42, 1))
+	mock.ExpectExec("INSERT INTO password_history").WithArgs(42, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM password_history").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COALESCE\\(organization_id, 0\\) FROM users WHERE id = \\?").
+		WithArgs(42).WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(p.OrganizationID))
+	mock.ExpectExec("INSERT INTO sso_identities").WithArgs(p.ID, "mock|novo@empresa.com", 42).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE sso_identities SET last_login_at").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO mfa_challenges").WithArgs(42, sqlmock.AnyArg(), loginPurposeSSO, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if frag := callback(t, state, code); frag.Get("sso") == "" {
+		t.Fatalf("esperado ticket de login, veio %v", frag)
+	}
+}
+
+func TestSSOCallbackStateIsSingleUse(t *testing.T) {
+	mock, p := newSSOTest(t)
+	state, code, nonce, verifier := ssoLogin(t, mock, p, 0, "fulano@empresa.com", "")
+
+	// Outra requisição já apagou o state: nada de trocar o código
+	expectState(mock, p, state, nonce, verifier, 0, 0)
+	if frag := callback(t, state, code); frag.Get("sso_error") == "" {
+		t.Fatalf("state reutilizado deveria falhar, veio %v", frag)
+	}
+}
+
+func TestSSOCallbackRejectsWrongNonceAndVerifier(t *testing.T) {
+	mock, p := newSSOTest(t)
+
+	// id_token emitido para outro login (nonce divergente)
+	state, code, _, verifier := ssoLogin(t, mock, p, 0, "fulano@empresa.com", "")
+	expectState(mock, p, state, "outro-nonce", verifier, 0, 1)
+	expectRefused(mock, p)
+	if frag := callback(t, state, code); frag.Get("sso_error") == "" {
+		t.Fatalf("nonce divergente deveria falhar, veio %v", frag)
+	}
+
+	// Código interceptado trocado sem o verifier certo: o IdP recusa (PKCE)
+	state, code, nonce, _ := ssoLogin(t, mock, p, 0, "fulano@empresa.com", "")
+	expectState(mock, p, state, nonce, "verifier-errado", 0, 1)
+	expectRefused(mock, p)
+	if frag := callback(t, state, code); frag.Get("sso_error") == "" {
+		t.Fatalf("verifier errado deveria falhar, veio %v", frag)
+	}
+}
+
+func TestResolveSSOUserRefusesOtherOrgsEmail(t *testing.T) {
+	mock, p := newSSOTest(t)
+	tok := &oidc.IDToken{Subject: "mock|fulano@empresa.com", Email: "fulano@empresa.com", EmailVerified: true}
+
+	mock.ExpectQuery("FROM sso_identities i\\s+JOIN users u").WithArgs(p.ID, tok.Subject).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM users WHERE email = \\?").WithArgs(tok.Email).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role", "organization_id"}).AddRow(9, RoleUser, 3))
+
+	if _, err := resolveSSOUser(httptest.NewRequest(http.MethodGet, "/", nil), p, tok); !errors.Is(err, errSSOUser) {
+		t.Fatalf("e-mail de outra organização não pode ser vinculado: %v", err)
+	}
+}
+
+func TestResolveSSOUserDoesNotAutoLinkMaster(t *testing.T) {
+	mock, p := newSSOTest(t)
+	// O admin do IdP da organização 2 afirma o e-mail do master da mesma organização
+	tok := &oidc.IDToken{Subject: "mock|root@empresa.com", Email: "root@empresa.com", EmailVerified: true}
+
+	mock.ExpectQuery("FROM sso_identities i\\s+JOIN users u").WithArgs(p.ID, tok.Subject).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM users WHERE email = \\?").WithArgs(tok.Email).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role", "organization_id"}).AddRow(1, RoleMaster, p.OrganizationID))
+
+	if _, err := resolveSSOUser(httptest.NewRequest(http.MethodGet, "/", nil), p, tok); !errors.Is(err, errSSOUser) {
+		t.Fatalf("conta master não pode ser vinculada pelo e-mail: %v", err)
+	}
+}
+
+func TestSSOExplicitLinkByOwner(t *testing.T) {
+	mock, p := newSSOTest(t)
+	state, code, nonce, verifier := ssoLogin(t, mock, p, 1, "root@empresa.com", "")
+	expectState(mock, p, state, nonce, verifier, 1, 1)
+
+	mock.ExpectQuery("SELECT COALESCE\\(email, ''\\) FROM users WHERE id = \\?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("Root@Empresa.com"))
+	mock.ExpectQuery("SELECT COALESCE\\(organization_id, 0\\) FROM users WHERE id = \\?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(p.OrganizationID))
+	mock.ExpectQuery("SELECT user_id FROM sso_identities").WithArgs(p.ID, "mock|root@empresa.com").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO sso_identities").WithArgs(p.ID, "mock|root@empresa.com", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(organization_id, 0\\) FROM users WHERE id = \\?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(p.OrganizationID))
+
+	if frag := callback(t, state, code); frag.Get("sso_linked") != p.Name {
+		t.Fatalf("esperado vínculo com %s, veio %v", p.Name, frag)
+	}
+}
+
+func TestSSOExplicitLinkRequiresAccountEmail(t *testing.T) {
+	mock, p := newSSOTest(t)
+	// Link do IdP repassado a outra pessoa: a identidade dela não entra na conta de quem pediu
+	state, code, nonce, verifier := ssoLogin(t, mock, p, 1, "vitima@empresa.com", "")
+	expectState(mock, p, state, nonce, verifier, 1, 1)
+	mock.ExpectQuery("SELECT COALESCE\\(email, ''\\) FROM users WHERE id = \\?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("root@empresa.com"))
+	expectRefused(mock, p)
+
+	if frag := callback(t, state, code); frag.Get("sso_error") == "" {
+		t.Fatalf("identidade de outro e-mail não pode ser vinculada, veio %v", frag)
+	}
+}
+
+func TestSSOProviderDomainsAreUnique(t *testing.T) {
+	mock := mockDB(t)
+	post := func(body string) *httptest.ResponseRecorder {
+		r := requestAs(http.MethodPost, "/api/master/sso-providers", RoleAdmin, 7, 2)
+		r.Body = io.NopCloser(strings.NewReader(body))
+		w := httptest.NewRecorder()
+		ssoProvidersHandler(w, r)
+		return w
+	}
+
+	if w := post(`{"name":"Empresa","issuer":"https://idp.test","client_id":"x","email_domains":"empresa"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("domínio inválido: status %d", w.Code)
+	}
+
+	// Outra organização já atende empresa.com
+	mock.ExpectQuery("SELECT 1 FROM organizations WHERE id = \\?").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mock.ExpectQuery("SELECT id FROM sso_providers WHERE id != \\? AND FIND_IN_SET").
+		WithArgs(0, "empresa.com").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	w := post(`{"name":"Empresa","issuer":"https://idp.test","client_id":"x","email_domains":" Empresa.com , empresa.com"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("domínio de outro provedor: status %d, esperado 409 (%s)", w.Code, w.Body.String())
+	}
+}
+
+func TestSSOIssuerMustBePublic(t *testing.T) {
+	if validIssuer("http://localhost:9000") || validIssuer("http://127.0.0.1:9000") || validIssuer("https://user:x@idp.test") {
+		t.Fatal("emissor http/loopback só com SSO_DEV_LOCAL_ISSUER")
+	}
+	ssoDevLocalIssuer = true
+	if !validIssuer("http://localhost:9000") || !validIssuer("http://[::1]:9000") || validIssuer("http://10.0.0.5") {
+		t.Fatal("em desenvolvimento só loopback pode ser http")
+	}
+	ssoDevLocalIssuer = false
+
+	// https em endereço interno: o cliente recusa a conexão e a resposta não conta o porquê
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a descoberta chegou ao servidor interno")
+	}))
+	defer srv.Close()
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT 1 FROM organizations WHERE id = \\?").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mock.ExpectQuery("SELECT id FROM sso_providers WHERE id != \\? AND FIND_IN_SET").WillReturnError(sql.ErrNoRows)
+
+	r := requestAs(http.MethodPost, "/api/master/sso-providers", RoleAdmin, 7, 2)
+	r.Body = io.NopCloser(strings.NewReader(`{"name":"Empresa","issuer":"` + srv.URL + `","client_id":"x","email_domains":"empresa.com"}`))
+	w := httptest.NewRecorder()
+	ssoProvidersHandler(w, r)
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "127.0.0.1") || strings.Contains(w.Body.String(), "rede interna") {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+}
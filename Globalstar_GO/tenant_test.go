@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// mockDB: Troca o banco global por um sqlmock durante o teste
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db
+	db = conn
+	t.Cleanup(func() {
+		db = old
+		conn.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return mock
+}
+
+// requestAs: Requisição com os cabeçalhos que o authMiddleware define
+func requestAs(method, target, role string, userID, orgID int) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("X-User-ID", strconv.Itoa(userID))
+	r.Header.Set("X-User-Role", role)
+	r.Header.Set("X-Org-ID", strconv.Itoa(orgID))
+	return r
+}
+
+func TestOrgCondAndInScope(t *testing.T) {
+	admin := requestAs(http.MethodGet, "/", RoleAdmin, 7, 2)
+	cond, args := orgCond(admin, "d")
+	if cond != "(? OR COALESCE(d.organization_id, 0) = ?)" {
+		t.Fatalf("condição inesperada: %s", cond)
+	}
+	if args[0] != false || args[1] != 2 {
+		t.Fatalf("admin da organização 2: argumentos %v, esperado [false 2]", args)
+	}
+	if !inScope(admin, 2) || inScope(admin, 3) || inScope(admin, 0) {
+		t.Fatal("admin só enxerga a própria organização")
+	}
+
+	master := requestAs(http.MethodGet, "/", RoleMaster, 1, 1)
+	if _, args := orgCond(master, "d"); args[0] != true {
+		t.Fatalf("master: argumentos %v, esperado crossTenant verdadeiro", args)
+	}
+	if !inScope(master, 3) {
+		t.Fatal("master enxerga todas as organizações")
+	}
+
+	// Sem os cabeçalhos do middleware a requisição não enxerga organização nenhuma
+	anon := httptest.NewRequest(http.MethodGet, "/", nil)
+	if inScope(anon, 2) {
+		t.Fatal("requisição sem organização não enxerga a organização 2")
+	}
+}
+
+func TestHasDevicePermissionFiltersByRequesterOrg(t *testing.T) {
+	mock := mockDB(t)
+	r := requestAs(http.MethodGet, "/", RoleUser, 7, 2)
+
+	// O dispositivo 55 é da organização 3: a consulta exige a organização 2 e não acha nada
+	mock.ExpectQuery("SELECT 1 FROM devices d\\s+WHERE d.id = \\? AND COALESCE\\(d.organization_id, 0\\) = \\?").
+		WithArgs(55, 2, roleHas(RoleUser, PermDevicesReadAll), 7, accessRank[AccessViewer]).
+		WillReturnError(sql.ErrNoRows)
+	if hasDevicePermission(r, 55, AccessViewer) {
+		t.Fatal("usuário da organização 2 não pode acessar dispositivo de outra organização")
+	}
+
+	mock.ExpectQuery("SELECT 1 FROM devices d").
+		WithArgs(10, 2, roleHas(RoleUser, PermDevicesReadAll), 7, accessRank[AccessOperator]).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	if !hasDevicePermission(r, 10, AccessOperator) {
+		t.Fatal("dispositivo da própria organização com vínculo deveria ser acessível")
+	}
+
+	// Master não consulta o banco
+	if !hasDevicePermission(requestAs(http.MethodGet, "/", RoleMaster, 1, 1), 55, AccessOwner) {
+		t.Fatal("master acessa qualquer dispositivo")
+	}
+}
+
+func TestAuditLogsHandlerFiltersByTargetOrg(t *testing.T) {
+	mock := mockDB(t)
+	r := requestAs(http.MethodGet, "/api/audit", RoleAdmin, 7, 2)
+
+	// Filtra pela organização gravada no registro (a do alvo), não pela do autor
+	mock.ExpectQuery("FROM audit_logs a LEFT JOIN users u ON u.id = a.user_id\\s+WHERE \\(\\? OR COALESCE\\(a.organization_id,").
+		WithArgs(false, 2, "", "", "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "action", "target_type", "target_id",
+			"details", "changes", "ip", "request_id", "user_agent", "created_at"}).
+			AddRow(1, 1, "master", "UPDATE_USER", "user", "9", "Editou", "", "10.0.0.1", "", "", time.Now()))
+
+	w := httptest.NewRecorder()
+	apiAuditLogsHandler(w, r)
+	var logs []AuditLog
+	if err := json.NewDecoder(w.Body).Decode(&logs); err != nil || len(logs) != 1 {
+		t.Fatalf("resposta inesperada (%v): %s", err, w.Body.String())
+	}
+}
+
+func TestMessagesHandlerScopesToOrg(t *testing.T) {
+	mock := mockDB(t)
+	r := requestAs(http.MethodGet, "/api/messages", RoleUser, 7, 2)
+
+	mock.ExpectQuery("JOIN user_permissions up ON up.device_id = d.id\\s+WHERE up.user_id = \\? AND \\(\\? OR COALESCE\\(d.organization_id, 0\\) = \\?\\)").
+		WithArgs("7", false, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "esn", "name", "payload", "received_at", "device_id", "access_level"}).
+			AddRow(1, "0-1", "Bomba", "{}", time.Now(), 10, AccessViewer))
+	// Quem mais vê o dispositivo: só usuários da mesma organização
+	mock.ExpectQuery("SELECT u.full_name FROM users u").
+		WithArgs(10, "7", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"full_name"}))
+
+	w := httptest.NewRecorder()
+	apiMessagesHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGroupsHandlerScopesToOrg(t *testing.T) {
+	mock := mockDB(t)
+	r := requestAs(http.MethodGet, "/api/master/groups", RoleAdmin, 7, 2)
+
+	mock.ExpectQuery("FROM device_groups g WHERE \\(\\? OR COALESCE\\(g.organization_id, 0\\) = \\?\\)").
+		WithArgs(false, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id", "name"}))
+
+	w := httptest.NewRecorder()
+	groupsHandler(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Fatalf("resposta inesperada %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuditOrgIsTheTargetsOrg(t *testing.T) {
+	mock := mockDB(t)
+
+	// Master (organização 1) editando um usuário da organização 2: o registro é da 2
+	master := requestAs(http.MethodPost, "/", RoleMaster, 1, 1)
+	mock.ExpectQuery("SELECT COALESCE\\(organization_id, 0\\) FROM users WHERE id = \\?").
+		WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(2))
+	if org := auditOrg(master, AuditEvent{Target: auditTarget(TargetUser, 9)}, 1); org != 2 {
+		t.Fatalf("organização do registro = %d, esperado 2 (a do alvo)", org)
+	}
+
+	// Alvo apagado: vale a organização informada pelo handler
+	if org := auditOrg(master, AuditEvent{Target: auditTarget(TargetUser, 9), OrgID: 3}, 1); org != 3 {
+		t.Fatalf("organização do registro = %d, esperado 3", org)
+	}
+
+	// Configuração da plataforma: só master enxerga
+	if org := auditOrg(master, AuditEvent{Target: auditTarget(TargetTemplate, "alert")}, 1); org != 0 {
+		t.Fatalf("organização do registro = %d, esperado 0", org)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- PROTEÇÃO CONTRA FORÇA BRUTA ---
+// Falhas de login (senha ou 2FA) são contadas por IP e por conta numa janela
+// de 15 minutos. A partir de loginDelayAfter falhas cada nova tentativa espera um
+// atraso crescente (1s, 2s, 4s... até loginMaxDelay); ao atingir o limite a
+// chave fica bloqueada por loginLockDuration (evento LOGIN_LOCKED). Os
+// contadores ficam no banco para valer entre réplicas. A recuperação de senha
+// tem cotas próprias por hora, para não inundar a caixa de ninguém.
+
+const (
+	scopeLoginIP   = "login_ip"
+	scopeLoginUser = "login_user"
+	scopeResetIP   = "reset_ip"
+	scopeResetUser = "reset_user"
+
+	loginWindow       = 15 * time.Minute
+	loginDelayAfter   = 3
+	loginMaxDelay     = time.Minute
+	accountLockAfter  = 10
+	ipLockAfter       = 50
+	loginLockDuration = 15 * time.Minute
+
+	resetWindow     = time.Hour
+	resetPerIP      = 10
+	resetPerAccount = 3
+)
+
+// Atrás de Nginx/balanceador o IP real vem nos cabeçalhos (só confiar se o proxy os reescreve)
+var trustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+
+// clientIP: IP de origem da requisição, sem a porta
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// accountKey: Contas são contadas pelo username informado, exista ele ou não
+// (a resposta não pode revelar quais usuários existem)
+func accountKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// throttleHit: Conta uma ocorrência na janela atual (reinicia a janela vencida).
+// Retorna o total na janela e quando ela termina.
+func throttleHit(scope, subject string, window time.Duration) (hits int, resetAt time.Time) {
+	now := time.Now()
+	expired := now.Add(-window)
+	// No ON DUPLICATE KEY as atribuições são avaliadas em ordem: hits ainda vê o window_start antigo
+	db.Exec(`INSERT INTO auth_throttle (scope, subject, hits, window_start) VALUES (?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE hits = IF(window_start < ?, 1, hits + 1), window_start = IF(window_start < ?, ?, window_start)`,
+		scope, subject, now, expired, expired, now)
+
+	var start time.Time
+	db.QueryRow("SELECT hits, window_start FROM auth_throttle WHERE scope = ? AND subject = ?", scope, subject).Scan(&hits, &start)
+	return hits, start.Add(window)
+}
+
+// throttleBlock: Recusa novas tentativas da chave até now + d
+func throttleBlock(scope, subject string, d time.Duration) {
+	db.Exec("UPDATE auth_throttle SET blocked_until = ? WHERE scope = ? AND subject = ?", time.Now().Add(d), scope, subject)
+}
+
+// throttleWait: Quanto falta para a chave poder tentar de novo (0 = liberada)
+func throttleWait(scope, subject string) time.Duration {
+	var until time.Time
+	err := db.QueryRow("SELECT blocked_until FROM auth_throttle WHERE scope = ? AND subject = ? AND blocked_until IS NOT NULL",
+		scope, subject).Scan(&until)
+	if err != nil {
+		return 0
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// throttleClear: Zera a chave (login bem-sucedido ou desbloqueio manual)
+func throttleClear(scope, subject string) {
+	db.Exec("DELETE FROM auth_throttle WHERE scope = ? AND subject = ?", scope, subject)
+}
+
+// loginDelay: Atraso progressivo após a n-ésima falha
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	d := time.Second * time.Duration(math.Pow(2, float64(failures-loginDelayAfter)))
+	if d > loginMaxDelay || d <= 0 {
+		return loginMaxDelay
+	}
+	return d
+}
+
+// loginWait: Espera exigida do IP ou da conta (a maior das duas)
+func loginWait(ip, account string) time.Duration {
+	wait := throttleWait(scopeLoginIP, ip)
+	if w := throttleWait(scopeLoginUser, account); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// registerLoginFailure: Conta a falha no IP e na conta, aplicando atraso ou bloqueio
+func registerLoginFailure(r *http.Request, userID int, account string) {
+	ip := clientIP(r)
+	if hits, _ := throttleHit(scopeLoginUser, account, loginWindow); hits >= accountLockAfter {
+		throttleBlock(scopeLoginUser, account, loginLockDuration)
+		recordAudit(r, AuditEvent{ActorID: userID, Actor: account, Action: AuditLoginLocked, Target: auditTarget(TargetUser, userID),
+			Details: fmt.Sprintf("Conta bloqueada por %d minutos após %d falhas de login", int(loginLockDuration.Minutes()), hits)})
+	} else if d := loginDelay(hits); d > 0 {
+		throttleBlock(scopeLoginUser, account, d)
+	}
+
+	if hits, _ := throttleHit(scopeLoginIP, ip, loginWindow); hits >= ipLockAfter {
+		throttleBlock(scopeLoginIP, ip, loginLockDuration)
+		recordAudit(r, AuditEvent{Actor: account, Action: AuditLoginLocked,
+			Details: fmt.Sprintf("IP %s bloqueado por %d minutos após %d falhas de login", ip, int(loginLockDuration.Minutes()), hits)})
+	} else if d := loginDelay(hits); d > 0 {
+		throttleBlock(scopeLoginIP, ip, d)
+	}
+}
+
+// tooManyAttempts: 429 com Retry-After (segundos, arredondado para cima)
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Muitas tentativas. Tente novamente em %d segundos.", seconds), http.StatusTooManyRequests)
+}
+
+// unlockUserHandler: Remove o bloqueio de login de uma conta (POST /api/master/user/unlock)
+func unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var u UserData
+	json.NewDecoder(r.Body).Decode(&u)
+
+	var username, targetRole string
+	var orgID int
+	err := db.QueryRow("SELECT username, role, COALESCE(organization_id, 0) FROM users WHERE id = ?", u.ID).
+		Scan(&username, &targetRole, &orgID)
+	if err != nil || !inScope(r, orgID) {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if !canManageRole(r.Header.Get("X-User-Role"), targetRole) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	throttleClear(scopeLoginUser, accountKey(username))
+
+	recordAudit(r, AuditEvent{Action: AuditLoginUnlocked, Target: auditTarget(TargetUser, u.ID), Details: fmt.Sprintf("Desbloqueou o login do usuário ID %d", u.ID)})
+	w.WriteHeader(http.StatusOK)
+}
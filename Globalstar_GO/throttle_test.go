@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoginDelay(t *testing.T) {
+	cases := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{loginDelayAfter - 1, 0},
+		{loginDelayAfter, time.Second},
+		{loginDelayAfter + 1, 2 * time.Second},
+		{loginDelayAfter + 2, 4 * time.Second},
+		{loginDelayAfter + 5, 32 * time.Second},
+		// 64s passaria do teto
+		{loginDelayAfter + 6, loginMaxDelay},
+		// 2^n estoura a duração: continua no teto
+		{loginDelayAfter + 100, loginMaxDelay},
+	}
+	for _, c := range cases {
+		if got := loginDelay(c.failures); got != c.delay {
+			t.Errorf("loginDelay(%d) = %v, esperado %v", c.failures, got, c.delay)
+		}
+	}
+}
+
+// untilAbout: blocked_until gravado como now + d (com folga para a execução do teste)
+type untilAbout struct{ d time.Duration }
+
+func (u untilAbout) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	return ok && time.Until(at) > u.d-5*time.Second && time.Until(at) <= u.d
+}
+
+func expectThrottleHit(mock sqlmock.Sqlmock, scope, subject string, hits int) {
+	mock.ExpectExec("INSERT INTO auth_throttle").WithArgs(scope, subject, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT hits, window_start FROM auth_throttle").WithArgs(scope, subject).
+		WillReturnRows(sqlmock.NewRows([]string{"hits", "window_start"}).AddRow(hits, time.Now()))
+}
+
+func TestRegisterLoginFailureDelaysThenLocks(t *testing.T) {
+	mock := mockDB(t)
+	r := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+
+	// Quinta falha: atraso de 4s na conta; o IP ainda está abaixo do limite
+	expectThrottleHit(mock, scopeLoginUser, "joao", loginDelayAfter+2)
+	mock.ExpectExec("UPDATE auth_throttle SET blocked_until").WithArgs(untilAbout{4 * time.Second}, scopeLoginUser, "joao").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectThrottleHit(mock, scopeLoginIP, "203.0.113.7", 1)
+	registerLoginFailure(r, 9, "joao")
+
+	// Décima: a conta fica bloqueada e o bloqueio vai para a auditoria
+	waitAudit := expectAudit(t, AuditLoginLocked)
+	expectThrottleHit(mock, scopeLoginUser, "joao", accountLockAfter)
+	mock.ExpectExec("UPDATE auth_throttle SET blocked_until").WithArgs(untilAbout{loginLockDuration}, scopeLoginUser, "joao").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(organization_id, 0\\) FROM users WHERE id = \\?").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id"}).AddRow(2))
+	expectThrottleHit(mock, scopeLoginIP, "203.0.113.7", 2)
+	registerLoginFailure(r, 9, "joao")
+	waitAudit()
+}
+
+func forgotPassword(username string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/forgot-password", strings.NewReader(`{"username":"`+username+`"}`))
+	r.RemoteAddr = "203.0.113.7:5000"
+	w := httptest.NewRecorder()
+	forgotPasswordHandler(w, r)
+	return w
+}
+
+func TestForgotPasswordQuotas(t *testing.T) {
+	// Dentro da cota da conta: procura o usuário (inexistente aqui, 200 do mesmo jeito)
+	mock := mockDB(t)
+	expectThrottleHit(mock, scopeResetIP, "203.0.113.7", 1)
+	expectThrottleHit(mock, scopeResetUser, "joao", resetPerAccount)
+	mock.ExpectQuery("SELECT id, email, invite_pending FROM users").WillReturnError(sql.ErrNoRows)
+	if w := forgotPassword(" Joao "); w.Code != http.StatusOK {
+		t.Fatalf("dentro da cota: status %d", w.Code)
+	}
+
+	// Acima da cota do IP: 429 com Retry-After
+	expectThrottleHit(mock, scopeResetIP, "203.0.113.7", resetPerIP+1)
+	if w := forgotPassword("joao"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("cota do IP: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestForgotPasswordAccountQuotaIsSilent(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db
+	db = conn
+	t.Cleanup(func() { db = old; conn.Close() })
+
+	// Acima da cota da conta: a mesma resposta de sempre, sem procurar o usuário
+	// nem gerar token (a consulta esperada abaixo não pode acontecer)
+	expectThrottleHit(mock, scopeResetIP, "203.0.113.7", 2)
+	expectThrottleHit(mock, scopeResetUser, "joao", resetPerAccount+1)
+	mock.ExpectQuery("SELECT id, email, invite_pending FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "invite_pending"}).AddRow(9, "joao@empresa.com", false))
+
+	w := forgotPassword("joao")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("cota da conta: status %d (%s), esperado 200 vazio", w.Code, w.Body.String())
+	}
+	if mock.ExpectationsWereMet() == nil {
+		t.Fatal("a cota da conta deveria impedir a busca do usuário")
+	}
+}